@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -23,16 +24,15 @@ const (
 	CLEANUP_SYNC_MIN_AGE      = 120 * time.Second
 )
 
-var (
-	regexEnvVarPrefixes = regexp.MustCompile(`[A-Z_\-0-9]+=.*?\s+(.*)`)
-)
-
 //go:embed commands/*
 var fsCommandTemplates embed.FS
 
 //go:embed webinterface/*
 var fsWebinterfaceTemplates embed.FS
 
+//go:embed personas/*
+var fsPersonas embed.FS
+
 type Config struct {
 	Debug struct {
 		FakeShell    bool `mapstructure:"fake_shell"`
@@ -53,22 +53,28 @@ type Config struct {
 	PathWebinterface string   `mapstructure:"path_webinterface"`
 	PathCaptures     string   `mapstructure:"path_captures"`
 	PathFFS          string   `mapstructure:"path_ffs"`
+	PathInternet     string   `mapstructure:"path_internet"`
+	PathPersonas     string   `mapstructure:"path_personas"`
 	HostName         string   `mapstructure:"host_name"`
 	Version          string   `mapstructure:"version"`
+	Persona          string   `mapstructure:"persona"` // the persona of servers and host names that don't select one
 	IPWhitelist      []string `mapstructure:"ip_whitelist"`
 	Hostnames        []struct {
-		Name string `mapstructure:"name"`
-		IP   string `mapstructure:"ip"`
+		Name    string `mapstructure:"name"`
+		IP      string `mapstructure:"ip"`
+		Persona string `mapstructure:"persona"`
 	} `mapstructure:"hostnames"`
 	Servers []struct {
-		Host string `mapstructure:"host"`
-		Port uint   `mapstructure:"port"`
+		Host    string `mapstructure:"host"`
+		Port    uint   `mapstructure:"port"`
+		Persona string `mapstructure:"persona"`
 	} `mapstructure:"servers"`
-	MaxIdleTimeout uint    `mapstructure:"max_idle"`
-	MaxSessionAge  uint    `mapstructure:"max_session_age"`
-	InputDelay     uint    `mapstructure:"input_delay"`
-	Ratelimit      float64 `mapstructure:"ratelimit"`
-	Webinterface   struct {
+	MaxIdleTimeout  uint    `mapstructure:"max_idle"`
+	MaxSessionAge   uint    `mapstructure:"max_session_age"`
+	SharedProcesses bool    `mapstructure:"shared_processes"` // sessions of the same host share the fake process table
+	InputDelay      uint    `mapstructure:"input_delay"`
+	Ratelimit       float64 `mapstructure:"ratelimit"`
+	Webinterface    struct {
 		Enabled  bool   `mapstructure:"enabled"`
 		Host     string `mapstructure:"host"`
 		Port     uint   `mapstructure:"port"`
@@ -90,14 +96,16 @@ type Config struct {
 	Commands struct {
 		Rewriters        [][]string `mapstructure:"rewriters"`
 		Simple           [][]string `mapstructure:"simple"`
-		Exit             []string   `mapstructure:"exit"`
-		PermissionDenied []string   `mapstructure:"permission_denied"`
-		DiskError        []string   `mapstructure:"disk_error"`
-		CommandNotFound  []string   `mapstructure:"command_not_found"`
-		FileNotFound     []string   `mapstructure:"file_not_found"`
-		NotImplemented   []string   `mapstructure:"not_implemented"`
-		Bullshit         []string   `mapstructure:"bullshit"`
+		Exit             [][]string `mapstructure:"exit"` // plain entries are lifted to rules with only a pattern, see commandRule
+		PermissionDenied [][]string `mapstructure:"permission_denied"`
+		DiskError        [][]string `mapstructure:"disk_error"`
+		CommandNotFound  [][]string `mapstructure:"command_not_found"`
+		FileNotFound     [][]string `mapstructure:"file_not_found"`
+		NotImplemented   [][]string `mapstructure:"not_implemented"`
+		Bullshit         [][]string `mapstructure:"bullshit"`
 	} `mapstructure:"commands"`
+	Downloads    [][]string `mapstructure:"downloads"`     // pairs of URL pattern and a file of PathInternet or an HTTP status code
+	VirtualFiles [][]string `mapstructure:"virtual_files"` // pairs of FFS path pattern and the template that generates the file
 }
 
 var cfgFile string = ""
@@ -142,6 +150,8 @@ func InitPaths() {
 	Conf.PathCommands = initPath(Conf.PathCommands, "commands")
 	Conf.PathWebinterface = initPath(Conf.PathWebinterface, "webinterface")
 	Conf.PathFFS = initPath(Conf.PathFFS, "ffs")
+	Conf.PathInternet = initPath(Conf.PathInternet, "internet")
+	Conf.PathPersonas = initPath(Conf.PathPersonas, "personas")
 	Conf.PathPayloads = initPath(Conf.PathPayloads, "payloads.txt")
 	Conf.PathHosts = initPath(Conf.PathHosts, "hosts.txt")
 	Conf.PathPasswords = initPath(Conf.PathPasswords, "passwords.txt")
@@ -155,7 +165,11 @@ func InitPaths() {
 		fmt.Sprintf("%s/%s", Conf.PathCaptures, "payloads"),
 		fmt.Sprintf("%s/%s", Conf.PathCaptures, "scp-uploads"),
 		fmt.Sprintf("%s/%s", Conf.PathCaptures, "ssh-keys"),
+		fmt.Sprintf("%s/%s", Conf.PathCaptures, "samples"),
+		fmt.Sprintf("%s/%s", Conf.PathCaptures, "events"),
 		Conf.PathFFS,
+		Conf.PathInternet,
+		Conf.PathPersonas,
 		Conf.PathWebinterface,
 	)
 	if err != nil {
@@ -191,6 +205,10 @@ func initConfig() {
 	if err != nil {
 		log.Panicf("[Config] Unable to copy command templates to disk, %v", err)
 	}
+	err = gutils.CopyEmbeddedFSToDisk(fsPersonas, Conf.PathPersonas, "personas")
+	if err != nil {
+		log.Panicf("[Config] Unable to copy personas to disk, %v", err)
+	}
 	err = gutils.CopyEmbeddedFSToDisk(fsWebinterfaceTemplates, Conf.PathWebinterface, "webinterface")
 	if err != nil {
 		log.Panicf("[Config] Unable to copy webinterface templates to disk, %v", err)
@@ -198,10 +216,78 @@ func initConfig() {
 
 	InitTemplaterFunctions()
 	InitTemplaterFunctionsHTML()
+	compileCommands()
 
 	LogGlobal.OK("Config loaded from %s", glog.WrapOrange(cfgFile))
 }
 
+// rewriter is a compiled entry of the `rewriters` config.
+type rewriter struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+var rewriters = struct {
+	lock *sync.Mutex
+	list []rewriter
+}{
+	lock: &sync.Mutex{},
+}
+
+// RewriteInput applies the rewriters of the config to a line of input.
+func RewriteInput(line string) string {
+	rewriters.lock.Lock()
+	list := rewriters.list
+	rewriters.lock.Unlock()
+	for _, rw := range list {
+		line = rw.re.ReplaceAllString(line, rw.replacement)
+	}
+	return line
+}
+
+// compileCommands compiles the rewriters, the command rules, the download rules, the responses of the simple commands, the templates
+// of the virtual files and the command templates of the config once, so mistakes are reported when the config is loaded instead of
+// when a bot runs into them. Invalid rewriters are left out, loaded personas are read again.
+func compileCommands() {
+	ResetTemplateCache()
+	resetPersonas()
+
+	list := []rewriter{}
+	for _, rw := range Conf.Commands.Rewriters {
+		if len(rw) < 2 {
+			LogGlobal.Error("[Config] Rewriter %v needs a pattern and a replacement", rw)
+			continue
+		}
+		re, err := regexp.Compile(rw[0])
+		if err != nil {
+			LogGlobal.Error("[Config] Invalid rewriter %s: %s", glog.Wrap(rw[0], glog.LightBlue), glog.Error(err))
+			continue
+		}
+		list = append(list, rewriter{re: re, replacement: rw[1]})
+	}
+	rewriters.lock.Lock()
+	rewriters.list = list
+	rewriters.lock.Unlock()
+
+	compileCommandRules()
+	compileDownloadRules()
+	compileTemplateStrings("simple command", Conf.Commands.Simple)
+	compileTemplateStrings("virtual file", Conf.VirtualFiles)
+	_, _ = compiledTemplates(Conf.PathCommands) // errors are logged
+}
+
+// compileTemplateStrings parses the templates of config pairs like the simple commands and logs invalid ones.
+func compileTemplateStrings(kind string, pairs [][]string) {
+	for _, pair := range pairs {
+		if len(pair) < 2 {
+			continue
+		}
+		if _, err := compiledTemplateString(pair[1]); err != nil {
+			LogGlobal.Error("[Config] Invalid template of %s %s: %s", kind, glog.Wrap(pair[0], glog.LightBlue), glog.Error(err))
+		}
+	}
+}
+
 func getConfig() string {
 	cfg, err := os.ReadFile(cfgFile)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -125,7 +126,8 @@ func (ofsm *FakeFSManager) Init(baseDir string) error {
 	return nil
 }
 
-func (ofsm *FakeFSManager) NewSession(sandboxKey string) (*FakeFS, error) {
+// NewSession returns the FakeFS of a sandbox, the layers are laid over the default FS (the first one is on top).
+func (ofsm *FakeFSManager) NewSession(sandboxKey string, layers ...string) (*FakeFS, error) {
 	sandboxPath := filepath.Join(ofsm.baseDir, "sandboxes", sandboxKey)
 
 	if !gutils.DirExists(sandboxPath) {
@@ -162,6 +164,7 @@ func (ofsm *FakeFSManager) NewSession(sandboxKey string) (*FakeFS, error) {
 
 	ofsm.logger.Debug("Creating new session for %s at %s", glog.Highlight(sandboxKey), glog.File(sandboxPath))
 
+	lowerLayers = append(lowerLayers, layers...)
 	lowerLayers = append(lowerLayers, filepath.Join(ofsm.baseDir, "defaultfs"))
 
 	ofs := &FakeFS{
@@ -171,6 +174,7 @@ func (ofsm *FakeFSManager) NewSession(sandboxKey string) (*FakeFS, error) {
 		workDir:   workLayerPath,
 		lowerDirs: lowerLayers,
 		logger:    ofsm.logger,
+		attrs:     newFileAttrs(),
 	}
 
 	ofsm.mu.Lock()
@@ -192,10 +196,16 @@ func (ofsm *FakeFSManager) DeactivateOverlay(fs *FakeFS) {
 type FakeFS struct {
 	manager   *FakeFSManager
 	logger    *glog.Logger
-	mergedDir string   // The dir containing the merged layers
-	upperDir  string   // The upper most layer, containing all changed made if any
-	workDir   string   // The work dir
-	lowerDirs []string // The lower layers, ordered by time
+	mergedDir string          // The dir containing the merged layers
+	upperDir  string          // The upper most layer, containing all changed made if any
+	workDir   string          // The work dir
+	lowerDirs []string        // The lower layers, ordered by time
+	hostName  string          // the host name of the system, see Conf.Hostnames
+	persona   *Persona        // the persona of the system, see Persona
+	processes *ProcessTable   // the processes /proc shows, only set on the view of a shell, see ProcessView
+	self      int             // the PID /proc/self refers to
+	environ   func() []string // the environment of the shell, for /proc/<pid>/environ
+	attrs     *fileAttrs      // the modes and owners that are only kept by the FFS, see Chmod and Chown
 }
 
 func (ofs *FakeFS) Mount() error {
@@ -280,71 +290,367 @@ func (ofs *FakeFS) insideMerged(path string) bool {
 	return strings.HasPrefix(absPath, mergedAbs)
 }
 
+// fakeFSMaxSymlinks is the number of symbolic links that are followed before giving up, like Linux does.
+const fakeFSMaxSymlinks = 40
+
+// hostPath returns the path on the host for a path of the FFS.
+// Symbolic links are resolved relative to the root of the FFS, like they would be in a chroot,
+// so links created by bots can't point outside the FFS. The last element of the path is only
+// resolved if follow is true.
+func (ofs *FakeFS) hostPath(path string, follow bool) (string, error) {
+	if !ofs.insideMerged(path) {
+		return "", errors.New("path outside root")
+	}
+
+	pending := strings.Split(filepath.Clean("/"+path), "/")
+	resolved := "/"
+	links := 0
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, elem)
+		if len(pending) == 0 && !follow {
+			resolved = next
+			break
+		}
+		info, err := os.Lstat(filepath.Join(ofs.mergedDir, next))
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next // missing elements are left for the caller to fail on
+			continue
+		}
+
+		links++
+		if links > fakeFSMaxSymlinks {
+			return "", &fs.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(ofs.mergedDir, next))
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+
+	return filepath.Join(ofs.mergedDir, resolved), nil
+}
+
 func (ofs *FakeFS) RemoveFile(path string, recursive bool) error {
 	ofs.logger.Info("Remove %s%s", glog.File(ofs.mergedDir), glog.Reason(path))
+	if ofs.isVirtual(path) {
+		return virtualError("remove", path)
+	}
 
-	if !ofs.insideMerged(path) {
-		return errors.New("path outside root")
+	p, err := ofs.hostPath(path, false)
+	if err != nil {
+		return err
 	}
 	if recursive {
-		return os.RemoveAll(filepath.Join(ofs.mergedDir, path))
+		err = os.RemoveAll(p)
+	} else {
+		err = os.Remove(p)
 	}
-	return os.Remove(filepath.Join(ofs.mergedDir, path))
+	if err == nil {
+		ofs.attrs.remove(p)
+	}
+	return err
 }
 
 func (ofs *FakeFS) OpenFile(path string, flag int, perm fs.FileMode) (*os.File, error) {
 	ofs.logger.Info("Open %s%s", glog.File(ofs.mergedDir), glog.Reason(path))
+	if ofs.isVirtual(path) {
+		// virtual files are generated on read, they can't be opened as real files
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EACCES}
+	}
 
-	if !ofs.insideMerged(path) {
-		return nil, errors.New("path outside root")
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return nil, err
 	}
 
 	// create the directory structure, so we don't get not found errors
 	// because our fake file system is incomplete
 	_ = ofs.MkdirAll(filepath.Dir(path), perm)
 
-	return os.OpenFile(filepath.Join(ofs.mergedDir, path), flag, perm)
+	return os.OpenFile(p, flag, perm.Perm())
+}
+
+func (ofs *FakeFS) ReadFile(path string) ([]byte, error) {
+	ofs.logger.Debug("ReadFile %s", glog.File(path))
+	if data, isDir, ok := ofs.lookupVirtual(path); ok {
+		if isDir {
+			return nil, &fs.PathError{Op: "read", Path: path, Err: syscall.EISDIR}
+		}
+		return data, nil
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(p)
+}
+
+// WriteFile writes the data to the file, it is created with the given permissions if it doesn't exist.
+func (ofs *FakeFS) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := ofs.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (ofs *FakeFS) Stat(path string) (fs.FileInfo, error) {
+	if info, ok := ofs.statVirtual(path); ok {
+		return info, nil
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return ofs.attrs.apply(p, info), nil
+}
+
+// Lstat is like Stat but doesn't follow symbolic links.
+func (ofs *FakeFS) Lstat(path string) (fs.FileInfo, error) {
+	if info, ok := ofs.statVirtual(path); ok {
+		return info, nil
+	}
+	p, err := ofs.hostPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return ofs.attrs.apply(p, info), nil
+}
+
+func (ofs *FakeFS) Readlink(path string) (string, error) {
+	p, err := ofs.hostPath(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	return os.Readlink(p)
 }
 
 func (ofs *FakeFS) DirExists(path string) bool {
-	if !ofs.insideMerged(path) {
+	if _, isDir, ok := ofs.lookupVirtual(path); ok {
+		return isDir
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
 		return false
 	}
 
-	return gutils.DirExists(filepath.Join(ofs.mergedDir, path))
+	return gutils.DirExists(p)
 }
 
 func (ofs *FakeFS) FileExists(path string) bool {
-	if !ofs.insideMerged(path) {
+	if _, isDir, ok := ofs.lookupVirtual(path); ok {
+		return !isDir
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
 		return false
 	}
 
-	return gutils.FileExists(filepath.Join(ofs.mergedDir, path))
+	return gutils.FileExists(p)
 }
 
 func (ofs *FakeFS) Mkdir(path string, mode fs.FileMode) error {
 	ofs.logger.Debug("Mkdir %s", glog.File(path))
-	if !ofs.insideMerged(path) {
-		return errors.New("path outside root")
+	if ofs.isVirtual(path) {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: syscall.EEXIST}
+	}
+	p, err := ofs.hostPath(path, false)
+	if err != nil {
+		return err
 	}
 
-	return os.Mkdir(filepath.Join(ofs.mergedDir, path), mode)
+	return os.Mkdir(p, mode.Perm())
 }
 
 func (ofs *FakeFS) MkdirAll(path string, mode fs.FileMode) error {
 	ofs.logger.Debug("MkdirAll %s", glog.File(path))
-	if !ofs.insideMerged(path) {
-		return errors.New("path outside root")
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return err
 	}
 
-	return os.MkdirAll(filepath.Join(ofs.mergedDir, path), mode)
+	return os.MkdirAll(p, mode.Perm())
 }
 
 func (ofs *FakeFS) ReadDir(path string) ([]os.DirEntry, error) {
 	ofs.logger.Debug("ReadDir %s", glog.File(path))
-	if !ofs.insideMerged(path) {
-		return nil, errors.New("path outside root")
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p)
+	for i, e := range entries {
+		entries[i] = &attrDirEntry{DirEntry: e, attrs: ofs.attrs, path: filepath.Join(p, e.Name())}
+	}
+	virtual := ofs.virtualDirEntries(path)
+	if len(virtual) == 0 && !ofs.isVirtual(path) {
+		return entries, err
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// virtual entries replace files of the same name, e.g. static copies in the FFS
+	for _, e := range entries {
+		if !ofs.isVirtual(filepath.Join(path, e.Name())) {
+			virtual = append(virtual, e.Name())
+		}
+	}
+	sort.Strings(virtual)
+	merged := []os.DirEntry{}
+	for _, name := range virtual {
+		if info, err := ofs.Lstat(filepath.Join(path, name)); err == nil {
+			merged = append(merged, fs.FileInfoToDirEntry(info))
+		}
+	}
+	return merged, nil
+}
+
+func (ofs *FakeFS) Rename(oldPath, newPath string) error {
+	ofs.logger.Info("Rename %s%s to %s", glog.File(ofs.mergedDir), glog.Reason(oldPath), glog.Reason(newPath))
+	if ofs.isVirtual(oldPath) || ofs.isVirtual(newPath) {
+		return virtualError("rename", oldPath)
+	}
+	oldP, err := ofs.hostPath(oldPath, false)
+	if err != nil {
+		return err
+	}
+	newP, err := ofs.hostPath(newPath, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(oldP, newP); err != nil {
+		return err
+	}
+	ofs.attrs.move(oldP, newP)
+	return nil
+}
+
+// Chmod changes the mode of a file. Only the permission bits are applied to the file on the host,
+// the setuid, setgid and sticky bits are kept by the FFS.
+func (ofs *FakeFS) Chmod(path string, mode fs.FileMode) error {
+	ofs.logger.Info("Chmod %s%s to %s", glog.File(ofs.mergedDir), glog.Reason(path), glog.Highlight(mode.String()))
+	if ofs.isVirtual(path) {
+		return virtualError("chmod", path)
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(p, mode.Perm()); err != nil {
+		return err
+	}
+	ofs.attrs.update(p, func(a *fileAttr) { a.special = mode & fakeFSSpecialBits })
+	return nil
+}
+
+// Chown changes the owner of a file, if follow is false a symbolic link itself is changed.
+// The owner is kept by the FFS, files on the host always belong to the user running the server.
+func (ofs *FakeFS) Chown(path string, uid, gid int, follow bool) error {
+	ofs.logger.Info("Chown %s%s to %d:%d", glog.File(ofs.mergedDir), glog.Reason(path), uid, gid)
+	if ofs.isVirtual(path) {
+		return virtualError("chown", path)
+	}
+	p, err := ofs.hostPath(path, follow)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	ofs.attrs.update(p, func(a *fileAttr) {
+		if !a.owned {
+			if st, ok := info.Sys().(*syscall.Stat_t); ok {
+				a.uid, a.gid = st.Uid, st.Gid
+			}
+			a.owned = true
+		}
+		// like chown(2), -1 leaves the id unchanged
+		if uid >= 0 {
+			a.uid = uint32(uid)
+		}
+		if gid >= 0 {
+			a.gid = uint32(gid)
+		}
+	})
+	return nil
+}
+
+// Chtimes changes the access and modification times of a file.
+func (ofs *FakeFS) Chtimes(path string, atime, mtime time.Time) error {
+	if ofs.isVirtual(path) {
+		return virtualError("chtimes", path)
+	}
+	p, err := ofs.hostPath(path, true)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(p, atime, mtime)
+}
+
+// Symlink creates newPath as symbolic link to target, the target is stored as is.
+func (ofs *FakeFS) Symlink(target, newPath string) error {
+	ofs.logger.Info("Symlink %s%s to %s", glog.File(ofs.mergedDir), glog.Reason(newPath), glog.Reason(target))
+	if ofs.isVirtual(newPath) {
+		return virtualError("symlink", newPath)
+	}
+	p, err := ofs.hostPath(newPath, false)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(target, p)
+}
+
+// Link creates newPath as hard link to oldPath.
+func (ofs *FakeFS) Link(oldPath, newPath string) error {
+	ofs.logger.Info("Link %s%s to %s", glog.File(ofs.mergedDir), glog.Reason(newPath), glog.Reason(oldPath))
+	if ofs.isVirtual(newPath) {
+		return virtualError("link", newPath)
+	}
+	oldP, err := ofs.hostPath(oldPath, false)
+	if err != nil {
+		return err
+	}
+	newP, err := ofs.hostPath(newPath, false)
+	if err != nil {
+		return err
 	}
 
-	return os.ReadDir(filepath.Join(ofs.mergedDir, path))
+	return os.Link(oldP, newP)
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// fakeFSSpecialBits are the mode bits that are never applied to files on the host,
+// a setuid root binary in an overlay would otherwise be a real one.
+const fakeFSSpecialBits = fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// fileAttr is the metadata of a file that is only kept by the FFS.
+type fileAttr struct {
+	special  fs.FileMode // setuid, setgid and sticky bits
+	owned    bool        // uid and gid are set
+	uid, gid uint32
+}
+
+// fileAttrs holds the modes and owners set by chmod and chown, by path on the host.
+// They are reported by Stat, Lstat and ReadDir but never applied to the files on the host.
+type fileAttrs struct {
+	mu    *sync.Mutex
+	files map[string]fileAttr
+}
+
+func newFileAttrs() *fileAttrs {
+	return &fileAttrs{
+		mu:    &sync.Mutex{},
+		files: map[string]fileAttr{},
+	}
+}
+
+// update changes the metadata of a file with fn.
+func (fa *fileAttrs) update(p string, fn func(a *fileAttr)) {
+	if fa == nil {
+		return
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	a := fa.files[p]
+	fn(&a)
+	if a.special == 0 && !a.owned {
+		delete(fa.files, p)
+		return
+	}
+	fa.files[p] = a
+}
+
+func (fa *fileAttrs) get(p string) (fileAttr, bool) {
+	if fa == nil {
+		return fileAttr{}, false
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	a, ok := fa.files[p]
+	return a, ok
+}
+
+// move moves the metadata of a file and everything below it, if it's a directory.
+func (fa *fileAttrs) move(oldP, newP string) {
+	if fa == nil {
+		return
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	for p, a := range fa.files {
+		if rel, ok := pathBelow(oldP, p); ok {
+			delete(fa.files, p)
+			fa.files[filepath.Join(newP, rel)] = a
+		}
+	}
+}
+
+// remove forgets the metadata of a file and everything below it.
+func (fa *fileAttrs) remove(p string) {
+	if fa == nil {
+		return
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	for f := range fa.files {
+		if _, ok := pathBelow(p, f); ok {
+			delete(fa.files, f)
+		}
+	}
+}
+
+// pathBelow returns the path of p relative to dir if p is dir or inside of it.
+func pathBelow(dir, p string) (string, bool) {
+	if p == dir {
+		return "", true
+	}
+	if strings.HasPrefix(p, dir+"/") {
+		return p[len(dir)+1:], true
+	}
+	return "", false
+}
+
+// apply returns info with the metadata of the file at the host path p.
+func (fa *fileAttrs) apply(p string, info fs.FileInfo) fs.FileInfo {
+	a, ok := fa.get(p)
+	if !ok {
+		return info
+	}
+	return &attrFileInfo{FileInfo: info, attr: a}
+}
+
+// attrFileInfo is the file info of a file with metadata kept by the FFS.
+type attrFileInfo struct {
+	fs.FileInfo
+	attr fileAttr
+}
+
+func (ai *attrFileInfo) Mode() fs.FileMode {
+	return ai.FileInfo.Mode()&^fakeFSSpecialBits | ai.attr.special
+}
+
+func (ai *attrFileInfo) Sys() any {
+	st, ok := ai.FileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ai.FileInfo.Sys()
+	}
+	cp := *st
+	cp.Mode &^= syscall.S_ISUID | syscall.S_ISGID | syscall.S_ISVTX
+	if ai.attr.special&fs.ModeSetuid != 0 {
+		cp.Mode |= syscall.S_ISUID
+	}
+	if ai.attr.special&fs.ModeSetgid != 0 {
+		cp.Mode |= syscall.S_ISGID
+	}
+	if ai.attr.special&fs.ModeSticky != 0 {
+		cp.Mode |= syscall.S_ISVTX
+	}
+	if ai.attr.owned {
+		cp.Uid, cp.Gid = ai.attr.uid, ai.attr.gid
+	}
+	return &cp
+}
+
+// attrDirEntry is a directory entry whose info includes the metadata kept by the FFS.
+type attrDirEntry struct {
+	os.DirEntry
+	attrs *fileAttrs
+	path  string // the path on the host
+}
+
+func (ae *attrDirEntry) Info() (fs.FileInfo, error) {
+	info, err := ae.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return ae.attrs.apply(ae.path, info), nil
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fakeBootCycle is the time between two reboots of the fake system.
+const fakeBootCycle = 97 * 24 * time.Hour
+
+// virtualFile generates the content of a file that only exists while it's read, like the files of /proc and /sys.
+// The second return value is false if the file doesn't exist, e.g. for an unknown network interface.
+type virtualFile func(ofs *FakeFS, p string) ([]byte, bool)
+
+// virtualDir returns the names of the entries of a virtual directory.
+type virtualDir func(ofs *FakeFS, p string) ([]string, bool)
+
+// virtualFiles are the generated files of the FFS by path pattern (see path.Match).
+// Files can also be generated from templates defined in the `virtual_files` section of the config.
+var virtualFiles map[string]virtualFile
+
+// virtualDirs are the generated directories of the FFS by path pattern.
+// Their entries are merged with the entries that exist on disk and the files of virtualFiles.
+var virtualDirs map[string]virtualDir
+
+func init() {
+	// assigned here to avoid an initialization cycle, the providers read the FFS
+	virtualFiles = map[string]virtualFile{
+		"/proc/uptime":                                procUptime,
+		"/proc/loadavg":                               procLoadavg,
+		"/proc/meminfo":                               procMeminfo,
+		"/proc/stat":                                  procStat,
+		"/proc/version":                               procVersion,
+		"/proc/sys/kernel/hostname":                   etcHostname,
+		"/etc/hostname":                               etcHostname,
+		"/sys/class/net/*/*":                          sysNetFile,
+		"/sys/class/net/*/statistics/*":               sysNetStatistic,
+		"/sys/devices/system/cpu/online":              sysCPUOnline,
+		"/sys/devices/system/cpu/possible":            sysCPUOnline,
+		"/sys/devices/system/cpu/present":             sysCPUOnline,
+		"/sys/class/dmi/id/product_name":              sysDMI,
+		"/sys/class/dmi/id/sys_vendor":                sysDMI,
+		"/sys/class/dmi/id/board_vendor":              sysDMI,
+		"/sys/class/dmi/id/bios_vendor":               sysDMI,
+		"/sys/class/dmi/id/chassis_vendor":            sysDMI,
+		"/sys/class/dmi/id/product_version":           sysDMI,
+		"/sys/fs/cgroup/cgroup.controllers":           sysCgroupControllers,
+		"/sys/kernel/mm/transparent_hugepage/enabled": sysTHP,
+		"/proc/*/*":                                   procPIDFile,
+	}
+	virtualDirs = map[string]virtualDir{
+		"/proc":                               procDir,
+		"/proc/*":                             procPIDDir,
+		"/sys":                                staticDir("block", "bus", "class", "dev", "devices", "firmware", "fs", "kernel", "module", "power"),
+		"/sys/class":                          staticDir("block", "dmi", "input", "mem", "misc", "net", "tty"),
+		"/sys/class/dmi":                      staticDir("id"),
+		"/sys/class/dmi/id":                   staticDir(),
+		"/sys/devices":                        staticDir("system", "virtual"),
+		"/sys/devices/system":                 staticDir("cpu"),
+		"/sys/devices/system/cpu":             sysCPUDir,
+		"/sys/fs":                             staticDir("cgroup"),
+		"/sys/fs/cgroup":                      staticDir(),
+		"/sys/kernel":                         staticDir("mm"),
+		"/sys/kernel/mm":                      staticDir("transparent_hugepage"),
+		"/sys/kernel/mm/transparent_hugepage": staticDir(),
+		"/sys/class/net":                      sysNetDir,
+		"/sys/class/net/*":                    sysNetInterfaceDir,
+		"/sys/class/net/*/statistics":         sysNetStatisticsDir,
+	}
+}
+
+// staticDir returns a virtualDir with the given entries.
+func staticDir(names ...string) virtualDir {
+	return func(ofs *FakeFS, p string) ([]string, bool) {
+		return names, true
+	}
+}
+
+// lookupVirtual returns the content of the virtual file at p, or whether p is a virtual directory.
+// The last return value is false if p is neither.
+func (ofs *FakeFS) lookupVirtual(p string) (data []byte, isDir bool, ok bool) {
+	p = filepath.Clean("/" + p)
+	for _, vf := range Conf.VirtualFiles {
+		if len(vf) < 2 {
+			continue
+		}
+		if match, _ := path.Match(vf[0], p); match {
+			return ofs.virtualTemplate(p, vf[1]), false, true
+		}
+	}
+	for pattern, file := range virtualFiles {
+		if match, _ := path.Match(pattern, p); match {
+			if data, exists := file(ofs, p); exists {
+				return data, false, true
+			}
+		}
+	}
+	for pattern, dir := range virtualDirs {
+		if match, _ := path.Match(pattern, p); match {
+			if _, exists := dir(ofs, p); exists {
+				return nil, true, true
+			}
+		}
+	}
+	// entries of virtual directories that have no generator are empty directories
+	if parent := filepath.Dir(p); parent != p {
+		for pattern, dir := range virtualDirs {
+			if match, _ := path.Match(pattern, parent); match {
+				names, _ := dir(ofs, parent)
+				for _, name := range names {
+					if name == filepath.Base(p) {
+						return nil, true, true
+					}
+				}
+			}
+		}
+	}
+	return nil, false, false
+}
+
+// virtualDirEntries returns the names of the virtual entries of the directory p.
+func (ofs *FakeFS) virtualDirEntries(p string) []string {
+	p = filepath.Clean("/" + p)
+	names := map[string]bool{}
+	for pattern, dir := range virtualDirs {
+		if match, _ := path.Match(pattern, p); match {
+			if entries, ok := dir(ofs, p); ok {
+				for _, e := range entries {
+					names[e] = true
+				}
+			}
+		}
+		// directories and files with a fixed path are entries of their parent
+		if !strings.ContainsAny(pattern, "*?[") && filepath.Dir(pattern) == p {
+			names[filepath.Base(pattern)] = true
+		}
+	}
+	for pattern := range virtualFiles {
+		if !strings.ContainsAny(pattern, "*?[") && filepath.Dir(pattern) == p {
+			names[filepath.Base(pattern)] = true
+		}
+	}
+	for _, vf := range Conf.VirtualFiles {
+		if len(vf) > 1 && !strings.ContainsAny(vf[0], "*?[") && filepath.Dir(vf[0]) == p {
+			names[filepath.Base(vf[0])] = true
+		}
+	}
+
+	list := []string{}
+	for name := range names {
+		if _, _, ok := ofs.lookupVirtual(filepath.Join(p, name)); ok {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// virtualFileInfo describes a virtual file or directory.
+type virtualFileInfo struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+func (vi *virtualFileInfo) Name() string { return vi.name }
+func (vi *virtualFileInfo) Size() int64  { return vi.size }
+func (vi *virtualFileInfo) Mode() fs.FileMode {
+	if vi.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+func (vi *virtualFileInfo) ModTime() time.Time { return vi.modTime }
+func (vi *virtualFileInfo) IsDir() bool        { return vi.isDir }
+func (vi *virtualFileInfo) Sys() any           { return nil }
+
+// statVirtual returns the file info of a virtual file or directory.
+func (ofs *FakeFS) statVirtual(p string) (fs.FileInfo, bool) {
+	data, isDir, ok := ofs.lookupVirtual(p)
+	if !ok {
+		return nil, false
+	}
+	info := &virtualFileInfo{name: filepath.Base(p), isDir: isDir, modTime: ofs.BootTime()}
+	switch p = filepath.Clean("/" + p); {
+	case isDir, strings.HasPrefix(p, "/proc/"):
+		// procfs reports 0
+	case strings.HasPrefix(p, "/sys/"):
+		info.size = 4096 // sysfs always reports a page
+	default:
+		info.size = int64(len(data))
+	}
+	return info, true
+}
+
+// virtualTemplateData is the data templates of the `virtual_files` config have access to.
+type virtualTemplateData struct {
+	HostName string
+	Path     string
+	Uptime   float64 // in seconds
+	Boot     time.Time
+	CPUs     int
+	MemTotal int // in KiB
+}
+
+func (ofs *FakeFS) virtualTemplate(p, tpl string) []byte {
+	output := ParseTemplateFromString(tpl, virtualTemplateData{
+		HostName: ofs.HostName(),
+		Path:     p,
+		Uptime:   time.Since(ofs.BootTime()).Seconds(),
+		Boot:     ofs.BootTime(),
+		CPUs:     ofs.CPUCount(),
+		MemTotal: ofs.MemTotal(),
+	})
+	return []byte(output + "\n")
+}
+
+// seed returns a number that is the same for every call on this system, but differs between systems.
+func (ofs *FakeFS) seed(key string) uint64 {
+	sum := sha256.Sum256([]byte(ofs.HostName() + "/" + key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// BootTime returns when the fake system was booted. Every system has its own boot time that doesn't
+// change when oSSH is restarted, only every few months the fake system "reboots".
+func (ofs *FakeFS) BootTime() time.Time {
+	offset := int64(ofs.seed("boot") % uint64(fakeBootCycle))
+	now := time.Now()
+	return now.Add(-time.Duration((now.UnixNano() + offset) % int64(fakeBootCycle)))
+}
+
+// LoadAverage returns the load of the last 1, 5 and 15 minutes, it changes slowly over time.
+func (ofs *FakeFS) LoadAverage() [3]float64 {
+	t := float64(time.Now().Unix())
+	base := 0.02 + float64(ofs.seed("load")%20)/100
+	return [3]float64{
+		base + 0.15*math.Abs(math.Sin(t/420)),
+		base + 0.08*math.Abs(math.Sin(t/1300)),
+		base + 0.04*math.Abs(math.Sin(t/3900)),
+	}
+}
+
+// MemInfo returns the memory statistics of the fake system in KiB.
+func (ofs *FakeFS) MemInfo() (total, free, buffers, cached, available int) {
+	t := float64(time.Now().Unix())
+	total = ofs.MemTotal()
+	used := int(float64(total) * (0.08 + 0.01*math.Sin(t/600)))
+	buffers = total / 48
+	cached = total / 7
+	free = total - used - buffers - cached
+	available = free + buffers + cached*4/5
+	return
+}
+
+func procUptime(ofs *FakeFS, p string) ([]byte, bool) {
+	uptime := time.Since(ofs.BootTime()).Seconds()
+	idle := uptime * float64(ofs.CPUCount()) * 0.97
+	return []byte(fmt.Sprintf("%.2f %.2f\n", uptime, idle)), true
+}
+
+func procLoadavg(ofs *FakeFS, p string) ([]byte, bool) {
+	load := ofs.LoadAverage()
+	lastPID := 200 + int(time.Since(ofs.BootTime()).Seconds()/20)%(fakeProcessMaxPID-200)
+	return []byte(fmt.Sprintf("%.2f %.2f %.2f 1/%d %d\n", load[0], load[1], load[2], len(fakeProcessDaemons)+2, lastPID)), true
+}
+
+func procMeminfo(ofs *FakeFS, p string) ([]byte, bool) {
+	total, free, buffers, cached, available := ofs.MemInfo()
+	lines := []struct {
+		name  string
+		value int
+	}{
+		{"MemTotal", total}, {"MemFree", free}, {"MemAvailable", available}, {"Buffers", buffers},
+		{"Cached", cached}, {"SwapCached", 0}, {"Active", cached / 2}, {"Inactive", cached / 3},
+		{"Active(anon)", 2048}, {"Inactive(anon)", total / 40}, {"Active(file)", cached / 2}, {"Inactive(file)", cached / 3},
+		{"Unevictable", 18432}, {"Mlocked", 18432}, {"SwapTotal", 2097148}, {"SwapFree", 2097148},
+		{"Dirty", 172}, {"Writeback", 0}, {"AnonPages", total / 40}, {"Mapped", total / 60}, {"Shmem", 1120},
+		{"KReclaimable", total / 80}, {"Slab", total / 50}, {"SReclaimable", total / 80}, {"SUnreclaim", total / 130},
+		{"KernelStack", 2464}, {"PageTables", 3528}, {"NFS_Unstable", 0}, {"Bounce", 0}, {"WritebackTmp", 0},
+		{"CommitLimit", total/2 + 2097148}, {"Committed_AS", total / 9}, {"VmallocTotal", 34359738367},
+		{"VmallocUsed", 14720}, {"VmallocChunk", 0}, {"Percpu", 1216}, {"HardwareCorrupted", 0},
+		{"AnonHugePages", 0}, {"ShmemHugePages", 0}, {"ShmemPmdMapped", 0}, {"FileHugePages", 0}, {"FilePmdMapped", 0},
+	}
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(fmt.Sprintf("%-16s%8d kB\n", l.name+":", l.value))
+	}
+	sb.WriteString("HugePages_Total:       0\nHugePages_Free:        0\nHugePages_Rsvd:        0\nHugePages_Surp:        0\n")
+	sb.WriteString(fmt.Sprintf("%-16s%8d kB\n%-16s%8d kB\n", "Hugepagesize:", 2048, "Hugetlb:", 0))
+	return []byte(sb.String()), true
+}
+
+func procStat(ofs *FakeFS, p string) ([]byte, bool) {
+	cpus := ofs.CPUCount()
+	boot := ofs.BootTime()
+	jiffies := int64(time.Since(boot).Seconds() * 100)
+	cpuLine := func(name string, n int64) string {
+		user, nice, system, iowait, softirq := n*15/1000, n/10000, n*6/1000, n*2/1000, n/1000
+		idle := n - user - nice - system - iowait - softirq
+		return fmt.Sprintf("%s %d %d %d %d %d 0 %d 0 0 0\n", name, user, nice, system, idle, iowait, softirq)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(cpuLine("cpu ", jiffies*int64(cpus)))
+	for i := 0; i < cpus; i++ {
+		sb.WriteString(cpuLine(fmt.Sprintf("cpu%d", i), jiffies))
+	}
+	sb.WriteString(fmt.Sprintf("intr %d\n", jiffies*31))
+	sb.WriteString(fmt.Sprintf("ctxt %d\n", jiffies*57))
+	sb.WriteString(fmt.Sprintf("btime %d\n", boot.Unix()))
+	sb.WriteString(fmt.Sprintf("processes %d\n", jiffies/2000+int64(len(fakeProcessDaemons))))
+	sb.WriteString("procs_running 1\nprocs_blocked 0\n")
+	sb.WriteString(fmt.Sprintf("softirq %d\n", jiffies*12))
+	return []byte(sb.String()), true
+}
+
+func procVersion(ofs *FakeFS, p string) ([]byte, bool) {
+	u := ofs.Persona().Uname
+	return []byte(fmt.Sprintf("%s version %s (root@buildhost) (gcc (GCC) 11.4.0, GNU ld (GNU Binutils) 2.38) %s\n", u.KernelName, u.KernelRelease, u.KernelVersion)), true
+}
+
+// ProcessView returns a view of the FFS for a shell whose /proc shows the processes of the given table,
+// the FFS itself is shared by all sessions of the system. environ returns the environment of the shell.
+func (ofs *FakeFS) ProcessView(pt *ProcessTable, self int, environ func() []string) *FakeFS {
+	view := *ofs
+	view.processes = pt
+	view.self = self
+	view.environ = environ
+	return &view
+}
+
+// procProcess returns the process a path of /proc/<pid> refers to.
+func procProcess(ofs *FakeFS, p string) (*FakeProcess, bool) {
+	if ofs.processes == nil {
+		return nil, false
+	}
+	name := strings.Split(strings.TrimPrefix(p, "/proc/"), "/")[0]
+	pid, err := strconv.Atoi(name)
+	if name == "self" {
+		pid, err = ofs.self, nil
+	}
+	if err != nil {
+		return nil, false
+	}
+	return ofs.processes.Get(pid)
+}
+
+func procDir(ofs *FakeFS, p string) ([]string, bool) {
+	names := []string{}
+	if ofs.processes == nil {
+		return names, true
+	}
+	for _, proc := range ofs.processes.List() {
+		names = append(names, strconv.Itoa(proc.PID))
+	}
+	return append(names, "self"), true
+}
+
+func procPIDDir(ofs *FakeFS, p string) ([]string, bool) {
+	if _, ok := procProcess(ofs, p); !ok {
+		return nil, false
+	}
+	return []string{"cmdline", "comm", "environ", "stat", "status"}, true
+}
+
+func procPIDFile(ofs *FakeFS, p string) ([]byte, bool) {
+	proc, ok := procProcess(ofs, p)
+	if !ok {
+		return nil, false
+	}
+
+	switch filepath.Base(p) {
+	case "cmdline":
+		if proc.kernel {
+			return []byte{}, true
+		}
+		return []byte(strings.Join(proc.Args, "\x00") + "\x00"), true
+	case "comm":
+		return []byte(proc.Name() + "\n"), true
+	case "status":
+		states := map[byte]string{'R': "R (running)", 'S': "S (sleeping)", 'I': "I (idle)", 'T': "T (stopped)", 'D': "D (disk sleep)"}
+		uid, gid, _ := ofs.lookupUser(proc.User)
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Name:\t%s\n", proc.Name()))
+		sb.WriteString(fmt.Sprintf("Umask:\t%04o\n", fakeShellUmask))
+		sb.WriteString(fmt.Sprintf("State:\t%s\n", states[proc.Stat[0]]))
+		sb.WriteString(fmt.Sprintf("Tgid:\t%d\nNgid:\t0\nPid:\t%d\nPPid:\t%d\nTracerPid:\t0\n", proc.PID, proc.PID, proc.PPID))
+		sb.WriteString(fmt.Sprintf("Uid:\t%d\t%d\t%d\t%d\nGid:\t%d\t%d\t%d\t%d\n", uid, uid, uid, uid, gid, gid, gid, gid))
+		if !proc.kernel {
+			sb.WriteString(fmt.Sprintf("VmSize:\t%8d kB\nVmRSS:\t%8d kB\n", proc.VSZ, proc.RSS))
+		}
+		sb.WriteString("Threads:\t1\n")
+		return []byte(sb.String()), true
+	case "stat":
+		cpu := proc.CPUTime().Milliseconds() / 10
+		start := proc.Started.Sub(ofs.processes.Boot()).Milliseconds() / 10
+		return []byte(fmt.Sprintf("%d (%s) %c %d %d %d 0 -1 4194560 0 0 0 0 %d %d 0 0 20 0 1 0 %d %d %d\n",
+			proc.PID, proc.Name(), proc.Stat[0], proc.PPID, proc.PID, proc.PID, cpu*9/10, cpu/10, start, proc.VSZ*1024, proc.RSS/4)), true
+	case "environ":
+		if ofs.environ == nil || proc.PID != ofs.self && proc.PPID != ofs.self {
+			return []byte{}, true // only the environment of our own processes is known
+		}
+		return []byte(strings.Join(ofs.environ(), "\x00") + "\x00"), true
+	}
+	return nil, false
+}
+
+func etcHostname(ofs *FakeFS, p string) ([]byte, bool) {
+	return []byte(ofs.HostName() + "\n"), true
+}
+
+// fakeNetInterface is a network interface of the fake system.
+type fakeNetInterface struct {
+	name    string
+	index   int
+	mac     string
+	mtu     int
+	netType int     // ARPHRD_* type
+	rate    float64 // received bytes per second, sent is a third of it
+}
+
+// netInterfaces returns the network interfaces of the fake system.
+func (ofs *FakeFS) netInterfaces() []fakeNetInterface {
+	seed := ofs.seed("mac")
+	return []fakeNetInterface{
+		{name: "lo", index: 1, mac: "00:00:00:00:00:00", mtu: 65536, netType: 772, rate: 310},
+		{
+			name:    "eth0",
+			index:   2,
+			mac:     fmt.Sprintf("52:54:00:%02x:%02x:%02x", byte(seed>>16), byte(seed>>8), byte(seed)),
+			mtu:     1500,
+			netType: 1,
+			rate:    9000 + float64(seed%7000),
+		},
+	}
+}
+
+// sysNetInterface returns the interface a path of /sys/class/net refers to.
+func sysNetInterface(ofs *FakeFS, p string) (fakeNetInterface, bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/sys/class/net/"), "/")
+	for _, iface := range ofs.netInterfaces() {
+		if iface.name == parts[0] {
+			return iface, true
+		}
+	}
+	return fakeNetInterface{}, false
+}
+
+func sysNetDir(ofs *FakeFS, p string) ([]string, bool) {
+	names := []string{}
+	for _, iface := range ofs.netInterfaces() {
+		names = append(names, iface.name)
+	}
+	return names, true
+}
+
+func sysNetInterfaceDir(ofs *FakeFS, p string) ([]string, bool) {
+	if _, ok := sysNetInterface(ofs, p); !ok {
+		return nil, false
+	}
+	return []string{"addr_len", "address", "broadcast", "carrier", "dev_id", "duplex", "flags", "ifindex", "mtu", "operstate", "speed", "statistics", "tx_queue_len", "type"}, true
+}
+
+func sysNetStatisticsDir(ofs *FakeFS, p string) ([]string, bool) {
+	if _, ok := sysNetInterface(ofs, p); !ok {
+		return nil, false
+	}
+	return []string{"rx_bytes", "rx_dropped", "rx_errors", "rx_packets", "tx_bytes", "tx_dropped", "tx_errors", "tx_packets"}, true
+}
+
+func sysNetFile(ofs *FakeFS, p string) ([]byte, bool) {
+	iface, ok := sysNetInterface(ofs, p)
+	if !ok {
+		return nil, false
+	}
+	loopback := iface.netType == 772
+	value := ""
+	switch filepath.Base(p) {
+	case "addr_len":
+		value = "6"
+	case "address":
+		value = iface.mac
+	case "broadcast":
+		value = "ff:ff:ff:ff:ff:ff"
+		if loopback {
+			value = "00:00:00:00:00:00"
+		}
+	case "carrier":
+		value = "1"
+	case "dev_id":
+		value = "0x0"
+	case "duplex":
+		value = "full"
+	case "flags":
+		value = "0x1003"
+		if loopback {
+			value = "0x9"
+		}
+	case "ifindex":
+		value = fmt.Sprint(iface.index)
+	case "mtu":
+		value = fmt.Sprint(iface.mtu)
+	case "operstate":
+		value = "up"
+		if loopback {
+			value = "unknown"
+		}
+	case "speed":
+		value = "1000"
+		if loopback {
+			return nil, false
+		}
+	case "tx_queue_len":
+		value = "1000"
+		if loopback {
+			value = "1000"
+		}
+	case "type":
+		value = fmt.Sprint(iface.netType)
+	default:
+		return nil, false
+	}
+	return []byte(value + "\n"), true
+}
+
+func sysNetStatistic(ofs *FakeFS, p string) ([]byte, bool) {
+	iface, ok := sysNetInterface(ofs, p)
+	if !ok {
+		return nil, false
+	}
+	uptime := time.Since(ofs.BootTime()).Seconds()
+	rx := int64(uptime * iface.rate)
+	tx := rx / 3
+	if iface.netType == 772 {
+		tx = rx // what goes out of the loopback comes back in
+	}
+	value := int64(0)
+	switch filepath.Base(p) {
+	case "rx_bytes":
+		value = rx
+	case "tx_bytes":
+		value = tx
+	case "rx_packets":
+		value = rx / 730
+	case "tx_packets":
+		value = tx / 410
+	case "rx_dropped":
+		value = rx / 90000000
+	case "rx_errors", "tx_errors", "tx_dropped":
+	default:
+		return nil, false
+	}
+	return []byte(fmt.Sprintf("%d\n", value)), true
+}
+
+func sysCPUOnline(ofs *FakeFS, p string) ([]byte, bool) {
+	if n := ofs.CPUCount(); n > 1 {
+		return []byte(fmt.Sprintf("0-%d\n", n-1)), true
+	}
+	return []byte("0\n"), true
+}
+
+func sysCPUDir(ofs *FakeFS, p string) ([]string, bool) {
+	names := []string{"online", "possible", "present"}
+	for i := 0; i < ofs.CPUCount(); i++ {
+		names = append(names, fmt.Sprintf("cpu%d", i))
+	}
+	return names, true
+}
+
+func sysDMI(ofs *FakeFS, p string) ([]byte, bool) {
+	values := map[string]string{
+		"product_name":    "Standard PC (i440FX + PIIX, 1996)",
+		"product_version": "pc-i440fx-5.2",
+		"sys_vendor":      "QEMU",
+		"board_vendor":    "QEMU",
+		"bios_vendor":     "SeaBIOS",
+		"chassis_vendor":  "QEMU",
+	}
+	return []byte(values[filepath.Base(p)] + "\n"), true
+}
+
+func sysCgroupControllers(ofs *FakeFS, p string) ([]byte, bool) {
+	return []byte("cpuset cpu io memory hugetlb pids rdma misc\n"), true
+}
+
+func sysTHP(ofs *FakeFS, p string) ([]byte, bool) {
+	return []byte("always [madvise] never\n"), true
+}
+
+// isVirtual returns whether p is a virtual file or directory.
+func (ofs *FakeFS) isVirtual(p string) bool {
+	_, _, ok := ofs.lookupVirtual(p)
+	return ok
+}
+
+// virtualError returns the error of an operation that would modify a virtual file or directory.
+func virtualError(op, p string) error {
+	return &fs.PathError{Op: op, Path: p, Err: syscall.EPERM}
+}
+
+// HostName returns the host name of the system the FFS belongs to.
+func (ofs *FakeFS) HostName() string {
+	if ofs.hostName != "" {
+		return ofs.hostName
+	}
+	return ofs.Persona().HostName
+}
+
+// CPUCount returns the number of CPUs according to /proc/cpuinfo.
+func (ofs *FakeFS) CPUCount() int {
+	data, err := ofs.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return 1
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "processor") {
+			n++
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// MemTotal returns the memory of the fake system in KiB.
+func (ofs *FakeFS) MemTotal() int {
+	if p := ofs.Persona(); p.MemTotal > 0 {
+		return p.MemTotal
+	}
+	return fakeMemTotal
+}
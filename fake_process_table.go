@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/toxyl/gutils"
+)
+
+const (
+	fakeProcessMaxPID  = 4194304         // pid_max of a 64-bit kernel
+	fakeMemTotal       = 8 * 1024 * 1024 // KiB of memory of the fake system
+	fakeProcessNameLen = 15              // the kernel cuts the names of processes (comm) after this many chars
+)
+
+// fakeProcessTemplate describes a process that runs on the fake system since it was booted.
+type fakeProcessTemplate struct {
+	user   string
+	args   string // the command line, kernel threads are written in brackets
+	stat   string
+	vsz    int    // in KiB
+	rss    int    // in KiB
+	parent string // prefix of the command line of the parent, init if empty
+}
+
+// fakeProcessDaemons are the processes of a freshly booted server, in the order they were started.
+var fakeProcessDaemons = []fakeProcessTemplate{
+	{"root", "/sbin/init", "Ss", 167744, 11520, ""},
+	{"root", "[kthreadd]", "S", 0, 0, ""},
+	{"root", "[rcu_gp]", "I<", 0, 0, ""},
+	{"root", "[rcu_par_gp]", "I<", 0, 0, ""},
+	{"root", "[kworker/0:0H-events_highpri]", "I<", 0, 0, ""},
+	{"root", "[mm_percpu_wq]", "I<", 0, 0, ""},
+	{"root", "[ksoftirqd/0]", "S", 0, 0, ""},
+	{"root", "[rcu_sched]", "I", 0, 0, ""},
+	{"root", "[migration/0]", "S", 0, 0, ""},
+	{"root", "[cpuhp/0]", "S", 0, 0, ""},
+	{"root", "[kdevtmpfs]", "S", 0, 0, ""},
+	{"root", "[khungtaskd]", "S", 0, 0, ""},
+	{"root", "[kswapd0]", "S", 0, 0, ""},
+	{"root", "[kworker/u8:1-events_unbound]", "I", 0, 0, ""},
+	{"root", "/lib/systemd/systemd-journald", "Ss", 48412, 15360, ""},
+	{"root", "/lib/systemd/systemd-udevd", "Ss", 22004, 5632, ""},
+	{"systemd-network", "/lib/systemd/systemd-networkd", "Ss", 16128, 7680, ""},
+	{"systemd-resolve", "/lib/systemd/systemd-resolved", "Ss", 25252, 12544, ""},
+	{"systemd-timesync", "/lib/systemd/systemd-timesyncd", "Ssl", 89356, 6528, ""},
+	{"root", "/usr/sbin/cron -f", "Ss", 6816, 2816, ""},
+	{"messagebus", "/usr/bin/dbus-daemon --system --address=systemd: --nofork --nopidfile --systemd-activation --syslog-only", "Ss", 8620, 4864, ""},
+	{"syslog", "/usr/sbin/rsyslogd -n -iNONE", "Ssl", 222400, 5120, ""},
+	{"root", "/lib/systemd/systemd-logind", "Ss", 15616, 7296, ""},
+	{"root", "/sbin/agetty -o -p -- \\u --noclear tty1 linux", "Ss+", 5836, 1792, ""},
+	{"root", "/usr/lib/postfix/sbin/master -w", "Ss", 38124, 4480, ""},
+	{"postfix", "qmgr -l -t unix -u", "S", 38556, 6016, "/usr/lib/postfix/sbin/master"},
+	{"postfix", "pickup -l -t unix -u -c", "S", 38500, 5888, "/usr/lib/postfix/sbin/master"},
+	{"root", "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups", "Ss", 15424, 8960, ""},
+}
+
+// fakeSignals are the names of the signals by number.
+var fakeSignals = []string{
+	"", "HUP", "INT", "QUIT", "ILL", "TRAP", "ABRT", "BUS", "FPE", "KILL", "USR1", "SEGV", "USR2", "PIPE", "ALRM", "TERM",
+	"STKFLT", "CHLD", "CONT", "STOP", "TSTP", "TTIN", "TTOU", "URG", "XCPU", "XFSZ", "VTALRM", "PROF", "WINCH", "IO", "PWR", "SYS",
+}
+
+// fakeSignalDescriptions are the texts bash reports for jobs terminated by a signal.
+var fakeSignalDescriptions = map[int]string{
+	1:  "Hangup",
+	2:  "Interrupt",
+	3:  "Quit",
+	6:  "Aborted",
+	9:  "Killed",
+	10: "User defined signal 1",
+	11: "Segmentation fault",
+	12: "User defined signal 2",
+	13: "Broken pipe",
+	14: "Alarm clock",
+	15: "Terminated",
+}
+
+// parseSignal returns the number of a signal given by number or name, with or without the SIG prefix.
+func parseSignal(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 0 && n <= 64
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	for n, sig := range fakeSignals {
+		if n > 0 && sig == name {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// signalName returns the name of a signal without the SIG prefix.
+func signalName(n int) string {
+	switch {
+	case n > 0 && n < len(fakeSignals):
+		return fakeSignals[n]
+	case n == 34:
+		return "RTMIN"
+	case n > 34 && n < 50:
+		return fmt.Sprintf("RTMIN+%d", n-34)
+	case n >= 50 && n < 64:
+		return fmt.Sprintf("RTMAX-%d", 64-n)
+	case n == 64:
+		return "RTMAX"
+	}
+	return strconv.Itoa(n)
+}
+
+// FakeProcess is an entry of the process table of the fake system.
+type FakeProcess struct {
+	PID      int
+	PPID     int
+	User     string
+	Args     []string // the command line, Args[0] being the command
+	TTY      string   // e.g. pts/0, ? for processes without a terminal
+	Stat     string   // the process state as shown by ps
+	Started  time.Time
+	CPU      float64 // in percent of one CPU
+	VSZ      int     // in KiB
+	RSS      int     // in KiB
+	Job      int     // the job number of a background job of a shell, 0 otherwise
+	Command  string  // the command line the job was started with
+	Exit     string  // how the process ended, e.g. "Done" or "Killed", empty while it runs
+	Ends     time.Time
+	Nohup    bool // the process survives the end of its session
+	shell    int  // the shell of the session the process belongs to, 0 for processes of the system
+	kernel   bool
+	resident bool // the process keeps running after its command returned, see FakeShell.daemonize
+}
+
+// Name returns the name of the process (comm) as the kernel knows it.
+func (p *FakeProcess) Name() string {
+	if len(p.Args) == 0 {
+		return "bash" // a background job that didn't run anything yet is a subshell
+	}
+	name := p.Args[0]
+	if p.kernel {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+	} else {
+		if i := strings.Index(name, ": "); i > 0 {
+			name = name[:i] // processes like sshd change their title, e.g. "sshd: root@pts/0"
+		}
+		name = strings.TrimPrefix(filepath.Base(strings.TrimSuffix(name, ":")), "-")
+	}
+	if len(name) > fakeProcessNameLen {
+		name = name[:fakeProcessNameLen]
+	}
+	return name
+}
+
+// CommandLine returns the full command line of the process.
+func (p *FakeProcess) CommandLine() string {
+	if len(p.Args) == 0 {
+		return "-bash"
+	}
+	return strings.Join(p.Args, " ")
+}
+
+// Alive reports whether the process is still running.
+func (p *FakeProcess) Alive() bool {
+	return p.Exit == "" && (p.Ends.IsZero() || time.Now().Before(p.Ends))
+}
+
+// Stopped reports whether the process has been stopped by a signal.
+func (p *FakeProcess) Stopped() bool {
+	return strings.HasPrefix(p.Stat, "T")
+}
+
+// CPUTime returns the CPU time the process has used so far.
+func (p *FakeProcess) CPUTime() time.Duration {
+	return time.Duration(float64(time.Since(p.Started)) * p.CPU / 100)
+}
+
+// JobState returns the state of a job as bash reports it, e.g. "Running" or "Done".
+func (p *FakeProcess) JobState() string {
+	switch {
+	case p.Exit != "":
+		return p.Exit
+	case !p.Alive():
+		return "Done"
+	case p.Stopped():
+		return "Stopped"
+	}
+	return "Running"
+}
+
+// ProcessTable holds the processes of the fake system.
+// Every session has its own table, unless sessions of the same host share one (see `shared_processes`).
+type ProcessTable struct {
+	lock      *sync.Mutex
+	processes map[int]*FakeProcess
+	lastPID   int
+	boot      time.Time
+	used      time.Time
+}
+
+// NewProcessTable returns a process table with the daemons of a server that has been running for a while.
+// The processes were started when the system of the FFS booted.
+func NewProcessTable(ofs *FakeFS) *ProcessTable {
+	boot := ofs.BootTime()
+	uptime := time.Since(boot).Seconds()
+	pt := &ProcessTable{
+		lock:      &sync.Mutex{},
+		processes: map[int]*FakeProcess{},
+		boot:      boot,
+		used:      time.Now(),
+	}
+	for _, d := range fakeProcessDaemons {
+		p := &FakeProcess{
+			User:    d.user,
+			Args:    strings.Fields(d.args),
+			TTY:     "?",
+			Stat:    d.stat,
+			VSZ:     d.vsz,
+			RSS:     d.rss,
+			kernel:  strings.HasPrefix(d.args, "["),
+			Started: pt.boot.Add(time.Duration(pt.lastPID*25) * time.Millisecond),
+		}
+		switch {
+		case pt.lastPID < 2:
+			p.PID = pt.lastPID + 1 // init and kthreadd
+		case p.kernel:
+			p.PID = pt.lastPID + gutils.GetRandomInt(1, 3)
+			p.PPID = 2
+		default:
+			p.PID = maxInt(pt.lastPID, 200) + gutils.GetRandomInt(2, 80)
+			p.PPID = 1
+			p.CPU = float64(gutils.GetRandomInt(0, 2)) / 10
+		}
+		if strings.HasSuffix(p.Stat, "+") {
+			p.TTY = "tty1"
+		}
+		if d.parent != "" {
+			if parent := pt.find(d.parent); parent != nil {
+				p.PPID = parent.PID
+			}
+		}
+		pt.lastPID = p.PID
+		pt.processes[p.PID] = p
+	}
+	// lots of processes came and went since the boot
+	pt.lastPID += gutils.GetRandomInt(500, int(uptime)%30000+1000)
+	return pt
+}
+
+// find returns the first process whose command line starts with the prefix, the caller must hold the lock.
+func (pt *ProcessTable) find(prefix string) *FakeProcess {
+	for _, p := range pt.sorted() {
+		if strings.HasPrefix(p.CommandLine(), prefix) {
+			return p
+		}
+	}
+	return nil
+}
+
+// sorted returns all processes ordered by PID, the caller must hold the lock.
+func (pt *ProcessTable) sorted() []*FakeProcess {
+	list := make([]*FakeProcess, 0, len(pt.processes))
+	for _, p := range pt.processes {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	return list
+}
+
+// Boot returns the time the fake system was started.
+func (pt *ProcessTable) Boot() time.Time {
+	return pt.boot
+}
+
+// Spawn starts a new process.
+func (pt *ProcessTable) Spawn(ppid int, user, tty string, args []string, stat string) *FakeProcess {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	pid := pt.lastPID
+	for {
+		pid += gutils.GetRandomInt(1, 4)
+		if pid >= fakeProcessMaxPID {
+			pid = 300 // like the kernel we wrap around, but skip the daemons
+		}
+		if _, used := pt.processes[pid]; !used {
+			break
+		}
+	}
+	pt.lastPID = pid
+
+	p := &FakeProcess{
+		PID:     pid,
+		PPID:    ppid,
+		User:    user,
+		Args:    args,
+		TTY:     tty,
+		Stat:    stat,
+		Started: time.Now(),
+		VSZ:     gutils.GetRandomInt(2000, 12000),
+		RSS:     gutils.GetRandomInt(500, 5000),
+	}
+	if parent, ok := pt.processes[ppid]; ok {
+		p.shell = parent.shell
+	}
+	pt.processes[pid] = p
+	return p
+}
+
+// AttachShell starts the sshd and bash processes of a new session and returns the PID of the shell.
+func (pt *ProcessTable) AttachShell(user string) (pid int, tty string) {
+	pt.lock.Lock()
+	ppid, shells := 1, 0
+	if sshd := pt.find("sshd: /usr/sbin/sshd"); sshd != nil {
+		ppid = sshd.PID
+	}
+	for _, p := range pt.processes {
+		if p.shell == p.PID {
+			shells++
+		}
+	}
+	pt.lastPID += gutils.GetRandomInt(1, 50)
+	pt.used = time.Now()
+	pt.lock.Unlock()
+
+	tty = fmt.Sprintf("pts/%d", shells)
+	sshd := pt.Spawn(ppid, user, "?", []string{fmt.Sprintf("sshd: %s@%s", user, tty)}, "Ss")
+	sshd.VSZ, sshd.RSS = 17116+gutils.GetRandomInt(0, 200), 10868+gutils.GetRandomInt(0, 300)
+	shell := pt.Spawn(sshd.PID, user, tty, []string{"-bash"}, "Ss")
+	shell.VSZ, shell.RSS = 7236+gutils.GetRandomInt(0, 300), 4064+gutils.GetRandomInt(0, 200)
+
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	sshd.shell, shell.shell = shell.PID, shell.PID
+	return shell.PID, tty
+}
+
+// DetachShell ends the processes of a session when the client disconnects.
+// Like with a real SIGHUP only processes started with nohup survive, they are inherited by init.
+func (pt *ProcessTable) DetachShell(shell int) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	pt.used = time.Now()
+	for pid, p := range pt.processes {
+		if p.shell != shell {
+			continue
+		}
+		if p.Nohup && p.Alive() && p.PID != shell {
+			p.PPID, p.Job, p.shell = 1, 0, 0
+			continue
+		}
+		delete(pt.processes, pid)
+	}
+}
+
+// Get returns a process that is still running.
+func (pt *ProcessTable) Get(pid int) (*FakeProcess, bool) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	p, ok := pt.processes[pid]
+	if !ok || !p.Alive() {
+		return nil, false
+	}
+	return p, true
+}
+
+// List returns the running processes ordered by PID.
+func (pt *ProcessTable) List() []*FakeProcess {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	list := []*FakeProcess{}
+	for _, p := range pt.sorted() {
+		if p.Alive() {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// Jobs returns the background jobs of a shell ordered by job number, including the finished ones.
+func (pt *ProcessTable) Jobs(shell int) []*FakeProcess {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	return pt.jobsLocked(shell)
+}
+
+// jobsLocked is Jobs for callers that hold the lock, e.g. in Update.
+func (pt *ProcessTable) jobsLocked(shell int) []*FakeProcess {
+	list := []*FakeProcess{}
+	for _, p := range pt.processes {
+		if p.Job > 0 && p.PPID == shell {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Job < list[j].Job })
+	return list
+}
+
+// Update changes a process while holding the lock of the table.
+func (pt *ProcessTable) Update(p *FakeProcess, update func(p *FakeProcess)) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	update(p)
+}
+
+// Finish marks a process as terminated with the given exit code.
+// Processes that aren't background jobs are removed right away, jobs once the shell reported them.
+func (pt *ProcessTable) Finish(p *FakeProcess, code int) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	if p.resident {
+		return
+	}
+	p.Ends = time.Now()
+	p.Exit = "Done"
+	if code != 0 {
+		p.Exit = fmt.Sprintf("Exit %d", code)
+	}
+	if p.Job == 0 {
+		delete(pt.processes, p.PID)
+	}
+}
+
+// Remove removes a process from the table.
+func (pt *ProcessTable) Remove(pid int) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	delete(pt.processes, pid)
+}
+
+// Signal sends a signal to a process on behalf of the given shell.
+// Signals that terminate the shell of another session are ignored, that session is still connected.
+func (pt *ProcessTable) Signal(pid, sig, sender int) error {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	p, ok := pt.processes[pid]
+	if !ok || !p.Alive() {
+		return syscall.ESRCH
+	}
+	switch signalName(sig) {
+	case "0", "CHLD", "WINCH", "URG":
+		return nil
+	case "STOP", "TSTP", "TTIN", "TTOU":
+		if !p.kernel && p.PID != 1 {
+			p.Stat = "T" + strings.TrimLeft(p.Stat, "RSDIT")
+		}
+		return nil
+	case "CONT":
+		if p.Stopped() {
+			p.Stat = "S" + p.Stat[1:]
+		}
+		return nil
+	case "TERM", "INT", "QUIT":
+		if p.shell == p.PID {
+			return nil // interactive shells ignore these
+		}
+	}
+	if p.kernel || p.PID == 1 || (p.shell != 0 && p.shell != sender && (p.shell == p.PID || p.TTY == "?")) {
+		return nil
+	}
+
+	p.Ends = time.Now()
+	p.Exit = fakeSignalDescriptions[sig]
+	if p.Exit == "" {
+		p.Exit = "Killed"
+	}
+	if p.Job == 0 {
+		delete(pt.processes, pid)
+	}
+	return nil
+}
+
+var processTables = struct {
+	lock   *sync.Mutex
+	tables map[string]*ProcessTable
+}{
+	lock:   &sync.Mutex{},
+	tables: map[string]*ProcessTable{},
+}
+
+// processTableFor returns the process table for a new session of the host on the system of the FFS.
+// If `shared_processes` is enabled, all sessions of a host on the same system see the same processes.
+// Tables nobody used for longer than `max_session_age` are discarded.
+func processTableFor(host string, ofs *FakeFS) *ProcessTable {
+	if !Conf.SharedProcesses {
+		return NewProcessTable(ofs)
+	}
+	key := host + "@" + ofs.HostName()
+	processTables.lock.Lock()
+	defer processTables.lock.Unlock()
+
+	for h, pt := range processTables.tables {
+		pt.lock.Lock()
+		expired := time.Since(pt.used) > time.Duration(Conf.MaxSessionAge)*time.Second
+		for _, p := range pt.processes {
+			if p.shell != 0 {
+				expired = false
+				break
+			}
+		}
+		pt.lock.Unlock()
+		if expired {
+			delete(processTables.tables, h)
+		}
+	}
+
+	pt, ok := processTables.tables[key]
+	if !ok {
+		pt = NewProcessTable(ofs)
+		processTables.tables[key] = pt
+	}
+	return pt
+}
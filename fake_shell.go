@@ -3,8 +3,9 @@ package main
 import (
 	"fmt"
 	"io"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gliderlabs/ssh"
@@ -15,24 +16,55 @@ import (
 )
 
 const (
-	fakeShellInitialWidth  = 80
-	fakeShellInitialHeight = 24
+	fakeShellInitialWidth       = 80
+	fakeShellInitialHeight      = 24
+	fakeShellContinuationPrompt = "> "
+	fakeShellMaxInputLines      = 200       // lines of an incomplete input read before it's run anyway
+	fakeShellMaxInputBytes      = 64 * 1024 // bytes of an incomplete input read before it's run anyway
 )
 
 type FakeShell struct {
-	session  *ssh.Session
-	terminal *term.Terminal
-	writer   *utils.SlowWriter
-	created  time.Time
-	stats    *FakeShellStats
-	prompt   string
-	cwd      string
-	logger   *glog.Logger
+	session     *ssh.Session
+	osshSession *Session
+	ffs         *FakeFS // the file system of the system the session is logged into, see Persona
+	terminal    *term.Terminal
+	input       *shellInput // the input of the session, shared by the terminal and raw reads
+	writer      *utils.SlowWriter
+	created     time.Time
+	stats       *FakeShellStats
+	prompt      string
+	cwd         string
+	loops       int // nesting level of loops
+	breaks      int // number of loops a pending break leaves
+	continues   int // number of loops a pending continue leaves, the last one continues
+	env         *ShellEnv
+	positional  []string // the positional parameters $1, $2, ...
+	name        string   // the name of the shell, $0
+	pid         int
+	tty         string          // the terminal of the session, e.g. pts/0
+	processes   *ProcessTable   // the processes of the fake system, see shared_processes
+	job         *FakeProcess    // the background job that is currently running, nil in the foreground
+	lastJob     int             // the PID of the last background job, $!
+	substDepth  int             // nesting level of command substitutions
+	substCount  int             // number of command substitutions run, to tell whether a command had any
+	scriptDepth int             // nesting level of scripts
+	subshells   int             // nesting level of subshells, including the commands of multi-command pipelines
+	history     []string        // the history the user can see, see FakeShellStats.CommandHistory for the full one
+	stage       string          // the sample hash of the script piped into a shell that is running, see recordStage
+	logins      []fakeLogin     // the shells started with su or sudo -i/-s, the last one is the current one
+	runAs       string          // the user a command started with sudo or su -c runs as
+	sudoers     map[string]bool // the users that entered their password for sudo, like sudo's timestamp
+	vars        *templateVars   // the variables the templates set for the session, see templateVarsOf
+	tabPending  bool            // true if tab was pressed without completing anything
+	width       int             // the size of the terminal as negotiated with the client, see TerminalSize
+	height      int
+	sizeLock    *sync.Mutex
+	streams     shellStreams
+	exitCode    int
+	lastStatus  int // the status of the command before the current one, for exit without an argument
+	logger      *glog.Logger
 }
 
-func (fs *FakeShell) User() string {
-	return (*fs.session).User()
-}
 func (fs *FakeShell) Host() string {
 	return gutils.ExtractHostFromAddr((*fs.session).RemoteAddr())
 }
@@ -50,44 +82,136 @@ func (fs *FakeShell) Close() {
 	(*fs.session).Close()
 }
 
+// TerminalSize returns the current number of columns and rows of the terminal.
+func (fs *FakeShell) TerminalSize() (width, height int) {
+	fs.sizeLock.Lock()
+	defer fs.sizeLock.Unlock()
+	return fs.width, fs.height
+}
+
+// Resize changes the size of the terminal, the change is recorded in the session capture.
+func (fs *FakeShell) Resize(width, height int) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	fs.sizeLock.Lock()
+	if width == fs.width && height == fs.height {
+		fs.sizeLock.Unlock()
+		return
+	}
+	fs.width, fs.height = width, height
+	fs.sizeLock.Unlock()
+
+	_ = fs.terminal.SetSize(width, height)
+	fs.stats.recording.AddResizeEvent(width, height)
+	fs.logger.Debug("%s: Terminal resized to %dx%d", fs.osshSession.LogID(), width, height)
+}
+
+// watchWindow applies the window-change requests of the client until the session ends.
+func (fs *FakeShell) watchWindow() {
+	_, winCh, ok := (*fs.session).Pty()
+	if !ok || winCh == nil {
+		return
+	}
+	go func() {
+		for win := range winCh {
+			fs.Resize(win.Width, win.Height)
+		}
+	}()
+}
+
+// templateDirs returns the directories with the command templates of the persona, they override the default ones.
+func (fs *FakeShell) templateDirs() []string {
+	if dir := fs.ffs.Persona().CommandsDir(); dir != "" {
+		return []string{dir}
+	}
+	return nil
+}
+
 func (fs *FakeShell) UpdatePrompt(path string) {
-	fs.prompt = fmt.Sprintf("%s@%s:%s# ", fs.User(), Conf.HostName, path)
+	sign := "$"
+	if fs.isRoot() {
+		sign = "#"
+	}
+	fs.prompt = fmt.Sprintf("%s@%s:%s%s ", fs.User(), fs.ffs.HostName(), path, sign)
 	fs.terminal.SetPrompt(fs.prompt)
 }
 
-func (fs *FakeShell) RecordExec(input, output string) {
-	fs.stats.recording.AddInputEvent(fs.prompt + input)
-	fs.writer.WriteLn(output)
-	fs.stats.recording.AddOutputEvent(output)
+// refreshPrompt updates the prompt after the working directory or the user changed.
+func (fs *FakeShell) refreshPrompt() {
+	if fs.cwd == fs.env.Value("HOME") {
+		fs.UpdatePrompt("~")
+	} else {
+		fs.UpdatePrompt(filepath.Base(fs.cwd))
+	}
 }
 
+func (fs *FakeShell) RecordInput(input string) {
+	fs.stats.recording.AddInputEvent(fs.prompt + strings.ReplaceAll(input, "\n", "\r\n"+fakeShellContinuationPrompt))
+}
+
+// RecordScriptInput marks a command that was read from a script in the session capture,
+// similar to what `set -x` would print.
+func (fs *FakeShell) RecordScriptInput(script string, line int, input string) {
+	fs.stats.recording.AddOutputEvent(fmt.Sprintf("+(%s:%d) %s", script, line, strings.ReplaceAll(input, "\n", "\r\n")))
+}
+
+// RecordWriteLn writes a line to stdout of the current command.
+// Output that goes to the terminal is recorded in the session capture.
 func (fs *FakeShell) RecordWriteLn(output string) {
+	if fs.streams.stdout != nil {
+		fs.streams.stdout.WriteString(output + "\n")
+		return
+	}
 	fs.writer.WriteLn(output)
 	fs.stats.recording.AddOutputEvent(output)
 }
 
 func (fs *FakeShell) RecordWrite(output string) {
+	if fs.streams.stdout != nil {
+		fs.streams.stdout.WriteString(output)
+		return
+	}
 	fs.writer.Write(output)
 	// TODO do we need to record this separately?
 	fs.stats.recording.AddOutputEvent(output)
 }
 
+// RecordErrorLn writes a line to stderr of the current command and marks the command as failed.
+func (fs *FakeShell) RecordErrorLn(output string) {
+	if fs.exitCode == 0 {
+		fs.exitCode = 1
+	}
+	fs.RecordStderrLn(output)
+}
+
+// RecordStderrLn writes a line to stderr of the current command without marking the command as failed,
+// e.g. for progress output.
+func (fs *FakeShell) RecordStderrLn(output string) {
+	if fs.streams.stderr != nil {
+		fs.streams.stderr.WriteString(output + "\n")
+		return
+	}
+	fs.writer.WriteLn(output)
+	fs.stats.recording.AddOutputEvent(output)
+}
+
 // WriteBinary writes a binary value.
 // Unlike the Record* functions it does not record this in the session capture.
 func (fs *FakeShell) WriteBinary(val int) {
-	fs.writer.Write(string(val))
+	fs.writer.Write(string(rune(val)))
 }
 
 // WriteBinary writes a binary value and sends it.
 // Unlike the Record* functions it does not record this in the session capture.
 func (fs *FakeShell) WriteBinaryLn(val int) {
-	fs.writer.WriteLn(string(val))
+	fs.writer.WriteLn(string(rune(val)))
 }
 
 // ReadBytes reads and returns a byte array with the given number of bytes from the SSH session.
 func (fs *FakeShell) ReadBytes(numBytes int) ([]byte, error) {
 	b := make([]byte, numBytes)
-	_, err := (*fs.session).Read(b)
+	_, err := fs.input.Read(b)
 	return b, err
 }
 
@@ -141,224 +265,113 @@ func (fs *FakeShell) ReadBytesUntilEOF(maxBytes int) ([]byte, error) {
 	return bytes, nil
 }
 
-func (fs *FakeShell) Exec(line string, s *Session, iSeq, lSeq int) bool {
-	fs.stats.AddCommandToHistory(line)
-
-	line = string(regexEnvVarPrefixes.ReplaceAll([]byte(line), []byte("$1")))
-
-	pieces := strings.Split(line, " ")
-	command := pieces[0]
-	args := pieces[1:]
-
-	rmtH, rmtP := gutils.SplitHostPortFromAddr((*fs.session).RemoteAddr())
-	lclH, lclP := gutils.SplitHostPortFromAddr((*fs.session).LocalAddr())
-
-	cmd := fmt.Sprintf("%s %s", glog.Reason(command), glog.Wrap(strings.Join(args, " "), glog.LightBlue))
-	if lSeq > 1 {
-		cmd = fmt.Sprintf("(%s/%s) %s", glog.Int(iSeq), glog.Int(lSeq), cmd)
-	}
-	s.UpdateActivity()
-	fs.logger.Info("%s: %s", s.LogID(), cmd)
-	defer func() {
-		s.UpdateActivity()
-	}()
-
-	if !s.Whitelisted {
-		// 1) make sure the client waits some time at least,
-		//    the more input the more wait time, hehe
-		dly := len(line) * int(Conf.InputDelay)
-		gutils.RandomSleep(dly, dly*2, time.Millisecond)
-	}
-
-	// Ignore just pressing enter with whitespace
-	if strings.TrimSpace(line) == "" {
-		return false
-	}
-
-	// 3) check if command should exit immediately
-	for _, cmd := range Conf.Commands.Exit {
-		if strings.HasPrefix(line+"  ", cmd+" ") {
-			fs.RecordExec(line, gutils.GeneratePseudoEmptyString(0)) // just to waste some more time ;)
-			return true
-		}
-	}
-
-	SrvMetrics.IncrementExecutedCommands()
-
-	data := struct {
-		User      string
-		IP        string
-		IPLocal   string
-		Port      int
-		PortLocal int
-		HostName  string
-		InputRaw  string
-		Command   string
-		Arguments []string
-	}{
-		User:      s.User,
-		IP:        rmtH,
-		IPLocal:   lclH,
-		Port:      rmtP,
-		PortLocal: lclP,
-		HostName:  Conf.HostName,
-		InputRaw:  line,
-		Command:   command,
-		Arguments: args,
-	}
-
-	// 3) check if command matches a simple command
-	for _, cmd := range Conf.Commands.Simple {
-		if strings.HasPrefix(line+"  ", cmd[0]+" ") {
-			fs.RecordExec(line, ParseTemplateFromString(cmd[1], data))
-			return false
-		}
-	}
-
-	// 4) check if command should return permission denied error
-	for _, cmd := range Conf.Commands.PermissionDenied {
-		if strings.HasPrefix(line+"  ", cmd+" ") {
-			fs.RecordExec(line, ParseTemplateFromString("{{ .Command }}: permission denied", data))
-			return false
-		}
-	}
-
-	// 5) check if command should return disk i/o error
-	for _, cmd := range Conf.Commands.DiskError {
-		if strings.HasPrefix(line+"  ", cmd+" ") {
-			fs.RecordExec(line, ParseTemplateFromString(gutils.GenerateGarbageString(1000)+"\nend_request: I/O error", data))
-			return false
-		}
-	}
-
-	// 6) check if command should return command not found error
-	for _, cmd := range Conf.Commands.CommandNotFound {
-		if strings.HasPrefix(line+"  ", cmd+" ") {
-			fs.RecordExec(line, ParseTemplateFromString("{{ .Command }}: command not found", data))
-			return false
-		}
-	}
-
-	// 7) check if command should return file not found error
-	for _, cmd := range Conf.Commands.FileNotFound {
-		if strings.HasPrefix(line+"  ", cmd+" ") {
-			fs.RecordExec(line, ParseTemplateFromString("\"{{ .Command }}\": No such file or directory (os error 2)", data))
-			return false
-		}
-	}
-
-	// 8) check if command should return not implemented error
-	for _, cmd := range Conf.Commands.NotImplemented {
-		if strings.HasPrefix(line+" ", cmd+" ") {
-			fs.RecordExec(line, ParseTemplateFromString("{{ .Command }}: Function not implemented", data))
-			return false
-		}
-	}
-
-	// 9) check if command should return bullshit
-	for _, cmd := range Conf.Commands.Bullshit {
-		if strings.HasPrefix(line+" ", cmd+" ") {
-			fs.RecordExec(line, gutils.GenerateGarbageString(1000))
-			return false
-		}
-	}
-
-	instr := strings.TrimSpace(line)
-	instrCmd := strings.Split(instr, " ")[0]
-
-	// 10) check if there is a go-implemented command for this
-	if goCmd, found := CmdLookup[instrCmd]; found {
-		return goCmd(fs, instr)
-	}
-
-	// 11) check if we have a template for the command
-	fs.RecordExec(line, ParseTemplateToString(command, data))
-	return false
-}
-
 func (fs *FakeShell) HandleInput(s *Session) {
+	input, lines := "", 0
 	for {
 		line, err := fs.terminal.ReadLine()
 		if err != nil {
 			if err == io.EOF {
+				if input != "" {
+					_ = fs.Exec(input) // let the parser complain about the incomplete input
+				}
 				break
 			} else {
 				panic(err)
 			}
 		}
 
-		// execute all rewriters
-		for _, rw := range Conf.Commands.Rewriters {
-			re := regexp.MustCompile(rw[0])
-			if re.MatchString(line) {
-				line = re.ReplaceAllString(line, rw[1])
-			}
-		}
+		line = RewriteInput(line)
 
-		lines := strings.Split(line, "\n")
-		mustExit := false
-		for i, ln := range lines {
-			if fs.Exec(ln, s, i+1, len(lines)) {
-				mustExit = true
-				break
-			}
+		if input != "" {
+			input += "\n"
+		}
+		input += line
+
+		// open quotes, here-documents and the like need more lines,
+		// so we ask for them with the secondary prompt
+		// an input that never gets complete is run once it reaches the limits,
+		// the parser reports the unexpected end of file and we start over
+		lines++
+		if lines < fakeShellMaxInputLines && len(input) < fakeShellMaxInputBytes && IsShellInputIncomplete(input) {
+			fs.terminal.SetPrompt(fakeShellContinuationPrompt)
+			continue
 		}
-		if mustExit {
+		fs.terminal.SetPrompt(fs.prompt)
+		lines = 0
+
+		fs.addHistory(input)
+		if fs.Exec(input) {
 			break
 		}
+		fs.reportJobs()
+		input = ""
 	}
 }
 
 func (fs *FakeShell) Process(s *Session) *FakeShellStats {
+	fs.watchWindow()
 	if (*fs.session).RawCommand() != "" {
 		// this means the client passed a command along (e.g. with -t/-tt param),
 		// let's run it and then close the connection.
-		raw := (*fs.session).RawCommand()
-
-		// execute all rewriters
-		for _, rw := range Conf.Commands.Rewriters {
-			re := regexp.MustCompile(rw[0])
-			if re.MatchString(raw) {
-				raw = re.ReplaceAllString(raw, rw[1])
-			}
-		}
-
-		commands := strings.Split(raw, "\n")
-		for i, cmd := range commands {
-			if fs.Exec(cmd, s, i+1, len(commands)) {
-				break
-			}
-		}
+		_ = fs.Exec(RewriteInput((*fs.session).RawCommand()))
 	} else {
+		fs.loadHistory()
 		fs.HandleInput(s)
+		fs.saveHistory()
 	}
+	fs.processes.DetachShell(fs.pid)
 	fs.Close()
 	return fs.stats
 }
 
 func NewFakeShell(s *Session) *FakeShell {
+	width, height := fakeShellInitialWidth, fakeShellInitialHeight
+	if s.Width > 0 && s.Height > 0 {
+		width, height = s.Width, s.Height
+	}
+
 	fs := &FakeShell{
-		session:  s.SSHSession,
-		terminal: nil,
-		writer:   nil,
-		created:  time.Now(),
+		session:     s.SSHSession,
+		osshSession: s,
+		terminal:    nil,
+		writer:      nil,
+		created:     time.Now(),
 		stats: &FakeShellStats{
 			CommandsExecuted: 0,
 			CommandHistory:   []string{},
 			Host:             "",
 			User:             (*s.SSHSession).User(),
-			recording:        utils.NewASCIICastV2(fakeShellInitialWidth, fakeShellInitialHeight),
+			recording:        utils.NewASCIICastV2(width, height),
 		},
-		logger: glog.NewLogger("Fake Shell", glog.OliveGreen, Conf.Debug.FakeShell, logMessageHandler),
+		width:    width,
+		height:   height,
+		sizeLock: &sync.Mutex{},
+		vars:     newTemplateVars(""),
+		logger:   glog.NewLogger("Fake Shell", glog.OliveGreen, Conf.Debug.FakeShell, logMessageHandler),
 	}
 
-	fs.terminal = term.NewTerminal(*s.SSHSession, "")
+	fs.input = newShellInput(*s.SSHSession)
+	fs.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{fs.input, *s.SSHSession}, "")
+	fs.terminal.AutoCompleteCallback = fs.autoComplete
+	_ = fs.terminal.SetSize(width, height)
 	fs.writer = utils.NewSlowWriter(Conf.Ratelimit, fs.terminal)
 	if s.Whitelisted {
 		fs.writer.SetRatelimit(10000) // set ridiculously high to effectively disable rate limit
 	}
 	fs.stats.Host = fs.Host()
+	fs.ffs = s.FFS
+	if fs.ffs == nil {
+		fs.ffs = activeFS
+	}
 	fs.cwd = "/home/" + (*s.SSHSession).User()
+	fs.processes = processTableFor(s.Host, fs.ffs)
+	fs.pid, fs.tty = fs.processes.AttachShell("root")
+	fs.ffs = fs.ffs.ProcessView(fs.processes, fs.pid, func() []string { return fs.env.Environ() })
+	fs.name = "-bash"
+	fs.initEnv()
 	fs.UpdatePrompt("~")
 	fs.logger.Debug("%s: Fake shell ready, current working directory: %s", s.LogID(), glog.File(fs.cwd))
 	return fs
@@ -1,31 +1,55 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	fso "io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/toxyl/glog"
 	"github.com/toxyl/gutils"
 )
 
-type Command func(fs *FakeShell, line string) (exit bool)
+// Command is a Go-implemented command, args[0] is the name the command was invoked with.
+type Command func(fs *FakeShell, args []string) (exit bool)
 
 var CmdLookup = map[string]Command{
-	"cd":    cmdCd,
-	"ls":    cmdLs,
-	"dir":   cmdLs, // TODO make a separate dir command?
-	"pwd":   cmdPwd,
-	"cat":   cmdCat,
-	"touch": cmdTouch,
-	"rm":    cmdRm,
-	"scp":   cmdScp,
+	"cd":       cmdCd,
+	"ls":       cmdLs,
+	"dir":      cmdLs,
+	"pwd":      cmdPwd,
+	"cat":      cmdCat,
+	"echo":     cmdEcho,
+	"touch":    cmdTouch,
+	"rm":       cmdRm,
+	"scp":      cmdScp,
+	"export":   cmdExport,
+	"unset":    cmdUnset,
+	"printenv": cmdPrintenv,
+	"history":  cmdHistory,
+}
+
+func init() {
+	// env runs other commands, registering it directly would be an initialization cycle
+	CmdLookup["env"] = cmdEnv
+
+	// these change how the interpreter runs a loop, see FakeShell.leaveLoop
+	CmdLookup["break"] = cmdBreak
+	CmdLookup["continue"] = cmdBreak
+	CmdLookup["true"] = cmdTrue
+	CmdLookup[":"] = cmdTrue
+	CmdLookup["false"] = cmdFalse
 }
 
 func toAbs(fs *FakeShell, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(fs.env.Value("HOME"), strings.TrimPrefix(path, "~"))
+	}
+
 	if !strings.HasPrefix(path, "/") {
 		path = filepath.Clean(filepath.Join(fs.cwd, path))
 	}
@@ -33,165 +57,285 @@ func toAbs(fs *FakeShell, path string) string {
 	return path
 }
 
-func cmdCd(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
-	var path string
-
-	if len(parts) == 1 {
-		parts = append(parts, "~")
+// errorString returns the error message the C library would print for the given error,
+// e.g. "No such file or directory".
+func errorString(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		msg := errno.Error()
+		return strings.ToUpper(msg[:1]) + msg[1:]
 	}
+	return gutils.GetLastError(err)
+}
 
-	if len(parts) < 2 {
-		path = filepath.Join("/home", fs.User())
-	} else {
-		path = parts[1]
+// stall writes a pseudo empty string to waste the time of bots. It's only written to the terminal,
+// in a pipe or a command substitution it would end up in the output of the command.
+func (fs *FakeShell) stall() {
+	if fs.streams.stdout != nil || fs.job != nil {
+		return
 	}
+	fs.RecordWriteLn(gutils.GeneratePseudoEmptyString(0))
+}
 
-	if strings.HasPrefix(parts[1], "~") {
-		path = filepath.Join("/home", fs.User(), strings.TrimPrefix(path, "~"))
+func cmdCd(fs *FakeShell, args []string) (exit bool) {
+	var dir string
+
+	switch {
+	case len(args) < 2:
+		dir = fs.env.Value("HOME")
+		if dir == "" {
+			fs.RecordErrorLn("-bash: cd: HOME not set")
+			return
+		}
+	case len(args) > 2:
+		fs.RecordErrorLn("-bash: cd: too many arguments")
+		return
+	case args[1] == "-":
+		dir = fs.env.Value("OLDPWD")
+		if dir == "" {
+			fs.RecordErrorLn("-bash: cd: OLDPWD not set")
+			return
+		}
+		fs.RecordWriteLn(dir)
+	default:
+		dir = args[1]
 	}
 
-	path = toAbs(fs, path)
+	path := toAbs(fs, dir)
 
-	if !activeFS.DirExists(path) {
-		fs.RecordWriteLn(fmt.Sprintf("cd: %s: no such file or directory", parts[1]))
+	if !fs.ffs.DirExists(path) {
+		if fs.ffs.FileExists(path) {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: cd: %s: Not a directory", dir))
+		} else {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: cd: %s: No such file or directory", dir))
+		}
 		return
 	}
 
+	fs.env.Set("OLDPWD", fs.cwd)
+	fs.env.Export("OLDPWD")
+	fs.env.Set("PWD", path)
 	fs.cwd = path
 
-	if path == filepath.Join("/home", fs.User()) {
-		fs.UpdatePrompt("~")
-	} else {
-		fs.UpdatePrompt(filepath.Base(path))
-	}
+	fs.refreshPrompt()
 
 	// cd runs way too fast without any output,
 	// let's fuck a bit with the bots
-	fs.RecordWriteLn(gutils.GeneratePseudoEmptyString(0))
+	fs.stall()
 
 	return
 }
 
-func cmdRm(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
-
-	if len(parts) < 2 {
-		fs.RecordWriteLn("rm: missing operand")
-		fs.RecordWriteLn("Try 'rm --help' for more information.")
+func cmdRm(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "rRfidvI", "")
+	if bad != "" {
+		badOption(fs, "rm", bad, 1)
 		return
 	}
+	has := func(c byte) bool {
+		_, ok := flags[c]
+		return ok
+	}
+	recursive, force, verbose := has('r') || has('R'), has('f'), has('v')
+	preserveRoot := true
+	for _, arg := range args[1:] {
+		if arg == "--no-preserve-root" {
+			preserveRoot = false
+		}
+	}
 
-	// TODO handle options
-	parts = gutils.RemoveCommandFlags(parts)
-
-	for _, pt := range parts[1:] {
-		if strings.HasPrefix(pt, "~") {
-			pt = filepath.Join("/home", fs.User(), strings.TrimPrefix(pt, "~"))
+	if len(operands) == 0 {
+		if !force {
+			usageError(fs, "rm", "missing operand")
 		}
-		path := toAbs(fs, pt)
+		return
+	}
 
-		if !activeFS.DirExists(path) && !activeFS.FileExists(path) {
-			fs.RecordWriteLn(fmt.Sprintf("rm: %s: no such file or directory", pt))
-			return
+	for _, op := range operands {
+		path := toAbs(fs, op)
+		if base := filepath.Base(op); base == "." || base == ".." {
+			fs.RecordErrorLn(fmt.Sprintf("rm: refusing to remove '.' or '..' directory: skipping '%s'", op))
+			continue
+		}
+		if path == "/" && recursive && preserveRoot {
+			fs.RecordErrorLn("rm: it is dangerous to operate recursively on '/'")
+			fs.RecordErrorLn("rm: use --no-preserve-root to override this failsafe")
+			continue
 		}
 
-		_ = activeFS.RemoveFile(path, false)
+		info, err := fs.ffs.Lstat(path)
+		if err != nil {
+			if !force {
+				fs.RecordErrorLn(fmt.Sprintf("rm: cannot remove '%s': %s", op, errorString(err)))
+			}
+			continue
+		}
+		if info.IsDir() && !recursive && !has('d') {
+			fs.RecordErrorLn(fmt.Sprintf("rm: cannot remove '%s': Is a directory", op))
+			continue
+		}
+		if err := fs.ffs.RemoveFile(path, recursive); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("rm: cannot remove '%s': %s", op, errorString(err)))
+			continue
+		}
+		if verbose {
+			if info.IsDir() {
+				fs.RecordWriteLn(fmt.Sprintf("removed directory '%s'", op))
+			} else {
+				fs.RecordWriteLn(fmt.Sprintf("removed '%s'", op))
+			}
+		}
 	}
 
 	// rm runs way too fast without any output,
 	// let's fuck a bit with the bots
-	fs.RecordWriteLn(gutils.GeneratePseudoEmptyString(0))
+	fs.stall()
 
 	return
 }
 
-func cmdLs(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
-	// TODO handle options
-	parts = gutils.RemoveCommandFlags(parts)
-
-	var dir string
-	if len(parts) < 2 {
-		dir = fs.cwd
-	} else {
-		dir = toAbs(fs, parts[1])
-	}
+func cmdPwd(fs *FakeShell, args []string) (exit bool) {
+	fs.RecordWriteLn(fs.cwd)
+	return
+}
 
-	entries, err := activeFS.ReadDir(dir)
-	if err != nil {
-		if err.(*os.PathError).Err.Error() != "not a directory" {
-			fs.RecordWriteLn(fmt.Sprintf("ls: cannot access '%s': %s", dir, gutils.GetLastError(err.(*os.PathError).Err)))
-			return
+func cmdCat(fs *FakeShell, args []string) (exit bool) {
+	// TODO handle flags
+	files := []string{}
+	for _, a := range args[1:] {
+		if a == "-" || !strings.HasPrefix(a, "-") {
+			files = append(files, a)
 		}
-
-		// "not a directory" means the path is a file, list it
-		fs.RecordWriteLn(parts[1])
-		return
 	}
 
-	// TODO check term width and fit grid like the real ls
-
-	for _, entry := range entries {
-		fs.RecordWrite(entry.Name())
-		fs.RecordWrite(" ")
+	if len(files) == 0 {
+		files = append(files, "-")
 	}
-	fs.RecordWrite("\n")
 
-	return
-}
+	for _, f := range files {
+		if f == "-" {
+			stdin, ok := fs.Stdin()
+			if !ok {
+				fs.RecordErrorLn("cat: specify file")
+				continue
+			}
+			fs.RecordWrite(stdin)
+			continue
+		}
 
-func cmdPwd(fs *FakeShell, line string) (exit bool) {
-	fs.RecordWriteLn(fs.cwd)
-	return
-}
+		path := toAbs(fs, f)
+		if fs.ffs.DirExists(path) {
+			fs.RecordErrorLn(fmt.Sprintf("cat: %s: Is a directory", f))
+			continue
+		}
 
-func cmdCat(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
+		fileContents, err := fs.ffs.ReadFile(path)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("cat: %s: %s", f, errorString(err)))
+			continue
+		}
 
-	if len(parts) < 2 {
-		// TODO echo input, like the real `cat` command
-		fs.RecordWriteLn("cat: specify file")
-		return
+		fs.RecordWrite(string(fileContents))
 	}
 
-	// TODO handle flags
-	parts = gutils.RemoveCommandFlags(parts)
+	return
+}
 
-	path := toAbs(fs, parts[1])
-	file, err := activeFS.OpenFile(path, os.O_RDONLY, 0)
-	if err != nil {
-		fs.RecordWriteLn(fmt.Sprintf("cat: %s: %s", parts[1], gutils.GetLastError(err)))
-		return
+// unescapeEcho interprets the backslash escapes `echo -e` understands.
+// The second return value is true if the output must stop (\c).
+func unescapeEcho(s string) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'c':
+			return sb.String(), true
+		case 'e', 'E':
+			sb.WriteByte(0x1b)
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\':
+			sb.WriteByte('\\')
+		case '0', 'x':
+			// \0nnn (octal, up to 3 digits) and \xHH (hex, up to 2 digits)
+			base, maxDigits, digits := 8, 3, "01234567"
+			if s[i] == 'x' {
+				base, maxDigits, digits = 16, 2, "0123456789abcdefABCDEF"
+			}
+			j := i + 1
+			for j < len(s) && j-i-1 < maxDigits && strings.IndexByte(digits, s[j]) >= 0 {
+				j++
+			}
+			if s[i] == 'x' && j == i+1 {
+				sb.WriteString("\\x") // no hex digits, bash prints it as is
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], base, 8)
+			sb.WriteByte(byte(n))
+			i = j - 1
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		}
 	}
-	defer file.Close()
+	return sb.String(), false
+}
 
-	stat, err := file.Stat()
-	if err != nil {
-		fs.RecordWriteLn(fmt.Sprintf("cat: %s: %s", parts[1], gutils.GetLastError(err)))
-		return
+func cmdEcho(fs *FakeShell, args []string) (exit bool) {
+	newline, escapes := true, false
+	i := 1
+	for ; i < len(args); i++ {
+		a := args[i]
+		if len(a) < 2 || a[0] != '-' || strings.Trim(a[1:], "neE") != "" {
+			break
+		}
+		for _, f := range a[1:] {
+			switch f {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
 	}
 
-	if stat.IsDir() {
-		fs.RecordWriteLn(fmt.Sprintf("cat: %s: Is a directory", parts[1]))
-		return
+	output := strings.Join(args[i:], " ")
+	if escapes {
+		stop := false
+		output, stop = unescapeEcho(output)
+		newline = newline && !stop
 	}
 
-	fileContents, err := io.ReadAll(file)
-	if err != nil {
-		fs.RecordWriteLn(fmt.Sprintf("cat: %s: %s", parts[1], gutils.GetLastError(err)))
-		return
+	if newline {
+		fs.RecordWriteLn(output)
+	} else {
+		fs.RecordWrite(output)
 	}
-
-	fs.RecordWrite(string(fileContents))
-
 	return
 }
 
-func cmdTouch(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
+func cmdTouch(fs *FakeShell, args []string) (exit bool) {
+	parts := args
 	if len(parts) < 2 {
-		fs.RecordWriteLn("touch: specify file")
+		fs.RecordErrorLn("touch: specify file")
 		return
 	}
 
@@ -199,9 +343,9 @@ func cmdTouch(fs *FakeShell, line string) (exit bool) {
 	parts = gutils.RemoveCommandFlags(parts)
 
 	path := toAbs(fs, parts[1])
-	file, err := activeFS.OpenFile(path, os.O_CREATE, 0)
+	file, err := fs.ffs.OpenFile(path, os.O_CREATE, 0666&^fakeShellUmask)
 	if err != nil {
-		fs.RecordWriteLn(fmt.Sprintf("touch: %s: %s", parts[1], gutils.GetLastError(err)))
+		fs.RecordErrorLn(fmt.Sprintf("touch: %s: %s", parts[1], gutils.GetLastError(err)))
 		return
 	}
 	defer file.Close()
@@ -209,12 +353,12 @@ func cmdTouch(fs *FakeShell, line string) (exit bool) {
 	return
 }
 
-func cmdScp(fs *FakeShell, line string) (exit bool) {
-	parts := strings.Split(line, " ")
+func cmdScp(fs *FakeShell, args []string) (exit bool) {
+	parts := args
 	if len(parts) < 2 {
-		fs.RecordWriteLn("usage: scp [-346ABCpqrTv] [-c cipher] [-F ssh_config] [-i identity_file]")
-		fs.RecordWriteLn("[-J destination] [-l limit] [-o ssh_option] [-P port]")
-		fs.RecordWriteLn("[-S program] source ... target")
+		fs.RecordErrorLn("usage: scp [-346ABCpqrTv] [-c cipher] [-F ssh_config] [-i identity_file]")
+		fs.RecordErrorLn("[-J destination] [-l limit] [-o ssh_option] [-P port]")
+		fs.RecordErrorLn("[-S program] source ... target")
 		return
 	}
 
@@ -271,12 +415,13 @@ func cmdScp(fs *FakeShell, line string) (exit bool) {
 				fs.WriteBinary(0b0) // ready to receive
 
 				path := toAbs(fs, msgFileNameFull)
-				if activeFS == nil {
+				if fs.ffs == nil {
 					fs.logger.Error("scp: %s: %s", msgFileNameStr, glog.Reason("no OverlayFS available!"))
 					return
 				}
 
-				file, err := activeFS.OpenFile(path, os.O_RDWR|os.O_CREATE, fso.FileMode(gutils.BytesToInt(msgMode, 0777)))
+				written := fs.watchPersistence(path)
+				file, err := fs.ffs.OpenFile(path, os.O_RDWR|os.O_CREATE, fso.FileMode(gutils.BytesToInt(msgMode, 0777)))
 				if err != nil && gutils.GetLastError(err) != "is a directory" {
 					fs.logger.Error("scp: %s: %s", msgFileNameStr, gutils.GetLastError(err))
 					return
@@ -290,6 +435,7 @@ func cmdScp(fs *FakeShell, line string) (exit bool) {
 				}
 
 				_, _ = file.Write(msgFileData)
+				written()
 
 				fs.logger.OK("File uploaded via SCP: %s", glog.File(msgFileNameFull))
 				fpath := filepath.Clean(fmt.Sprintf("%s/scp-uploads/%s", Conf.PathCaptures, msgFileNameFull))
@@ -327,13 +473,13 @@ func cmdScp(fs *FakeShell, line string) (exit bool) {
 				msgDirNameStr := string(msgDirName)
 				msgDirNameStr = strings.Trim(msgDirNameStr, "'\"")
 
-				if activeFS == nil {
+				if fs.ffs == nil {
 					fs.logger.Error("scp: %s: %s", msgDirNameStr, glog.Reason("no OverlayFS available!"))
 					return
 				}
 
 				dirs = append(dirs, msgDirNameStr)
-				_ = activeFS.MkdirAll(strings.Join(dirs, "/"), fso.FileMode(gutils.BytesToInt(msgMode, 0777)))
+				_ = fs.ffs.MkdirAll(strings.Join(dirs, "/"), fso.FileMode(gutils.BytesToInt(msgMode, 0777)))
 
 				fs.WriteBinary(0b0) // data read
 				continue
@@ -358,3 +504,163 @@ func cmdScp(fs *FakeShell, line string) (exit bool) {
 	}
 	return false
 }
+
+func isShellName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isShellNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func cmdExport(fs *FakeShell, args []string) (exit bool) {
+	names := []string{}
+	for _, arg := range args[1:] {
+		if arg != "-p" && arg != "--" {
+			names = append(names, arg)
+		}
+	}
+
+	if len(names) == 0 {
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+		for _, name := range fs.env.ExportedNames() {
+			fs.RecordWriteLn(fmt.Sprintf("declare -x %s=\"%s\"", name, r.Replace(fs.env.Value(name))))
+		}
+		return
+	}
+
+	for _, arg := range names {
+		name, value, ok := splitAssignment(arg)
+		if !ok {
+			name = arg
+		}
+		if !isShellName(name) {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: export: `%s': not a valid identifier", arg))
+			continue
+		}
+		if ok {
+			fs.env.Set(name, value)
+		}
+		fs.env.Export(name)
+	}
+	return
+}
+
+func cmdUnset(fs *FakeShell, args []string) (exit bool) {
+	for _, name := range args[1:] {
+		if name == "-v" || name == "-f" || name == "--" {
+			continue
+		}
+		if !isShellName(name) {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: unset: `%s': not a valid identifier", name))
+			continue
+		}
+		fs.env.Unset(name)
+	}
+	return
+}
+
+func cmdEnv(fs *FakeShell, args []string) (exit bool) {
+	args = args[1:]
+	ignoreEnv := false
+	vars := map[string]string{}
+
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "-i" || arg == "-" || arg == "--ignore-environment" {
+			ignoreEnv = true
+		} else if name, value, ok := splitAssignment(arg); ok {
+			vars[name] = value
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+
+	if ignoreEnv {
+		// the command gets a clean environment, the shell's variables are restored afterwards
+		env := fs.env
+		fs.env = NewShellEnv()
+		defer func() { fs.env = env }()
+	}
+
+	if len(args) == 0 {
+		env := fs.env.Clone()
+		for name, value := range vars {
+			env.Set(name, value)
+			env.Export(name)
+		}
+		for _, kv := range env.Environ() {
+			fs.RecordWriteLn(kv)
+		}
+		return
+	}
+
+	return fs.execCommandWithEnv(args, vars)
+}
+
+func cmdPrintenv(fs *FakeShell, args []string) (exit bool) {
+	if len(args) < 2 {
+		for _, kv := range fs.env.Environ() {
+			fs.RecordWriteLn(kv)
+		}
+		return
+	}
+
+	missing := false
+	for _, name := range args[1:] {
+		if !fs.env.IsExported(name) {
+			missing = true
+			continue
+		}
+		fs.RecordWriteLn(fs.env.Value(name))
+	}
+	if missing {
+		fs.exitCode = 1
+	}
+	return
+}
+
+func cmdTrue(fs *FakeShell, args []string) (exit bool) {
+	return
+}
+
+func cmdFalse(fs *FakeShell, args []string) (exit bool) {
+	fs.exitCode = 1
+	return
+}
+
+// cmdBreak handles `break [N]` and `continue [N]`, the loops pick up the pending levels.
+func cmdBreak(fs *FakeShell, args []string) (exit bool) {
+	name := args[0]
+	if fs.loops == 0 {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: only meaningful in a `for', `while', or `until' loop", name))
+		fs.exitCode = 0
+		return
+	}
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s: numeric argument required", name, args[1]))
+			fs.exitCode = 128
+			n = fs.loops
+		} else if n < 1 {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %d: loop count out of range", name, n))
+			return
+		}
+	}
+	if n > fs.loops {
+		n = fs.loops
+	}
+	if name == "break" {
+		fs.breaks = n
+	} else {
+		fs.continues = n
+	}
+	return
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	fso "io/fs"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	CmdLookup["test"] = cmdTest
+	CmdLookup["["] = cmdTest
+}
+
+// testError is a syntax error in the expression of test, it makes test exit with 2.
+type testError string
+
+func (e testError) Error() string { return string(e) }
+
+// testExpr evaluates the arguments of test with the precedence of bash: ! binds tighter than -a, which
+// binds tighter than -o. Up to four arguments are evaluated by their number like POSIX describes it.
+type testExpr struct {
+	fs   *FakeShell
+	args []string
+	pos  int
+}
+
+func cmdTest(fs *FakeShell, args []string) (exit bool) {
+	name := args[0]
+	operands := args[1:]
+	if name == "[" {
+		if len(operands) == 0 || operands[len(operands)-1] != "]" {
+			fs.RecordErrorLn("-bash: [: missing `]'")
+			fs.exitCode = 2
+			return
+		}
+		operands = operands[:len(operands)-1]
+	}
+
+	t := &testExpr{fs: fs, args: operands}
+	ok, err := t.eval()
+	switch {
+	case err != nil:
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s", name, err))
+		fs.exitCode = 2
+	case !ok:
+		fs.exitCode = 1
+	}
+	return
+}
+
+func (t *testExpr) eval() (bool, error) {
+	switch len(t.args) {
+	case 0:
+		return false, nil
+	case 1:
+		return t.args[0] != "", nil
+	case 2:
+		if t.args[0] == "!" {
+			return t.args[1] == "", nil
+		}
+		if testUnary(t.args[0]) {
+			return t.unary(t.args[0], t.args[1])
+		}
+		return false, testError(t.args[0] + ": unary operator expected")
+	case 3:
+		if testBinary(t.args[1]) {
+			return t.binary(t.args[0], t.args[1], t.args[2])
+		}
+	}
+	ok, err := t.or()
+	if err == nil && t.pos < len(t.args) {
+		err = testError("too many arguments")
+	}
+	return ok, err
+}
+
+func (t *testExpr) next() (string, bool) {
+	if t.pos >= len(t.args) {
+		return "", false
+	}
+	t.pos++
+	return t.args[t.pos-1], true
+}
+
+func (t *testExpr) or() (bool, error) {
+	ok, err := t.and()
+	for err == nil && t.pos < len(t.args) && t.args[t.pos] == "-o" {
+		t.pos++
+		var right bool
+		right, err = t.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (t *testExpr) and() (bool, error) {
+	ok, err := t.not()
+	for err == nil && t.pos < len(t.args) && t.args[t.pos] == "-a" {
+		t.pos++
+		var right bool
+		right, err = t.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (t *testExpr) not() (bool, error) {
+	if t.pos < len(t.args) && t.args[t.pos] == "!" {
+		t.pos++
+		ok, err := t.not()
+		return !ok, err
+	}
+	return t.primary()
+}
+
+func (t *testExpr) primary() (bool, error) {
+	arg, ok := t.next()
+	if !ok {
+		return false, testError("argument expected")
+	}
+	if arg == "(" {
+		res, err := t.or()
+		if err != nil {
+			return false, err
+		}
+		if closing, _ := t.next(); closing != ")" {
+			return false, testError("`)' expected")
+		}
+		return res, nil
+	}
+	if t.pos+1 < len(t.args) && testBinary(t.args[t.pos]) {
+		op, right := t.args[t.pos], t.args[t.pos+1]
+		t.pos += 2
+		return t.binary(arg, op, right)
+	}
+	if testUnary(arg) {
+		operand, ok := t.next()
+		if !ok {
+			return false, testError(arg + ": unary operator expected")
+		}
+		return t.unary(arg, operand)
+	}
+	return arg != "", nil
+}
+
+func testUnary(op string) bool {
+	return len(op) == 2 && op[0] == '-' && strings.IndexByte("bcdefghkLnOprsStuwxzGN", op[1]) >= 0
+}
+
+func testBinary(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge", "-nt", "-ot", "-ef":
+		return true
+	}
+	return false
+}
+
+func (t *testExpr) unary(op, operand string) (bool, error) {
+	switch op {
+	case "-z":
+		return operand == "", nil
+	case "-n":
+		return operand != "", nil
+	case "-t":
+		return false, nil // the terminal is never a terminal of ours
+	}
+
+	path := toAbs(t.fs, operand)
+	if op == "-h" || op == "-L" {
+		info, err := t.fs.ffs.Lstat(path)
+		return err == nil && info.Mode()&fso.ModeSymlink != 0, nil
+	}
+	info, err := t.fs.ffs.Stat(path)
+	if operand == "" || err != nil {
+		return false, nil
+	}
+	mode := info.Mode()
+	switch op {
+	case "-e":
+		return true, nil
+	case "-f":
+		return mode.IsRegular(), nil
+	case "-d":
+		return mode.IsDir(), nil
+	case "-s":
+		return info.Size() > 0, nil
+	case "-b":
+		return mode&fso.ModeDevice != 0 && mode&fso.ModeCharDevice == 0, nil
+	case "-c":
+		return mode&fso.ModeCharDevice != 0, nil
+	case "-p":
+		return mode&fso.ModeNamedPipe != 0, nil
+	case "-S":
+		return mode&fso.ModeSocket != 0, nil
+	case "-u":
+		return mode&fso.ModeSetuid != 0, nil
+	case "-g":
+		return mode&fso.ModeSetgid != 0, nil
+	case "-k":
+		return mode&fso.ModeSticky != 0, nil
+	case "-r":
+		return t.fs.mayAccess(info, 4), nil
+	case "-w":
+		return t.fs.mayAccess(info, 2), nil
+	case "-x":
+		return t.fs.mayAccess(info, 1), nil
+	case "-O", "-G":
+		uid, gid, _ := t.fs.lookupUser(t.fs.User())
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if op == "-O" {
+				return int(st.Uid) == uid, nil
+			}
+			return int(st.Gid) == gid, nil
+		}
+		return uid == 0, nil
+	case "-N":
+		return true, nil
+	}
+	return false, nil
+}
+
+// mayAccess returns whether the user of the shell may read (4), write (2) or execute (1) the file.
+// Like for the kernel, root may do everything except executing files no one may execute.
+func (fs *FakeShell) mayAccess(info fso.FileInfo, bit fso.FileMode) bool {
+	mode := info.Mode()
+	if fs.isRoot() {
+		return bit != 1 || mode.IsDir() || mode&0111 != 0
+	}
+	uid, gid, _ := fs.lookupUser(fs.User())
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		switch {
+		case int(st.Uid) == uid:
+			return mode&(bit<<6) != 0
+		case int(st.Gid) == gid:
+			return mode&(bit<<3) != 0
+		}
+	}
+	return mode&bit != 0
+}
+
+func (t *testExpr) binary(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-nt", "-ot", "-ef":
+		a, errA := t.fs.ffs.Stat(toAbs(t.fs, left))
+		b, errB := t.fs.ffs.Stat(toAbs(t.fs, right))
+		switch op {
+		case "-nt":
+			return errA == nil && (errB != nil || a.ModTime().After(b.ModTime())), nil
+		case "-ot":
+			return errB == nil && (errA != nil || a.ModTime().Before(b.ModTime())), nil
+		}
+		return errA == nil && errB == nil && toAbs(t.fs, left) == toAbs(t.fs, right), nil
+	}
+
+	a, err := testInteger(left)
+	if err != nil {
+		return false, err
+	}
+	b, err := testInteger(right)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	}
+	return a >= b, nil
+}
+
+// testInteger parses an operand of an arithmetic comparison, surrounding blanks are allowed.
+func testInteger(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, testError(s + ": integer expression expected")
+	}
+	return n, nil
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+func init() {
+	CmdLookup["vi"] = cmdVi
+	CmdLookup["vim"] = cmdVi
+	CmdLookup["vim.tiny"] = cmdVi
+	CmdLookup["nano"] = cmdNano
+}
+
+// textBuffer is a file opened in an editor.
+type textBuffer struct {
+	name     string // the file name as the user gave it, empty for a new buffer
+	path     string
+	lines    [][]rune
+	row, col int // the cursor
+	top      int // the first line on the screen
+	modified bool
+	isNew    bool   // the file didn't exist when it was opened
+	cut      []rune // the lines cut with ^K in nano
+}
+
+// openTextBuffer reads a file into a buffer, a file that doesn't exist yet gives an empty buffer.
+// The error is the message the editor shows, e.g. for a directory.
+func (fs *FakeShell) openTextBuffer(name string) (*textBuffer, string) {
+	b := &textBuffer{name: name, lines: [][]rune{{}}}
+	if name == "" {
+		return b, ""
+	}
+	b.path = toAbs(fs, name)
+	if fs.ffs.DirExists(b.path) {
+		return b, fmt.Sprintf("\"%s\" is a directory", name)
+	}
+	data, err := fs.ffs.ReadFile(b.path)
+	if err != nil {
+		b.isNew = true
+		return b, ""
+	}
+	content := strings.TrimSuffix(string(data), "\n")
+	if content != "" {
+		b.lines = nil
+		for _, line := range strings.Split(content, "\n") {
+			b.lines = append(b.lines, []rune(line))
+		}
+	}
+	return b, ""
+}
+
+// text returns the content of the buffer, every line ends with a newline like vi and nano write it.
+func (b *textBuffer) text() string {
+	if len(b.lines) == 1 && len(b.lines[0]) == 0 {
+		return ""
+	}
+	lines := make([]string, len(b.lines))
+	for i, line := range b.lines {
+		lines[i] = string(line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lineCount returns the number of lines that are written to the file.
+func (b *textBuffer) lineCount() int {
+	if b.text() == "" {
+		return 0
+	}
+	return len(b.lines)
+}
+
+// save writes the buffer to the file, persistence is detected like for any other write.
+func (fs *FakeShell) saveTextBuffer(b *textBuffer, name string) error {
+	path := toAbs(fs, name)
+	if fs.ffs.DirExists(path) {
+		return syscall.EISDIR
+	}
+	written := fs.watchPersistence(path)
+	if err := fs.ffs.WriteFile(path, []byte(b.text()), 0644); err != nil {
+		return err
+	}
+	written()
+	if b.path == "" || b.path == path {
+		b.name, b.path, b.modified = name, path, false
+	}
+	return nil
+}
+
+func (b *textBuffer) line() []rune {
+	return b.lines[b.row]
+}
+
+// moveTo puts the cursor on the line and column, both are kept inside the buffer.
+// With end the cursor may stand behind the last character, like in insert mode.
+func (b *textBuffer) moveTo(row, col int, end bool) {
+	b.row = maxInt(0, minInt(row, len(b.lines)-1))
+	last := len(b.line())
+	if !end && last > 0 {
+		last--
+	}
+	b.col = maxInt(0, minInt(col, last))
+}
+
+// firstNonBlank returns the column of the first character of the line that isn't white space.
+func (b *textBuffer) firstNonBlank() int {
+	for i, r := range b.line() {
+		if !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return 0
+}
+
+func (b *textBuffer) insert(r rune) {
+	line := b.line()
+	line = append(line[:b.col], append([]rune{r}, line[b.col:]...)...)
+	b.lines[b.row] = line
+	b.col++
+	b.modified = true
+}
+
+// newline splits the line at the cursor.
+func (b *textBuffer) newline() {
+	line := b.line()
+	rest := append([]rune{}, line[b.col:]...)
+	b.lines[b.row] = line[:b.col]
+	b.insertLine(b.row+1, rest)
+	b.row, b.col = b.row+1, 0
+}
+
+func (b *textBuffer) insertLine(row int, line []rune) {
+	b.lines = append(b.lines, nil)
+	copy(b.lines[row+1:], b.lines[row:])
+	b.lines[row] = line
+	b.modified = true
+}
+
+// deleteLine removes the line and returns it, the last line of a buffer is only emptied.
+func (b *textBuffer) deleteLine(row int) []rune {
+	line := b.lines[row]
+	if len(b.lines) == 1 {
+		b.lines[0] = []rune{}
+	} else {
+		b.lines = append(b.lines[:row], b.lines[row+1:]...)
+	}
+	b.modified = true
+	return line
+}
+
+// backspace removes the character before the cursor, at the start of a line it joins it with the previous one.
+func (b *textBuffer) backspace() {
+	if b.col > 0 {
+		b.col--
+		b.deleteRune(false)
+		return
+	}
+	if b.row == 0 {
+		return
+	}
+	line := b.deleteLine(b.row)
+	b.row--
+	b.col = len(b.line())
+	b.lines[b.row] = append(b.line(), line...)
+}
+
+// deleteRune removes the character under the cursor, with join the next line is appended at the end of a line.
+func (b *textBuffer) deleteRune(join bool) {
+	line := b.line()
+	if b.col < len(line) {
+		b.lines[b.row] = append(line[:b.col], line[b.col+1:]...)
+		b.modified = true
+	} else if join && b.row+1 < len(b.lines) {
+		next := b.deleteLine(b.row + 1)
+		b.lines[b.row] = append(b.line(), next...)
+	}
+}
+
+// view returns the rows of the screen that show the buffer and where the cursor is on it.
+// Long lines wrap, rows after the end of the buffer are filled with the filler.
+func (b *textBuffer) view(width, height int, filler string) (rows []string, col, row int) {
+	if b.row < b.top {
+		b.top = b.row
+	}
+	offset := len([]rune(expandTabs(string(b.line()[:b.col]))))
+	for {
+		rows, row = []string{}, -1
+		for i := b.top; i < len(b.lines) && len(rows) < height; i++ {
+			if i == b.row {
+				row, col = len(rows)+offset/width, offset%width
+			}
+			rows = append(rows, wrapRows(string(b.lines[i]), width)...)
+		}
+		if (row >= 0 && row < height) || b.top >= b.row {
+			break
+		}
+		b.top++
+	}
+	for len(rows) < height {
+		rows = append(rows, filler)
+	}
+	return rows[:height], col, minInt(row, height-1)
+}
+
+// editorOf returns the editor the user prefers, like sensible-editor it looks at $VISUAL and $EDITOR.
+func (fs *FakeShell) editorOf() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := fs.env.Value(name); editor != "" {
+			return filepath.Base(strings.Fields(editor)[0])
+		}
+	}
+	return "nano"
+}
+
+// editFile opens the file in the editor of the user. It returns false if there is no terminal to edit in.
+func (fs *FakeShell) editFile(name string) bool {
+	sc, ok := fs.OpenScreen(true)
+	if !ok {
+		return false
+	}
+	defer sc.Close()
+	if strings.HasPrefix(fs.editorOf(), "vi") {
+		fs.runVi(sc, name)
+	} else {
+		fs.runNano(sc, name)
+	}
+	return true
+}
+
+// editorFiles returns the files given to an editor, options are skipped.
+func editorFiles(args []string) []string {
+	files := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			return append(files, args[i+1:]...)
+		}
+		if arg != "-" && !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+			files = append(files, arg)
+		}
+	}
+	return files
+}
+
+const (
+	viNormal = iota
+	viInsert
+	viCommand
+)
+
+// viEditor is a minimal vi: normal, insert and command-line mode with the commands people use to get a file
+// written and vi closed again.
+type viEditor struct {
+	fs      *FakeShell
+	sc      *Screen
+	buf     *textBuffer
+	mode    int
+	command []rune
+	message string
+	prefix  rune // the first key of commands like dd, gg and ZZ
+	done    bool
+}
+
+func cmdVi(fs *FakeShell, args []string) (exit bool) {
+	name := ""
+	if files := editorFiles(args[1:]); len(files) > 0 {
+		name = files[0]
+	}
+	sc, ok := fs.OpenScreen(true)
+	if !ok {
+		fs.RecordStderrLn("Vim: Warning: Output is not to a terminal")
+		fs.RecordErrorLn("Vim: Error reading input, exiting...")
+		return
+	}
+	defer sc.Close()
+	fs.runVi(sc, name)
+	return
+}
+
+func (fs *FakeShell) runVi(sc *Screen, name string) {
+	buf, message := fs.openTextBuffer(name)
+	vi := &viEditor{fs: fs, sc: sc, buf: buf, message: message}
+	if message == "" && name != "" {
+		if buf.isNew {
+			vi.message = fmt.Sprintf("\"%s\" [New]", name)
+		} else {
+			vi.message = fmt.Sprintf("\"%s\" %dL, %dB", name, buf.lineCount(), len(buf.text()))
+		}
+	}
+	for !vi.done {
+		vi.render()
+		k, err := sc.ReadKey()
+		if err != nil {
+			return
+		}
+		switch vi.mode {
+		case viNormal:
+			vi.normalKey(k)
+		case viInsert:
+			vi.insertKey(k)
+		case viCommand:
+			vi.commandKey(k)
+		}
+	}
+}
+
+func (vi *viEditor) render() {
+	width, height := vi.sc.Size()
+	rows, col, row := vi.buf.view(width, height-1, "\x1b[94m~")
+	status := vi.message
+	switch vi.mode {
+	case viInsert:
+		status = "\x1b[1m-- INSERT --"
+	case viCommand:
+		status = ":" + string(vi.command)
+		col, row = len([]rune(status)), height-1
+	}
+	vi.sc.Render(append(rows, status), minInt(col, width-1), row)
+}
+
+func (vi *viEditor) normalKey(k Key) {
+	b := vi.buf
+	_, height := vi.sc.Size()
+	prefix := vi.prefix
+	vi.prefix = 0
+	switch {
+	case k.Is('h') || k.Code == KeyLeft || k.Code == KeyBackspace:
+		b.moveTo(b.row, b.col-1, false)
+	case k.Is('l') || k.Code == KeyRight || k.Is(' '):
+		b.moveTo(b.row, b.col+1, false)
+	case k.Is('j') || k.Code == KeyDown || k.IsCtrl('n'):
+		b.moveTo(b.row+1, b.col, false)
+	case k.Code == KeyEnter || k.Is('+'):
+		b.moveTo(b.row+1, 0, false)
+		b.moveTo(b.row, b.firstNonBlank(), false)
+	case k.Is('k') || k.Code == KeyUp || k.IsCtrl('p'):
+		b.moveTo(b.row-1, b.col, false)
+	case k.Is('-'):
+		b.moveTo(b.row-1, 0, false)
+		b.moveTo(b.row, b.firstNonBlank(), false)
+	case k.Is('0') || k.Code == KeyHome:
+		b.moveTo(b.row, 0, false)
+	case k.Is('^'):
+		b.moveTo(b.row, b.firstNonBlank(), false)
+	case k.Is('$') || k.Code == KeyEnd:
+		b.moveTo(b.row, len(b.line()), false)
+	case k.Is('G'):
+		b.moveTo(len(b.lines)-1, 0, false)
+	case k.Is('g') && prefix == 'g':
+		b.moveTo(0, 0, false)
+	case k.Code == KeyPageDown || k.IsCtrl('f'):
+		b.moveTo(b.row+height-3, b.col, false)
+	case k.Code == KeyPageUp || k.IsCtrl('b'):
+		b.moveTo(b.row-height+3, b.col, false)
+	case k.Is('x') || k.Code == KeyDelete:
+		b.deleteRune(false)
+		b.moveTo(b.row, b.col, false)
+	case k.Is('X'):
+		if b.col > 0 {
+			b.backspace()
+		}
+	case k.Is('d') && prefix == 'd':
+		b.deleteLine(b.row)
+		b.moveTo(b.row, b.firstNonBlank(), false)
+	case k.Is('D'):
+		b.lines[b.row] = b.line()[:b.col]
+		b.modified = true
+		b.moveTo(b.row, b.col, false)
+	case k.Is('i'):
+		vi.mode = viInsert
+	case k.Is('I'):
+		b.moveTo(b.row, b.firstNonBlank(), true)
+		vi.mode = viInsert
+	case k.Is('a'):
+		b.moveTo(b.row, b.col+1, true)
+		vi.mode = viInsert
+	case k.Is('A'):
+		b.moveTo(b.row, len(b.line()), true)
+		vi.mode = viInsert
+	case k.Is('o'):
+		b.insertLine(b.row+1, []rune{})
+		b.moveTo(b.row+1, 0, true)
+		vi.mode = viInsert
+	case k.Is('O'):
+		b.insertLine(b.row, []rune{})
+		b.moveTo(b.row, 0, true)
+		vi.mode = viInsert
+	case k.Is(':'):
+		vi.mode, vi.command = viCommand, nil
+	case k.Is('Z') && prefix == 'Z':
+		vi.execute("x")
+	case k.Is('Q') && prefix == 'Z':
+		vi.execute("q!")
+	case k.Is('u'):
+		vi.message = "Already at oldest change"
+	case k.IsCtrl('c'):
+		vi.message = "Type  :qa!  and press <Enter> to abandon all changes and exit Vim"
+	case k.Is('d') || k.Is('g') || k.Is('Z'):
+		vi.prefix = k.Rune
+	}
+	if vi.mode != viNormal {
+		vi.message = ""
+	}
+}
+
+func (vi *viEditor) insertKey(k Key) {
+	b := vi.buf
+	switch k.Code {
+	case KeyRune:
+		b.insert(k.Rune)
+	case KeyTab:
+		b.insert('\t')
+	case KeyEnter:
+		b.newline()
+	case KeyBackspace:
+		b.backspace()
+	case KeyDelete:
+		b.deleteRune(true)
+	case KeyLeft:
+		b.moveTo(b.row, b.col-1, true)
+	case KeyRight:
+		b.moveTo(b.row, b.col+1, true)
+	case KeyUp:
+		b.moveTo(b.row-1, b.col, true)
+	case KeyDown:
+		b.moveTo(b.row+1, b.col, true)
+	case KeyHome:
+		b.moveTo(b.row, 0, true)
+	case KeyEnd:
+		b.moveTo(b.row, len(b.line()), true)
+	case KeyEscape:
+		vi.mode = viNormal
+		b.moveTo(b.row, b.col-1, false)
+	case KeyCtrl:
+		if k.Rune == 'c' {
+			vi.mode = viNormal
+			b.moveTo(b.row, b.col-1, false)
+		}
+	}
+}
+
+func (vi *viEditor) commandKey(k Key) {
+	switch {
+	case k.Code == KeyEnter:
+		vi.mode = viNormal
+		vi.execute(strings.TrimSpace(string(vi.command)))
+	case k.Code == KeyEscape || k.IsCtrl('c') || (k.Code == KeyBackspace && len(vi.command) == 0):
+		vi.mode = viNormal
+	default:
+		vi.command, _ = editLine(vi.command, k)
+	}
+}
+
+// execute runs a command of the command line.
+func (vi *viEditor) execute(command string) {
+	b := vi.buf
+	if n, err := strconv.Atoi(command); err == nil {
+		b.moveTo(n-1, 0, false)
+		b.moveTo(b.row, b.firstNonBlank(), false)
+		return
+	}
+	name, arg, _ := strings.Cut(command, " ")
+	arg = strings.TrimSpace(arg)
+	force := strings.HasSuffix(name, "!")
+	name = strings.TrimSuffix(name, "!")
+	switch name {
+	case "":
+	case "w", "wq", "x", "wa", "wqa", "xa", "up", "update":
+		if (name == "x" || name == "xa" || strings.HasPrefix(name, "up")) && !b.modified && arg == "" {
+			vi.done = name != "up" && name != "update"
+			return
+		}
+		if !vi.write(arg, force) {
+			return
+		}
+		vi.done = name != "w" && name != "wa" && !strings.HasPrefix(name, "up")
+	case "q", "qa", "quit", "qall":
+		if b.modified && !force {
+			vi.message = "\x1b[97;41mE37: No write since last change (add ! to override)"
+			return
+		}
+		vi.done = true
+	case "set", "se", "syntax", "sy":
+		// settings don't change anything here
+	default:
+		vi.message = "\x1b[97;41mE492: Not an editor command: " + command
+	}
+}
+
+// write saves the buffer, to the file with the name or the one that was opened.
+func (vi *viEditor) write(name string, force bool) bool {
+	b := vi.buf
+	if name == "" {
+		name = b.name
+	}
+	if name == "" {
+		vi.message = "\x1b[97;41mE32: No file name"
+		return false
+	}
+	isNew := !vi.fs.ffs.FileExists(toAbs(vi.fs, name))
+	if name != b.name && !isNew && !force {
+		vi.message = "\x1b[97;41mE13: File exists (add ! to override)"
+		return false
+	}
+	if err := vi.fs.saveTextBuffer(b, name); err != nil {
+		vi.message = fmt.Sprintf("\"%s\" \x1b[97;41mE212: Can't open file for writing", name)
+		return false
+	}
+	label := ""
+	if isNew {
+		label = "[New] "
+	}
+	vi.message = fmt.Sprintf("\"%s\" %s%dL, %dB written", name, label, b.lineCount(), len(b.text()))
+	return true
+}
+
+const (
+	nanoEdit = iota
+	nanoFileName
+	nanoSaveQuestion
+)
+
+// nanoEditor is a minimal nano, it writes the file with ^O and exits with ^X.
+type nanoEditor struct {
+	fs       *FakeShell
+	sc       *Screen
+	buf      *textBuffer
+	mode     int
+	input    []rune // the answer to the file name prompt
+	message  string
+	cutting  bool // the last key was ^K, so the next one adds to the cut lines
+	exitSave bool // the file name prompt came from ^X, nano exits once the file is written
+	done     bool
+}
+
+func cmdNano(fs *FakeShell, args []string) (exit bool) {
+	name := ""
+	if files := editorFiles(args[1:]); len(files) > 0 {
+		name = files[0]
+	}
+	sc, ok := fs.OpenScreen(true)
+	if !ok {
+		fs.RecordErrorLn("Too many errors from stdin")
+		return
+	}
+	defer sc.Close()
+	fs.runNano(sc, name)
+	return
+}
+
+func (fs *FakeShell) runNano(sc *Screen, name string) {
+	buf, message := fs.openTextBuffer(name)
+	nano := &nanoEditor{fs: fs, sc: sc, buf: buf, message: message}
+	switch {
+	case message != "":
+	case buf.isNew:
+		nano.message = "New File"
+	case name != "":
+		nano.message = fmt.Sprintf("Read %d line%s", buf.lineCount(), map[bool]string{true: "", false: "s"}[buf.lineCount() == 1])
+	}
+	for !nano.done {
+		nano.render()
+		k, err := sc.ReadKey()
+		if err != nil {
+			return
+		}
+		switch nano.mode {
+		case nanoEdit:
+			nano.editKey(k)
+		case nanoFileName:
+			nano.fileNameKey(k)
+		case nanoSaveQuestion:
+			nano.questionKey(k)
+		}
+	}
+}
+
+// nanoShortcuts formats the two lines of shortcuts at the bottom of the screen.
+func nanoShortcuts(width int, shortcuts [][2]string) []string {
+	columns := maxInt(1, minInt(6, width/16))
+	size := width / columns
+	rows := []string{"", ""}
+	for i, s := range shortcuts {
+		if i/2 >= columns {
+			break
+		}
+		rows[i%2] += screenReverse(s[0]) + screenFit(" "+s[1], size-len(s[0]))
+	}
+	return rows
+}
+
+func (nano *nanoEditor) render() {
+	width, height := nano.sc.Size()
+	b := nano.buf
+
+	title := []rune(strings.Repeat(" ", width))
+	copy(title, []rune("  GNU nano 6.2"))
+	name := b.name
+	if name == "" {
+		name = "New Buffer"
+	}
+	copy(title[maxInt(0, (width-len([]rune(name)))/2):], []rune(name))
+	if b.modified && width > 10 {
+		copy(title[width-10:], []rune("Modified"))
+	}
+
+	rows, col, row := b.view(width, maxInt(1, height-4), "")
+	rows = append([]string{screenReverse(string(title[:width]))}, rows...)
+	row++
+
+	status := ""
+	shortcuts := [][2]string{
+		{"^G", "Help"}, {"^X", "Exit"}, {"^O", "Write Out"}, {"^R", "Read File"}, {"^W", "Where Is"}, {"^\\", "Replace"},
+		{"^K", "Cut"}, {"^U", "Paste"}, {"^T", "Execute"}, {"^J", "Justify"}, {"^C", "Location"}, {"^/", "Go To Line"},
+	}
+	switch nano.mode {
+	case nanoFileName:
+		status = screenReverse(screenFit("File Name to Write: "+string(nano.input), width))
+		col, row = minInt(20+len(nano.input), width-1), height-3
+		shortcuts = [][2]string{{"^G", "Help"}, {"^C", "Cancel"}, {"M-D", "DOS Format"}, {"M-M", "Mac Format"}, {"M-A", "Append"}, {"M-P", "Prepend"}}
+	case nanoSaveQuestion:
+		status = screenReverse(screenFit("Save modified buffer?", width))
+		col, row = minInt(22, width-1), height-3
+		shortcuts = [][2]string{{" Y", "Yes"}, {" N", "No"}, {"^C", "Cancel"}}
+	default:
+		if nano.message != "" {
+			msg := "[ " + nano.message + " ]"
+			status = strings.Repeat(" ", maxInt(0, (width-len(msg))/2)) + screenReverse(msg)
+		}
+	}
+	rows = append(rows, status)
+	rows = append(rows, nanoShortcuts(width, shortcuts)...)
+	nano.sc.Render(rows, minInt(col, width-1), row)
+}
+
+func (nano *nanoEditor) editKey(k Key) {
+	b := nano.buf
+	_, height := nano.sc.Size()
+	cutting := nano.cutting
+	nano.cutting = false
+	if k.Code != KeyResize {
+		nano.message = ""
+	}
+	switch {
+	case k.Code == KeyRune:
+		b.insert(k.Rune)
+	case k.Code == KeyTab:
+		b.insert('\t')
+	case k.Code == KeyEnter:
+		b.newline()
+	case k.Code == KeyBackspace:
+		b.backspace()
+	case k.Code == KeyDelete || k.IsCtrl('d'):
+		b.deleteRune(true)
+	case k.Code == KeyLeft || k.IsCtrl('b'):
+		if b.col == 0 && b.row > 0 {
+			b.moveTo(b.row-1, len(b.lines[b.row-1]), true)
+		} else {
+			b.moveTo(b.row, b.col-1, true)
+		}
+	case k.Code == KeyRight || k.IsCtrl('f'):
+		if b.col == len(b.line()) && b.row+1 < len(b.lines) {
+			b.moveTo(b.row+1, 0, true)
+		} else {
+			b.moveTo(b.row, b.col+1, true)
+		}
+	case k.Code == KeyUp || k.IsCtrl('p'):
+		b.moveTo(b.row-1, b.col, true)
+	case k.Code == KeyDown || k.IsCtrl('n'):
+		b.moveTo(b.row+1, b.col, true)
+	case k.Code == KeyHome || k.IsCtrl('a'):
+		b.moveTo(b.row, 0, true)
+	case k.Code == KeyEnd || k.IsCtrl('e'):
+		b.moveTo(b.row, len(b.line()), true)
+	case k.Code == KeyPageUp || k.IsCtrl('y'):
+		b.moveTo(b.row-height+4, b.col, true)
+	case k.Code == KeyPageDown || k.IsCtrl('v'):
+		b.moveTo(b.row+height-4, b.col, true)
+	case k.IsCtrl('k'):
+		if !cutting {
+			b.cut = nil
+		}
+		if len(b.lines) > 1 || len(b.line()) > 0 {
+			b.cut = append(b.cut, append(b.deleteLine(b.row), '\n')...)
+		}
+		b.moveTo(b.row, 0, true)
+		nano.cutting = true
+	case k.IsCtrl('u'):
+		if len(b.cut) == 0 {
+			nano.message = "Cutbuffer is empty"
+			break
+		}
+		for _, r := range b.cut {
+			if r == '\n' {
+				b.newline()
+			} else {
+				b.insert(r)
+			}
+		}
+	case k.IsCtrl('c'):
+		chars := 0
+		for _, line := range b.lines[:b.row] {
+			chars += len(line) + 1
+		}
+		total := len([]rune(b.text()))
+		nano.message = fmt.Sprintf("line %d/%d (%d%%), col %d/%d (%d%%), char %d/%d (%d%%)",
+			b.row+1, len(b.lines), (b.row+1)*100/len(b.lines), b.col+1, len(b.line())+1, (b.col+1)*100/(len(b.line())+1),
+			chars+b.col, total, (chars+b.col)*100/maxInt(total, 1))
+	case k.IsCtrl('s'):
+		if b.name != "" {
+			nano.write(b.name)
+			break
+		}
+		fallthrough
+	case k.IsCtrl('o'):
+		nano.mode, nano.input, nano.exitSave = nanoFileName, []rune(b.name), false
+	case k.IsCtrl('x'):
+		if !b.modified {
+			nano.done = true
+			break
+		}
+		nano.mode = nanoSaveQuestion
+	}
+}
+
+func (nano *nanoEditor) fileNameKey(k Key) {
+	switch {
+	case k.Code == KeyEnter:
+		nano.mode = nanoEdit
+		name := strings.TrimSpace(string(nano.input))
+		if name == "" {
+			nano.message = "Cancelled"
+			return
+		}
+		if nano.write(name) && nano.exitSave {
+			nano.done = true
+		}
+	case k.IsCtrl('c'):
+		nano.mode = nanoEdit
+		nano.message = "Cancelled"
+	default:
+		nano.input, _ = editLine(nano.input, k)
+	}
+}
+
+func (nano *nanoEditor) questionKey(k Key) {
+	switch {
+	case k.Is('y') || k.Is('Y'):
+		nano.mode, nano.input, nano.exitSave = nanoFileName, []rune(nano.buf.name), true
+	case k.Is('n') || k.Is('N'):
+		nano.done = true
+	case k.IsCtrl('c'):
+		nano.mode = nanoEdit
+		nano.message = "Cancelled"
+	}
+}
+
+// write saves the buffer to the file with the name.
+func (nano *nanoEditor) write(name string) bool {
+	if err := nano.fs.saveTextBuffer(nano.buf, name); err != nil {
+		nano.message = fmt.Sprintf("Error writing %s: %s", name, errorString(err))
+		return false
+	}
+	n := nano.buf.lineCount()
+	nano.message = fmt.Sprintf("Wrote %d line%s", n, map[bool]string{true: "", false: "s"}[n == 1])
+	return true
+}
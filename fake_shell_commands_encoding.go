@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	CmdLookup["base64"] = cmdBase64
+	CmdLookup["xxd"] = cmdXxd
+	CmdLookup["printf"] = cmdPrintf
+	CmdLookup["rev"] = cmdRev
+}
+
+// readSingleInput reads the only input of a command like base64 that takes at most one file.
+func readSingleInput(fs *FakeShell, name string, operands []string) (string, bool) {
+	if len(operands) > 1 {
+		usageError(fs, name, fmt.Sprintf("extra operand '%s'", operands[1]))
+		return "", false
+	}
+	inputs := readInputs(fs, name, operands)
+	if len(inputs) == 0 {
+		return "", false
+	}
+	return inputs[0].data, true
+}
+
+func cmdBase64(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{"wrap": true})
+	opts, operands, bad := parseOpts(rest, "dinw", "w")
+	if bad != "" {
+		badOption(fs, "base64", bad, 1)
+		return
+	}
+	_, decode := opts['d']
+	_, ignoreGarbage := opts['i']
+	if _, ok := long["decode"]; ok {
+		decode = true
+	}
+	if _, ok := long["ignore-garbage"]; ok {
+		ignoreGarbage = true
+	}
+	wrap := 76
+	for _, w := range []string{opts['w'], long["wrap"]} {
+		if w == "" {
+			continue
+		}
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 {
+			fs.RecordErrorLn(fmt.Sprintf("base64: invalid wrap size: '%s'", w))
+			return
+		}
+		wrap = n
+	}
+	data, ok := readSingleInput(fs, "base64", operands)
+	if !ok {
+		return
+	}
+
+	if !decode {
+		encoded := base64.StdEncoding.EncodeToString([]byte(data))
+		if encoded == "" {
+			return
+		}
+		lines := []string{}
+		for wrap > 0 && len(encoded) > wrap {
+			lines = append(lines, encoded[:wrap])
+			encoded = encoded[wrap:]
+		}
+		writeLines(fs, append(lines, encoded))
+		return
+	}
+
+	var clean strings.Builder
+	for _, c := range []byte(data) {
+		switch {
+		case c == '\n' || c == '\r':
+		case ignoreGarbage && !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", rune(c)):
+		default:
+			clean.WriteByte(c)
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(clean.String())
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		// like base64 we write everything up to the broken block, input without padding is decoded completely
+		if raw, rawErr := base64.RawStdEncoding.DecodeString(strings.TrimRight(clean.String(), "=")); rawErr == nil {
+			decoded = raw
+		} else {
+			decoded, _ = base64.StdEncoding.DecodeString(clean.String()[:int(corrupt)/4*4])
+		}
+	}
+	fs.RecordWrite(string(decoded))
+	if err != nil {
+		fs.RecordErrorLn("base64: invalid input")
+	}
+	return
+}
+
+// xxdDump formats data like xxd: offset, hex in groups and the printable characters.
+func xxdDump(data []byte, offset, cols, group int, upper bool) []string {
+	digits := "%02x"
+	if upper {
+		digits = "%02X"
+	}
+	width := cols*2 + 1
+	if group > 0 {
+		width += (cols - 1) / group
+	}
+	lines := []string{}
+	for start := 0; start < len(data); start += cols {
+		chunk := data[start:minInt(start+cols, len(data))]
+		var hexPart, text strings.Builder
+		for i, c := range chunk {
+			if i > 0 && group > 0 && i%group == 0 {
+				hexPart.WriteByte(' ')
+			}
+			fmt.Fprintf(&hexPart, digits, c)
+			if c >= 0x20 && c < 0x7f {
+				text.WriteByte(c)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%08x: %-*s %s", offset+start, width, hexPart.String(), text.String()))
+	}
+	return lines
+}
+
+// xxdReverse turns a hex dump back into binary data. Plain dumps are just hex digits,
+// otherwise every line starts with an offset and the hex column ends at the text column.
+func xxdReverse(dump string, plain bool) []byte {
+	data := []byte{}
+	appendHex := func(s string) {
+		digits := []byte{}
+		for _, c := range []byte(s) {
+			if strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+				digits = append(digits, c)
+			}
+		}
+		b, _ := hex.DecodeString(string(digits[:len(digits)/2*2]))
+		data = append(data, b...)
+	}
+	if plain {
+		appendHex(dump)
+		return data
+	}
+	for _, line := range splitLines(dump) {
+		offset, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(offset), 16, 64); err == nil && int(n) > len(data) {
+			data = append(data, make([]byte, int(n)-len(data))...)
+		}
+		if i := strings.Index(strings.TrimLeft(rest, " "), "  "); i >= 0 {
+			rest = strings.TrimLeft(rest, " ")[:i]
+		}
+		appendHex(rest)
+	}
+	return data
+}
+
+func cmdXxd(fs *FakeShell, args []string) (exit bool) {
+	reverse, plain, upper := false, false, false
+	cols, group, length, skip := 16, 2, -1, 0
+	operands := []string{}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value := func() (int, bool) {
+			v := ""
+			if i+1 < len(args) {
+				i++
+				v = args[i]
+			}
+			n, err := strconv.ParseInt(v, 0, 64)
+			if err != nil {
+				fs.RecordErrorLn(fmt.Sprintf("xxd: option -%s requires an argument", name))
+				fs.exitCode = 1
+				return 0, false
+			}
+			return int(n), true
+		}
+		ok := true
+		switch name {
+		case "r", "revert":
+			reverse = true
+		case "p", "ps", "postscript", "plain":
+			plain = true
+		case "u":
+			upper = true
+		case "c", "cols":
+			cols, ok = value()
+		case "g", "groupsize":
+			group, ok = value()
+		case "l", "len":
+			length, ok = value()
+		case "s", "seek":
+			skip, ok = value()
+		case "rp", "pr":
+			reverse, plain = true, true
+		default:
+			fs.RecordErrorLn("Usage:")
+			fs.RecordErrorLn("       xxd [options] [infile [outfile]]")
+			fs.RecordErrorLn("    or")
+			fs.RecordErrorLn("       xxd -r [-s [-]offset] [-c cols] [-ps] [infile [outfile]]")
+			fs.exitCode = 1
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	if len(operands) > 2 {
+		fs.RecordErrorLn("Usage:")
+		fs.RecordErrorLn("       xxd [options] [infile [outfile]]")
+		fs.exitCode = 1
+		return
+	}
+	in := []string{}
+	if len(operands) > 0 {
+		in = operands[:1]
+	}
+	inputs := readInputs(fs, "xxd", in)
+	if len(inputs) == 0 {
+		fs.exitCode = 2
+		return
+	}
+	data := []byte(inputs[0].data)
+
+	output := ""
+	if reverse {
+		output = string(xxdReverse(string(data), plain))
+	} else {
+		data = data[minInt(skip, len(data)):]
+		if length >= 0 {
+			data = data[:minInt(length, len(data))]
+		}
+		if cols <= 0 {
+			cols = 16
+		}
+		if plain {
+			encoded := hex.EncodeToString(data)
+			if upper {
+				encoded = strings.ToUpper(encoded)
+			}
+			lines := []string{}
+			for len(encoded) > 60 {
+				lines = append(lines, encoded[:60])
+				encoded = encoded[60:]
+			}
+			if encoded != "" {
+				lines = append(lines, encoded)
+			}
+			output = strings.Join(lines, "\n")
+		} else {
+			output = strings.Join(xxdDump(data, skip, cols, group, upper), "\n")
+		}
+		if output != "" {
+			output += "\n"
+		}
+	}
+
+	if len(operands) < 2 || operands[1] == "-" {
+		fs.RecordWrite(output)
+		return
+	}
+	path := toAbs(fs, operands[1])
+	written := fs.watchPersistence(path)
+	if err := fs.ffs.WriteFile(path, []byte(output), 0666&^fakeShellUmask); err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("xxd: %s: %s", operands[1], errorString(err)))
+		fs.exitCode = 3
+		return
+	}
+	written()
+	return
+}
+
+// printfEscape decodes the backslash escape of a printf format at the start of s and returns how many bytes
+// it takes. Unlike echo, octal escapes don't need a leading 0. stop is true for \c, which ends the output.
+func printfEscape(s string) (out string, n int, stop bool) {
+	if len(s) < 2 {
+		return s, len(s), false
+	}
+	hexDigits := func(start, max int) int {
+		j := start
+		for j < len(s) && j-start < max && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+			j++
+		}
+		return j
+	}
+	switch c := s[1]; {
+	case c >= '0' && c <= '7':
+		j := 1
+		for j < len(s) && j < 4 && s[j] >= '0' && s[j] <= '7' {
+			j++
+		}
+		v, _ := strconv.ParseUint(s[1:j], 8, 8)
+		return string([]byte{byte(v)}), j, false
+	case c == 'x':
+		j := hexDigits(2, 2)
+		if j == 2 {
+			return s[:2], 2, false
+		}
+		v, _ := strconv.ParseUint(s[2:j], 16, 8)
+		return string([]byte{byte(v)}), j, false
+	case c == 'u' || c == 'U':
+		max := 4
+		if c == 'U' {
+			max = 8
+		}
+		j := hexDigits(2, max)
+		if j == 2 {
+			return s[:2], 2, false
+		}
+		v, _ := strconv.ParseUint(s[2:j], 16, 32)
+		return string(rune(v)), j, false
+	case c == '"' || c == '\'' || c == '?':
+		return string(c), 2, false
+	case c == 'c':
+		return "", 2, true
+	}
+	out, _ = unescapeEcho(s[:2])
+	return out, 2, false
+}
+
+// printfQuote quotes a string so the shell reads it back as it is, like printf %q.
+func printfQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789,._+:@%/-=", r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// printfNumber converts an argument of a numeric conversion like printf does: a leading quote gives the
+// code of the next character. The error is the message for invalid numbers.
+func printfNumber(arg string) (int64, string) {
+	if arg == "" {
+		return 0, ""
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(arg[1:])
+		if len(arg) == 1 {
+			r = 0
+		}
+		return int64(r), ""
+	}
+	s := strings.TrimSpace(arg)
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		// 010 is octal for printf, ParseInt with base 0 wants 0o10
+		if u, uerr := strconv.ParseUint(s, 0, 64); uerr == nil {
+			return int64(u), ""
+		}
+		return 0, fmt.Sprintf("%s: invalid number", arg)
+	}
+	return n, ""
+}
+
+// printfFormat formats the arguments, the format is reused until all arguments are consumed.
+// It returns the output and the errors.
+func printfFormat(format string, args []string) (string, []string) {
+	var out strings.Builder
+	errs := []string{}
+	next := func() (string, bool) {
+		if len(args) == 0 {
+			return "", false
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg, true
+	}
+	for {
+		consumed := false
+		for i := 0; i < len(format); i++ {
+			c := format[i]
+			if c == '\\' {
+				escaped, n, stop := printfEscape(format[i:])
+				out.WriteString(escaped)
+				if stop {
+					return out.String(), errs
+				}
+				i += n - 1
+				continue
+			}
+			if c != '%' {
+				out.WriteByte(c)
+				continue
+			}
+			if i+1 < len(format) && format[i+1] == '%' {
+				out.WriteByte('%')
+				i++
+				continue
+			}
+
+			// %[flags][width][.precision]conversion
+			j := i + 1
+			for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+				j++
+			}
+			spec := format[i:j]
+			for _, part := range []string{"width", "precision"} {
+				if part == "precision" {
+					if j >= len(format) || format[j] != '.' {
+						break
+					}
+					spec += "."
+					j++
+				}
+				if j < len(format) && format[j] == '*' {
+					arg, ok := next()
+					consumed = consumed || ok
+					n, _ := printfNumber(arg)
+					spec += strconv.FormatInt(n, 10)
+					j++
+					continue
+				}
+				start := j
+				for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+					j++
+				}
+				spec += format[start:j]
+			}
+			for j < len(format) && strings.IndexByte("hlLjzt", format[j]) >= 0 {
+				j++ // length modifiers don't matter
+			}
+			if j >= len(format) {
+				errs = append(errs, fmt.Sprintf("%s: invalid conversion specification", format[i:]))
+				return out.String(), errs
+			}
+			conv := format[j]
+			i = j
+			arg, ok := next()
+			consumed = consumed || ok
+			switch conv {
+			case 's':
+				out.WriteString(fmt.Sprintf(spec+"s", arg))
+			case 'b':
+				unescaped, stop := unescapeEcho(arg)
+				out.WriteString(fmt.Sprintf(spec+"s", unescaped))
+				if stop {
+					return out.String(), errs
+				}
+			case 'q':
+				out.WriteString(fmt.Sprintf(spec+"s", printfQuote(arg)))
+			case 'c':
+				if arg != "" {
+					r, _ := utf8.DecodeRuneInString(arg)
+					out.WriteString(fmt.Sprintf(spec+"c", r))
+				}
+			case 'd', 'i':
+				n, err := printfNumber(arg)
+				if err != "" {
+					errs = append(errs, err)
+				}
+				out.WriteString(fmt.Sprintf(spec+"d", n))
+			case 'o', 'u', 'x', 'X':
+				n, err := printfNumber(arg)
+				if err != "" {
+					errs = append(errs, err)
+				}
+				verb := string(conv)
+				if conv == 'u' {
+					verb = "d"
+				}
+				out.WriteString(fmt.Sprintf(spec+verb, uint64(n)))
+			case 'f', 'F', 'e', 'E', 'g', 'G', 'a', 'A':
+				f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+				if err != nil && arg != "" {
+					n, nerr := printfNumber(arg)
+					if nerr != "" {
+						errs = append(errs, nerr)
+					}
+					f = float64(n)
+				}
+				verb := strings.ToLower(string(conv))
+				if verb == "a" {
+					verb = "x"
+				}
+				if conv == 'E' || conv == 'G' || conv == 'A' {
+					verb = strings.ToUpper(verb)
+				}
+				out.WriteString(fmt.Sprintf(spec+verb, f))
+			default:
+				errs = append(errs, fmt.Sprintf("%%%c: invalid format character", conv))
+				return out.String(), errs
+			}
+		}
+		if !consumed || len(args) == 0 {
+			return out.String(), errs
+		}
+	}
+}
+
+func cmdPrintf(fs *FakeShell, args []string) (exit bool) {
+	args = args[1:]
+	variable := ""
+	if len(args) > 0 && args[0] == "-v" {
+		if len(args) < 2 {
+			fs.RecordErrorLn("-bash: printf: -v: option requires an argument")
+			fs.RecordErrorLn("printf: usage: printf [-v var] format [arguments]")
+			fs.exitCode = 2
+			return
+		}
+		variable = args[1]
+		args = args[2:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fs.RecordErrorLn("printf: usage: printf [-v var] format [arguments]")
+		fs.exitCode = 2
+		return
+	}
+	if strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: printf: %s: invalid option", args[0][:2]))
+		fs.RecordErrorLn("printf: usage: printf [-v var] format [arguments]")
+		fs.exitCode = 2
+		return
+	}
+
+	output, errs := printfFormat(args[0], args[1:])
+	if variable != "" {
+		if !isShellName(variable) {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: printf: `%s': not a valid identifier", variable))
+			fs.exitCode = 2
+			return
+		}
+		fs.env.Set(variable, output)
+	} else {
+		fs.RecordWrite(output)
+	}
+	for _, err := range errs {
+		fs.RecordErrorLn("-bash: printf: " + err)
+	}
+	return
+}
+
+func cmdRev(fs *FakeShell, args []string) (exit bool) {
+	_, operands, bad := parseOpts(args[1:], "", "")
+	if bad != "" {
+		badOption(fs, "rev", bad, 1)
+		return
+	}
+	for _, in := range readInputs(fs, "rev", operands) {
+		lines := splitLines(in.data)
+		for i, line := range lines {
+			r := []rune(line)
+			for a, b := 0, len(r)-1; a < b; a, b = a+1, b-1 {
+				r[a], r[b] = r[b], r[a]
+			}
+			lines[i] = string(r)
+		}
+		writeLines(fs, lines)
+	}
+	return
+}
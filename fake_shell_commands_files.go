@@ -0,0 +1,1022 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	fso "io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const fakeShellUmask = 0022
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"mkdir": cmdMkdir,
+		"rmdir": cmdRmdir,
+		"cp":    cmdCp,
+		"mv":    cmdMv,
+		"ln":    cmdLn,
+		"chmod": cmdChmod,
+		"chown": cmdChown,
+		"chgrp": cmdChown,
+		"stat":  cmdStat,
+	} {
+		CmdLookup[name] = cmd
+	}
+}
+
+// usageError prints an error about the usage of a command followed by the hint GNU tools print.
+func usageError(fs *FakeShell, name, msg string) {
+	fs.RecordErrorLn(fmt.Sprintf("%s: %s", name, msg))
+	fs.RecordErrorLn(fmt.Sprintf("Try '%s --help' for more information.", name))
+}
+
+// joinShown joins a path as given by the user with a file name, without cleaning it like filepath.Join would.
+func joinShown(dir, name string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// walkFFS calls fn for path and, if it is a directory, for everything below it.
+// Symbolic links are not followed, shown is the path as it should appear in messages.
+func (fs *FakeShell) walkFFS(path, shown string, fn func(path, shown string, info fso.FileInfo)) {
+	info, err := fs.ffs.Lstat(path)
+	if err != nil {
+		return
+	}
+	fn(path, shown, info)
+	if !info.IsDir() {
+		return
+	}
+	entries, err := fs.ffs.ReadDir(path)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		fs.walkFFS(filepath.Join(path, e.Name()), joinShown(shown, e.Name()), fn)
+	}
+}
+
+func cmdMkdir(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "pv", "m")
+	if bad != "" {
+		badOption(fs, "mkdir", bad, 1)
+		return
+	}
+	if len(operands) == 0 {
+		usageError(fs, "mkdir", "missing operand")
+		return
+	}
+	_, parents := flags['p']
+	_, verbose := flags['v']
+
+	mode, explicitMode := uint32(0777&^fakeShellUmask), false
+	if m, ok := flags['m']; ok {
+		if mode, ok = parseMode(m, 0777, true); !ok {
+			fs.RecordErrorLn(fmt.Sprintf("mkdir: invalid mode '%s'", m))
+			return
+		}
+		explicitMode = true
+	}
+
+	for _, op := range operands {
+		path := toAbs(fs, op)
+		var err error
+		if parents {
+			if fs.ffs.DirExists(path) {
+				continue
+			}
+			if _, statErr := fs.ffs.Lstat(path); statErr == nil {
+				err = syscall.EEXIST
+			} else {
+				err = fs.ffs.MkdirAll(path, fileMode(mode))
+			}
+		} else {
+			err = fs.ffs.Mkdir(path, fileMode(mode))
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("mkdir: cannot create directory '%s': %s", op, errorString(err)))
+			continue
+		}
+		if explicitMode {
+			_ = fs.ffs.Chmod(path, fileMode(mode))
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("mkdir: created directory '%s'", op))
+		}
+	}
+	return
+}
+
+func cmdRmdir(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "pv", "")
+	if bad != "" {
+		badOption(fs, "rmdir", bad, 1)
+		return
+	}
+	if len(operands) == 0 {
+		usageError(fs, "rmdir", "missing operand")
+		return
+	}
+	_, parents := flags['p']
+	_, verbose := flags['v']
+
+	remove := func(op string) bool {
+		path := toAbs(fs, op)
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("rmdir: removing directory, '%s'", op))
+		}
+		info, err := fs.ffs.Lstat(path)
+		if err == nil && !info.IsDir() {
+			err = syscall.ENOTDIR
+		}
+		if err == nil {
+			err = fs.ffs.RemoveFile(path, false)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("rmdir: failed to remove '%s': %s", op, errorString(err)))
+			return false
+		}
+		return true
+	}
+
+	for _, op := range operands {
+		if !remove(op) || !parents {
+			continue
+		}
+		// -p removes the parents given in the operand as well, a/b/c removes a/b and a
+		for dir := filepath.Dir(strings.TrimRight(op, "/")); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+			if !remove(dir) {
+				break
+			}
+		}
+	}
+	return
+}
+
+// copyPath copies src to dst, directories are only copied if recursive is true.
+// If preserve is true mode and modification time are kept, if noDeref is true symbolic links are copied as links.
+// Errors are reported with the given command name, srcShown and dstShown are the paths as given by the user.
+func (fs *FakeShell) copyPath(name, srcShown, dstShown, src, dst string, recursive, preserve, noDeref, verbose bool) {
+	var info fso.FileInfo
+	var err error
+	if noDeref {
+		info, err = fs.ffs.Lstat(src)
+	} else {
+		info, err = fs.ffs.Stat(src)
+	}
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("%s: cannot stat '%s': %s", name, srcShown, errorString(err)))
+		return
+	}
+	dstInfo, dstErr := fs.ffs.Stat(dst)
+
+	switch {
+	case info.IsDir():
+		if !recursive {
+			fs.RecordErrorLn(fmt.Sprintf("%s: -r not specified; omitting directory '%s'", name, srcShown))
+			return
+		}
+		if dst == src || strings.HasPrefix(dst, src+"/") {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot copy a directory, '%s', into itself, '%s'", name, srcShown, dstShown))
+			return
+		}
+		if dstErr == nil && !dstInfo.IsDir() {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot overwrite non-directory '%s' with directory '%s'", name, dstShown, srcShown))
+			return
+		}
+		if dstErr != nil {
+			if err := fs.ffs.Mkdir(dst, info.Mode().Perm()); err != nil {
+				fs.RecordErrorLn(fmt.Sprintf("%s: cannot create directory '%s': %s", name, dstShown, errorString(err)))
+				return
+			}
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("'%s' -> '%s'", srcShown, dstShown))
+		}
+		entries, err := fs.ffs.ReadDir(src)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot access '%s': %s", name, srcShown, errorString(err)))
+			return
+		}
+		for _, e := range entries {
+			fs.copyPath(name, joinShown(srcShown, e.Name()), joinShown(dstShown, e.Name()),
+				filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), recursive, preserve, noDeref, verbose)
+		}
+
+	case info.Mode()&fso.ModeSymlink != 0:
+		target, err := fs.ffs.Readlink(src)
+		if err == nil {
+			_ = fs.ffs.RemoveFile(dst, false)
+			err = fs.ffs.Symlink(target, dst)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot create symbolic link '%s': %s", name, dstShown, errorString(err)))
+			return
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("'%s' -> '%s'", srcShown, dstShown))
+		}
+		return
+
+	default:
+		if dstErr == nil && os.SameFile(info, dstInfo) {
+			fs.RecordErrorLn(fmt.Sprintf("%s: '%s' and '%s' are the same file", name, srcShown, dstShown))
+			return
+		}
+		if dstErr == nil && dstInfo.IsDir() {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot overwrite directory '%s' with non-directory", name, dstShown))
+			return
+		}
+		data, err := fs.ffs.ReadFile(src)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot open '%s' for reading: %s", name, srcShown, errorString(err)))
+			return
+		}
+		written := fs.watchPersistence(dst)
+		f, err := fs.ffs.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot create regular file '%s': %s", name, dstShown, errorString(err)))
+			return
+		}
+		_, err = f.Write(data)
+		f.Close()
+		written()
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: error writing '%s': %s", name, dstShown, errorString(err)))
+			return
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("'%s' -> '%s'", srcShown, dstShown))
+		}
+	}
+
+	if preserve {
+		_ = fs.ffs.Chmod(dst, info.Mode()&(fso.ModePerm|fso.ModeSetuid|fso.ModeSetgid|fso.ModeSticky))
+		_ = fs.ffs.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+}
+
+// copyTargets splits the operands of cp, mv and ln into sources and the destination.
+// It reports missing operands and returns ok=false if the command can't run.
+func copyTargets(fs *FakeShell, name string, operands []string) (srcs []string, dst string, dstIsDir, ok bool) {
+	switch len(operands) {
+	case 0:
+		usageError(fs, name, "missing file operand")
+		return nil, "", false, false
+	case 1:
+		usageError(fs, name, fmt.Sprintf("missing destination file operand after '%s'", operands[0]))
+		return nil, "", false, false
+	}
+	dst = operands[len(operands)-1]
+	srcs = operands[:len(operands)-1]
+	dstIsDir = fs.ffs.DirExists(toAbs(fs, dst))
+	if len(srcs) > 1 && !dstIsDir {
+		fs.RecordErrorLn(fmt.Sprintf("%s: target '%s' is not a directory", name, dst))
+		return nil, "", false, false
+	}
+	return srcs, dst, dstIsDir, true
+}
+
+func cmdCp(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "rRapdfinvPLu", "")
+	if bad != "" {
+		badOption(fs, "cp", bad, 1)
+		return
+	}
+	has := func(c byte) bool {
+		_, ok := flags[c]
+		return ok
+	}
+	recursive := has('r') || has('R') || has('a')
+	preserve := has('p') || has('a')
+	noDeref := has('a') || has('d') || has('P')
+
+	srcs, dst, dstIsDir, ok := copyTargets(fs, "cp", operands)
+	if !ok {
+		return
+	}
+	for _, src := range srcs {
+		srcPath, dstPath, dstShown := toAbs(fs, src), toAbs(fs, dst), dst
+		if dstIsDir {
+			dstPath = filepath.Join(dstPath, filepath.Base(srcPath))
+			dstShown = joinShown(dst, filepath.Base(srcPath))
+		}
+		if strings.HasSuffix(dst, "/") && !dstIsDir {
+			fs.RecordErrorLn(fmt.Sprintf("cp: cannot create regular file '%s': Not a directory", dst))
+			continue
+		}
+		if has('n') && fs.ffs.FileExists(dstPath) {
+			continue
+		}
+		fs.copyPath("cp", src, dstShown, srcPath, dstPath, recursive, preserve, noDeref, has('v'))
+	}
+	return
+}
+
+func cmdMv(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "finvu", "")
+	if bad != "" {
+		badOption(fs, "mv", bad, 1)
+		return
+	}
+	_, noClobber := flags['n']
+	_, verbose := flags['v']
+
+	srcs, dst, dstIsDir, ok := copyTargets(fs, "mv", operands)
+	if !ok {
+		return
+	}
+	for _, src := range srcs {
+		srcPath, dstPath, dstShown := toAbs(fs, src), toAbs(fs, dst), dst
+		if dstIsDir {
+			dstPath = filepath.Join(dstPath, filepath.Base(srcPath))
+			dstShown = joinShown(dst, filepath.Base(srcPath))
+		}
+
+		info, err := fs.ffs.Lstat(srcPath)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("mv: cannot stat '%s': %s", src, errorString(err)))
+			continue
+		}
+		if strings.HasSuffix(dst, "/") && !dstIsDir {
+			fs.RecordErrorLn(fmt.Sprintf("mv: cannot move '%s' to '%s': Not a directory", src, dst))
+			continue
+		}
+		if dstPath == srcPath {
+			fs.RecordErrorLn(fmt.Sprintf("mv: '%s' and '%s' are the same file", src, dstShown))
+			continue
+		}
+		if info.IsDir() && strings.HasPrefix(dstPath, srcPath+"/") {
+			fs.RecordErrorLn(fmt.Sprintf("mv: cannot move '%s' to a subdirectory of itself, '%s'", src, dstShown))
+			continue
+		}
+		if dstInfo, err := fs.ffs.Lstat(dstPath); err == nil {
+			if noClobber {
+				continue
+			}
+			if dstInfo.IsDir() && !info.IsDir() {
+				fs.RecordErrorLn(fmt.Sprintf("mv: cannot overwrite directory '%s' with non-directory", dstShown))
+				continue
+			}
+			if !dstInfo.IsDir() && info.IsDir() {
+				fs.RecordErrorLn(fmt.Sprintf("mv: cannot overwrite non-directory '%s' with directory '%s'", dstShown, src))
+				continue
+			}
+		}
+
+		written := fs.watchPersistence(dstPath)
+		err = fs.ffs.Rename(srcPath, dstPath)
+		written()
+		if errors.Is(err, syscall.EXDEV) {
+			// the OverlayFS can't rename directories of the lower layers, so we copy them like mv does across file systems
+			fs.copyPath("mv", src, dstShown, srcPath, dstPath, true, true, true, false)
+			err = fs.ffs.RemoveFile(srcPath, true)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("mv: cannot move '%s' to '%s': %s", src, dstShown, errorString(err)))
+			continue
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("renamed '%s' -> '%s'", src, dstShown))
+		}
+	}
+	return
+}
+
+func cmdLn(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "sfnvT", "")
+	if bad != "" {
+		badOption(fs, "ln", bad, 1)
+		return
+	}
+	_, symbolic := flags['s']
+	_, force := flags['f']
+	_, verbose := flags['v']
+
+	if len(operands) == 0 {
+		usageError(fs, "ln", "missing file operand")
+		return
+	}
+	targets, dir, dirIsDir := operands, ".", true
+	if len(operands) > 1 {
+		targets, dir = operands[:len(operands)-1], operands[len(operands)-1]
+		dirIsDir = fs.ffs.DirExists(toAbs(fs, dir))
+		if len(targets) > 1 && !dirIsDir {
+			fs.RecordErrorLn(fmt.Sprintf("ln: target '%s' is not a directory", dir))
+			return
+		}
+	}
+
+	kind := "hard link"
+	if symbolic {
+		kind = "symbolic link"
+	}
+	for _, target := range targets {
+		link, linkShown := toAbs(fs, dir), dir
+		if dirIsDir {
+			name := filepath.Base(target)
+			link = filepath.Join(link, name)
+			linkShown = name
+			if len(operands) > 1 {
+				linkShown = joinShown(dir, name)
+			}
+		}
+
+		if !symbolic {
+			info, err := fs.ffs.Lstat(toAbs(fs, target))
+			if err != nil {
+				fs.RecordErrorLn(fmt.Sprintf("ln: failed to access '%s': %s", target, errorString(err)))
+				continue
+			}
+			if info.IsDir() {
+				fs.RecordErrorLn(fmt.Sprintf("ln: %s: hard link not allowed for directory", target))
+				continue
+			}
+		}
+		if _, err := fs.ffs.Lstat(link); err == nil {
+			if !force {
+				fs.RecordErrorLn(fmt.Sprintf("ln: failed to create %s '%s': File exists", kind, linkShown))
+				continue
+			}
+			_ = fs.ffs.RemoveFile(link, false)
+		}
+
+		var err error
+		if symbolic {
+			err = fs.ffs.Symlink(target, link)
+		} else {
+			err = fs.ffs.Link(toAbs(fs, target), link)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("ln: failed to create %s '%s': %s", kind, linkShown, errorString(err)))
+			continue
+		}
+		if verbose {
+			arrow := "=>"
+			if symbolic {
+				arrow = "->"
+			}
+			fs.RecordWriteLn(fmt.Sprintf("'%s' %s '%s'", linkShown, arrow, target))
+		}
+	}
+	return
+}
+
+// fileMode converts permission bits as used by chmod (e.g. 04755) to a FileMode.
+func fileMode(bits uint32) fso.FileMode {
+	mode := fso.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= fso.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= fso.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= fso.ModeSticky
+	}
+	return mode
+}
+
+// modeBits converts a FileMode to permission bits as used by chmod.
+func modeBits(mode fso.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fso.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&fso.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&fso.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// parseMode applies a numeric (755) or symbolic (u+x,go-w) mode to the current permission bits.
+func parseMode(spec string, cur uint32, isDir bool) (uint32, bool) {
+	if spec == "" {
+		return 0, false
+	}
+	if strings.Trim(spec, "01234567") == "" {
+		v, err := strconv.ParseUint(spec, 8, 32)
+		if err != nil || v > 07777 {
+			return 0, false
+		}
+		return uint32(v), true
+	}
+
+	for _, clause := range strings.Split(spec, ",") {
+		i, who := 0, uint32(0)
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 04700
+			case 'g':
+				who |= 02070
+			case 'o':
+				who |= 01007
+			case 'a':
+				who |= 07777
+			}
+		}
+		if i >= len(clause) {
+			return 0, false
+		}
+		// without who all bits are affected, except the ones the umask masks
+		mask, clear := who, who
+		if who == 0 {
+			mask, clear = 07777&^fakeShellUmask, 07777
+		}
+
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, false
+			}
+			i++
+
+			perm := uint32(0)
+			if i < len(clause) && strings.IndexByte("ugo", clause[i]) >= 0 {
+				var v uint32
+				switch clause[i] {
+				case 'u':
+					v = (cur >> 6) & 7
+				case 'g':
+					v = (cur >> 3) & 7
+				case 'o':
+					v = cur & 7
+				}
+				perm = v<<6 | v<<3 | v
+				i++
+			} else {
+				for ; i < len(clause) && strings.IndexByte("rwxXst", clause[i]) >= 0; i++ {
+					switch clause[i] {
+					case 'r':
+						perm |= 0444
+					case 'w':
+						perm |= 0222
+					case 'x':
+						perm |= 0111
+					case 'X':
+						if isDir || cur&0111 != 0 {
+							perm |= 0111
+						}
+					case 's':
+						perm |= 06000
+					case 't':
+						perm |= 01000
+					}
+				}
+			}
+
+			switch op {
+			case '+':
+				cur |= perm & mask
+			case '-':
+				cur &^= perm & mask
+			case '=':
+				cur = cur&^clear | perm&mask
+			}
+		}
+	}
+	return cur, true
+}
+
+func cmdChmod(fs *FakeShell, args []string) (exit bool) {
+	var recursive, verbose, changes, quiet bool
+	mode := ""
+	files := []string{}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "--"):
+			continue
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && strings.Trim(arg[1:], "Rvcf") == "":
+			recursive = recursive || strings.Contains(arg, "R")
+			verbose = verbose || strings.Contains(arg, "v")
+			changes = changes || strings.Contains(arg, "c")
+			quiet = quiet || strings.Contains(arg, "f")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && strings.Trim(arg[1:], "rwxXst") != "":
+			badOption(fs, "chmod", strings.TrimLeft(arg[1:], "Rvcf")[:1], 1)
+			return
+		case mode == "":
+			mode = arg // includes modes like -x
+		default:
+			files = append(files, arg)
+		}
+	}
+	if mode == "" && len(files) > 0 {
+		mode, files = files[0], files[1:]
+	}
+	if mode == "" {
+		usageError(fs, "chmod", "missing operand")
+		return
+	}
+	if len(files) == 0 {
+		usageError(fs, "chmod", fmt.Sprintf("missing operand after '%s'", mode))
+		return
+	}
+	if _, ok := parseMode(mode, 0, false); !ok {
+		usageError(fs, "chmod", fmt.Sprintf("invalid mode: '%s'", mode))
+		return
+	}
+
+	apply := func(path, shown string, info fso.FileInfo) {
+		if info.Mode()&fso.ModeSymlink != 0 {
+			return // the mode of symbolic links can't be changed
+		}
+		old := modeBits(info.Mode())
+		bits, _ := parseMode(mode, old, info.IsDir())
+		if err := fs.ffs.Chmod(path, fileMode(bits)); err != nil {
+			if !quiet {
+				fs.RecordErrorLn(fmt.Sprintf("chmod: changing permissions of '%s': %s", shown, errorString(err)))
+			}
+			return
+		}
+		if verbose || (changes && bits != old) {
+			if bits == old {
+				fs.RecordWriteLn(fmt.Sprintf("mode of '%s' retained as %04o (%s)", shown, bits, lsMode(fileMode(bits))[1:]))
+			} else {
+				fs.RecordWriteLn(fmt.Sprintf("mode of '%s' changed from %04o (%s) to %04o (%s)", shown, old, lsMode(fileMode(old))[1:], bits, lsMode(fileMode(bits))[1:]))
+			}
+		}
+	}
+
+	for _, f := range files {
+		path := toAbs(fs, f)
+		info, err := fs.ffs.Stat(path)
+		if err != nil {
+			if !quiet {
+				fs.RecordErrorLn(fmt.Sprintf("chmod: cannot access '%s': %s", f, errorString(err)))
+			} else if fs.exitCode == 0 {
+				fs.exitCode = 1
+			}
+			continue
+		}
+		if recursive && info.IsDir() {
+			fs.walkFFS(path, f, apply)
+			continue
+		}
+		apply(path, f, info)
+	}
+	return
+}
+
+// lookupUser returns the uid and login group of a user of the FFS, numeric ids are accepted as well.
+func (fs *FakeShell) lookupUser(name string) (uid, gid int, ok bool) {
+	return fs.ffs.lookupUser(name)
+}
+
+// lookupUser returns the uid and login group of a user of /etc/passwd, numeric ids are accepted as well.
+func (ofs *FakeFS) lookupUser(name string) (uid, gid int, ok bool) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, -1, true
+	}
+	data, err := ofs.ReadFile("/etc/passwd")
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || fields[0] != name {
+			continue
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 == nil && err2 == nil {
+			return uid, gid, true
+		}
+	}
+	return 0, 0, false
+}
+
+// lookupGroup returns the gid of a group of the FFS, numeric ids are accepted as well.
+// Without /etc/group users are assumed to have a group of the same name.
+func (fs *FakeShell) lookupGroup(name string) (gid int, ok bool) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, true
+	}
+	for id, n := range fs.lookupIDs("/etc/group") {
+		if n == name {
+			return int(id), true
+		}
+	}
+	if _, gid, ok := fs.lookupUser(name); ok && gid >= 0 {
+		return gid, true
+	}
+	return 0, false
+}
+
+// cmdChown implements chown and chgrp.
+func cmdChown(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+	flags, operands, bad := parseOpts(args[1:], "Rvcfh", "")
+	if bad != "" {
+		badOption(fs, name, bad, 1)
+		return
+	}
+	_, recursive := flags['R']
+	_, verbose := flags['v']
+	_, quiet := flags['f']
+	_, noDeref := flags['h']
+
+	if len(operands) == 0 {
+		usageError(fs, name, "missing operand")
+		return
+	}
+	if len(operands) == 1 {
+		usageError(fs, name, fmt.Sprintf("missing operand after '%s'", operands[0]))
+		return
+	}
+
+	spec := operands[0]
+	uid, gid := -1, -1
+	if name == "chgrp" {
+		id, ok := fs.lookupGroup(spec)
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("chgrp: invalid group: '%s'", spec))
+			return
+		}
+		gid = id
+	} else {
+		user, group := spec, ""
+		if i := strings.IndexAny(spec, ":."); i >= 0 {
+			user, group = spec[:i], spec[i+1:]
+		}
+		if user != "" {
+			id, loginGroup, ok := fs.lookupUser(user)
+			if !ok {
+				fs.RecordErrorLn(fmt.Sprintf("chown: invalid user: '%s'", spec))
+				return
+			}
+			uid = id
+			if group == "" && strings.ContainsAny(spec, ":.") {
+				gid = loginGroup // "user:" means the login group of the user
+			}
+		}
+		if group != "" {
+			id, ok := fs.lookupGroup(group)
+			if !ok {
+				fs.RecordErrorLn(fmt.Sprintf("chown: invalid group: '%s'", spec))
+				return
+			}
+			gid = id
+		}
+	}
+
+	apply := func(path, shown string, info fso.FileInfo) {
+		if err := fs.ffs.Chown(path, uid, gid, !noDeref); err != nil {
+			if !quiet {
+				what := "ownership"
+				if name == "chgrp" {
+					what = "group"
+				}
+				fs.RecordErrorLn(fmt.Sprintf("%s: changing %s of '%s': %s", name, what, shown, errorString(err)))
+			}
+			return
+		}
+		if verbose {
+			fs.RecordWriteLn(fmt.Sprintf("changed ownership of '%s' to %s", shown, spec))
+		}
+	}
+
+	for _, f := range operands[1:] {
+		path := toAbs(fs, f)
+		info, err := fs.ffs.Lstat(path)
+		if err != nil {
+			if !quiet {
+				fs.RecordErrorLn(fmt.Sprintf("%s: cannot access '%s': %s", name, f, errorString(err)))
+			} else if fs.exitCode == 0 {
+				fs.exitCode = 1
+			}
+			continue
+		}
+		if recursive && info.IsDir() {
+			fs.walkFFS(path, f, apply)
+			continue
+		}
+		apply(path, f, info)
+	}
+	return
+}
+
+// statFileType returns the description of the type of a file stat prints.
+func statFileType(info fso.FileInfo) string {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fso.ModeSymlink != 0:
+		return "symbolic link"
+	case mode&fso.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fso.ModeSocket != 0:
+		return "socket"
+	case mode&fso.ModeCharDevice != 0:
+		return "character special file"
+	case mode&fso.ModeDevice != 0:
+		return "block special file"
+	case info.Size() == 0:
+		return "regular empty file"
+	}
+	return "regular file"
+}
+
+// statPerm returns the permission bits of a file in octal, including the special bits.
+func statPerm(mode fso.FileMode) string {
+	perm := uint32(mode.Perm())
+	if mode&fso.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&fso.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&fso.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return strconv.FormatUint(uint64(perm), 8)
+}
+
+// statFormat expands the directives of a format of stat -c for a file.
+func (fs *FakeShell) statFormat(format, shown string, info fso.FileInfo) string {
+	st, _ := info.Sys().(*syscall.Stat_t)
+	if st == nil {
+		// virtual files are owned by root and have no inode
+		st = &syscall.Stat_t{Nlink: 1, Blksize: 1024}
+		if info.IsDir() {
+			st.Nlink = 2
+		}
+	}
+	users, groups := fs.lookupIDs("/etc/passwd"), fs.lookupIDs("/etc/group")
+	if len(groups) == 0 {
+		groups = users
+	}
+	idName := func(names map[uint32]string, id uint32) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		return "UNKNOWN"
+	}
+	modTime := info.ModTime()
+	timeOf := func(ts syscall.Timespec) time.Time {
+		if ts.Sec == 0 && ts.Nsec == 0 {
+			return modTime
+		}
+		return time.Unix(ts.Sec, ts.Nsec)
+	}
+	times := map[byte]time.Time{'x': timeOf(st.Atim), 'y': modTime, 'z': timeOf(st.Ctim)}
+
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '\\' && i+1 < len(format) {
+			i++
+			switch format[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(format[i])
+			}
+			continue
+		}
+		if c != '%' || i+1 >= len(format) {
+			sb.WriteByte(c)
+			continue
+		}
+		// flags and width, e.g. %-10s or %04a
+		spec := ""
+		for i+1 < len(format) && strings.IndexByte("-0123456789", format[i+1]) >= 0 {
+			i++
+			spec += string(format[i])
+		}
+		if i+1 >= len(format) {
+			sb.WriteString("%" + spec)
+			break
+		}
+		i++
+		value := ""
+		switch d := format[i]; d {
+		case '%':
+			sb.WriteByte('%')
+			continue
+		case 'n':
+			value = shown
+		case 'N':
+			value = "'" + shown + "'"
+			if target, err := fs.ffs.Readlink(toAbs(fs, shown)); err == nil && info.Mode()&fso.ModeSymlink != 0 {
+				value += " -> '" + target + "'"
+			}
+		case 's':
+			value = strconv.FormatInt(info.Size(), 10)
+		case 'b':
+			value = strconv.FormatInt(st.Blocks, 10)
+		case 'B':
+			value = "512"
+		case 'o':
+			value = strconv.FormatInt(int64(st.Blksize), 10)
+		case 'F':
+			value = statFileType(info)
+		case 'a':
+			value = statPerm(info.Mode())
+		case 'A':
+			value = lsMode(info.Mode())
+		case 'f':
+			value = strconv.FormatUint(uint64(st.Mode), 16)
+		case 'h':
+			value = strconv.FormatUint(uint64(st.Nlink), 10)
+		case 'i':
+			value = strconv.FormatUint(st.Ino, 10)
+		case 'd':
+			value = strconv.FormatUint(uint64(st.Dev), 10)
+		case 'D':
+			value = strconv.FormatUint(uint64(st.Dev), 16)
+		case 'u':
+			value = strconv.FormatUint(uint64(st.Uid), 10)
+		case 'U':
+			value = idName(users, st.Uid)
+		case 'g':
+			value = strconv.FormatUint(uint64(st.Gid), 10)
+		case 'G':
+			value = idName(groups, st.Gid)
+		case 'x', 'y', 'z':
+			value = times[d].Format("2006-01-02 15:04:05.000000000 -0700")
+		case 'X', 'Y', 'Z':
+			value = strconv.FormatInt(times[d+'a'-'A'].Unix(), 10)
+		case 'w':
+			value = "-"
+		case 'W':
+			value = "0"
+		default:
+			value = "?"
+		}
+		sb.WriteString(fmt.Sprintf("%"+spec+"s", value))
+	}
+	return sb.String()
+}
+
+// statDefaultFormat is the format of stat without -c, like the one of GNU coreutils.
+const statDefaultFormat = "  File: %n\n  Size: %-10s\tBlocks: %-10b IO Block: %-6o %F\n" +
+	"Device: %Dh/%dd\tInode: %-11i Links: %h\n" +
+	"Access: (%04a/%A)  Uid: (%5u/%8U)   Gid: (%5g/%8G)\n" +
+	"Access: %x\nModify: %y\nChange: %z\n Birth: %w"
+
+func cmdStat(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{"format": true, "printf": true})
+	flags, operands, bad := parseOpts(rest, "Lt", "c")
+	if bad != "" {
+		badOption(fs, "stat", bad, 1)
+		return
+	}
+	if len(operands) == 0 {
+		usageError(fs, "stat", "missing operand")
+		return
+	}
+
+	format, newline := statDefaultFormat, true
+	if c, ok := flags['c']; ok {
+		format = c
+	}
+	if c, ok := long["format"]; ok {
+		format = c
+	}
+	if c, ok := long["printf"]; ok {
+		format, newline = c, false
+	}
+	_, follow := flags['L']
+	if _, ok := long["dereference"]; ok {
+		follow = true
+	}
+
+	for _, f := range operands {
+		path := toAbs(fs, f)
+		info, err := fs.ffs.Lstat(path)
+		if err == nil && follow {
+			info, err = fs.ffs.Stat(path)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("stat: cannot statx '%s': %s", f, errorString(err)))
+			continue
+		}
+		output := fs.statFormat(format, f, info)
+		if newline {
+			fs.RecordWriteLn(output)
+		} else {
+			fs.RecordWrite(output)
+		}
+	}
+	return
+}
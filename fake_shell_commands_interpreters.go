@@ -0,0 +1,759 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// pythonNames are the names the Python interpreter can be run with.
+var pythonNames = []string{"python", "python3", "python2", "python2.7", "python3.10"}
+
+func init() {
+	for _, name := range pythonNames {
+		CmdLookup[name] = cmdPython
+	}
+	CmdLookup["perl"] = cmdPerl
+	CmdLookup["php"] = cmdPhp
+
+	// scripts run shell commands, which can run scripts again, defining the languages directly would be an initialization cycle
+	python2 = &scriptLanguage{escapes: true, effects: pythonEffects()}
+	python3 = &scriptLanguage{escapes: true, effects: pythonEffects(), check: python3Check}
+	perl = &scriptLanguage{effects: perlEffects()}
+	php = &scriptLanguage{effects: phpEffects()}
+}
+
+// the emulated scripting languages, see init
+var python2, python3, perl, php *scriptLanguage
+
+// scriptStringPattern matches a string literal in single or double quotes, {str} in the patterns of
+// script effects stands for it.
+const scriptStringPattern = `("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')`
+
+// scriptPattern compiles a pattern of the script emulation.
+func scriptPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(strings.ReplaceAll(pattern, "{str}", scriptStringPattern))
+}
+
+// scriptLiteral returns the value of a string literal. Escapes are resolved in double quotes,
+// in single quotes only if the language does so too (like Python), otherwise only \\ and \' are.
+func scriptLiteral(literal string, escapes bool) string {
+	if len(literal) < 2 {
+		return literal
+	}
+	body := literal[1 : len(literal)-1]
+	if literal[0] == '\'' && !escapes {
+		return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(body)
+	}
+	var sb strings.Builder
+	for i := 0; i < len(body); {
+		if body[i] != '\\' {
+			sb.WriteByte(body[i])
+			i++
+			continue
+		}
+		out, n, _ := printfEscape(body[i:])
+		sb.WriteString(out)
+		i += n
+	}
+	return sb.String()
+}
+
+var (
+	scriptStringLiteral = regexp.MustCompile(scriptStringPattern)
+	scriptURLPattern    = regexp.MustCompile("\\b(?:https?|ftp|tftp)://[^\\s\"'<>`)]+")
+	scriptHostPattern   = scriptPattern(`{str}\s*,\s*(\d{1,5})\b|PeerAddr\s*=>\s*["']([\w.-]+):(\d{1,5})`)
+	scriptIPPattern     = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+	scriptHostName      = regexp.MustCompile(`(?i)^[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}$`)
+)
+
+// recordTargets records the URLs and hosts a script mentions as events,
+// e.g. where a reverse shell connects to or where a payload is downloaded from.
+func (fs *FakeShell) recordTargets(source string) {
+	seen := map[string]bool{}
+	record := func(eventType, detail string) {
+		if !seen[detail] {
+			seen[detail] = true
+			fs.RecordEvent(eventType, detail, "")
+		}
+	}
+	urls := scriptURLPattern.FindAllStringIndex(source, -1)
+	for _, u := range urls {
+		record("url", source[u[0]:u[1]])
+	}
+	for _, m := range scriptHostPattern.FindAllStringSubmatch(source, -1) {
+		host, port := "", ""
+		if m[1] != "" {
+			host, port = scriptLiteral(m[1], false), m[2]
+		} else {
+			host, port = m[3], m[4]
+		}
+		if net.ParseIP(host) != nil || scriptHostName.MatchString(host) {
+			record("host", net.JoinHostPort(host, port))
+			seen[host] = true
+		}
+	}
+	for _, loc := range scriptIPPattern.FindAllStringIndex(source, -1) {
+		inURL := false
+		for _, u := range urls {
+			inURL = inURL || (loc[0] >= u[0] && loc[0] < u[1])
+		}
+		if ip := source[loc[0]:loc[1]]; !inURL && net.ParseIP(ip) != nil {
+			record("host", ip)
+		}
+	}
+}
+
+// scriptEffect is something a script does that the emulation shows, e.g. printing a string or running a command.
+type scriptEffect struct {
+	re  *regexp.Regexp
+	run func(r *scriptRun, m []string) bool // returns false if the script ends, e.g. after an uncaught exception
+}
+
+// scriptLanguage is a scripting language whose interpreter is emulated.
+type scriptLanguage struct {
+	escapes bool                    // whether escapes are resolved in single quoted strings
+	check   func(r *scriptRun) bool // fails scripts that don't compile, may be nil
+	effects []scriptEffect
+}
+
+// scriptRun is a script run by an emulated interpreter.
+type scriptRun struct {
+	fs     *FakeShell
+	lang   *scriptLanguage
+	name   string // the interpreter, e.g. python3
+	file   string // how the interpreter names the script in errors, e.g. the path or <string>
+	source string
+	line   int                    // the line of the current effect
+	ors    string                 // appended to printed strings, e.g. by perl -l
+	vars   map[string]pythonValue // the variables Python scripts assigned literals to
+}
+
+// runSource emulates a script. Scripts aren't really run, the emulation looks for the things one-liners of bots
+// usually do (print text, run shell commands, download files, connect back) and does them in the order they
+// appear in the script. The URLs and hosts the script mentions are recorded as events.
+func (fs *FakeShell) runSource(r *scriptRun) {
+	r.fs = fs
+	fs.recordTargets(r.source)
+	fs.exitCode = 0
+	if r.lang.check != nil && !r.lang.check(r) {
+		return
+	}
+
+	r.effects(r.source, 1, nil)
+}
+
+// effects does the effects found in the source in the order they appear, the source starts at the given line
+// of the script. Effects whose pattern is skip are left out. It returns false if the script ends.
+func (r *scriptRun) effects(source string, line int, skip *regexp.Regexp) bool {
+	type match struct {
+		start, end int
+		effect     scriptEffect
+		groups     []string
+	}
+	matches := []match{}
+	for _, e := range r.lang.effects {
+		if e.re == skip {
+			continue
+		}
+		for _, loc := range e.re.FindAllStringSubmatchIndex(source, -1) {
+			groups := make([]string, len(loc)/2)
+			for i := range groups {
+				if loc[2*i] >= 0 {
+					groups[i] = source[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			matches = append(matches, match{loc[0], loc[1], e, groups})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+	end := 0
+	for _, m := range matches {
+		if m.start < end {
+			continue // part of the previous effect, e.g. a print in the string of os.system()
+		}
+		end = m.end
+		r.line = line + strings.Count(source[:m.start], "\n")
+		if !m.effect.run(r, m.groups) {
+			return false
+		}
+	}
+	return true
+}
+
+// nestedEffects does the effects found in the arguments of a print() the emulation can't evaluate.
+func (r *scriptRun) nestedEffects(source string) bool {
+	return r.effects(source, r.line, pythonPrintCall)
+}
+
+// execScript emulates a script file whose shebang names one of the emulated interpreters.
+// It returns false if the interpreter isn't one of them.
+func (fs *FakeShell) execScript(interpreter, file, source string) bool {
+	name := filepath.Base(interpreter)
+	switch {
+	case name == "perl":
+		fs.runSource(&scriptRun{lang: perl, name: name, file: file, source: source})
+	case name == "php":
+		fs.runPhp(file, source)
+	default:
+		for _, python := range pythonNames {
+			if name == python {
+				lang, _ := pythonLanguage(name)
+				fs.runSource(&scriptRun{lang: lang, name: name, file: file, source: source})
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// sourceLine returns the line of the current effect.
+func (r *scriptRun) sourceLine() string {
+	lines := strings.Split(r.source, "\n")
+	if r.line < 1 || r.line > len(lines) {
+		return ""
+	}
+	return lines[r.line-1]
+}
+
+// literal returns the value of a string literal of the script.
+func (r *scriptRun) literal(s string) string {
+	return scriptLiteral(s, r.lang.escapes)
+}
+
+// print writes a string literal to stdout.
+func (r *scriptRun) print(m []string) bool {
+	r.fs.RecordWrite(r.literal(m[1]) + r.ors)
+	return true
+}
+
+// println writes a string literal and a new line to stdout.
+func (r *scriptRun) println(m []string) bool {
+	r.fs.RecordWriteLn(r.literal(m[1]))
+	return true
+}
+
+// shell runs a command with /bin/sh like system() does. Output of commands whose output goes to the script
+// (popen, backticks) is discarded. The exit code of the command doesn't end the script.
+func (r *scriptRun) shell(command string, captured bool) bool {
+	if captured {
+		stdout := r.fs.streams.stdout
+		r.fs.streams.stdout = &shellOutput{}
+		defer func() {
+			r.fs.streams.stdout = stdout
+		}()
+	}
+	r.fs.runScript("sh", command, nil, true)
+	r.fs.exitCode = 0
+	return true
+}
+
+// fetch requests the URL from the fake internet, it returns nil if the URL is invalid.
+func (r *scriptRun) fetch(rawURL string) *download {
+	d, err := r.fs.fetch(rawURL, "http")
+	if err != nil {
+		return nil
+	}
+	r.fs.downloadDelay()
+	return d
+}
+
+func cmdPython(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+	lang, version := pythonLanguage(name)
+	usage := func(msg string) {
+		fs.RecordErrorLn(msg)
+		fs.RecordErrorLn(fmt.Sprintf("usage: %s [option] ... [-c cmd | -m mod | file | -] [arg] ...", name))
+		fs.RecordErrorLn(fmt.Sprintf("Try `%s -h' for more information.", name))
+		fs.exitCode = 2
+	}
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--version" {
+			fs.RecordWriteLn(version)
+			return
+		}
+		if arg == "--" {
+			i++
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		for j := 1; j < len(arg); j++ {
+			switch c := arg[j]; c {
+			case 'c', 'm', 'W', 'X':
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						usage(fmt.Sprintf("Argument expected for the -%c option", c))
+						return
+					}
+					i++
+					value = args[i]
+				}
+				switch c {
+				case 'c':
+					defer fs.recordInline(name+" -c", value)()
+					fs.runSource(&scriptRun{lang: lang, name: name, file: "<string>", source: value})
+					return
+				case 'm':
+					if !pythonModules[value] {
+						fs.RecordErrorLn(fmt.Sprintf("/usr/bin/%s: No module named %s", name, value))
+						fs.exitCode = 1
+					}
+					return
+				}
+				j = len(arg)
+			case 'V':
+				fs.RecordWriteLn(version)
+				return
+			case 'h':
+				fs.RecordWriteLn(fmt.Sprintf("usage: %s [option] ... [-c cmd | -m mod | file | -] [arg] ...", name))
+				return
+			default:
+				if !strings.ContainsRune("bBdEiIOqsSuvx", rune(c)) {
+					usage(fmt.Sprintf("Unknown option: -%c", c))
+					return
+				}
+			}
+		}
+	}
+
+	if i >= len(args) || args[i] == "-" {
+		// without a script the interpreter reads it from stdin, e.g. `curl ... | python3`
+		if stdin, ok := fs.Stdin(); ok && strings.TrimSpace(stdin) != "" {
+			defer fs.recordStage(name, stdin)()
+			fs.runSource(&scriptRun{lang: lang, name: name, file: "<stdin>", source: stdin})
+		}
+		return // an interactive interpreter, nothing to do
+	}
+
+	script := args[i]
+	data, err := fs.readScript(script)
+	if err != nil {
+		var errno syscall.Errno
+		if errors.As(err, &errno) && errno == syscall.EISDIR {
+			fs.RecordErrorLn(fmt.Sprintf("/usr/bin/%s: can't find '__main__' module in '%s'", name, toAbs(fs, script)))
+		} else {
+			fs.RecordErrorLn(fmt.Sprintf("/usr/bin/%s: can't open file '%s': [Errno 2] %s", name, toAbs(fs, script), errorString(err)))
+		}
+		fs.exitCode = 2
+		return
+	}
+	fs.runSource(&scriptRun{lang: lang, name: name, file: script, source: data})
+	return
+}
+
+// pythonLanguage returns the language and the version of the Python interpreter with the name.
+func pythonLanguage(name string) (*scriptLanguage, string) {
+	if strings.HasPrefix(name, "python2") {
+		return python2, "Python 2.7.18"
+	}
+	return python3, "Python 3.10.12"
+}
+
+// readScript reads a script file given to an interpreter and records its execution.
+func (fs *FakeShell) readScript(script string) (string, error) {
+	path := toAbs(fs, script)
+	if fs.ffs.DirExists(path) {
+		return "", syscall.EISDIR
+	}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fs.RecordEvent("exec", path, SaveSample(data))
+	return string(data), nil
+}
+
+// pythonModules are the modules of the standard library bots run with -m, others are missing like pip usually is.
+var pythonModules = map[string]bool{
+	"base64": true, "http.server": true, "SimpleHTTPServer": true, "json.tool": true, "pty": true, "venv": true,
+}
+
+// exception reports an uncaught Python exception at the current line and ends the script.
+func (r *scriptRun) exception(exception string) bool {
+	r.fs.RecordErrorLn("Traceback (most recent call last):")
+	r.fs.RecordErrorLn(fmt.Sprintf("  File \"%s\", line %d, in <module>", r.file, r.line))
+	if !strings.HasPrefix(r.file, "<") {
+		r.fs.RecordErrorLn("    " + strings.TrimSpace(r.sourceLine()))
+	}
+	r.fs.RecordErrorLn(exception)
+	r.fs.exitCode = 1
+	return false
+}
+
+// pythonError returns the first exception if the script runs with Python 3, the second one with Python 2.
+func (r *scriptRun) pythonError(python3, python2 string) string {
+	if strings.HasPrefix(r.name, "python2") {
+		return python2
+	}
+	return python3
+}
+
+// pythonDownload answers a download with urllib, the data is written to the file unless it is empty.
+func (r *scriptRun) pythonDownload(rawURL, file string) bool {
+	d := r.fetch(rawURL)
+	if d == nil {
+		return r.exception(fmt.Sprintf("ValueError: unknown url type: '%s'", rawURL))
+	}
+	if d.status >= 400 {
+		return r.exception(fmt.Sprintf(r.pythonError("urllib.error.HTTPError: HTTP Error %s", "urllib2.HTTPError: HTTP Error %s"), strings.Replace(d.StatusText(), " ", ": ", 1)))
+	}
+	if file == "" {
+		return true
+	}
+	if err := r.fs.writeDownload(file, d.data, false); err != nil {
+		exception, errno := "OSError", 5
+		switch err.Error() {
+		case "No such file or directory":
+			exception, errno = "FileNotFoundError", 2
+		case "Permission denied":
+			exception, errno = "PermissionError", 13
+		case "Is a directory":
+			exception, errno = "IsADirectoryError", 21
+		}
+		return r.exception(fmt.Sprintf("%s: [Errno %d] %s: '%s'", r.pythonError(exception, "IOError"), errno, err.Error(), file))
+	}
+	return true
+}
+
+var pythonPrintStatement = scriptPattern(`\bprint\s+{str}`)
+
+// python3Check fails scripts that use the print statement of Python 2.
+func python3Check(r *scriptRun) bool {
+	loc := pythonPrintStatement.FindStringIndex(r.source)
+	if loc == nil {
+		return true
+	}
+	// Python 3 doesn't compile Python 2 scripts
+	start := strings.LastIndex(r.source[:loc[0]], "\n") + 1
+	r.line = strings.Count(r.source[:loc[0]], "\n") + 1
+	r.fs.RecordErrorLn(fmt.Sprintf("  File \"%s\", line %d", r.file, r.line))
+	r.fs.RecordErrorLn("    " + r.sourceLine())
+	r.fs.RecordErrorLn("    " + strings.Repeat(" ", loc[0]-start) + strings.Repeat("^", loc[1]-loc[0]))
+	r.fs.RecordErrorLn("SyntaxError: Missing parentheses in call to 'print'. Did you mean print(...)?")
+	r.fs.exitCode = 1
+	return false
+}
+
+func pythonEffects() []scriptEffect {
+	return []scriptEffect{
+		{pythonPrintCall, (*scriptRun).pythonPrint},
+		{pythonAssignment, (*scriptRun).pythonAssign},
+		{pythonPrintStatement, (*scriptRun).println},
+		{scriptPattern(`\b(?:os\.system|subprocess\.(?:call|run|check_call|Popen))\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.shell(r.literal(m[1]), false)
+		}},
+		{scriptPattern(`\b(?:os\.popen|subprocess\.(?:check_output|getoutput|getstatusoutput)|commands\.getoutput)\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.shell(r.literal(m[1]), true)
+		}},
+		{scriptPattern(`\bsubprocess\.(call|run|check_call|Popen|check_output)\(\s*\[((?:\s*{str}\s*,?)+)\]`), func(r *scriptRun, m []string) bool {
+			argv := []string{}
+			for _, s := range scriptStringLiteral.FindAllString(m[2], -1) {
+				argv = append(argv, printfQuote(r.literal(s)))
+			}
+			return r.shell(strings.Join(argv, " "), m[1] == "check_output")
+		}},
+		{scriptPattern(`\burlretrieve\(\s*{str}\s*,\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.pythonDownload(r.literal(m[1]), r.literal(m[2]))
+		}},
+		{scriptPattern(`\burlopen\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.pythonDownload(r.literal(m[1]), "")
+		}},
+		{scriptPattern(`\brequests\.get\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			r.fetch(r.literal(m[1])) // requests doesn't raise on HTTP errors
+			return true
+		}},
+		{scriptPattern(`(?:\.connect|\bcreate_connection)\(\s*\(\s*{str}\s*,\s*(\d+)`), func(r *scriptRun, m []string) bool {
+			r.fs.downloadDelay()
+			return r.exception(r.pythonError("ConnectionRefusedError: [Errno 111] Connection refused", "socket.error: [Errno 111] Connection refused"))
+		}},
+	}
+}
+
+func cmdPerl(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+	code := []string{}
+	ors := ""
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--version" {
+			fs.RecordWriteLn(perlVersion(fs))
+			return
+		}
+		if arg == "--" {
+			i++
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		for j := 1; j < len(arg); j++ {
+			switch c := arg[j]; c {
+			case 'e', 'E':
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						fs.RecordErrorLn(fmt.Sprintf("No code specified for -%c.", c))
+						fs.exitCode = 2
+						return
+					}
+					i++
+					value = args[i]
+				}
+				code = append(code, value)
+				j = len(arg)
+			case 'M', 'm', 'I', 'i', 'x', 'd', 'D':
+				j = len(arg) // the rest of the argument is the value of the option
+			case 'l', '0', 'C':
+				if c == 'l' {
+					ors = "\n"
+				}
+				for j+1 < len(arg) && arg[j+1] >= '0' && arg[j+1] <= '9' {
+					j++
+				}
+			case 'v':
+				fs.RecordWriteLn(perlVersion(fs))
+				return
+			default:
+				if !strings.ContainsRune("acfnpsStTuUwWX", rune(c)) {
+					fs.RecordErrorLn(fmt.Sprintf("Unrecognized switch: -%c  (-h will show valid options).", c))
+					fs.exitCode = 29
+					return
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(code) > 0:
+		source := strings.Join(code, "\n")
+		defer fs.recordInline(name+" -e", source)()
+		fs.runSource(&scriptRun{lang: perl, name: name, file: "-e", source: source, ors: ors})
+	case i >= len(args) || args[i] == "-":
+		if stdin, ok := fs.Stdin(); ok && strings.TrimSpace(stdin) != "" {
+			defer fs.recordStage(name, stdin)()
+			fs.runSource(&scriptRun{lang: perl, name: name, file: "-", source: stdin, ors: ors})
+		}
+	default:
+		data, err := fs.readScript(args[i])
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("Can't open perl script \"%s\": %s", args[i], errorString(err)))
+			fs.exitCode = 2
+			return
+		}
+		fs.runSource(&scriptRun{lang: perl, name: name, file: args[i], source: data, ors: ors})
+	}
+	return
+}
+
+// perlVersion returns the banner of perl -v.
+func perlVersion(fs *FakeShell) string {
+	return fmt.Sprintf(`
+This is perl 5, version 34, subversion 0 (v5.34.0) built for %s-linux-gnu-thread-multi
+(with 60 registered patches, see perl -V for more detail)
+
+Copyright 1987-2021, Larry Wall
+
+Perl may be copied only under the terms of either the Artistic License or the
+GNU General Public License, which may be found in the Perl 5 source kit.
+
+Complete documentation for Perl, including FAQ lists, should be found on
+this system using "man perl" or "perldoc perl".  If you have access to the
+Internet, point your browser at http://www.perl.org/, the Perl Home Page.
+`, fs.ffs.Persona().Uname.Machine)
+}
+
+func perlEffects() []scriptEffect {
+	return []scriptEffect{
+		{scriptPattern(`\bprint\s*\(?\s*{str}`), (*scriptRun).print},
+		{scriptPattern(`\bsay\s*\(?\s*{str}`), (*scriptRun).println},
+		{scriptPattern(`\b(?:system|exec)\s*\(?\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.shell(r.literal(m[1]), false)
+		}},
+		{regexp.MustCompile("`([^`]*)`|\\bqx\\s*[{(/]([^})/]*)[})/]"), func(r *scriptRun, m []string) bool {
+			return r.shell(m[1]+m[2], true)
+		}},
+		{scriptPattern(`\b(?:getstore|mirror)\s*\(\s*{str}\s*,\s*{str}`), func(r *scriptRun, m []string) bool {
+			// LWP::Simple returns the status instead of dying
+			if d := r.fetch(r.literal(m[1])); d != nil && d.status < 400 {
+				_ = r.fs.writeDownload(r.literal(m[2]), d.data, false)
+			}
+			return true
+		}},
+		{scriptPattern(`\bget\s*\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			r.fetch(r.literal(m[1]))
+			return true
+		}},
+		{regexp.MustCompile(`\bconnect\s*\(|\bIO::Socket::INET\b`), func(r *scriptRun, m []string) bool {
+			// the connection fails, one-liners just end then
+			r.fs.downloadDelay()
+			return false
+		}},
+	}
+}
+
+func cmdPhp(fs *FakeShell, args []string) (exit bool) {
+	usage := func(msg string) {
+		fs.RecordErrorLn(msg)
+		fs.RecordErrorLn("Usage: php [options] [-f] <file> [--] [args...]")
+		fs.exitCode = 1
+	}
+
+	script := ""
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--version" {
+			fs.RecordWriteLn(phpVersion)
+			return
+		}
+		if arg == "--" {
+			i++
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		c := arg[1]
+		switch c {
+		case 'r', 'f', 'd', 'c', 'z', 'B', 'R', 'F', 'E':
+			value := arg[2:]
+			if value == "" {
+				if i+1 >= len(args) {
+					usage(fmt.Sprintf("Error in argument %d, char 2: option requires an argument -- %c", i, c))
+					return
+				}
+				i++
+				value = args[i]
+			}
+			switch c {
+			case 'r':
+				defer fs.recordInline("php -r", value)()
+				fs.runSource(&scriptRun{lang: php, name: "php", file: "Command line code", source: value})
+				return
+			case 'f':
+				script = value
+			}
+		case 'v':
+			fs.RecordWriteLn(phpVersion)
+			return
+		case 'n', 'q', 'e', 'H', 'C', 'a', 'l', 's', 'w':
+		default:
+			usage(fmt.Sprintf("Error in argument %d, char 2: no argument for option %c", i, c))
+			return
+		}
+		if script != "" {
+			break
+		}
+	}
+	if script == "" && i < len(args) && args[i] != "-" {
+		script = args[i]
+	}
+
+	source, file := "", script
+	if script == "" {
+		stdin, ok := fs.Stdin()
+		if !ok || strings.TrimSpace(stdin) == "" {
+			return // an interactive interpreter, nothing to do
+		}
+		defer fs.recordStage("php", stdin)()
+		source, file = stdin, "Standard input code"
+	} else {
+		data, err := fs.readScript(script)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("Could not open input file: %s", script))
+			fs.exitCode = 1
+			return
+		}
+		source = data
+	}
+	fs.runPhp(file, source)
+	return
+}
+
+// runPhp emulates a PHP script. Text outside of the PHP tags is printed as it is.
+func (fs *FakeShell) runPhp(file, source string) {
+	if !strings.Contains(source, "<?") {
+		fs.RecordWrite(source)
+		return
+	}
+	fs.runSource(&scriptRun{lang: php, name: "php", file: file, source: source})
+}
+
+const phpVersion = `PHP 8.1.2-1ubuntu2.14 (cli) (built: Aug 18 2023 11:41:11) (NTS)
+Copyright (c) The PHP Group
+Zend Engine v4.1.2, Copyright (c) Zend Technologies
+    with Zend OPcache v8.1.2-1ubuntu2.14, Copyright (c), by Zend Technologies`
+
+// phpWarning reports a warning of PHP at the current line, the script goes on.
+func (r *scriptRun) phpWarning(msg string) bool {
+	r.fs.RecordErrorLn(fmt.Sprintf("PHP Warning:  %s in %s on line %d", msg, r.file, r.line))
+	return true
+}
+
+// phpDownload answers a download with the stream functions of PHP, the data is written to the file unless it is empty.
+func (r *scriptRun) phpDownload(function, rawURL, file string) bool {
+	d := r.fetch(rawURL)
+	if d == nil {
+		return r.phpWarning(fmt.Sprintf("%s(%s): Failed to open stream: No such file or directory", function, rawURL))
+	}
+	if d.status >= 400 {
+		return r.phpWarning(fmt.Sprintf("%s(%s): Failed to open stream: HTTP request failed! HTTP/1.1 %s", function, rawURL, d.StatusText()))
+	}
+	if file == "" {
+		return true
+	}
+	if err := r.fs.writeDownload(file, d.data, false); err != nil {
+		return r.phpWarning(fmt.Sprintf("%s(%s): Failed to open stream: %s", function, file, err.Error()))
+	}
+	return true
+}
+
+func phpEffects() []scriptEffect {
+	return []scriptEffect{
+		{scriptPattern(`\b(?:echo|print)\s*\(?\s*{str}`), (*scriptRun).print},
+		{scriptPattern(`\b(?:system|passthru)\s*\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.shell(r.literal(m[1]), false)
+		}},
+		{scriptPattern(`\b(?:exec|shell_exec|popen|proc_open)\s*\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.shell(r.literal(m[1]), true)
+		}},
+		{regexp.MustCompile("`([^`]*)`"), func(r *scriptRun, m []string) bool {
+			return r.shell(m[1], true)
+		}},
+		{scriptPattern(`\bcopy\s*\(\s*{str}\s*,\s*{str}`), func(r *scriptRun, m []string) bool {
+			if src := r.literal(m[1]); strings.Contains(src, "://") {
+				return r.phpDownload("copy", src, r.literal(m[2]))
+			}
+			return true
+		}},
+		{scriptPattern(`\bfile_put_contents\s*\(\s*{str}\s*,\s*file_get_contents\s*\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			return r.phpDownload("file_get_contents", r.literal(m[2]), r.literal(m[1]))
+		}},
+		{scriptPattern(`\bfile_get_contents\s*\(\s*{str}`), func(r *scriptRun, m []string) bool {
+			if src := r.literal(m[1]); strings.Contains(src, "://") {
+				return r.phpDownload("file_get_contents", src, "")
+			}
+			return true
+		}},
+		{scriptPattern(`\bfsockopen\s*\(\s*{str}\s*,\s*(\d+)`), func(r *scriptRun, m []string) bool {
+			r.fs.downloadDelay()
+			return r.phpWarning(fmt.Sprintf("fsockopen(): Unable to connect to %s:%s (Connection refused)", r.literal(m[1]), m[2]))
+		}},
+	}
+}
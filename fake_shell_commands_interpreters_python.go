@@ -0,0 +1,1095 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pythonPrintCall matches print() with arguments nested up to three levels deep, e.g. print(os.popen("id").read()).
+var pythonPrintCall = scriptPattern(`\bprint\s*\(((?:[^()'"]|[bfr]?{str}|\((?:[^()'"]|[bfr]?{str}|\((?:[^()'"]|[bfr]?{str})*\))*\))*)\)`)
+
+// pythonAssignment matches assignments of expressions to a variable, print() can use the variable later on.
+var pythonAssignment = regexp.MustCompile(`(?m)\b([A-Za-z_]\w*)[ \t]*=[ \t]*((?:[-+*/%()\[\]., \t\w]|` + scriptStringPattern + `)+)(?:;|$)`)
+
+// pythonValue is the value of an expression: nil (None), bool, int64, float64, string, pythonList or pythonTuple.
+type pythonValue interface{}
+
+// pythonList is the value of a list, pythonTuple the one of a tuple.
+type (
+	pythonList  []pythonValue
+	pythonTuple []pythonValue
+)
+
+// pythonUnknown is the value of a variable that was assigned something the emulation can't evaluate.
+type pythonUnknown struct{}
+
+// pythonException is raised by an expression, e.g. "NameError: name 'x' is not defined".
+type pythonException string
+
+// pythonNode is a node of a parsed expression.
+type pythonNode struct {
+	op    string // a binary operator, "-" with one operand, "call", "name", "attr", "index", "list", "tuple" or "" for constants
+	name  string
+	value pythonValue
+	args  []*pythonNode
+}
+
+// pythonParser parses the expressions print() is called with. Only literals, arithmetic, variables and a few
+// well-known calls are supported, parse fails for everything else so the caller can fall back to the effects.
+type pythonParser struct {
+	tokens []string
+	pos    int
+}
+
+var pythonToken = regexp.MustCompile(`^(?:\s+|[bfr]?` + scriptStringPattern + `|\d+\.\d*|\.\d+|\d+|[A-Za-z_][\w.]*|\.[A-Za-z_]\w*|\*\*|//|[-+*/%(),=\[\]])`)
+
+// pythonCalls are the functions and methods print() can show the result of.
+var pythonCalls = map[string]bool{
+	"len": true, "str": true, "int": true, "float": true, "abs": true, "repr": true,
+	"os.getcwd": true, "os.getuid": true, "os.geteuid": true, "os.getpid": true, "os.getlogin": true, "getpass.getuser": true,
+	"socket.gethostname": true, "platform.node": true, "platform.machine": true, "platform.system": true, "platform.release": true,
+	"os.cpu_count": true, "multiprocessing.cpu_count": true, "os.popen": true, "os.system": true, "subprocess.getoutput": true, ".read": true,
+	".strip": true, ".upper": true, ".lower": true,
+}
+
+// pythonAttributes are the module attributes print() can show.
+var pythonAttributes = map[string]bool{"sys.platform": true, "os.name": true, "os.sep": true}
+
+// pythonTokens splits source into tokens, ok is false if it contains something the parser doesn't know.
+func pythonTokens(source string) (tokens []string, ok bool) {
+	for rest := source; rest != ""; {
+		loc := pythonToken.FindStringIndex(rest)
+		if loc == nil {
+			return tokens, false
+		}
+		if t := rest[:loc[1]]; strings.TrimSpace(t) != "" {
+			tokens = append(tokens, t)
+		}
+		rest = rest[loc[1]:]
+	}
+	return tokens, true
+}
+
+func parsePython(source string) ([]*pythonNode, map[string]*pythonNode, bool) {
+	tokens, ok := pythonTokens(source)
+	if !ok {
+		return nil, nil, false
+	}
+	p := &pythonParser{tokens: tokens}
+	args, kwargs := []*pythonNode{}, map[string]*pythonNode{}
+	for p.pos < len(p.tokens) {
+		if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == "=" {
+			name := p.tokens[p.pos]
+			p.pos += 2
+			n := p.expr()
+			if n == nil {
+				return nil, nil, false
+			}
+			kwargs[name] = n
+		} else {
+			n := p.expr()
+			if n == nil {
+				return nil, nil, false
+			}
+			args = append(args, n)
+		}
+		if p.pos < len(p.tokens) && !p.accept(",") {
+			return nil, nil, false
+		}
+	}
+	return args, kwargs, true
+}
+
+func (p *pythonParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *pythonParser) accept(t string) bool {
+	if p.peek() == t {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pythonParser) expr() *pythonNode {
+	n := p.term()
+	for n != nil && (p.peek() == "+" || p.peek() == "-") {
+		op := p.tokens[p.pos]
+		p.pos++
+		m := p.term()
+		if m == nil {
+			return nil
+		}
+		n = &pythonNode{op: op, args: []*pythonNode{n, m}}
+	}
+	return n
+}
+
+func (p *pythonParser) term() *pythonNode {
+	n := p.unary()
+	for n != nil && (p.peek() == "*" || p.peek() == "/" || p.peek() == "//" || p.peek() == "%") {
+		op := p.tokens[p.pos]
+		p.pos++
+		m := p.unary()
+		if m == nil {
+			return nil
+		}
+		n = &pythonNode{op: op, args: []*pythonNode{n, m}}
+	}
+	return n
+}
+
+func (p *pythonParser) unary() *pythonNode {
+	if p.accept("-") {
+		if n := p.unary(); n != nil {
+			return &pythonNode{op: "-", args: []*pythonNode{n}}
+		}
+		return nil
+	}
+	if p.accept("+") {
+		return p.unary()
+	}
+	n := p.atom()
+	if n != nil && p.accept("**") {
+		m := p.unary()
+		if m == nil {
+			return nil
+		}
+		n = &pythonNode{op: "**", args: []*pythonNode{n, m}}
+	}
+	return n
+}
+
+func (p *pythonParser) atom() *pythonNode {
+	t := p.peek()
+	p.pos++
+	switch {
+	case t == "(":
+		if p.accept(")") {
+			return p.trailers(&pythonNode{op: "tuple"})
+		}
+		n := p.expr()
+		if n == nil {
+			return nil
+		}
+		if p.peek() == "," {
+			n = &pythonNode{op: "tuple", args: []*pythonNode{n}}
+			for p.accept(",") && p.peek() != ")" {
+				m := p.expr()
+				if m == nil {
+					return nil
+				}
+				n.args = append(n.args, m)
+			}
+		}
+		if !p.accept(")") {
+			return nil
+		}
+		return p.trailers(n)
+	case t == "[":
+		n := &pythonNode{op: "list"}
+		for !p.accept("]") {
+			m := p.expr()
+			if m == nil {
+				return nil
+			}
+			n.args = append(n.args, m)
+			if !p.accept(",") && p.peek() != "]" {
+				return nil
+			}
+		}
+		return p.trailers(n)
+	case t == "" || strings.ContainsAny(t[:1], "-+*/%),=]"):
+		return nil
+	case t[0] >= '0' && t[0] <= '9' || t[0] == '.':
+		if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return &pythonNode{value: i}
+		}
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil
+		}
+		return &pythonNode{value: f}
+	case strings.ContainsAny(t[len(t)-1:], `'"`):
+		// adjacent string literals are concatenated
+		n := pythonString(t)
+		for strings.ContainsAny(p.peek(), `'"`) {
+			n = &pythonNode{op: "+", args: []*pythonNode{n, pythonString(p.tokens[p.pos])}}
+			p.pos++
+		}
+		return p.trailers(n)
+	}
+	switch t {
+	case "True", "False":
+		return &pythonNode{value: t == "True"}
+	case "None":
+		return &pythonNode{}
+	}
+	if i := strings.LastIndex(t, "."); i > 0 && !pythonCalls[t] && !pythonAttributes[t] && pythonCalls[t[i:]] {
+		// a method of a variable, e.g. x.strip(), is split into the variable and the method
+		p.tokens = append(p.tokens[:p.pos-1], append([]string{t[:i], t[i:]}, p.tokens[p.pos:]...)...)
+		p.pos--
+		return p.atom()
+	}
+	if p.accept("(") {
+		if !pythonCalls[t] {
+			return nil
+		}
+		n := &pythonNode{op: "call", name: t}
+		for !p.accept(")") {
+			arg := p.expr()
+			if arg == nil {
+				return nil
+			}
+			n.args = append(n.args, arg)
+			if !p.accept(",") && p.peek() != ")" {
+				return nil
+			}
+		}
+		return p.trailers(n)
+	}
+	if strings.Contains(t, ".") {
+		if !pythonAttributes[t] {
+			return nil
+		}
+		return &pythonNode{op: "attr", name: t}
+	}
+	return p.trailers(&pythonNode{op: "name", name: t})
+}
+
+// trailers parses the subscripts and the calls of string methods that follow a value, e.g. [0], .read() or .strip().
+func (p *pythonParser) trailers(n *pythonNode) *pythonNode {
+	for {
+		switch {
+		case p.accept("["):
+			i := p.expr()
+			if i == nil || !p.accept("]") {
+				return nil
+			}
+			n = &pythonNode{op: "index", args: []*pythonNode{n, i}}
+		case strings.HasPrefix(p.peek(), "."):
+			method := p.tokens[p.pos]
+			if !pythonCalls[method] || p.pos+2 >= len(p.tokens) || p.tokens[p.pos+1] != "(" || p.tokens[p.pos+2] != ")" {
+				return nil
+			}
+			p.pos += 3
+			n = &pythonNode{op: "call", name: method, args: []*pythonNode{n}}
+		default:
+			return n
+		}
+	}
+}
+
+// pythonString returns the node of a string literal, f-strings are marked to be formatted when evaluated.
+func pythonString(t string) *pythonNode {
+	switch t[0] {
+	case 'f':
+		return &pythonNode{op: "fstring", value: t[1:]}
+	case 'b', 'r':
+		t = t[1:]
+	}
+	return &pythonNode{value: t}
+}
+
+// pythonFloat formats a float like Python's repr does.
+func pythonFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if a := math.Abs(f); a >= 1e16 || (a < 1e-4 && a != 0) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// pythonStr converts a value to a string like str() does.
+func pythonStr(v pythonValue) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return pythonFloat(v)
+	case string:
+		return v
+	case pythonList:
+		return "[" + pythonReprs(v) + "]"
+	case pythonTuple:
+		if len(v) == 1 {
+			return "(" + pythonRepr(v[0]) + ",)"
+		}
+		return "(" + pythonReprs(v) + ")"
+	}
+	return ""
+}
+
+// pythonReprs joins the representations of the items of a list or a tuple.
+func pythonReprs(items []pythonValue) string {
+	reprs := []string{}
+	for _, v := range items {
+		reprs = append(reprs, pythonRepr(v))
+	}
+	return strings.Join(reprs, ", ")
+}
+
+// pythonRepr converts a value to a string like repr() does.
+func pythonRepr(v pythonValue) string {
+	if s, ok := v.(string); ok {
+		s = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`).Replace(s)
+		if strings.Contains(s, "'") && !strings.Contains(s, `"`) {
+			return `"` + s + `"` // Python prefers double quotes over escaping single ones
+		}
+		return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+	}
+	return pythonStr(v)
+}
+
+// pythonTypeName returns the name of the type of a value for error messages.
+func pythonTypeName(v pythonValue) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case pythonList:
+		return "list"
+	case pythonTuple:
+		return "tuple"
+	}
+	return "str"
+}
+
+// pythonItems returns the items of a list, a tuple or a string.
+func pythonItems(v pythonValue) ([]pythonValue, bool) {
+	switch v := v.(type) {
+	case pythonList:
+		return v, true
+	case pythonTuple:
+		return v, true
+	case string:
+		items := []pythonValue{}
+		for _, c := range v {
+			items = append(items, string(c))
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// pythonNumber returns the value as float and whether it is a number at all, bools count as numbers.
+func pythonNumber(v pythonValue) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// pythonInt returns the value as integer if it is an int or a bool.
+func pythonInt(v pythonValue) (int64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// eval evaluates a parsed expression.
+func (r *scriptRun) eval(n *pythonNode) (pythonValue, error) {
+	python2 := r.python2()
+	switch n.op {
+	case "":
+		if s, ok := n.value.(string); ok {
+			return r.literal(s), nil
+		}
+		return n.value, nil
+	case "fstring":
+		return r.formatString(r.literal(n.value.(string)))
+	case "name":
+		if v, ok := r.vars[n.name]; ok {
+			if _, unknown := v.(pythonUnknown); unknown {
+				return nil, errPythonUnsupported
+			}
+			return v, nil
+		}
+		if r.definedNames()[n.name] {
+			return nil, errPythonUnsupported // defined by something the emulation doesn't understand
+		}
+		return nil, pythonException(fmt.Sprintf("NameError: name '%s' is not defined", n.name))
+	case "attr":
+		return map[string]pythonValue{"sys.platform": r.pythonError("linux", "linux2"), "os.name": "posix", "os.sep": "/"}[n.name], nil
+	case "call":
+		return r.call(n)
+	}
+
+	values := []pythonValue{}
+	for _, arg := range n.args {
+		v, err := r.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	switch n.op {
+	case "list":
+		return pythonList(values), nil
+	case "tuple":
+		return pythonTuple(values), nil
+	case "index":
+		return r.index(values[0], values[1])
+	}
+	if n.op == "-" && len(values) == 1 {
+		if i, ok := pythonInt(values[0]); ok {
+			return -i, nil
+		}
+		if f, ok := pythonNumber(values[0]); ok {
+			return -f, nil
+		}
+		return nil, pythonException(fmt.Sprintf("TypeError: bad operand type for unary -: '%s'", pythonTypeName(values[0])))
+	}
+
+	a, b := values[0], values[1]
+	typeError := func() error {
+		return pythonException(fmt.Sprintf("TypeError: unsupported operand type(s) for %s: '%s' and '%s'", n.op, pythonTypeName(a), pythonTypeName(b)))
+	}
+	if v, ok, err := r.sequenceOp(n.op, a, b); ok {
+		return v, err
+	}
+	sa, aIsStr := a.(string)
+	sb, bIsStr := b.(string)
+	switch {
+	case n.op == "+" && aIsStr && bIsStr:
+		return sa + sb, nil
+	case n.op == "+" && (aIsStr || bIsStr):
+		if aIsStr {
+			return nil, pythonException(r.pythonError(fmt.Sprintf(`TypeError: can only concatenate str (not "%s") to str`, pythonTypeName(b)),
+				fmt.Sprintf("TypeError: cannot concatenate 'str' and '%s' objects", pythonTypeName(b))))
+		}
+		return nil, typeError()
+	case n.op == "*" && (aIsStr || bIsStr):
+		s, count := sa, b
+		if bIsStr {
+			s, count = sb, a
+		}
+		times, ok := pythonInt(count)
+		if !ok || (aIsStr && bIsStr) {
+			return nil, pythonException(fmt.Sprintf("TypeError: can't multiply sequence by non-int of type '%s'", pythonTypeName(count)))
+		}
+		if times <= 0 {
+			return "", nil
+		}
+		if int64(len(s))*times > 1<<16 {
+			return nil, pythonException("MemoryError")
+		}
+		return strings.Repeat(s, int(times)), nil
+	case n.op == "%" && aIsStr:
+		return r.formatPercent(sa, b)
+	}
+
+	ia, aInt := pythonInt(a)
+	ib, bInt := pythonInt(b)
+	fa, aNum := pythonNumber(a)
+	fb, bNum := pythonNumber(b)
+	if !aNum || !bNum {
+		return nil, typeError()
+	}
+	zero := func(integer bool) error {
+		if python2 && integer {
+			return pythonException("ZeroDivisionError: integer division or modulo by zero")
+		}
+		if n.op == "%" && !python2 {
+			return pythonException(map[bool]string{true: "ZeroDivisionError: integer modulo by zero", false: "ZeroDivisionError: float modulo"}[integer])
+		}
+		if n.op == "//" && !python2 {
+			return pythonException(map[bool]string{true: "ZeroDivisionError: integer division or modulo by zero", false: "ZeroDivisionError: float divmod()"}[integer])
+		}
+		return pythonException(r.pythonError("ZeroDivisionError: division by zero", "ZeroDivisionError: float division by zero"))
+	}
+	integers := aInt && bInt
+	switch n.op {
+	case "+":
+		if integers {
+			return ia + ib, nil
+		}
+		return fa + fb, nil
+	case "-":
+		if integers {
+			return ia - ib, nil
+		}
+		return fa - fb, nil
+	case "*":
+		if integers {
+			return ia * ib, nil
+		}
+		return fa * fb, nil
+	case "/":
+		if fb == 0 {
+			return nil, zero(integers)
+		}
+		if integers && python2 {
+			return pythonFloorDiv(ia, ib), nil
+		}
+		return fa / fb, nil
+	case "//":
+		if fb == 0 {
+			return nil, zero(integers)
+		}
+		if integers {
+			return pythonFloorDiv(ia, ib), nil
+		}
+		return math.Floor(fa / fb), nil
+	case "%":
+		if fb == 0 {
+			return nil, zero(integers)
+		}
+		if integers {
+			return ia - pythonFloorDiv(ia, ib)*ib, nil
+		}
+		return fa - math.Floor(fa/fb)*fb, nil
+	case "**":
+		if integers && ib >= 0 {
+			if p := math.Pow(fa, fb); math.Abs(p) < 1<<62 {
+				return int64(p), nil
+			}
+			return nil, pythonException("OverflowError: result too large to show")
+		}
+		return math.Pow(fa, fb), nil
+	}
+	return nil, typeError()
+}
+
+// sequenceOp evaluates + and * with lists and tuples, ok is false if neither operand is one.
+func (r *scriptRun) sequenceOp(op string, a, b pythonValue) (v pythonValue, ok bool, err error) {
+	_, aIsList := a.(pythonList)
+	_, aIsTuple := a.(pythonTuple)
+	_, bIsList := b.(pythonList)
+	_, bIsTuple := b.(pythonTuple)
+	if !aIsList && !aIsTuple && !bIsList && !bIsTuple {
+		return nil, false, nil
+	}
+	if _, isStr := a.(string); isStr && op == "%" {
+		return nil, false, nil // formatting with a tuple of arguments
+	}
+	wrap := func(items []pythonValue, tuple bool) pythonValue {
+		if tuple {
+			return pythonTuple(items)
+		}
+		return pythonList(items)
+	}
+	switch op {
+	case "+":
+		if aIsList && bIsList || aIsTuple && bIsTuple {
+			items, _ := pythonItems(a)
+			more, _ := pythonItems(b)
+			return wrap(append(append([]pythonValue{}, items...), more...), aIsTuple), true, nil
+		}
+		if aIsList || aIsTuple {
+			return nil, true, pythonException(fmt.Sprintf(`TypeError: can only concatenate %s (not "%s") to %s`, pythonTypeName(a), pythonTypeName(b), pythonTypeName(a)))
+		}
+	case "*":
+		seq, count := a, b
+		if bIsList || bIsTuple {
+			seq, count = b, a
+		}
+		times, isInt := pythonInt(count)
+		if !isInt {
+			return nil, true, pythonException(fmt.Sprintf("TypeError: can't multiply sequence by non-int of type '%s'", pythonTypeName(count)))
+		}
+		items, _ := pythonItems(seq)
+		if int64(len(items))*times > 1<<16 {
+			return nil, true, pythonException("MemoryError")
+		}
+		repeated := []pythonValue{}
+		for i := int64(0); i < times; i++ {
+			repeated = append(repeated, items...)
+		}
+		_, tuple := seq.(pythonTuple)
+		return wrap(repeated, tuple), true, nil
+	}
+	return nil, true, pythonException(fmt.Sprintf("TypeError: unsupported operand type(s) for %s: '%s' and '%s'", op, pythonTypeName(a), pythonTypeName(b)))
+}
+
+// index evaluates a subscript of a list, a tuple or a string.
+func (r *scriptRun) index(seq, i pythonValue) (pythonValue, error) {
+	items, ok := pythonItems(seq)
+	if !ok {
+		return nil, pythonException(r.pythonError(fmt.Sprintf("TypeError: '%s' object is not subscriptable", pythonTypeName(seq)),
+			fmt.Sprintf("TypeError: '%s' object has no attribute '__getitem__'", pythonTypeName(seq))))
+	}
+	kind := pythonTypeName(seq)
+	if kind == "str" {
+		kind = "string"
+	}
+	pos, ok := pythonInt(i)
+	if !ok {
+		if kind == "string" {
+			return nil, pythonException("TypeError: string indices must be integers")
+		}
+		return nil, pythonException(r.pythonError(fmt.Sprintf("TypeError: %s indices must be integers or slices, not %s", kind, pythonTypeName(i)),
+			fmt.Sprintf("TypeError: %s indices must be integers, not %s", kind, pythonTypeName(i))))
+	}
+	if pos < 0 {
+		pos += int64(len(items))
+	}
+	if pos < 0 || pos >= int64(len(items)) {
+		return nil, pythonException(fmt.Sprintf("IndexError: %s index out of range", kind))
+	}
+	return items[pos], nil
+}
+
+// pythonFloorDiv divides like Python does, rounding towards negative infinity.
+func pythonFloorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// call evaluates a call of one of the pythonCalls.
+func (r *scriptRun) call(n *pythonNode) (pythonValue, error) {
+	fs := r.fs
+	values := []pythonValue{}
+	for _, arg := range n.args {
+		v, err := r.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	arg := func() (pythonValue, error) {
+		if len(values) != 1 {
+			return nil, pythonException(fmt.Sprintf("TypeError: %s() takes exactly one argument (%d given)", n.name, len(values)))
+		}
+		return values[0], nil
+	}
+	uid, _, ok := fs.lookupUser(fs.User())
+	if !ok {
+		uid = 0
+	}
+
+	switch n.name {
+	case "os.getcwd":
+		return fs.cwd, nil
+	case "os.getuid", "os.geteuid":
+		return int64(uid), nil
+	case "os.getpid":
+		return int64(fs.pid + 1), nil
+	case "os.getlogin", "getpass.getuser":
+		return fs.User(), nil
+	case "socket.gethostname", "platform.node":
+		return fs.ffs.HostName(), nil
+	case "platform.machine":
+		return fs.ffs.Persona().Uname.Machine, nil
+	case "platform.system":
+		return fs.ffs.Persona().Uname.KernelName, nil
+	case "platform.release":
+		return fs.ffs.Persona().Uname.KernelRelease, nil
+	case "os.cpu_count", "multiprocessing.cpu_count":
+		return int64(fs.ffs.CPUCount()), nil
+	case "os.popen", "subprocess.getoutput":
+		v, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		output := r.capture(pythonStr(v))
+		if n.name == "subprocess.getoutput" {
+			output = strings.TrimSuffix(output, "\n")
+		}
+		return output, nil
+	case "os.system":
+		v, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		r.fs.runScript("sh", pythonStr(v), nil, true)
+		status := r.fs.exitCode
+		r.fs.exitCode = 0
+		return int64(status << 8), nil // the wait status
+	case ".read":
+		return values[0], nil
+	case ".strip", ".upper", ".lower":
+		s, isStr := values[0].(string)
+		if !isStr {
+			return nil, pythonException(fmt.Sprintf("AttributeError: '%s' object has no attribute '%s'", pythonTypeName(values[0]), n.name[1:]))
+		}
+		return map[string]func(string) string{".strip": strings.TrimSpace, ".upper": strings.ToUpper, ".lower": strings.ToLower}[n.name](s), nil
+	}
+
+	v, err := arg()
+	if err != nil {
+		return nil, err
+	}
+	switch n.name {
+	case "len":
+		if items, ok := pythonItems(v); ok {
+			return int64(len(items)), nil
+		}
+		return nil, pythonException(fmt.Sprintf("TypeError: object of type '%s' has no len()", pythonTypeName(v)))
+	case "str":
+		return pythonStr(v), nil
+	case "repr":
+		return pythonRepr(v), nil
+	case "abs":
+		if i, ok := pythonInt(v); ok {
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		if f, ok := pythonNumber(v); ok {
+			return math.Abs(f), nil
+		}
+	case "int":
+		if f, ok := pythonNumber(v); ok {
+			return int64(f), nil
+		}
+		if _, isStr := v.(string); !isStr {
+			return nil, pythonException(fmt.Sprintf("TypeError: int() argument must be a string, a bytes-like object or a number, not '%s'", pythonTypeName(v)))
+		}
+		if i, err := strconv.ParseInt(strings.TrimSpace(pythonStr(v)), 10, 64); err == nil {
+			return i, nil
+		}
+		return nil, pythonException(fmt.Sprintf("ValueError: invalid literal for int() with base 10: %s", pythonRepr(v)))
+	case "float":
+		if f, ok := pythonNumber(v); ok {
+			return f, nil
+		}
+		if _, isStr := v.(string); !isStr {
+			return nil, pythonException(fmt.Sprintf("TypeError: float() argument must be a string or a number, not '%s'", pythonTypeName(v)))
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(pythonStr(v)), 64); err == nil {
+			return f, nil
+		}
+		return nil, pythonException(fmt.Sprintf("ValueError: could not convert string to float: %s", pythonRepr(v)))
+	}
+	return nil, pythonException(fmt.Sprintf("TypeError: bad operand type for %s(): '%s'", n.name, pythonTypeName(v)))
+}
+
+// formatString formats an f-string, the fields can be any expression eval understands.
+func (r *scriptRun) formatString(s string) (pythonValue, error) {
+	sb := strings.Builder{}
+	for {
+		i := strings.IndexAny(s, "{}")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		sb.WriteString(s[:i])
+		if i+1 < len(s) && s[i+1] == s[i] {
+			sb.WriteByte(s[i]) // {{ and }} are literal braces
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if s[i] == '}' || end < 0 {
+			return nil, pythonException("SyntaxError: f-string: single '}' is not allowed")
+		}
+		args, _, ok := parsePython(s[i+1 : i+end])
+		if !ok || len(args) != 1 {
+			return nil, errPythonUnsupported
+		}
+		v, err := r.eval(args[0])
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(pythonStr(v))
+		s = s[i+end+1:]
+	}
+}
+
+// pythonFormatSpec matches a conversion of the % operator after the %, e.g. -10s or .2f.
+var pythonFormatSpec = regexp.MustCompile(`^([-+ 0#]*)(\d*)(?:\.(\d+))?([sdifrxXeEgGc%])`)
+
+// formatPercent formats a string with the % operator, a tuple on the right side holds one value per conversion.
+func (r *scriptRun) formatPercent(format string, arg pythonValue) (pythonValue, error) {
+	args := []pythonValue{arg}
+	if t, isTuple := arg.(pythonTuple); isTuple {
+		args = t
+	}
+	sb := strings.Builder{}
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		m := pythonFormatSpec.FindStringSubmatch(format[i+1:])
+		if m == nil {
+			if i+1 >= len(format) {
+				return nil, pythonException("ValueError: incomplete format")
+			}
+			return nil, pythonException(fmt.Sprintf("ValueError: unsupported format character '%c' (0x%x) at index %d", format[i+1], format[i+1], i+1))
+		}
+		i += len(m[0])
+		verb := m[4][0]
+		if verb == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		if next >= len(args) {
+			return nil, pythonException("TypeError: not enough arguments for format string")
+		}
+		v := args[next]
+		next++
+		spec := "%" + m[1] + m[2]
+		if m[3] != "" {
+			spec += "." + m[3]
+		}
+		switch verb {
+		case 's', 'r':
+			s := pythonStr(v)
+			if verb == 'r' {
+				s = pythonRepr(v)
+			}
+			sb.WriteString(fmt.Sprintf(spec+"s", s))
+		case 'c':
+			s, isStr := v.(string)
+			if c, isInt := pythonInt(v); isInt {
+				s, isStr = string(rune(c)), true
+			}
+			if !isStr || len([]rune(s)) != 1 {
+				return nil, pythonException("TypeError: %c requires int or char")
+			}
+			sb.WriteString(fmt.Sprintf(spec+"s", s))
+		case 'd', 'i', 'x', 'X':
+			f, ok := pythonNumber(v)
+			if !ok {
+				return nil, pythonException(fmt.Sprintf("TypeError: %%%c format: a number is required, not %s", verb, pythonTypeName(v)))
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(verb), int64(f)))
+		default:
+			f, ok := pythonNumber(v)
+			if !ok {
+				return nil, pythonException(fmt.Sprintf("TypeError: must be real number, not %s", pythonTypeName(v)))
+			}
+			if m[3] == "" {
+				spec += ".6"
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(verb), f))
+		}
+	}
+	if next < len(args) {
+		return nil, pythonException("TypeError: not all arguments converted during string formatting")
+	}
+	return sb.String(), nil
+}
+
+// errPythonUnsupported reports an expression the emulation can't evaluate.
+var errPythonUnsupported = fmt.Errorf("unsupported expression")
+
+func (e pythonException) Error() string {
+	return string(e)
+}
+
+// python2 returns true if the script runs with Python 2.
+func (r *scriptRun) python2() bool {
+	return strings.HasPrefix(r.name, "python2")
+}
+
+// capture runs a command with /bin/sh and returns its output, like os.popen().read() does.
+func (r *scriptRun) capture(command string) string {
+	stdout := r.fs.streams.stdout
+	output := &shellOutput{}
+	r.fs.streams.stdout = output
+	defer func() {
+		r.fs.streams.stdout = stdout
+	}()
+	r.fs.runScript("sh", command, nil, true)
+	r.fs.exitCode = 0
+	return output.String()
+}
+
+// pythonPrint evaluates the arguments of print() and prints them. Expressions the emulation doesn't understand
+// still do what the emulation sees in them (e.g. a download in print(urlopen(...).read())) and print an empty line.
+func (r *scriptRun) pythonPrint(m []string) bool {
+	args, kwargs, ok := parsePython(m[1])
+	if !ok {
+		return r.pythonFailed(errPythonUnsupported, m[1])
+	}
+	sep, end := " ", "\n"
+	for name, n := range kwargs {
+		v, err := r.eval(n)
+		if err != nil {
+			return r.pythonFailed(err, m[1])
+		}
+		switch name {
+		case "sep":
+			sep = pythonStr(v)
+		case "end":
+			end = pythonStr(v)
+		case "file", "flush":
+		default:
+			return r.exception(fmt.Sprintf("TypeError: '%s' is an invalid keyword argument for print()", name))
+		}
+	}
+	values := []string{}
+	python2 := r.python2()
+	for _, n := range args {
+		v, err := r.eval(n)
+		if err != nil {
+			return r.pythonFailed(err, m[1])
+		}
+		if python2 && len(args) > 1 {
+			values = append(values, pythonRepr(v)) // print(a, b) prints a tuple in Python 2
+		} else {
+			values = append(values, pythonStr(v))
+		}
+	}
+	if python2 && len(args) > 1 {
+		r.fs.RecordWriteLn("(" + strings.Join(values, ", ") + ")")
+		return true
+	}
+	r.fs.RecordWrite(strings.Join(values, sep) + end)
+	return true
+}
+
+// pythonFailed handles an error of eval: exceptions end the script like in Python,
+// unsupported expressions fall back to the effects. Python would fail on a name that was
+// never defined or imported before it got to the effects, so that's what it does then.
+func (r *scriptRun) pythonFailed(err error, source string) bool {
+	if e, ok := err.(pythonException); ok {
+		return r.exception(string(e))
+	}
+	if name := r.undefinedName(source); name != "" {
+		return r.exception(fmt.Sprintf("NameError: name '%s' is not defined", name))
+	}
+	if !r.nestedEffects(source) {
+		return false
+	}
+	r.fs.RecordWriteLn("")
+	return true
+}
+
+// pythonBuiltins are the names Python knows without an import.
+var pythonBuiltins = map[string]bool{
+	"True": true, "False": true, "None": true, "and": true, "or": true, "not": true, "in": true, "is": true,
+	"if": true, "else": true, "for": true, "lambda": true, "print": true, "input": true, "open": true,
+	"len": true, "str": true, "int": true, "float": true, "abs": true, "repr": true, "bool": true, "bytes": true,
+	"list": true, "dict": true, "set": true, "tuple": true, "range": true, "type": true, "chr": true, "ord": true,
+	"hex": true, "oct": true, "bin": true, "sum": true, "min": true, "max": true, "sorted": true, "reversed": true,
+	"enumerate": true, "zip": true, "map": true, "filter": true, "any": true, "all": true, "round": true, "pow": true,
+	"exec": true, "eval": true, "compile": true, "format": true, "getattr": true, "vars": true, "dir": true,
+	"globals": true, "locals": true, "exit": true, "quit": true, "__import__": true, "__name__": true, "__file__": true,
+	"unicode": true, "raw_input": true, "xrange": true, "long": true, "basestring": true, "reduce": true,
+}
+
+// pythonImports matches imports, the names they define are the ones after as or the first part of a module.
+var pythonImports = regexp.MustCompile(`\b(?:from\s+[\w.]+\s+)?import\s+\(?([\w.*]+(?:\s+as\s+\w+)?(?:\s*,\s*[\w.]+(?:\s+as\s+\w+)?)*)`)
+
+// pythonDefinitions matches the other statements that define names: functions, classes, with ... as,
+// loops and assignments of values the emulation doesn't know, like dicts.
+var pythonDefinitions = regexp.MustCompile(`\b(?:def|class|as)\s+([A-Za-z_]\w*)|\bfor\s+([\w\s,]+?)\s+in\b|\b([A-Za-z_]\w*)\s*(?:[-+*/%]|//|\*\*)?=(?:[^=]|$)`)
+
+// definedNames returns the names the script defines or imports anywhere, "*" is set if anything
+// could be defined by a wildcard import.
+func (r *scriptRun) definedNames() map[string]bool {
+	defined := map[string]bool{}
+	for _, m := range pythonDefinitions.FindAllStringSubmatch(r.source, -1) {
+		for _, names := range m[1:] {
+			for _, name := range strings.Split(names, ",") {
+				defined[strings.TrimSpace(name)] = true
+			}
+		}
+	}
+	for _, m := range pythonImports.FindAllStringSubmatch(r.source, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			if fields := strings.Fields(name); len(fields) > 0 {
+				defined[strings.Split(fields[len(fields)-1], ".")[0]] = true
+			}
+		}
+	}
+	return defined
+}
+
+// undefinedName returns the first name of an expression that is neither a variable, a builtin nor defined by the script.
+func (r *scriptRun) undefinedName(source string) string {
+	defined := r.definedNames()
+	if defined["*"] {
+		return ""
+	}
+	tokens, _ := pythonTokens(source)
+	for i, t := range tokens {
+		if t == "for" || t == "lambda" {
+			return "" // the names of comprehensions and lambdas are defined by the expression itself
+		}
+		if !(t[0] == '_' || t[0] >= 'A' && t[0] <= 'Z' || t[0] >= 'a' && t[0] <= 'z') || strings.ContainsAny(t[len(t)-1:], `'"`) {
+			continue // not a name
+		}
+		if i+1 < len(tokens) && tokens[i+1] == "=" {
+			continue // a keyword argument
+		}
+		name := strings.Split(t, ".")[0]
+		if _, ok := r.vars[name]; !ok && !defined[name] && !pythonBuiltins[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// pythonAssign stores the value of an assignment for later expressions, values that can't be evaluated
+// still do what the emulation sees in them and leave the value of the variable unknown.
+func (r *scriptRun) pythonAssign(m []string) bool {
+	if r.vars == nil {
+		r.vars = map[string]pythonValue{}
+	}
+	var v pythonValue
+	err := errPythonUnsupported
+	if args, _, ok := parsePython(m[2]); ok && len(args) > 0 {
+		n := args[0]
+		if len(args) > 1 {
+			n = &pythonNode{op: "tuple", args: args} // x = 1, 2
+		}
+		v, err = r.eval(n)
+	}
+	if e, isException := err.(pythonException); isException {
+		return r.exception(string(e))
+	}
+	if err != nil {
+		r.vars[m[1]] = pythonUnknown{}
+		return r.effects(m[2], r.line, pythonAssignment)
+	}
+	r.vars[m[1]] = v
+	return true
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	fso "io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const lsRecentTime = 182 * 24 * time.Hour // files older than this show the year instead of the time
+
+// lsOptions are the flags ls was called with.
+type lsOptions struct {
+	all        bool // -a
+	almostAll  bool // -A
+	long       bool // -l
+	human      bool // -h
+	recursive  bool // -R
+	byTime     bool // -t
+	bySize     bool // -S
+	reverse    bool // -r
+	onePerLine bool // -1
+	dirsOnly   bool // -d
+	classify   bool // -F
+	columns    bool // lay out names in columns instead of one per line
+}
+
+// lsEntry is a file ls lists.
+type lsEntry struct {
+	name   string // the name as shown
+	path   string // the absolute path in the FFS
+	info   fso.FileInfo
+	target string // the target of a symbolic link
+}
+
+func cmdLs(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+	flags, operands, bad := parseOpts(args[1:], "aAlhRtSr1dF", "")
+	if bad != "" {
+		badOption(fs, name, bad, 2)
+		return
+	}
+	has := func(c byte) bool {
+		_, ok := flags[c]
+		return ok
+	}
+	opts := lsOptions{
+		all:        has('a'),
+		almostAll:  has('A'),
+		long:       has('l'),
+		human:      has('h'),
+		recursive:  has('R'),
+		byTime:     has('t'),
+		bySize:     has('S'),
+		reverse:    has('r'),
+		onePerLine: has('1'),
+		dirsOnly:   has('d'),
+		classify:   has('F'),
+		// ls only uses columns if it writes to a terminal, dir always does
+		columns: name == "dir" || fs.streams.stdout == nil,
+	}
+
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+
+	files, dirs := []lsEntry{}, []lsEntry{}
+	for _, op := range operands {
+		path := toAbs(fs, op)
+		info, err := fs.ffs.Lstat(path)
+		if err == nil && info.Mode()&fso.ModeSymlink != 0 && !opts.long && !opts.dirsOnly && !opts.classify {
+			// symbolic links given as argument are followed, unless the link itself is of interest
+			info, err = fs.ffs.Stat(path)
+		}
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: cannot access '%s': %s", name, op, errorString(err)))
+			fs.exitCode = 2
+			continue
+		}
+		e := lsEntry{name: op, path: path, info: info}
+		if info.Mode()&fso.ModeSymlink != 0 {
+			e.target, _ = fs.ffs.Readlink(path)
+		}
+		if info.IsDir() && !opts.dirsOnly {
+			dirs = append(dirs, e)
+		} else {
+			files = append(files, e)
+		}
+	}
+	opts.sort(files)
+	opts.sort(dirs)
+
+	if len(files) > 0 {
+		fs.lsPrint(files, opts, false)
+	}
+	headers := len(operands) > 1 || opts.recursive
+	for i, d := range dirs {
+		if i > 0 || len(files) > 0 {
+			fs.RecordWriteLn("")
+		}
+		fs.lsDir(name, d.name, d.path, opts, headers)
+	}
+	return
+}
+
+// lsDir lists the content of a directory, shown is the name of the directory as given by the user.
+func (fs *FakeShell) lsDir(name, shown, path string, opts lsOptions, header bool) {
+	if header {
+		fs.RecordWriteLn(shown + ":")
+	}
+	entries, err := fs.lsReadDir(path, opts)
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("%s: cannot open directory '%s': %s", name, shown, errorString(err)))
+		fs.exitCode = 2
+		return
+	}
+	fs.lsPrint(entries, opts, true)
+
+	if !opts.recursive {
+		return
+	}
+	for _, e := range entries {
+		if !e.info.IsDir() || e.name == "." || e.name == ".." {
+			continue
+		}
+		fs.RecordWriteLn("")
+		fs.lsDir(name, strings.TrimSuffix(shown, "/")+"/"+e.name, e.path, opts, true)
+	}
+}
+
+// lsReadDir returns the sorted entries of a directory, hidden files are only included if asked for.
+func (fs *FakeShell) lsReadDir(path string, opts lsOptions) ([]lsEntry, error) {
+	dirEntries, err := fs.ffs.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []lsEntry{}
+	if opts.all {
+		for _, n := range []string{".", ".."} {
+			if info, err := fs.ffs.Stat(filepath.Join(path, n)); err == nil {
+				entries = append(entries, lsEntry{name: n, path: filepath.Join(path, n), info: info})
+			}
+		}
+	}
+	for _, de := range dirEntries {
+		if strings.HasPrefix(de.Name(), ".") && !opts.all && !opts.almostAll {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue // removed in the meantime
+		}
+		e := lsEntry{name: de.Name(), path: filepath.Join(path, de.Name()), info: info}
+		if info.Mode()&fso.ModeSymlink != 0 {
+			e.target, _ = fs.ffs.Readlink(e.path)
+		}
+		entries = append(entries, e)
+	}
+	opts.sort(entries)
+	return entries, nil
+}
+
+// sort sorts entries by name, size (-S) or modification time (-t), newest and largest first.
+func (opts lsOptions) sort(entries []lsEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if opts.reverse {
+			a, b = b, a
+		}
+		switch {
+		case opts.bySize && a.info.Size() != b.info.Size():
+			return a.info.Size() > b.info.Size()
+		case opts.byTime && !a.info.ModTime().Equal(b.info.ModTime()):
+			return a.info.ModTime().After(b.info.ModTime())
+		}
+		return a.name < b.name
+	})
+}
+
+// lsPrint prints the entries, either as long listing or just their names.
+// If total is true the long listing starts with the number of blocks used, like it does for directories.
+func (fs *FakeShell) lsPrint(entries []lsEntry, opts lsOptions, total bool) {
+	if !opts.long {
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.name+opts.indicator(e))
+		}
+		if opts.columns && !opts.onePerLine {
+			width, _ := fs.TerminalSize()
+			names = formatColumns(names, width)
+		}
+		for _, n := range names {
+			fs.RecordWriteLn(n)
+		}
+		return
+	}
+
+	users, groups := fs.lookupIDs("/etc/passwd"), fs.lookupIDs("/etc/group")
+	if len(groups) == 0 {
+		groups = users // most users have a group of the same name
+	}
+	idName := func(names map[uint32]string, id uint32) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		return strconv.FormatUint(uint64(id), 10)
+	}
+
+	type lsLine struct {
+		mode, links, owner, group, size, date, name string
+	}
+	lines := []lsLine{}
+	var blocks int64
+	var wLinks, wOwner, wGroup, wSize int
+	for _, e := range entries {
+		l := lsLine{
+			mode:  lsMode(e.info.Mode()),
+			links: "1",
+			owner: "root",
+			group: "root",
+			size:  strconv.FormatInt(e.info.Size(), 10),
+			date:  lsTime(e.info.ModTime()),
+			name:  e.name + opts.indicator(e),
+		}
+		if st, ok := e.info.Sys().(*syscall.Stat_t); ok {
+			l.links = strconv.FormatUint(uint64(st.Nlink), 10)
+			l.owner = idName(users, st.Uid)
+			l.group = idName(groups, st.Gid)
+			blocks += st.Blocks / 2 // st_blocks counts 512 byte blocks, ls shows 1K blocks
+		}
+		if opts.human {
+			l.size = humanSize(e.info.Size())
+		}
+		if e.target != "" {
+			l.name = e.name + " -> " + e.target
+		}
+		wLinks = maxInt(wLinks, len(l.links))
+		wOwner = maxInt(wOwner, len(l.owner))
+		wGroup = maxInt(wGroup, len(l.group))
+		wSize = maxInt(wSize, len(l.size))
+		lines = append(lines, l)
+	}
+
+	if total {
+		if opts.human {
+			fs.RecordWriteLn("total " + humanSize(blocks*1024))
+		} else {
+			fs.RecordWriteLn(fmt.Sprintf("total %d", blocks))
+		}
+	}
+	for _, l := range lines {
+		fs.RecordWriteLn(fmt.Sprintf("%s %*s %-*s %-*s %*s %s %s", l.mode, wLinks, l.links, wOwner, l.owner, wGroup, l.group, wSize, l.size, l.date, l.name))
+	}
+}
+
+// indicator returns the character -F appends to the name of the entry.
+func (opts lsOptions) indicator(e lsEntry) string {
+	if !opts.classify {
+		return ""
+	}
+	mode := e.info.Mode()
+	switch {
+	case mode.IsDir():
+		return "/"
+	case mode&fso.ModeSymlink != 0:
+		if opts.long {
+			return ""
+		}
+		return "@"
+	case mode&fso.ModeNamedPipe != 0:
+		return "|"
+	case mode&fso.ModeSocket != 0:
+		return "="
+	case mode&0111 != 0:
+		return "*"
+	}
+	return ""
+}
+
+// lookupIDs reads a passwd or group file of the FFS and returns the names by id.
+func (fs *FakeShell) lookupIDs(path string) map[uint32]string {
+	names := map[uint32]string{}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		return names
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return names
+}
+
+// lsMode returns the mode as shown by ls, e.g. "drwxr-xr-x".
+func lsMode(mode fso.FileMode) string {
+	b := []byte("-rwxrwxrwx")
+	switch {
+	case mode.IsDir():
+		b[0] = 'd'
+	case mode&fso.ModeSymlink != 0:
+		b[0] = 'l'
+	case mode&fso.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case mode&fso.ModeSocket != 0:
+		b[0] = 's'
+	case mode&fso.ModeCharDevice != 0:
+		b[0] = 'c'
+	case mode&fso.ModeDevice != 0:
+		b[0] = 'b'
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = c
+		} else {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, mode&fso.ModeSetuid != 0, 's')
+	special(6, mode&fso.ModeSetgid != 0, 's')
+	special(9, mode&fso.ModeSticky != 0, 't')
+	return string(b)
+}
+
+// lsTime returns the modification time as shown by ls, the year replaces the time for old files.
+func lsTime(t time.Time) string {
+	if age := time.Since(t); age > lsRecentTime || age < -time.Hour {
+		return t.Format("Jan _2  2006")
+	}
+	return t.Format("Jan _2 15:04")
+}
+
+// humanSize returns the size with a unit like `ls -h` shows it, e.g. "4.0K" or "12M".
+func humanSize(size int64) string {
+	if size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+	units := "KMGTPE"
+	value, unit := float64(size)/1024, 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	// ls always rounds up
+	if value < 10 && math.Ceil(value*10)/10 < 10 {
+		return fmt.Sprintf("%.1f%c", math.Ceil(value*10)/10, units[unit])
+	}
+	return fmt.Sprintf("%.0f%c", math.Ceil(value), units[unit])
+}
+
+// formatColumns lays out the names in as many columns as fit into the given width,
+// sorted top to bottom like ls does. It returns the lines to print.
+func formatColumns(names []string, width int) []string {
+	if len(names) == 0 {
+		return []string{}
+	}
+
+	var rows int
+	var widths []int
+	for cols := len(names); cols >= 1; cols-- {
+		rows = (len(names) + cols - 1) / cols
+		if cols > 1 && (cols-1)*rows >= len(names) {
+			continue // the last column would be empty
+		}
+		widths = make([]int, cols)
+		for i, n := range names {
+			c := i / rows
+			w := utf8.RuneCountInString(n)
+			if c < cols-1 {
+				w += 2
+			}
+			widths[c] = maxInt(widths[c], w)
+		}
+		lineWidth := 0
+		for _, w := range widths {
+			lineWidth += w
+		}
+		if lineWidth < width {
+			break
+		}
+	}
+
+	lines := []string{}
+	for r := 0; r < rows; r++ {
+		var sb strings.Builder
+		for c := range widths {
+			i := c*rows + r
+			if i >= len(names) {
+				break
+			}
+			sb.WriteString(names[i])
+			if i+rows < len(names) {
+				sb.WriteString(strings.Repeat(" ", widths[c]-utf8.RuneCountInString(names[i])))
+			}
+		}
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
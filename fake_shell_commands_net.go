@@ -0,0 +1,728 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toxyl/glog"
+)
+
+const (
+	downloadMinPlaceholder = 4 * 1024  // placeholders for unknown URLs are at least this large
+	downloadMaxPlaceholder = 64 * 1024 // and at most this large
+	downloadChunkSize      = 50 * 1024 // progress is shown after each chunk
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"wget":   cmdWget,
+		"curl":   cmdCurl,
+		"tftp":   cmdTftp,
+		"ftpget": cmdFtpget,
+	} {
+		CmdLookup[name] = cmd
+	}
+	// busybox runs other commands, registering it directly would be an initialization cycle
+	CmdLookup["busybox"] = cmdBusybox
+}
+
+// download is the answer of the fake internet to a request.
+type download struct {
+	url    *url.URL
+	ip     string // the address the host resolved to
+	port   string
+	status int // HTTP status code
+	data   []byte
+}
+
+// Name returns the name of the requested file, empty if the URL doesn't have one.
+func (d *download) Name() string {
+	if strings.HasSuffix(d.url.Path, "/") {
+		return ""
+	}
+	return path.Base("/" + d.url.Path)
+}
+
+// StatusText returns the status code and text, e.g. "404 Not Found".
+func (d *download) StatusText() string {
+	return fmt.Sprintf("%d %s", d.status, http.StatusText(d.status))
+}
+
+// ContentType guesses the content type of the download by its name.
+func (d *download) ContentType() string {
+	if d.status != http.StatusOK {
+		return "text/html"
+	}
+	if d.Name() == "" {
+		return "text/html"
+	}
+	switch path.Ext(d.Name()) {
+	case ".sh":
+		return "text/x-sh"
+	case ".txt", ".pl", ".py":
+		return "text/plain"
+	case ".html", ".htm":
+		return "text/html"
+	}
+	return "application/octet-stream"
+}
+
+// downloadRule is a compiled entry of the `downloads` config.
+type downloadRule struct {
+	re     *regexp.Regexp
+	target string // a file of the internet directory or an HTTP status code
+}
+
+var downloadRules = struct {
+	lock *sync.Mutex
+	list []downloadRule
+}{
+	lock: &sync.Mutex{},
+}
+
+// compileDownloadRules compiles the URL patterns of the `downloads` config, invalid ones are logged and left out.
+func compileDownloadRules() {
+	list := []downloadRule{}
+	for _, rule := range Conf.Downloads {
+		if len(rule) < 2 {
+			LogGlobal.Error("[Config] Download rule %v needs a URL pattern and a file or status code", rule)
+			continue
+		}
+		re, err := regexp.Compile(rule[0])
+		if err != nil {
+			LogGlobal.Error("[Config] Invalid URL pattern of download rule %s: %s", glog.Wrap(rule[0], glog.LightBlue), glog.Error(err))
+			continue
+		}
+		list = append(list, downloadRule{re: re, target: rule[1]})
+	}
+	downloadRules.lock.Lock()
+	downloadRules.list = list
+	downloadRules.lock.Unlock()
+}
+
+// configDownloadRules returns the compiled rules of the `downloads` config.
+func configDownloadRules() []downloadRule {
+	downloadRules.lock.Lock()
+	defer downloadRules.lock.Unlock()
+	return downloadRules.list
+}
+
+// fetch answers a request to the fake internet, nothing is ever sent over the network.
+// The URL is recorded as event of the session. The response comes from the first rule of the `downloads` config
+// matching the URL, which names either a file of the internet directory or an HTTP status code.
+// URLs without a rule get a placeholder or a 404, always the same for the same URL.
+func (fs *FakeShell) fetch(rawURL, scheme string) (*download, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = scheme + "://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid URL")
+	}
+	fs.RecordEvent("download", u.String(), "")
+
+	d := &download{url: u, ip: u.Hostname(), port: u.Port(), status: http.StatusOK}
+	if net.ParseIP(d.ip) == nil {
+		// a stable, public looking address for the host name
+		sum := sha256.Sum256([]byte(u.Hostname()))
+		d.ip = fmt.Sprintf("%d.%d.%d.%d", 1+int(sum[0])%222, sum[1], sum[2], 1+int(sum[3])%254)
+	}
+	if d.port == "" {
+		d.port = map[string]string{"https": "443", "ftp": "21", "tftp": "69"}[u.Scheme]
+		if d.port == "" {
+			d.port = "80"
+		}
+	}
+
+	for _, rule := range configDownloadRules() {
+		if !rule.re.MatchString(u.String()) {
+			continue
+		}
+		if code, err := strconv.Atoi(rule.target); err == nil {
+			d.status = code
+			break
+		}
+		d.data, err = os.ReadFile(filepath.Join(Conf.PathInternet, filepath.Clean("/"+rule.target)))
+		if err != nil {
+			fs.logger.Debug("%s: Stand-in file %s for %s is missing", fs.osshSession.LogID(), glog.File(rule.target), glog.Wrap(u.String(), glog.LightBlue))
+			d.status = http.StatusNotFound
+		}
+		break
+	}
+
+	if d.data == nil && d.status == http.StatusOK {
+		sum := sha256.Sum256([]byte(u.String()))
+		switch path.Ext(d.Name()) {
+		case ".sh", ".py", ".pl", ".php", ".txt":
+			d.status = http.StatusNotFound // a placeholder would only produce nonsense when run
+		default:
+			if sum[0]%4 == 0 {
+				d.status = http.StatusNotFound
+				break
+			}
+			size := downloadMinPlaceholder + int(binary.BigEndian.Uint32(sum[4:8]))%(downloadMaxPlaceholder-downloadMinPlaceholder)
+			d.data = make([]byte, size)
+			_, _ = rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[8:16])))).Read(d.data)
+		}
+	}
+	if d.status != http.StatusOK && d.data == nil {
+		text := d.StatusText()
+		d.data = []byte(fmt.Sprintf("<html>\r\n<head><title>%s</title></head>\r\n<body>\r\n<center><h1>%s</h1></center>\r\n<hr><center>nginx</center>\r\n</body>\r\n</html>\r\n", text, text))
+	}
+	return d, nil
+}
+
+// downloadDelay makes downloads take some time, like they would on a slow connection.
+func (fs *FakeShell) downloadDelay() {
+	fs.osshSession.RandomSleep(150, 900)
+}
+
+// writeDownload writes the data to a file of the FFS.
+func (fs *FakeShell) writeDownload(file string, data []byte, appendData bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendData {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	p := toAbs(fs, file)
+	if fs.ffs.DirExists(p) {
+		return fmt.Errorf("Is a directory")
+	}
+	written := fs.watchPersistence(p)
+	f, err := fs.ffs.OpenFile(p, flags, 0666&^fakeShellUmask)
+	if err != nil {
+		return fmt.Errorf("%s", errorString(err))
+	}
+	defer f.Close()
+	defer written()
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("%s", errorString(err))
+	}
+	return nil
+}
+
+// splitLongOpts removes the long options (--name or --name=value) from args.
+// Options listed in withValue take the next argument as value if it isn't attached.
+func splitLongOpts(args []string, withValue map[string]bool) (long map[string]string, rest []string) {
+	long = map[string]string{}
+	rest = []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return long, append(rest, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+		name, value := strings.TrimPrefix(arg, "--"), ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j+1:]
+		} else if withValue[name] && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		long[name] = value
+	}
+	return long, rest
+}
+
+// busyboxProgress returns the progress bar busybox shows for transfers.
+func busyboxProgress(name string, size int, percent int) string {
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	bar := strings.Repeat("*", percent*32/100) + strings.Repeat(" ", 32-percent*32/100)
+	return fmt.Sprintf("%-20s %3d%% |%s| %5s  0:00:00 ETA", name, percent, bar, humanSize(int64(size*percent/100)))
+}
+
+func cmdWget(fs *FakeShell, args []string) (exit bool) {
+	return fs.wget(args[1:], false)
+}
+
+// wget emulates GNU wget, or the wget applet of busybox.
+func (fs *FakeShell) wget(args []string, busybox bool) (exit bool) {
+	long, rest := splitLongOpts(args, map[string]bool{
+		"output-document": true, "directory-prefix": true, "user-agent": true, "header": true,
+		"tries": true, "timeout": true, "output-file": true, "post-data": true, "execute": true,
+	})
+	flags, urls, bad := parseOpts(rest, "qcNbnvdFrpkSx46", "OPUtToeY")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("wget: invalid option -- '%s'", bad))
+		if !busybox {
+			fs.RecordErrorLn("Usage: wget [OPTION]... [URL]...")
+			fs.RecordErrorLn("")
+			fs.RecordErrorLn("Try `wget --help' for more options.")
+		}
+		fs.exitCode = 2
+		return
+	}
+	if u, ok := long["url"]; ok {
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		if busybox {
+			fs.RecordErrorLn("Usage: wget [-cqS] [--spider] [-O FILE] [-o LOGFILE] [--header 'HEADER: VALUE'] [-Y on/off]")
+			fs.RecordErrorLn("\t[--no-check-certificate] [-P DIR] [-U AGENT] [-T SEC] URL...")
+		} else {
+			fs.RecordErrorLn("wget: missing URL")
+			fs.RecordErrorLn("Usage: wget [OPTION]... [URL]...")
+			fs.RecordErrorLn("")
+			fs.RecordErrorLn("Try `wget --help' for more options.")
+		}
+		return
+	}
+
+	_, quiet := flags['q']
+	if _, ok := long["quiet"]; ok {
+		quiet = true
+	}
+	output, hasOutput := flags['O']
+	if o, ok := long["output-document"]; ok {
+		output, hasOutput = o, true
+	}
+	prefix := flags['P']
+	if p, ok := long["directory-prefix"]; ok {
+		prefix = p
+	}
+	log := func(msg string) {
+		if !quiet {
+			fs.RecordStderrLn(msg)
+		}
+	}
+
+	for i, rawURL := range urls {
+		if !busybox {
+			log(fmt.Sprintf("--%s--  %s", time.Now().Format("2006-01-02 15:04:05"), rawURL))
+		}
+		d, err := fs.fetch(rawURL, "http")
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: Invalid URL %s: Invalid host name.", rawURL, rawURL))
+			fs.exitCode = 1
+			continue
+		}
+		host := d.url.Hostname()
+		fs.downloadDelay()
+
+		file := output
+		if !hasOutput {
+			file = d.Name()
+			if file == "" {
+				file = "index.html"
+			}
+			if prefix != "" {
+				file = joinShown(prefix, file)
+			}
+			// wget doesn't overwrite existing files, it numbers them
+			for n, base := 1, file; !busybox && (fs.ffs.FileExists(toAbs(fs, file)) || fs.ffs.DirExists(toAbs(fs, file))); n++ {
+				file = fmt.Sprintf("%s.%d", base, n)
+			}
+		}
+
+		if busybox {
+			log(fmt.Sprintf("Connecting to %s (%s:%s)", host, d.ip, d.port))
+			if d.status != http.StatusOK {
+				fs.RecordErrorLn(fmt.Sprintf("wget: server returned error: HTTP/1.1 %s", d.StatusText()))
+				continue
+			}
+			if file == "-" {
+				log("writing to stdout")
+			} else {
+				log(fmt.Sprintf("saving to '%s'", file))
+			}
+			for sent := downloadChunkSize; sent < len(d.data); sent += downloadChunkSize {
+				fs.downloadDelay()
+			}
+			log(busyboxProgress(path.Base(file), len(d.data), 100))
+		} else {
+			if host != d.ip {
+				log(fmt.Sprintf("Resolving %s (%s)... %s", host, host, d.ip))
+				log(fmt.Sprintf("Connecting to %s (%s)|%s|:%s... connected.", host, host, d.ip, d.port))
+			} else {
+				log(fmt.Sprintf("Connecting to %s:%s... connected.", d.ip, d.port))
+			}
+			log(fmt.Sprintf("HTTP request sent, awaiting response... %s", d.StatusText()))
+			if d.status != http.StatusOK {
+				log(fmt.Sprintf("%s ERROR %s.", time.Now().Format("2006-01-02 15:04:05"), strings.Replace(d.StatusText(), " ", ": ", 1)))
+				log("")
+				fs.exitCode = 8
+				continue
+			}
+			log(fmt.Sprintf("Length: %d (%s) [%s]", len(d.data), humanSize(int64(len(d.data))), d.ContentType()))
+			if file == "-" {
+				log("Saving to: ‘STDOUT’")
+			} else {
+				log(fmt.Sprintf("Saving to: ‘%s’", file))
+			}
+			log("")
+
+			start := time.Now()
+			for offset := 0; offset < len(d.data); offset += downloadChunkSize {
+				chunkStart := time.Now()
+				fs.downloadDelay()
+				chunk := minInt(downloadChunkSize, len(d.data)-offset)
+				dots := ""
+				for k := 0; k < 50; k++ {
+					if k > 0 && k%10 == 0 {
+						dots += " "
+					}
+					if k*1024 < chunk {
+						dots += "."
+					} else {
+						dots += " "
+					}
+				}
+				log(fmt.Sprintf("%6dK %s %3d%% %s", offset/1024, dots, (offset+chunk)*100/len(d.data), transferRate(chunk, time.Since(chunkStart))))
+			}
+			log("")
+			rate := transferRate(len(d.data), time.Since(start))
+			if file == "-" {
+				log(fmt.Sprintf("%s (%s) - written to stdout [%d/%d]", time.Now().Format("2006-01-02 15:04:05"), rate, len(d.data), len(d.data)))
+			} else {
+				log(fmt.Sprintf("%s (%s) - ‘%s’ saved [%d/%d]", time.Now().Format("2006-01-02 15:04:05"), rate, file, len(d.data), len(d.data)))
+			}
+			log("")
+		}
+
+		if file == "-" {
+			fs.RecordWrite(string(d.data))
+			continue
+		}
+		// all downloads go into the same file if it was given with -O
+		if err := fs.writeDownload(file, d.data, hasOutput && i > 0); err != nil {
+			if busybox {
+				fs.RecordErrorLn(fmt.Sprintf("wget: can't open '%s': %s", file, err.Error()))
+			} else {
+				fs.RecordErrorLn(fmt.Sprintf("%s: %s", file, err.Error()))
+			}
+			continue
+		}
+		if busybox {
+			log(fmt.Sprintf("'%s' saved", file))
+		}
+	}
+	return
+}
+
+// transferRate returns the speed of a transfer as wget shows it, e.g. "1.21 MB/s".
+func transferRate(size int, d time.Duration) string {
+	rate := float64(size) / d.Seconds()
+	switch {
+	case d <= 0:
+		return "--.-KB/s"
+	case rate >= 1024*1024:
+		return fmt.Sprintf("%.2f MB/s", rate/1024/1024)
+	case rate >= 1024:
+		return fmt.Sprintf("%.2f KB/s", rate/1024)
+	}
+	return fmt.Sprintf("%.0f B/s", rate)
+}
+
+func cmdCurl(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{
+		"output": true, "user-agent": true, "header": true, "request": true, "data": true, "connect-timeout": true,
+		"max-time": true, "retry": true, "proxy": true, "cookie": true, "referer": true, "user": true, "write-out": true,
+		"form": true, "upload-file": true, "url": true,
+	})
+	flags, urls, bad := parseOpts(rest, "sSLkfvIiO#g46NqJRZ", "oXHdAumeFTxbcwrCYyKE")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("curl: option -%s: is unknown", bad))
+		fs.RecordErrorLn("curl: try 'curl --help' or 'curl --manual' for more information")
+		fs.exitCode = 2
+		return
+	}
+	has := func(c byte, name string) bool {
+		_, ok := flags[c]
+		_, okLong := long[name]
+		return ok || okLong
+	}
+	if u, ok := long["url"]; ok {
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		fs.RecordErrorLn("curl: try 'curl --help' or 'curl --manual' for more information")
+		fs.exitCode = 2
+		return
+	}
+
+	silent, showError, fail := has('s', "silent"), has('S', "show-error"), has('f', "fail")
+	headOnly, withHeaders := has('I', "head"), has('i', "include")
+	output, hasOutput := flags['o']
+	if o, ok := long["output"]; ok {
+		output, hasOutput = o, true
+	}
+	remoteName := has('O', "remote-name")
+	curlError := func(code int, msg string) {
+		if !silent || showError {
+			fs.RecordErrorLn(fmt.Sprintf("curl: (%d) %s", code, msg))
+		}
+		fs.exitCode = code
+	}
+
+	for i, rawURL := range urls {
+		d, err := fs.fetch(rawURL, "http")
+		if err != nil {
+			curlError(3, "URL using bad/illegal format or missing URL")
+			continue
+		}
+		fs.downloadDelay()
+
+		file := output
+		if remoteName {
+			file = d.Name()
+			if file == "" {
+				curlError(23, "Remote file name has no length!")
+				continue
+			}
+		}
+		toFile := (hasOutput || remoteName) && file != "-"
+		// like curl we show the progress meter unless the data goes to the terminal
+		meter := !silent && (toFile || fs.streams.stdout != nil)
+		if meter {
+			fs.RecordStderrLn("  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current")
+			fs.RecordStderrLn("                                 Dload  Upload   Total   Spent    Left  Speed")
+		}
+		start := time.Now()
+		for sent := downloadChunkSize; sent < len(d.data); sent += downloadChunkSize {
+			fs.downloadDelay()
+		}
+
+		if fail && d.status >= 400 {
+			if meter {
+				fs.RecordStderrLn("  0     0    0     0    0     0      0      0 --:--:-- --:--:-- --:--:--     0")
+			}
+			curlError(22, fmt.Sprintf("The requested URL returned error: %d", d.status))
+			continue
+		}
+		if meter {
+			size := humanSize(int64(len(d.data)))
+			speed := humanSize(int64(float64(len(d.data)) / time.Since(start).Seconds()))
+			fs.RecordStderrLn(fmt.Sprintf("100 %5s  100 %5s    0     0  %5s      0 --:--:-- --:--:-- --:--:-- %5s", size, size, speed, speed))
+		}
+
+		body := ""
+		if headOnly || withHeaders {
+			body = fmt.Sprintf("HTTP/1.1 %s\r\nServer: nginx\r\nDate: %s\r\nContent-Type: %s\r\nContent-Length: %d\r\nConnection: keep-alive\r\n\r\n",
+				d.StatusText(), time.Now().UTC().Format(http.TimeFormat), d.ContentType(), len(d.data))
+		}
+		if !headOnly {
+			body += string(d.data)
+		}
+		if !toFile {
+			fs.RecordWrite(body)
+			continue
+		}
+		if err := fs.writeDownload(file, []byte(body), hasOutput && !remoteName && i > 0); err != nil {
+			curlError(23, "Failure writing output to destination")
+		}
+	}
+	return
+}
+
+func cmdTftp(fs *FakeShell, args []string) (exit bool) {
+	var host, remote, local string
+	get, put := false, false
+
+	if i := indexOf(args, "-c"); i >= 2 && i+2 < len(args) {
+		// tftp-hpa: tftp HOST -c get REMOTE [LOCAL]
+		host, remote = args[1], args[i+2]
+		get, put = args[i+1] == "get", args[i+1] == "put"
+		if i+3 < len(args) {
+			local = args[i+3]
+		}
+	} else {
+		// busybox: tftp -g -r REMOTE [-l LOCAL] HOST [PORT]
+		flags, operands, bad := parseOpts(args[1:], "gp", "lrb")
+		if bad != "" || len(operands) == 0 {
+			fs.RecordErrorLn("BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.")
+			fs.RecordErrorLn("")
+			fs.RecordErrorLn("Usage: tftp [OPTIONS] HOST [PORT]")
+			fs.RecordErrorLn("")
+			fs.RecordErrorLn("Transfer a file from/to tftp server")
+			fs.RecordErrorLn("")
+			fs.RecordErrorLn("\t-l FILE\tLocal FILE")
+			fs.RecordErrorLn("\t-r FILE\tRemote FILE")
+			fs.RecordErrorLn("\t-g\tGet file")
+			fs.RecordErrorLn("\t-p\tPut file")
+			fs.RecordErrorLn("\t-b SIZE\tTransfer blocks of SIZE octets")
+			return
+		}
+		_, get = flags['g']
+		_, put = flags['p']
+		host, remote, local = operands[0], flags['r'], flags['l']
+		if len(operands) > 1 {
+			host += ":" + operands[1]
+		}
+	}
+	if remote == "" {
+		remote = local
+	}
+	if local == "" {
+		local = path.Base(remote)
+	}
+	if remote == "" || get == put {
+		fs.RecordErrorLn("tftp: specify either -g or -p, and a file name with -r or -l")
+		return
+	}
+
+	if put {
+		data, err := fs.ffs.ReadFile(toAbs(fs, local))
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("tftp: can't open '%s': %s", local, errorString(err)))
+			return
+		}
+		fs.RecordEvent("upload", fmt.Sprintf("tftp://%s/%s", host, strings.TrimPrefix(remote, "/")), SaveSample(data))
+		fs.downloadDelay()
+		return
+	}
+
+	d, err := fs.fetch(fmt.Sprintf("tftp://%s/%s", host, strings.TrimPrefix(remote, "/")), "tftp")
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("tftp: bad address '%s'", host))
+		return
+	}
+	fs.downloadDelay()
+	if d.status != http.StatusOK {
+		fs.RecordErrorLn("tftp: server error: (1) File not found")
+		return
+	}
+	for sent := downloadChunkSize; sent < len(d.data); sent += downloadChunkSize {
+		fs.downloadDelay()
+	}
+	if err := fs.writeDownload(local, d.data, false); err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("tftp: can't open '%s': %s", local, err.Error()))
+		return
+	}
+	fs.RecordStderrLn(busyboxProgress(path.Base(remote), len(d.data), 100))
+	return
+}
+
+func cmdFtpget(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "cv", "upP")
+	if bad != "" || len(operands) < 2 {
+		fs.RecordErrorLn("BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.")
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn("Usage: ftpget [OPTIONS] HOST [LOCAL_FILE] REMOTE_FILE")
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn("Download a file via FTP")
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn("\t-c\tContinue previous transfer")
+		fs.RecordErrorLn("\t-v\tVerbose")
+		fs.RecordErrorLn("\t-u USER\tUsername")
+		fs.RecordErrorLn("\t-p PASS\tPassword")
+		fs.RecordErrorLn("\t-P NUM\tPort")
+		return
+	}
+	host, remote := operands[0], operands[len(operands)-1]
+	local := path.Base(remote)
+	if len(operands) > 2 {
+		local = operands[1]
+	}
+	if port, ok := flags['P']; ok {
+		host += ":" + port
+	}
+	if user, ok := flags['u']; ok {
+		host = url.User(user).String() + "@" + host
+	}
+
+	d, err := fs.fetch(fmt.Sprintf("ftp://%s/%s", host, strings.TrimPrefix(remote, "/")), "ftp")
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("ftpget: bad address '%s'", operands[0]))
+		return
+	}
+	if _, verbose := flags['v']; verbose {
+		fs.RecordStderrLn(fmt.Sprintf("Connecting to %s (%s:%s)", d.url.Hostname(), d.ip, d.port))
+	}
+	fs.downloadDelay()
+	if d.status != http.StatusOK {
+		fs.RecordErrorLn("ftpget: unexpected server response to RETR: 550 Failed to open file.")
+		return
+	}
+	for sent := downloadChunkSize; sent < len(d.data); sent += downloadChunkSize {
+		fs.downloadDelay()
+	}
+	if local == "-" {
+		fs.RecordWrite(string(d.data))
+		return
+	}
+	if err := fs.writeDownload(local, d.data, false); err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("ftpget: can't open '%s': %s", local, err.Error()))
+	}
+	return
+}
+
+// indexOf returns the index of s in list, or -1.
+func indexOf(list []string, s string) int {
+	for i, e := range list {
+		if e == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// busyboxApplets are the applets of a typical busybox build.
+var busyboxApplets = strings.Fields(`[ [[ acpid adjtimex ar arch arp arping ash awk basename bc blkdiscard blockdev brctl
+	bunzip2 busybox bzcat bzip2 cal cat chgrp chmod chown chpasswd chroot chvt clear cmp cp cpio crond crontab cttyhack cut
+	date dc dd deallocvt depmod devmem df diff dirname dmesg dnsdomainname dos2unix dpkg dpkg-deb du dumpkmap dumpleases echo
+	ed egrep env expand expr factor fallocate false fatattr fdisk fgrep find fold free freeramdisk fsfreeze fstrim ftpget
+	ftpput getopt getty grep groups gunzip gzip halt head hexdump hostid hostname httpd hwclock i2cdetect i2cdump i2cget
+	i2cset id ifconfig ifdown ifup init insmod ionice ip ipcalc ipneigh kill killall klogd last less link linux32 linux64
+	linuxrc ln loadfont loadkmap logger login logname logread losetup ls lsmod lsscsi lzcat lzma lzop md5sum mdev microcom
+	mkdir mkdosfs mke2fs mkfifo mknod mkpasswd mkswap mktemp modinfo modprobe more mount mt mv nameif nc netstat nl nologin
+	nproc nsenter nslookup nuke od openvt partprobe passwd paste patch pidof ping ping6 pivot_root poweroff printf ps pwd
+	rdate readlink realpath reboot renice reset resume rev rm rmdir rmmod route rpm rpm2cpio run-init run-parts sed seq
+	setkeycodes setpriv setsid sh sha1sum sha256sum sha512sum shred shuf sleep sort ssl_client start-stop-daemon stat
+	static-sh strings stty su svc svok swapoff swapon switch_root sync sysctl syslogd tac tail tar taskset tc tee telnet
+	test tftp time timeout top touch tr traceroute traceroute6 true truncate tty tunctl ubirename udhcpc udhcpd uevent
+	umount uname uncompress unexpand uniq unix2dos unlink unlzma unshare unxz unzip uptime usleep uudecode uuencode vconfig
+	vi w watch watchdog wc wget which who whoami xargs xxd xz xzcat yes zcat`)
+
+func cmdBusybox(fs *FakeShell, args []string) (exit bool) {
+	if len(args) < 2 || args[1] == "--help" {
+		fs.RecordWriteLn("BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.")
+		fs.RecordWriteLn("BusyBox is copyrighted by many authors between 1998-2015.")
+		fs.RecordWriteLn("Licensed under GPLv2. See source distribution for detailed")
+		fs.RecordWriteLn("copyright notices.")
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Usage: busybox [function [arguments]...]")
+		fs.RecordWriteLn("   or: busybox --list[-full]")
+		fs.RecordWriteLn("   or: function [arguments]...")
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Currently defined functions:")
+		for _, line := range formatColumns(busyboxApplets, 80) {
+			fs.RecordWriteLn("\t" + line)
+		}
+		return
+	}
+
+	applet := filepath.Base(args[1])
+	switch {
+	case applet == "--list":
+		for _, a := range busyboxApplets {
+			fs.RecordWriteLn(a)
+		}
+		return
+	case indexOf(busyboxApplets, applet) < 0:
+		// bots love to check for honeypots with made up applets
+		fs.RecordErrorLn(fmt.Sprintf("%s: applet not found", applet))
+		fs.exitCode = 127
+		return
+	case applet == "wget":
+		return fs.wget(args[2:], true)
+	}
+	return fs.execCommand(append([]string{applet}, args[2:]...))
+}
@@ -0,0 +1,1360 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"apt":        cmdApt,
+		"apt-get":    cmdApt,
+		"dpkg":       cmdDpkg,
+		"dpkg-query": cmdDpkg,
+		"yum":        cmdYum,
+		"apk":        cmdApk,
+		"opkg":       cmdOpkg,
+		"which":      cmdWhich,
+	} {
+		CmdLookup[name] = cmd
+	}
+}
+
+// aptUsage is what apt prints without a command.
+const aptUsage = `apt 2.3.9 (%s)
+Usage: apt [options] command
+
+apt is a commandline package manager and provides commands for
+searching and managing as well as querying information about packages.
+It provides the same functionality as the specialized APT tools,
+like apt-get and apt-cache, but enables options more suitable for
+interactive use by default.
+
+Most used commands:
+  list - list packages based on package names
+  search - search in package descriptions
+  show - show package details
+  install - install packages
+  reinstall - reinstall packages
+  remove - remove packages
+  autoremove - Remove automatically all unused packages
+  update - update list of available packages
+  upgrade - upgrade the system by installing/upgrading packages
+  full-upgrade - upgrade the system by removing/installing/upgrading packages
+  edit-sources - edit the source information file
+  satisfy - satisfy dependency strings
+
+See apt(8) for more information about the available commands.
+Configuration options and syntax is detailed in apt.conf(5).
+Information about how to configure sources can be found in sources.list(5).
+Package and version choices can be expressed via apt_preferences(5).
+Security details are available in apt-secure(8).
+                                        This APT has Super Cow Powers.`
+
+// aptGetUsage is what apt-get prints without a command.
+const aptGetUsage = `apt 2.3.9 (%s)
+Usage: apt-get [options] command
+       apt-get [options] install|remove pkg1 [pkg2 ...]
+       apt-get [options] source pkg1 [pkg2 ...]
+
+apt-get is a command line interface for retrieval of packages
+and information about them from authenticated sources and
+for management of packages and their dependencies.
+
+Most used commands:
+  update - Retrieve new lists of packages
+  upgrade - Perform an upgrade
+  install - Install new packages (pkg is libc6 not libc6.deb)
+  reinstall - Reinstall packages (pkg is libc6 not libc6.deb)
+  remove - Remove packages
+  purge - Remove packages and config files
+  autoremove - Remove automatically all unused packages
+  dist-upgrade - Distribution upgrade, see apt-get(8)
+  dselect-upgrade - Follow dselect selections
+  build-dep - Configure build-dependencies for source packages
+  satisfy - Satisfy dependency strings
+  clean - Erase downloaded archive files
+  autoclean - Erase old downloaded archive files
+  check - Verify that there are no broken dependencies
+  source - Download source archives
+  download - Download the binary package into the current directory
+  changelog - Download and display the changelog for the given package
+
+See apt-get(8) for more information about the available commands.
+Configuration options and syntax is detailed in apt.conf(5).
+Information about how to configure sources can be found in sources.list(5).
+Package and version choices can be expressed via apt_preferences(5).
+Security details are available in apt-secure(8).
+                                        This APT has Super Cow Powers.`
+
+// packageManager returns the package catalog of the system if the command is its package manager.
+// Otherwise it fails like a command that doesn't exist and returns nil.
+func (fs *FakeShell) packageManager(command, manager string) *PersonaPackages {
+	catalog := fs.ffs.Persona().Packages()
+	if catalog.Manager != manager {
+		fs.RecordErrorLn(fmt.Sprintf("%s: command not found", command))
+		fs.exitCode = 127
+		return nil
+	}
+	return catalog
+}
+
+// packageStep pauses between the steps of a package manager, the longer the more there is to do.
+func (fs *FakeShell) packageStep(kB int) {
+	fs.osshSession.RandomSleep(100+kB/8, 400+kB/4)
+}
+
+// readAnswer asks a question like "Do you want to continue? [Y/n] " and returns the answer.
+// ok is false if there is nothing to read the answer from, i.e. no pty and no input piped into the command.
+func (fs *FakeShell) readAnswer(prompt string) (answer string, ok bool) {
+	if stdin, piped := fs.Stdin(); piped {
+		fs.RecordWrite(prompt)
+		if stdin == "" {
+			return "", false
+		}
+		line, rest, _ := strings.Cut(stdin, "\n")
+		fs.streams.stdin = &rest
+		return strings.TrimSpace(line), true
+	}
+	if _, _, pty := (*fs.session).Pty(); !pty || fs.job != nil {
+		fs.RecordWrite(prompt)
+		return "", false
+	}
+	fs.stats.recording.AddOutputEvent(prompt)
+	fs.terminal.SetPrompt(prompt)
+	line, err := fs.terminal.ReadLine()
+	fs.terminal.SetPrompt(fs.prompt)
+	if err != nil {
+		return "", false
+	}
+	fs.stats.recording.AddOutputEvent(line + "\r\n")
+	return strings.TrimSpace(line), true
+}
+
+// aptSize formats a number of bytes like apt does, e.g. 6,109 kB or 25.3 MB.
+func aptSize(size int) string {
+	value := float64(size)
+	for i, unit := range []string{"B", "kB", "MB", "GB", "TB"} {
+		if value < 100 && i > 0 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		if value < 10000 || unit == "TB" {
+			return fmt.Sprintf("%s %s", groupThousands(int(value+0.5)), unit)
+		}
+		value /= 1000
+	}
+	return ""
+}
+
+// groupThousands formats a number with commas between groups of three digits.
+func groupThousands(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// debName returns how apt and dpkg name a package in messages, libraries carry the architecture.
+func debName(p *PersonaPackage, arch string) string {
+	if strings.HasPrefix(p.Name, "lib") {
+		return p.Name + ":" + arch
+	}
+	return p.Name
+}
+
+// debVersion returns the version without the epoch, as used in the names of package files.
+func debVersion(version string) string {
+	if _, v, found := strings.Cut(version, ":"); found {
+		return v
+	}
+	return version
+}
+
+// packageNames returns the names of the packages.
+func packageNames(packages []*PersonaPackage) []string {
+	names := []string{}
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// packageSize returns the download size of the packages in kB.
+func packageSize(packages []*PersonaPackage) int {
+	size := 0
+	for _, p := range packages {
+		size += p.Size
+	}
+	return size
+}
+
+// wrapNames prints names indented by two spaces and wrapped like apt does.
+func (fs *FakeShell) wrapNames(names []string) {
+	line := " "
+	for _, name := range names {
+		if len(line)+len(name)+1 > 79 && line != " " {
+			fs.RecordWriteLn(line)
+			line = " "
+		}
+		line += " " + name
+	}
+	fs.RecordWriteLn(line)
+}
+
+// cmdApt emulates apt and apt-get with the package catalog of the persona.
+func cmdApt(fs *FakeShell, args []string) (exit bool) {
+	name := args[0]
+	catalog := fs.packageManager(name, "apt")
+	if catalog == nil {
+		return
+	}
+	long, rest := splitLongOpts(args[1:], map[string]bool{"option": true, "target-release": true})
+	opts, operands, bad := parseOpts(rest, "yqsfmdVuhb", "oct")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("E: Command line option '%s' [from -%s] is not understood in combination with the other options.", bad, bad))
+		fs.exitCode = 100
+		return
+	}
+	_, yes := opts['y']
+	for opt := range long {
+		switch opt {
+		case "yes", "assume-yes", "force-yes":
+			yes = true
+		case "help":
+			operands = nil
+		}
+	}
+	if _, help := opts['h']; help || len(operands) == 0 {
+		usage := aptUsage
+		if name == "apt-get" {
+			usage = aptGetUsage
+		}
+		fs.RecordWriteLn(fmt.Sprintf(usage, catalog.Arch))
+		if len(operands) == 0 && !help {
+			fs.exitCode = 1
+		}
+		return
+	}
+
+	if name == "apt" && (fs.streams.stdout != nil || fs.job != nil) {
+		fs.RecordStderrLn("")
+		fs.RecordStderrLn("WARNING: apt does not have a stable CLI interface. Use with caution in scripts.")
+		fs.RecordStderrLn("")
+	}
+
+	fail := func(lines ...string) bool {
+		for _, line := range lines {
+			fs.RecordErrorLn(line)
+		}
+		fs.exitCode = 100
+		return false
+	}
+	locked := func() bool {
+		if fs.isRoot() {
+			return false
+		}
+		fail("E: Could not open lock file /var/lib/dpkg/lock-frontend - open (13: Permission denied)",
+			"E: Unable to acquire the dpkg frontend lock (/var/lib/dpkg/lock-frontend), are you root?")
+		return true
+	}
+	readState := func() {
+		for _, step := range []string{"Reading package lists...", "Building dependency tree...", "Reading state information..."} {
+			fs.packageStep(0)
+			fs.RecordWriteLn(step + " Done")
+		}
+	}
+	summary := func(installed, reinstalled, removed int) {
+		if reinstalled > 0 {
+			fs.RecordWriteLn(fmt.Sprintf("0 upgraded, %d newly installed, %d reinstalled, %d to remove and 0 not upgraded.", installed, reinstalled, removed))
+			return
+		}
+		fs.RecordWriteLn(fmt.Sprintf("0 upgraded, %d newly installed, %d to remove and 0 not upgraded.", installed, removed))
+	}
+	confirm := func() bool {
+		if yes {
+			return true
+		}
+		answer, ok := fs.readAnswer("Do you want to continue? [Y/n] ")
+		if !ok || (answer != "" && !strings.HasPrefix(strings.ToLower(answer), "y")) {
+			fs.RecordWriteLn("Abort.")
+			fs.exitCode = 1
+			return false
+		}
+		return true
+	}
+
+	db := fs.loadPackageDB()
+	command, packages := operands[0], operands[1:]
+	switch command {
+	case "update":
+		if !fs.isRoot() {
+			fs.RecordWriteLn("Reading package lists... Done")
+			return fail("E: Could not open lock file /var/lib/apt/lists/lock - open (13: Permission denied)",
+				"E: Unable to lock directory /var/lib/apt/lists/")
+		}
+		fs.aptUpdate(catalog)
+		if name == "apt" {
+			fs.packageStep(0)
+			fs.RecordWriteLn("Building dependency tree... Done")
+			fs.RecordWriteLn("Reading state information... Done")
+			fs.RecordWriteLn("All packages are up to date.")
+		}
+
+	case "upgrade", "full-upgrade", "dist-upgrade", "autoremove", "autoclean", "clean", "check":
+		if locked() {
+			return
+		}
+		if command == "clean" || command == "autoclean" {
+			return
+		}
+		readState()
+		if strings.HasSuffix(command, "upgrade") {
+			fs.packageStep(0)
+			fs.RecordWriteLn("Calculating upgrade... Done")
+		}
+		summary(0, 0, 0)
+
+	case "install", "reinstall":
+		if locked() {
+			return
+		}
+		readState()
+		install, already, unknown := db.plan(packages, command == "reinstall")
+		if len(unknown) > 0 {
+			for _, u := range unknown {
+				fs.RecordErrorLn(fmt.Sprintf("E: Unable to locate package %s", u))
+			}
+			fs.exitCode = 100
+			return
+		}
+		for _, p := range already {
+			if command == "reinstall" {
+				continue
+			}
+			fs.RecordWriteLn(fmt.Sprintf("%s is already the newest version (%s).", p.Name, p.Version))
+		}
+		requested := map[string]bool{}
+		for _, p := range db.catalog.Packages {
+			if indexOf(packages, p.Name) >= 0 {
+				requested[p.Name] = true
+			}
+		}
+		additional := []string{}
+		for _, p := range install {
+			if !requested[p.Name] {
+				additional = append(additional, p.Name)
+			}
+		}
+		newNames := []string{}
+		reinstalled := 0
+		for _, p := range install {
+			if db.isInstalled(p.Name) {
+				reinstalled++
+			} else {
+				newNames = append(newNames, p.Name)
+			}
+		}
+		sort.Strings(additional)
+		sort.Strings(newNames)
+		if len(additional) > 0 {
+			fs.RecordWriteLn("The following additional packages will be installed:")
+			fs.wrapNames(additional)
+		}
+		if len(newNames) > 0 {
+			fs.RecordWriteLn("The following NEW packages will be installed:")
+			fs.wrapNames(newNames)
+		}
+		summary(len(newNames), reinstalled, 0)
+		if len(install) == 0 {
+			return
+		}
+		size := packageSize(install)
+		fs.RecordWriteLn(fmt.Sprintf("Need to get %s of archives.", aptSize(size*1000)))
+		if reinstalled < len(install) {
+			fs.RecordWriteLn(fmt.Sprintf("After this operation, %s of additional disk space will be used.", aptSize(size*3000-reinstalledSize(db, install))))
+		}
+		if len(additional) > 0 && !confirm() {
+			return
+		}
+		fs.aptInstall(db, install, strings.Join(packages, " "))
+
+	case "remove", "purge":
+		if locked() {
+			return
+		}
+		readState()
+		remove := []*PersonaPackage{}
+		for _, n := range packages {
+			n = strings.SplitN(n, ":", 2)[0]
+			p := db.catalog.Get(n)
+			if p == nil {
+				return fail(fmt.Sprintf("E: Unable to locate package %s", n))
+			}
+			if !db.isInstalled(p.Name) {
+				fs.RecordWriteLn(fmt.Sprintf("Package '%s' is not installed, so not removed", p.Name))
+				continue
+			}
+			remove = append(remove, p)
+		}
+		if len(remove) > 0 {
+			fs.RecordWriteLn("The following packages will be REMOVED:")
+			names := packageNames(remove)
+			if command == "purge" {
+				for i := range names {
+					names[i] += "*"
+				}
+			}
+			fs.wrapNames(names)
+		}
+		summary(0, 0, len(remove))
+		if len(remove) == 0 {
+			return
+		}
+		fs.RecordWriteLn(fmt.Sprintf("After this operation, %s disk space will be freed.", aptSize(packageSize(remove)*3000)))
+		if !confirm() {
+			return
+		}
+		fs.RecordWriteLn(fmt.Sprintf("(Reading database ... %d files and directories currently installed.)", dpkgFiles(db)))
+		for _, p := range remove {
+			fs.packageStep(p.Size)
+			fs.RecordWriteLn(fmt.Sprintf("Removing %s (%s) ...", debName(p, catalog.Arch), p.Version))
+			db.remove(p)
+		}
+		if command == "purge" {
+			for _, p := range remove {
+				fs.RecordWriteLn(fmt.Sprintf("Purging configuration files for %s (%s) ...", debName(p, catalog.Arch), p.Version))
+			}
+		}
+		fs.RecordWriteLn("Processing triggers for man-db (2.9.4-2) ...")
+		if err := db.save(); err != nil {
+			fs.RecordErrorLn("E: Sub-process /usr/bin/dpkg returned an error code (1)")
+		}
+		fs.RecordEvent("package", fmt.Sprintf("%s %s %s", name, command, strings.Join(packageNames(remove), " ")), "")
+
+	case "list", "search", "show", "policy":
+		if name == "apt-get" {
+			return fail(fmt.Sprintf("E: Invalid operation %s", command))
+		}
+		fs.aptQuery(db, command, packages, long)
+
+	default:
+		return fail(fmt.Sprintf("E: Invalid operation %s", command))
+	}
+	return
+}
+
+// reinstalledSize returns the installed size in bytes of the packages that are already installed.
+func reinstalledSize(db *packageDB, packages []*PersonaPackage) int {
+	size := 0
+	for _, p := range packages {
+		if db.isInstalled(p.Name) {
+			size += p.Size * 3000
+		}
+	}
+	return size
+}
+
+// dpkgFiles returns the number of files dpkg claims to know about.
+func dpkgFiles(db *packageDB) int {
+	files := 61420
+	for name := range db.installed {
+		if p := db.catalog.Get(name); p != nil {
+			files += 7 + p.Size/40
+		}
+	}
+	return files
+}
+
+// aptUpdate prints the transcript of apt update, the first update of the system downloads the package lists.
+func (fs *FakeShell) aptUpdate(catalog *PersonaPackages) {
+	first := !fs.ffs.DirExists("/var/lib/apt/lists/partial")
+	start := time.Now()
+	fetched := 0
+	n := 0
+	line := func(get bool, suite, what string, kB int) {
+		n++
+		fs.packageStep(kB)
+		if get {
+			fetched += kB
+			fs.RecordWriteLn(fmt.Sprintf("Get:%d %s %s %s [%s]", n, catalog.Mirror, suite, what, aptSize(kB*1000)))
+			return
+		}
+		fs.RecordWriteLn(fmt.Sprintf("Hit:%d %s %s %s", n, catalog.Mirror, suite, what))
+	}
+	line(first, catalog.Release, "InRelease", 270)
+	for _, suite := range []string{"-updates", "-backports", "-security"} {
+		line(true, catalog.Release+suite, "InRelease", 110+len(suite))
+	}
+	if first {
+		line(true, catalog.Release+"/main", catalog.Arch+" Packages", 1389)
+		line(true, catalog.Release+"/main", "Translation-en", 511)
+		line(true, catalog.Release+"/universe", catalog.Arch+" Packages", 13369)
+		line(true, catalog.Release+"-updates/main", catalog.Arch+" Packages", 712)
+		line(true, catalog.Release+"-security/main", catalog.Arch+" Packages", 488)
+		_ = fs.ffs.MkdirAll("/var/lib/apt/lists/partial", 0700)
+	}
+	elapsed := maxInt(int(time.Since(start).Seconds()+0.5), 1)
+	fs.RecordWriteLn(fmt.Sprintf("Fetched %s in %ds (%s/s)", aptSize(fetched*1000), elapsed, aptSize(fetched*1000/elapsed)))
+	fs.packageStep(0)
+	fs.RecordWriteLn("Reading package lists... Done")
+}
+
+// aptInstall downloads, unpacks and sets up the packages.
+func (fs *FakeShell) aptInstall(db *packageDB, install []*PersonaPackage, requested string) {
+	catalog := db.catalog
+	start := time.Now()
+	for i, p := range install {
+		fs.packageStep(p.Size)
+		fs.RecordWriteLn(fmt.Sprintf("Get:%d %s %s/main %s %s %s [%s]", i+1, catalog.Mirror, catalog.Release, catalog.Arch, p.Name, p.Version, aptSize(p.Size*1000)))
+	}
+	size := packageSize(install) * 1000
+	elapsed := maxInt(int(time.Since(start).Seconds()+0.5), 1)
+	fs.RecordWriteLn(fmt.Sprintf("Fetched %s in %ds (%s/s)", aptSize(size), elapsed, aptSize(size/elapsed)))
+
+	for i, p := range install {
+		if db.isInstalled(p.Name) {
+			fs.RecordWriteLn(fmt.Sprintf("(Reading database ... %d files and directories currently installed.)", dpkgFiles(db)))
+		} else {
+			fs.RecordWriteLn(fmt.Sprintf("Selecting previously unselected package %s.", debName(p, catalog.Arch)))
+			if i == 0 {
+				fs.RecordWriteLn(fmt.Sprintf("(Reading database ... %d files and directories currently installed.)", dpkgFiles(db)))
+			}
+		}
+		file := fmt.Sprintf("%s_%s_%s.deb", p.Name, debVersion(p.Version), catalog.Arch)
+		if len(install) > 1 {
+			file = fmt.Sprintf("%02d-%s", i, file)
+		}
+		fs.RecordWriteLn(fmt.Sprintf("Preparing to unpack .../%s ...", file))
+		fs.packageStep(p.Size)
+		if db.isInstalled(p.Name) {
+			fs.RecordWriteLn(fmt.Sprintf("Unpacking %s (%s) over (%s) ...", debName(p, catalog.Arch), p.Version, p.Version))
+		} else {
+			fs.RecordWriteLn(fmt.Sprintf("Unpacking %s (%s) ...", debName(p, catalog.Arch), p.Version))
+		}
+	}
+	for _, p := range install {
+		fs.packageStep(p.Size / 4)
+		fs.RecordWriteLn(fmt.Sprintf("Setting up %s (%s) ...", debName(p, catalog.Arch), p.Version))
+		db.install(p)
+	}
+	fs.RecordWriteLn("Processing triggers for man-db (2.9.4-2) ...")
+	fs.RecordWriteLn("Processing triggers for libc-bin (2.34-0ubuntu3) ...")
+	if err := db.save(); err != nil {
+		fs.RecordErrorLn("E: Sub-process /usr/bin/dpkg returned an error code (1)")
+		fs.exitCode = 100
+	}
+	fs.RecordEvent("package", "apt install "+requested, "")
+}
+
+// aptQuery answers apt list, search, show and policy.
+func (fs *FakeShell) aptQuery(db *packageDB, command string, patterns []string, long map[string]string) {
+	catalog := db.catalog
+	_, installedOnly := long["installed"]
+	matches := func(p *PersonaPackage) bool {
+		if installedOnly && !db.isInstalled(p.Name) {
+			return false
+		}
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, pattern := range patterns {
+			switch command {
+			case "search":
+				if strings.Contains(strings.ToLower(p.Name+" "+p.Description), strings.ToLower(pattern)) {
+					return true
+				}
+			case "list":
+				if ok, _ := path.Match(pattern, p.Name); ok {
+					return true
+				}
+			default:
+				if p.Name == pattern {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	packages := []*PersonaPackage{}
+	for i := range catalog.Packages {
+		if matches(&catalog.Packages[i]) {
+			packages = append(packages, &catalog.Packages[i])
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	entry := func(p *PersonaPackage) string {
+		if db.isInstalled(p.Name) {
+			return fmt.Sprintf("%s/%s,now %s %s [installed]", p.Name, catalog.Release, p.Version, catalog.Arch)
+		}
+		return fmt.Sprintf("%s/%s %s %s", p.Name, catalog.Release, p.Version, catalog.Arch)
+	}
+
+	switch command {
+	case "list":
+		fs.RecordWriteLn("Listing... Done")
+		for _, p := range packages {
+			fs.RecordWriteLn(entry(p))
+		}
+	case "search":
+		fs.RecordWriteLn("Sorting... Done")
+		fs.RecordWriteLn("Full Text Search... Done")
+		for _, p := range packages {
+			fs.RecordWriteLn(entry(p))
+			fs.RecordWriteLn("  " + p.Description)
+			fs.RecordWriteLn("")
+		}
+	case "show", "policy":
+		if len(packages) == 0 {
+			for _, pattern := range patterns {
+				fs.RecordErrorLn(fmt.Sprintf("N: Unable to locate package %s", pattern))
+			}
+			if command == "show" {
+				fs.RecordErrorLn("E: No packages found")
+				fs.exitCode = 100
+			}
+			return
+		}
+		for _, p := range packages {
+			if command == "policy" {
+				installed := db.installed[p.Name]
+				if installed == "" {
+					installed = "(none)"
+				}
+				fs.RecordWriteLn(fmt.Sprintf("%s:\n  Installed: %s\n  Candidate: %s\n  Version table:\n     %s 500\n        500 %s %s/main %s Packages",
+					p.Name, installed, p.Version, p.Version, catalog.Mirror, catalog.Release, catalog.Arch))
+				continue
+			}
+			fs.RecordWriteLn(fmt.Sprintf("Package: %s\nVersion: %s\nPriority: optional\nInstalled-Size: %s", p.Name, p.Version, aptSize(p.Size*3000)))
+			if len(p.Depends) > 0 {
+				fs.RecordWriteLn("Depends: " + strings.Join(p.Depends, ", "))
+			}
+			fs.RecordWriteLn(fmt.Sprintf("Download-Size: %s\nAPT-Sources: %s %s/main %s Packages\nDescription: %s\n", aptSize(p.Size*1000), catalog.Mirror, catalog.Release, catalog.Arch, p.Description))
+		}
+	}
+}
+
+// cmdDpkg emulates dpkg and dpkg-query: listing and querying the installed packages, removing them
+// and failing to install package files.
+func cmdDpkg(fs *FakeShell, args []string) (exit bool) {
+	name := args[0]
+	catalog := fs.packageManager(name, "apt")
+	if catalog == nil {
+		return
+	}
+	db := fs.loadPackageDB()
+
+	action, operands := "", []string{}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--print-architecture" || arg == "--version" || arg == "--help":
+			action = arg
+		case strings.HasPrefix(arg, "--"):
+			for long, short := range map[string]string{"--list": "-l", "--listfiles": "-L", "--status": "-s", "--search": "-S", "--show": "-W", "--install": "-i", "--remove": "-r", "--purge": "-P"} {
+				if arg == long {
+					action = short
+				}
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			action = arg[:2]
+			if len(arg) > 2 {
+				operands = append(operands, arg[2:])
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	query := "dpkg-query"
+
+	switch action {
+	case "--print-architecture":
+		fs.RecordWriteLn(catalog.Arch)
+	case "--version":
+		fs.RecordWriteLn(fmt.Sprintf("Debian '%s' package management program version 1.20.9ubuntu2 (%s).", name, catalog.Arch))
+	case "-l", "-W":
+		packages := []*PersonaPackage{}
+		missing := []string{}
+		for _, pattern := range append(operands, map[bool]string{true: "*"}[len(operands) == 0]) {
+			if pattern == "" {
+				continue
+			}
+			found := false
+			for _, n := range db.names() {
+				if ok, _ := path.Match(pattern, n); ok {
+					if p := catalog.Get(n); p != nil {
+						packages = append(packages, p)
+						found = true
+					}
+				}
+			}
+			if !found {
+				missing = append(missing, pattern)
+			}
+		}
+		if action == "-W" {
+			for _, p := range packages {
+				fs.RecordWriteLn(fmt.Sprintf("%s\t%s", p.Name, p.Version))
+			}
+		} else if len(packages) > 0 {
+			fs.dpkgList(db, packages)
+		}
+		for _, m := range missing {
+			fs.RecordErrorLn(fmt.Sprintf("%s: no packages found matching %s", query, m))
+		}
+	case "-L", "-s":
+		if len(operands) == 0 {
+			fs.RecordErrorLn(fmt.Sprintf("%s: error: --%s needs at least one package name argument", query, map[string]string{"-L": "listfiles", "-s": "status"}[action]))
+			fs.exitCode = 2
+			return
+		}
+		for i, n := range operands {
+			p := catalog.Get(n)
+			if p == nil || !db.isInstalled(n) {
+				if action == "-L" {
+					fs.RecordErrorLn(fmt.Sprintf("%s: package '%s' is not installed", query, n))
+					fs.RecordErrorLn("Use dpkg --contents (= dpkg-deb --contents) to list archive files contents.")
+				} else {
+					fs.RecordErrorLn(fmt.Sprintf("%s: package '%s' is not installed and no information is available", query, n))
+					fs.RecordErrorLn("Use dpkg --info (= dpkg-deb --info) to examine archive files.")
+				}
+				continue
+			}
+			if i > 0 {
+				fs.RecordWriteLn("")
+			}
+			if action == "-L" {
+				dirs := map[string]bool{}
+				files := []string{"/.", "/usr", "/usr/share", "/usr/share/doc", "/usr/share/doc/" + p.Name, "/usr/share/doc/" + p.Name + "/copyright"}
+				for _, bin := range p.Binaries {
+					for d := filepath.Dir(bin); d != "/" && !dirs[d]; d = filepath.Dir(d) {
+						dirs[d] = true
+					}
+				}
+				for d := range dirs {
+					files = append(files, d)
+				}
+				files = append(files, p.Binaries...)
+				sort.Strings(files[1:])
+				for _, f := range files {
+					fs.RecordWriteLn(f)
+				}
+				continue
+			}
+			fs.RecordWriteLn(fmt.Sprintf("Package: %s\nStatus: install ok installed\nPriority: optional\nInstalled-Size: %d\nArchitecture: %s\nVersion: %s", p.Name, p.Size*3, catalog.Arch, db.installed[p.Name]))
+			if len(p.Depends) > 0 {
+				fs.RecordWriteLn("Depends: " + strings.Join(p.Depends, ", "))
+			}
+			fs.RecordWriteLn("Description: " + p.Description)
+		}
+	case "-S":
+		if len(operands) == 0 {
+			fs.RecordErrorLn(fmt.Sprintf("%s: error: --search needs at least one file name pattern argument", query))
+			fs.exitCode = 2
+			return
+		}
+		for _, pattern := range operands {
+			found := false
+			for _, n := range db.names() {
+				p := catalog.Get(n)
+				if p == nil {
+					continue
+				}
+				for _, bin := range p.Binaries {
+					if strings.Contains(bin, pattern) {
+						fs.RecordWriteLn(fmt.Sprintf("%s: %s", p.Name, bin))
+						found = true
+					}
+				}
+			}
+			if !found {
+				fs.RecordErrorLn(fmt.Sprintf("%s: no path found matching pattern *%s*", query, pattern))
+			}
+		}
+	case "-i", "-r", "-P":
+		if name == "dpkg-query" {
+			break
+		}
+		if !fs.isRoot() {
+			fs.RecordErrorLn("dpkg: error: requested operation requires superuser privilege")
+			fs.exitCode = 2
+			return
+		}
+		if len(operands) == 0 {
+			fs.RecordErrorLn(fmt.Sprintf("dpkg: error: --%s needs at least one package archive file argument", map[string]string{"-i": "install", "-r": "remove", "-P": "purge"}[action]))
+			fs.exitCode = 2
+			return
+		}
+		if action == "-i" {
+			for _, file := range operands {
+				if !fs.ffs.FileExists(toAbs(fs, file)) {
+					fs.RecordErrorLn(fmt.Sprintf("dpkg: error: cannot access archive '%s': No such file or directory", file))
+					fs.exitCode = 2
+					return
+				}
+			}
+			for _, file := range operands {
+				fs.RecordErrorLn(fmt.Sprintf("dpkg-deb: error: '%s' is not a Debian format archive", file))
+				fs.RecordErrorLn(fmt.Sprintf("dpkg: error processing archive %s (--install):", file))
+				fs.RecordErrorLn(" dpkg-deb --control subprocess returned error exit status 2")
+			}
+			fs.RecordErrorLn("Errors were encountered while processing:")
+			for _, file := range operands {
+				fs.RecordErrorLn(" " + file)
+			}
+			return
+		}
+		fs.RecordWriteLn(fmt.Sprintf("(Reading database ... %d files and directories currently installed.)", dpkgFiles(db)))
+		for _, n := range operands {
+			p := catalog.Get(n)
+			if p == nil || !db.isInstalled(n) {
+				fs.RecordErrorLn(fmt.Sprintf("dpkg: warning: ignoring request to remove %s which isn't installed", n))
+				continue
+			}
+			fs.packageStep(p.Size)
+			fs.RecordWriteLn(fmt.Sprintf("Removing %s (%s) ...", debName(p, catalog.Arch), p.Version))
+			db.remove(p)
+		}
+		_ = db.save()
+		fs.RecordEvent("package", fmt.Sprintf("dpkg %s %s", action, strings.Join(operands, " ")), "")
+	}
+	if action == "" || action == "--help" || (name == "dpkg-query" && (action == "-i" || action == "-r" || action == "-P")) {
+		fs.RecordErrorLn(fmt.Sprintf("%s: error: need an action option", name))
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn(fmt.Sprintf("Type %s --help for help about installing and deinstalling packages [*];", name))
+		fs.RecordErrorLn("Use 'apt' or 'aptitude' for user-friendly package management;")
+		fs.RecordErrorLn("Type dpkg -Dhelp for a list of dpkg debug flag values;")
+		fs.RecordErrorLn("Type dpkg --force-help for a list of forcing options;")
+		fs.RecordErrorLn("Type dpkg-deb --help for help about manipulating *.deb files;")
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn("Options marked [*] produce a lot of output - pipe it through 'less' or 'more' !")
+		fs.exitCode = 2
+	}
+	return
+}
+
+// dpkgList prints the table of dpkg -l.
+func (fs *FakeShell) dpkgList(db *packageDB, packages []*PersonaPackage) {
+	nameWidth, versionWidth, archWidth := 14, 12, 12
+	for _, p := range packages {
+		nameWidth = maxInt(nameWidth, len(p.Name))
+		versionWidth = maxInt(versionWidth, len(p.Version))
+	}
+	descWidth := 40
+	if width, _ := fs.TerminalSize(); fs.streams.stdout == nil {
+		descWidth = maxInt(width-nameWidth-versionWidth-archWidth-8, 10)
+	}
+	fs.RecordWriteLn("Desired=Unknown/Install/Remove/Purge/Hold")
+	fs.RecordWriteLn("| Status=Not/Inst/Conf-files/Unpacked/halF-conf/Half-inst/trig-aWait/Trig-pend")
+	fs.RecordWriteLn("|/ Err?=(none)/Reinst-required (Status,Err: uppercase=bad)")
+	fs.RecordWriteLn(fmt.Sprintf("||/ %-*s %-*s %-*s %s", nameWidth, "Name", versionWidth, "Version", archWidth, "Architecture", "Description"))
+	fs.RecordWriteLn(fmt.Sprintf("+++-%s-%s-%s-%s", strings.Repeat("=", nameWidth), strings.Repeat("=", versionWidth), strings.Repeat("=", archWidth), strings.Repeat("=", descWidth)))
+	for _, p := range packages {
+		desc := p.Description
+		if fs.streams.stdout == nil && len(desc) > descWidth {
+			desc = desc[:descWidth]
+		}
+		fs.RecordWriteLn(fmt.Sprintf("ii  %-*s %-*s %-*s %s", nameWidth, p.Name, versionWidth, db.installed[p.Name], archWidth, db.catalog.Arch, desc))
+	}
+}
+
+// mirrorHost returns the host name of the mirror of the catalog.
+func mirrorHost(catalog *PersonaPackages) string {
+	if u, err := url.Parse(catalog.Mirror); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return catalog.Mirror
+}
+
+// yumSize formats a size in kB like yum does, e.g. 194 k or 3.9 M.
+func yumSize(kB int) string {
+	if kB < 1000 {
+		return fmt.Sprintf("%d k", kB)
+	}
+	return fmt.Sprintf("%.1f M", float64(kB)/1024)
+}
+
+// cmdYum emulates yum with the package catalog of the persona.
+func cmdYum(fs *FakeShell, args []string) (exit bool) {
+	catalog := fs.packageManager(args[0], "yum")
+	if catalog == nil {
+		return
+	}
+	long, rest := splitLongOpts(args[1:], nil)
+	opts, operands, bad := parseOpts(rest, "yqCv", "ec")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("Command line error: no such option: -%s", bad))
+		fs.exitCode = 1
+		return
+	}
+	_, yes := opts['y']
+	if _, ok := long["assumeyes"]; ok {
+		yes = true
+	}
+	fail := func(msg string) bool {
+		fs.RecordErrorLn(msg)
+		fs.exitCode = 1
+		return false
+	}
+
+	fs.packageStep(0)
+	fs.RecordWriteLn("Loaded plugins: fastestmirror")
+	if len(operands) == 0 {
+		fs.RecordErrorLn("You need to give some command")
+		return fail("Usage: yum [options] COMMAND\n\nList of Commands:\n\ncheck-update   Check for available package updates\ninstall        Install a package or packages on your system\nlist           List a package or groups of packages\nremove         Remove a package or packages from your system\nupdate         Update a package or packages on your system")
+	}
+	command, packages := operands[0], operands[1:]
+	switch command {
+	case "install", "remove", "erase", "update", "upgrade", "makecache", "clean":
+		if !fs.isRoot() {
+			return fail("You need to be root to perform this command.")
+		}
+	}
+	mirrors := func() {
+		fs.packageStep(0)
+		fs.RecordWriteLn("Loading mirror speeds from cached hostfile")
+		for _, repo := range []string{"base", "extras", "updates"} {
+			fs.RecordWriteLn(fmt.Sprintf(" * %s: %s", repo, mirrorHost(catalog)))
+		}
+	}
+	db := fs.loadPackageDB()
+	fullName := func(p *PersonaPackage) string {
+		return fmt.Sprintf("%s-%s.%s", p.Name, p.Version, catalog.Arch)
+	}
+	table := func(title string, packages []*PersonaPackage) {
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Dependencies Resolved")
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn(strings.Repeat("=", 80))
+		fs.RecordWriteLn(fmt.Sprintf(" %-22s %-10s %-26s %-12s %5s", "Package", "Arch", "Version", "Repository", "Size"))
+		fs.RecordWriteLn(strings.Repeat("=", 80))
+		fs.RecordWriteLn(title + ":")
+		for _, p := range packages {
+			fs.RecordWriteLn(fmt.Sprintf(" %-22s %-10s %-26s %-12s %5s", p.Name, catalog.Arch, p.Version, "base", yumSize(p.Size)))
+		}
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Transaction Summary")
+		fs.RecordWriteLn(strings.Repeat("=", 80))
+	}
+	confirm := func() bool {
+		if yes {
+			return true
+		}
+		answer, ok := fs.readAnswer("Is this ok [y/d/N]: ")
+		if !ok || !strings.HasPrefix(strings.ToLower(answer), "y") {
+			fs.RecordWriteLn("Exiting on user command")
+			fs.exitCode = 1
+			return false
+		}
+		return true
+	}
+
+	switch command {
+	case "install", "reinstall":
+		mirrors()
+		install, already, unknown := db.plan(packages, command == "reinstall")
+		for _, p := range already {
+			fs.RecordWriteLn(fmt.Sprintf("Package %s already installed and latest version", fullName(p)))
+		}
+		for _, u := range unknown {
+			fs.RecordWriteLn(fmt.Sprintf("No package %s available.", u))
+		}
+		if len(install) == 0 {
+			if len(unknown) > 0 {
+				return fail("Error: Nothing to do")
+			}
+			fs.RecordWriteLn("Nothing to do")
+			return
+		}
+		fs.RecordWriteLn("Resolving Dependencies")
+		fs.RecordWriteLn("--> Running transaction check")
+		for _, p := range install {
+			fs.packageStep(0)
+			fs.RecordWriteLn(fmt.Sprintf("---> Package %s.%s 0:%s will be installed", p.Name, catalog.Arch, p.Version))
+		}
+		fs.RecordWriteLn("--> Finished Dependency Resolution")
+		table("Installing", install)
+		fs.RecordWriteLn(fmt.Sprintf("Install  %d Package%s", len(install), map[bool]string{true: "s"}[len(install) > 1]))
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn(fmt.Sprintf("Total download size: %s", yumSize(packageSize(install))))
+		fs.RecordWriteLn(fmt.Sprintf("Installed size: %s", yumSize(packageSize(install)*3)))
+		if !confirm() {
+			return
+		}
+		fs.RecordWriteLn("Downloading packages:")
+		for _, p := range install {
+			fs.packageStep(p.Size)
+			fs.RecordWriteLn(fmt.Sprintf("%-58s | %7sB  00:00:%02d", fullName(p)+".rpm", yumSize(p.Size), 1+p.Size/2000))
+		}
+		fs.RecordWriteLn("Running transaction check")
+		fs.RecordWriteLn("Running transaction test")
+		fs.RecordWriteLn("Transaction test succeeded")
+		fs.RecordWriteLn("Running transaction")
+		for i, p := range install {
+			fs.packageStep(p.Size / 2)
+			fs.RecordWriteLn(fmt.Sprintf("  Installing : %-56s %d/%d", fmt.Sprintf("%s-%s.%s", p.Name, p.Version, catalog.Arch), i+1, len(install)))
+			db.install(p)
+		}
+		for i, p := range install {
+			fs.RecordWriteLn(fmt.Sprintf("  Verifying  : %-56s %d/%d", fmt.Sprintf("%s-%s.%s", p.Name, p.Version, catalog.Arch), i+1, len(install)))
+		}
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Installed:")
+		for _, p := range install {
+			fs.RecordWriteLn(fmt.Sprintf("  %s.%s 0:%s", p.Name, catalog.Arch, p.Version))
+		}
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Complete!")
+		_ = db.save()
+		fs.RecordEvent("package", "yum install "+strings.Join(packages, " "), "")
+
+	case "remove", "erase":
+		remove := []*PersonaPackage{}
+		for _, n := range packages {
+			if p := catalog.Get(n); p != nil && db.isInstalled(n) {
+				remove = append(remove, p)
+			} else {
+				fs.RecordWriteLn(fmt.Sprintf("No Match for argument: %s", n))
+			}
+		}
+		if len(remove) == 0 {
+			fs.RecordWriteLn("No Packages marked for removal")
+			return
+		}
+		fs.RecordWriteLn("Resolving Dependencies")
+		fs.RecordWriteLn("--> Running transaction check")
+		for _, p := range remove {
+			fs.RecordWriteLn(fmt.Sprintf("---> Package %s.%s 0:%s will be erased", p.Name, catalog.Arch, p.Version))
+		}
+		fs.RecordWriteLn("--> Finished Dependency Resolution")
+		table("Removing", remove)
+		fs.RecordWriteLn(fmt.Sprintf("Remove  %d Package%s", len(remove), map[bool]string{true: "s"}[len(remove) > 1]))
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn(fmt.Sprintf("Installed size: %s", yumSize(packageSize(remove)*3)))
+		if !confirm() {
+			return
+		}
+		fs.RecordWriteLn("Running transaction")
+		for i, p := range remove {
+			fs.packageStep(p.Size / 2)
+			fs.RecordWriteLn(fmt.Sprintf("  Erasing    : %-56s %d/%d", fullName(p), i+1, len(remove)))
+			db.remove(p)
+		}
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Removed:")
+		for _, p := range remove {
+			fs.RecordWriteLn(fmt.Sprintf("  %s.%s 0:%s", p.Name, catalog.Arch, p.Version))
+		}
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn("Complete!")
+		_ = db.save()
+		fs.RecordEvent("package", "yum remove "+strings.Join(packageNames(remove), " "), "")
+
+	case "update", "upgrade", "check-update":
+		mirrors()
+		fs.RecordWriteLn("No packages marked for update")
+
+	case "makecache", "clean":
+		mirrors()
+		fs.packageStep(1000)
+		fs.RecordWriteLn("Metadata Cache Created")
+
+	case "list":
+		mirrors()
+		installedOnly := len(packages) > 0 && packages[0] == "installed"
+		if installedOnly {
+			packages = packages[1:]
+		}
+		for _, section := range []bool{true, false} {
+			lines := []string{}
+			for _, p := range catalog.Packages {
+				if db.isInstalled(p.Name) != section {
+					continue
+				}
+				if len(packages) > 0 && indexOf(packages, p.Name) < 0 {
+					continue
+				}
+				repo := "base"
+				if section {
+					repo = "@base"
+				}
+				lines = append(lines, fmt.Sprintf("%-40s %-26s %s", p.Name+"."+catalog.Arch, p.Version, repo))
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			sort.Strings(lines)
+			fs.RecordWriteLn(map[bool]string{true: "Installed Packages", false: "Available Packages"}[section])
+			for _, line := range lines {
+				fs.RecordWriteLn(line)
+			}
+			if installedOnly {
+				break
+			}
+		}
+
+	default:
+		return fail(fmt.Sprintf("No such command: %s. Please use /usr/bin/yum --help", command))
+	}
+	return
+}
+
+// cmdApk emulates apk of Alpine Linux with the package catalog of the persona.
+func cmdApk(fs *FakeShell, args []string) (exit bool) {
+	catalog := fs.packageManager(args[0], "apk")
+	if catalog == nil {
+		return
+	}
+	long, rest := splitLongOpts(args[1:], map[string]bool{"repository": true, "root": true})
+	opts, operands, _ := parseOpts(rest, "UqvfiesX", "Xp")
+	_, update := opts['U']
+	if _, ok := long["update-cache"]; ok {
+		update = true
+	}
+	if _, ok := long["update"]; ok {
+		update = true
+	}
+	fail := func(msg string) bool {
+		fs.RecordErrorLn(msg)
+		fs.exitCode = 1
+		return false
+	}
+	if len(operands) == 0 {
+		fs.RecordWriteLn(fmt.Sprintf("apk-tools 2.14.0, compiled for %s.\n\nusage: apk [<OPTIONS>...] COMMAND [<ARGUMENTS>...]\n\nPackage installation and removal:\n  add        Add packages to WORLD and commit changes\n  del        Remove packages from WORLD and commit changes\n\nSystem maintenance:\n  update     Update repository indexes\n  upgrade    Install upgrades available from repositories\n\nQuerying package information:\n  info       Give detailed information about packages or repositories\n  search     Search for packages by name or description", catalog.Arch))
+		fs.exitCode = 1
+		return
+	}
+	command, packages := operands[0], operands[1:]
+	db := fs.loadPackageDB()
+	total := func() string {
+		size := 0
+		for name := range db.installed {
+			if p := catalog.Get(name); p != nil {
+				size += p.Size * 3
+			}
+		}
+		return fmt.Sprintf("OK: %d MiB in %d packages", maxInt(size/1024, 1), len(db.installed))
+	}
+	fetch := func() {
+		for _, repo := range []string{"main", "community"} {
+			fs.packageStep(600)
+			fs.RecordWriteLn(fmt.Sprintf("fetch %s/%s/%s/APKINDEX.tar.gz", catalog.Mirror, repo, catalog.Arch))
+		}
+	}
+
+	switch command {
+	case "add", "del", "update", "upgrade", "fix":
+		if !fs.isRoot() {
+			fs.RecordErrorLn("ERROR: Unable to lock database: Permission denied")
+			fs.RecordErrorLn("ERROR: Failed to open apk database: Permission denied")
+			fs.exitCode = 99
+			return
+		}
+	}
+
+	switch command {
+	case "update":
+		fetch()
+		fs.RecordWriteLn(fmt.Sprintf("%s [%s/main]", catalog.Release, catalog.Mirror))
+		fs.RecordWriteLn(fmt.Sprintf("%s [%s/community]", catalog.Release, catalog.Mirror))
+		fs.RecordWriteLn(fmt.Sprintf("OK: %d distinct packages available", 20000+len(catalog.Packages)))
+	case "upgrade":
+		if update {
+			fetch()
+		}
+		fs.RecordWriteLn(total())
+	case "add":
+		if update {
+			fetch()
+		}
+		install, _, unknown := db.plan(packages, false)
+		if len(unknown) > 0 {
+			fs.RecordErrorLn("ERROR: unable to select packages:")
+			for _, u := range unknown {
+				fs.RecordErrorLn(fmt.Sprintf("  %s (no such package):", u))
+				fs.RecordErrorLn(fmt.Sprintf("    required by: world[%s]", u))
+			}
+			fs.exitCode = len(unknown)
+			return
+		}
+		for i, p := range install {
+			fs.packageStep(p.Size)
+			fs.RecordWriteLn(fmt.Sprintf("(%d/%d) Installing %s (%s)", i+1, len(install), p.Name, p.Version))
+			db.install(p)
+		}
+		if len(install) > 0 {
+			fs.RecordWriteLn("Executing busybox-1.36.1-r2.trigger")
+			_ = db.save()
+			fs.RecordEvent("package", "apk add "+strings.Join(packages, " "), "")
+		}
+		fs.RecordWriteLn(total())
+	case "del":
+		remove := []*PersonaPackage{}
+		for _, n := range packages {
+			if p := catalog.Get(n); p != nil && db.isInstalled(n) {
+				remove = append(remove, p)
+			} else {
+				fs.RecordErrorLn(fmt.Sprintf("WARNING: %s is not installed", n))
+			}
+		}
+		for i, p := range remove {
+			fs.packageStep(p.Size / 2)
+			fs.RecordWriteLn(fmt.Sprintf("(%d/%d) Purging %s (%s)", i+1, len(remove), p.Name, p.Version))
+			db.remove(p)
+		}
+		if len(remove) > 0 {
+			_ = db.save()
+			fs.RecordEvent("package", "apk del "+strings.Join(packageNames(remove), " "), "")
+		}
+		fs.RecordWriteLn(total())
+	case "info":
+		if len(packages) == 0 {
+			for _, n := range db.names() {
+				fs.RecordWriteLn(n)
+			}
+			return
+		}
+		for _, n := range packages {
+			p := catalog.Get(n)
+			if p == nil {
+				continue
+			}
+			fs.RecordWriteLn(fmt.Sprintf("%s-%s description:\n%s\n", p.Name, p.Version, p.Description))
+		}
+	case "search":
+		for _, p := range catalog.Packages {
+			if len(packages) == 0 || strings.Contains(p.Name, packages[0]) {
+				fs.RecordWriteLn(fmt.Sprintf("%s-%s", p.Name, p.Version))
+			}
+		}
+	default:
+		return fail(fmt.Sprintf("apk: unknown command '%s'", command))
+	}
+	return
+}
+
+// cmdOpkg emulates opkg of OpenWrt with the package catalog of the persona. Like on a real router the package
+// lists live in RAM, so nothing can be installed before opkg update.
+func cmdOpkg(fs *FakeShell, args []string) (exit bool) {
+	catalog := fs.packageManager(args[0], "opkg")
+	if catalog == nil {
+		return
+	}
+	_, rest := splitLongOpts(args[1:], map[string]bool{"dest": true})
+	_, operands, _ := parseOpts(rest, "Vf", "do")
+	fail := func(lines ...string) bool {
+		fs.RecordErrorLn("Collected errors:")
+		for _, line := range lines {
+			fs.RecordErrorLn(" * " + line)
+		}
+		fs.exitCode = 255
+		return false
+	}
+	if len(operands) == 0 {
+		fs.RecordWriteLn("opkg must have one sub-command argument")
+		fs.RecordWriteLn("usage: opkg [options...] sub-command [arguments...]")
+		fs.RecordWriteLn("where sub-command is one of:\n\nPackage Manipulation:\n\tupdate\t\t\tUpdate list of available packages\n\tupgrade <pkgs>\t\tUpgrade packages\n\tinstall <pkgs>\t\tInstall package(s)\n\tremove <pkgs|regexp>\tRemove package(s)\n\nInformational Commands:\n\tlist\t\t\tList available packages\n\tlist-installed\t\tList installed packages\n\tinfo [pkg|regexp]\tDisplay all info for <pkg>")
+		fs.exitCode = 1
+		return
+	}
+	command, packages := operands[0], operands[1:]
+	db := fs.loadPackageDB()
+	lists := "/var/opkg-lists"
+	feeds := []string{"core", "base", "luci", "packages", "routing", "telephony"}
+
+	switch command {
+	case "update":
+		if !fs.isRoot() {
+			return fail("opkg_conf_load: Could not lock /var/lock/opkg.lock: Permission denied.")
+		}
+		_ = fs.ffs.MkdirAll(lists, 0755)
+		for _, feed := range feeds {
+			fs.packageStep(300)
+			fs.RecordWriteLn(fmt.Sprintf("Downloading %s/%s/Packages.gz", catalog.Mirror, feed))
+			fs.RecordWriteLn(fmt.Sprintf("Updated list of available packages in %s/openwrt_%s", lists, feed))
+			fs.RecordWriteLn(fmt.Sprintf("Downloading %s/%s/Packages.sig", catalog.Mirror, feed))
+			fs.RecordWriteLn("Signature check passed.")
+		}
+	case "install":
+		if !fs.isRoot() {
+			return fail("opkg_conf_load: Could not lock /var/lock/opkg.lock: Permission denied.")
+		}
+		if !fs.ffs.DirExists(lists) {
+			names := []string{}
+			for _, n := range packages {
+				fs.RecordErrorLn(fmt.Sprintf("Unknown package '%s'.", n))
+				names = append(names, fmt.Sprintf("opkg_install_cmd: Cannot install package %s.", n))
+			}
+			return fail(names...)
+		}
+		install, already, unknown := db.plan(packages, false)
+		for _, p := range already {
+			fs.RecordWriteLn(fmt.Sprintf("Package %s (%s) installed in root is up to date.", p.Name, p.Version))
+		}
+		for _, p := range install {
+			fs.RecordWriteLn(fmt.Sprintf("Installing %s (%s) to root...", p.Name, p.Version))
+			fs.packageStep(p.Size)
+			fs.RecordWriteLn(fmt.Sprintf("Downloading %s/packages/%s_%s_%s.ipk", catalog.Mirror, p.Name, p.Version, catalog.Arch))
+		}
+		for _, p := range install {
+			fs.RecordWriteLn(fmt.Sprintf("Configuring %s.", p.Name))
+			db.install(p)
+		}
+		if len(install) > 0 {
+			_ = db.save()
+			fs.RecordEvent("package", "opkg install "+strings.Join(packages, " "), "")
+		}
+		if len(unknown) > 0 {
+			names := []string{}
+			for _, n := range unknown {
+				fs.RecordErrorLn(fmt.Sprintf("Unknown package '%s'.", n))
+				names = append(names, fmt.Sprintf("opkg_install_cmd: Cannot install package %s.", n))
+			}
+			return fail(names...)
+		}
+	case "remove":
+		for _, n := range packages {
+			p := catalog.Get(n)
+			if p == nil || !db.isInstalled(n) {
+				fs.RecordWriteLn("No packages removed.")
+				continue
+			}
+			fs.packageStep(p.Size / 2)
+			fs.RecordWriteLn(fmt.Sprintf("Removing package %s from root...", p.Name))
+			db.remove(p)
+			_ = db.save()
+			fs.RecordEvent("package", "opkg remove "+p.Name, "")
+		}
+	case "list-installed":
+		for _, n := range db.names() {
+			fs.RecordWriteLn(fmt.Sprintf("%s - %s", n, db.installed[n]))
+		}
+	case "list":
+		if !fs.ffs.DirExists(lists) {
+			return
+		}
+		for _, p := range catalog.Packages {
+			fs.RecordWriteLn(fmt.Sprintf("%s - %s - %s", p.Name, p.Version, p.Description))
+		}
+	case "info":
+		for _, n := range packages {
+			if p := catalog.Get(n); p != nil && db.isInstalled(n) {
+				fs.RecordWriteLn(fmt.Sprintf("Package: %s\nVersion: %s\nDepends: %s\nStatus: install user installed\nArchitecture: %s\nDescription: %s\n", p.Name, p.Version, strings.Join(p.Depends, ", "), catalog.Arch, p.Description))
+			}
+		}
+	case "upgrade":
+		for _, n := range packages {
+			if p := catalog.Get(n); p != nil && db.isInstalled(n) {
+				fs.RecordWriteLn(fmt.Sprintf("Package %s (%s) installed in root is up to date.", p.Name, p.Version))
+			}
+		}
+	default:
+		fs.RecordErrorLn(fmt.Sprintf("opkg: unknown sub-command %s", command))
+		fs.exitCode = 1
+	}
+	return
+}
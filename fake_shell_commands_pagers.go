@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	CmdLookup["less"] = cmdLess
+	CmdLookup["more"] = cmdMore
+}
+
+// pagerFile is a file shown by less or more.
+type pagerFile struct {
+	name    string
+	content string
+}
+
+// pagerFiles reads the files given to a pager, stdin if there are none. Files that can't be read are reported
+// with the prefix, false means there was nothing to show.
+func (fs *FakeShell) pagerFiles(prefix string, args []string) ([]pagerFile, bool) {
+	names := editorFiles(args)
+	if len(names) == 0 {
+		stdin, ok := fs.Stdin()
+		if !ok {
+			return nil, false
+		}
+		return []pagerFile{{name: "-", content: stdin}}, true
+	}
+	files := []pagerFile{}
+	for _, name := range names {
+		if name == "-" {
+			stdin, _ := fs.Stdin()
+			files = append(files, pagerFile{name: name, content: stdin})
+			continue
+		}
+		path := toAbs(fs, name)
+		if fs.ffs.DirExists(path) {
+			fs.RecordErrorLn(fmt.Sprintf("%s%s is a directory", prefix, name))
+			continue
+		}
+		data, err := fs.ffs.ReadFile(path)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s%s: %s", prefix, name, errorString(err)))
+			continue
+		}
+		files = append(files, pagerFile{name: name, content: string(data)})
+	}
+	return files, len(files) > 0
+}
+
+// pagerRows returns the rows the content takes on a screen of the given width.
+func pagerRows(content string, width int) []string {
+	rows := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		rows = append(rows, wrapRows(line, width)...)
+	}
+	return rows
+}
+
+// less is the state of the pager while it shows a file.
+type less struct {
+	sc      *Screen
+	files   []pagerFile
+	current int      // the file that is shown
+	rows    []string // the rows of the file
+	top     int      // the first row on the screen
+	input   []rune   // the command that is typed, e.g. a search
+	prompt  rune     // the command the input belongs to, / or :
+	pattern *regexp.Regexp
+	message string
+}
+
+func cmdLess(fs *FakeShell, args []string) (exit bool) {
+	files, ok := fs.pagerFiles("", args[1:])
+	if !ok {
+		if len(editorFiles(args[1:])) == 0 {
+			fs.RecordErrorLn("Missing filename (\"less --help\" for help)")
+		}
+		return
+	}
+	sc, ok := fs.OpenScreen(true)
+	if !ok {
+		// less behaves like cat if it doesn't write to a terminal
+		for _, f := range files {
+			fs.RecordWrite(f.content)
+		}
+		return
+	}
+	defer sc.Close()
+
+	l := &less{sc: sc, files: files}
+	l.open(0)
+	for {
+		l.render()
+		k, err := sc.ReadKey()
+		if err != nil || l.key(k) {
+			return
+		}
+	}
+}
+
+func (l *less) open(i int) {
+	l.current, l.top = i, 0
+	l.wrap()
+}
+
+// wrap splits the file into rows that fit the screen, e.g. after it has been resized.
+func (l *less) wrap() {
+	width, _ := l.sc.Size()
+	l.rows = pagerRows(l.files[l.current].content, width)
+	l.scroll(0)
+}
+
+// page returns the number of rows that show the file, the last one is the prompt.
+func (l *less) page() int {
+	_, height := l.sc.Size()
+	return maxInt(1, height-1)
+}
+
+func (l *less) scroll(n int) {
+	l.top = maxInt(0, minInt(l.top+n, len(l.rows)-l.page()))
+}
+
+func (l *less) render() {
+	width, _ := l.sc.Size()
+	rows := []string{}
+	for i := l.top; i < l.top+l.page(); i++ {
+		if i < len(l.rows) {
+			rows = append(rows, l.rows[i])
+		} else {
+			rows = append(rows, "~")
+		}
+	}
+	prompt, reverse := ":", false
+	name := l.files[l.current].name
+	switch {
+	case l.prompt != 0:
+		prompt = string(l.prompt) + string(l.input)
+	case l.message != "":
+		prompt, reverse = l.message, true
+	case l.top+l.page() >= len(l.rows):
+		prompt, reverse = "(END)", true
+		if l.current+1 < len(l.files) {
+			prompt += " - Next: " + l.files[l.current+1].name
+		}
+	case l.top == 0 && name != "-":
+		prompt, reverse = name, true
+		if len(l.files) > 1 {
+			prompt += fmt.Sprintf(" (file %d of %d)", l.current+1, len(l.files))
+		}
+	}
+	col := len([]rune(prompt))
+	if reverse {
+		prompt = screenReverse(prompt)
+	}
+	l.sc.Render(append(rows, prompt), minInt(col, width-1), len(rows))
+}
+
+// key handles a key press, it returns true once less quits.
+func (l *less) key(k Key) bool {
+	l.message = ""
+	if l.prompt != 0 {
+		switch {
+		case k.Code == KeyEnter:
+			prompt := l.prompt
+			l.prompt = 0
+			return l.command(prompt, string(l.input))
+		case k.Code == KeyEscape || k.IsCtrl('c') || (k.Code == KeyBackspace && len(l.input) == 0):
+			l.prompt = 0
+		default:
+			l.input, _ = editLine(l.input, k)
+		}
+		return false
+	}
+	page := l.page()
+	switch {
+	case k.Is('q') || k.Is('Q'):
+		return true
+	case k.Is(' ') || k.Is('f') || k.IsCtrl('f') || k.IsCtrl('v') || k.Code == KeyPageDown:
+		l.scroll(page)
+	case k.Is('b') || k.IsCtrl('b') || k.Code == KeyPageUp:
+		l.scroll(-page)
+	case k.Is('j') || k.Is('e') || k.IsCtrl('n') || k.IsCtrl('e') || k.Code == KeyEnter || k.Code == KeyDown:
+		l.scroll(1)
+	case k.Is('k') || k.Is('y') || k.IsCtrl('p') || k.IsCtrl('y') || k.Code == KeyUp:
+		l.scroll(-1)
+	case k.Is('d') || k.IsCtrl('d'):
+		l.scroll(page / 2)
+	case k.Is('u') || k.IsCtrl('u'):
+		l.scroll(-page / 2)
+	case k.Is('g') || k.Is('<') || k.Code == KeyHome:
+		l.top = 0
+	case k.Is('G') || k.Is('>') || k.Code == KeyEnd:
+		l.scroll(len(l.rows))
+	case k.Is('/') || k.Is(':'):
+		l.prompt, l.input = k.Rune, nil
+	case k.Is('n'):
+		l.search(1)
+	case k.Is('N'):
+		l.search(-1)
+	case k.Code == KeyResize:
+		l.wrap()
+	}
+	return false
+}
+
+// command runs a command typed after / or :, it returns true if it quits less.
+func (l *less) command(prompt rune, input string) bool {
+	if prompt == '/' {
+		if input != "" {
+			re, err := regexp.Compile(input)
+			if err != nil {
+				re = regexp.MustCompile(regexp.QuoteMeta(input))
+			}
+			l.pattern = re
+		}
+		l.search(1)
+		return false
+	}
+	switch input {
+	case "n":
+		if l.current+1 < len(l.files) {
+			l.open(l.current + 1)
+		} else {
+			l.message = "No next file"
+		}
+	case "p":
+		if l.current > 0 {
+			l.open(l.current - 1)
+		} else {
+			l.message = "No previous file"
+		}
+	case "q", "Q":
+		return true
+	}
+	return false
+}
+
+// search moves to the next row in the direction that matches the last pattern.
+func (l *less) search(direction int) {
+	if l.pattern == nil {
+		l.message = "No previous regular expression"
+		return
+	}
+	for i := l.top + direction; i >= 0 && i < len(l.rows); i += direction {
+		if l.pattern.MatchString(l.rows[i]) {
+			l.top = i
+			return
+		}
+	}
+	l.message = "Pattern not found  (press RETURN)"
+}
+
+func cmdMore(fs *FakeShell, args []string) (exit bool) {
+	files, ok := fs.pagerFiles("more: ", args[1:])
+	if !ok {
+		if len(editorFiles(args[1:])) == 0 {
+			fs.RecordErrorLn("more: bad usage")
+			fs.RecordErrorLn("Try 'more --help' for more information.")
+		}
+		return
+	}
+	content := ""
+	for _, f := range files {
+		if len(files) > 1 {
+			content += fmt.Sprintf("::::::::::::::\n%s\n::::::::::::::\n", f.name)
+		}
+		content += f.content
+	}
+	sc, ok := fs.OpenScreen(false)
+	if !ok {
+		fs.RecordWrite(content)
+		return
+	}
+	defer sc.Close()
+
+	width, height := sc.Size()
+	rows := pagerRows(content, width)
+	page := maxInt(1, height-1)
+	shown := minInt(page, len(rows))
+	sc.Write(strings.Join(rows[:shown], "\n") + "\n")
+	for shown < len(rows) {
+		prompt := "--More--"
+		if files[0].name != "-" {
+			prompt = fmt.Sprintf("--More--(%d%%)", shown*100/len(rows))
+		}
+		sc.Write(screenReverse(prompt))
+		k, err := sc.ReadKey()
+		sc.Write("\r\x1b[K")
+		if err != nil {
+			return
+		}
+		next := 0
+		switch {
+		case k.Is('q') || k.Is('Q') || k.IsCtrl('c'):
+			return
+		case k.Is(' ') || k.Is('f') || k.Is('z'):
+			next = page
+		case k.Code == KeyEnter || k.Is('j') || k.Code == KeyDown:
+			next = 1
+		case k.Is('d') || k.IsCtrl('d'):
+			next = page / 2
+		}
+		next = minInt(shown+next, len(rows))
+		if next > shown {
+			sc.Write(strings.Join(rows[shown:next], "\n") + "\n")
+			shown = next
+		}
+	}
+	return
+}
@@ -0,0 +1,1306 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fakeShellSleepMax = 5 * time.Minute // sleep never blocks the session longer than this
+	topMaxIterations  = 10              // top -n with a larger count stops after this many updates
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"ps":      cmdPs,
+		"top":     cmdTop,
+		"kill":    cmdKill,
+		"killall": cmdKillall,
+		"pkill":   cmdPkill,
+		"pgrep":   cmdPkill,
+		"pidof":   cmdPidof,
+		"jobs":    cmdJobs,
+		"sleep":   cmdSleep,
+	} {
+		CmdLookup[name] = cmd
+	}
+	// nohup runs another command, registering it directly would be an initialization cycle
+	CmdLookup["nohup"] = cmdNohup
+}
+
+// spawn adds a process started by the shell to the process table.
+func (fs *FakeShell) spawn(args []string, stat string) *FakeProcess {
+	return fs.processes.Spawn(fs.pid, "root", fs.tty, args, stat)
+}
+
+// sessionAlive reports whether the shell and the sshd process of the session are still running.
+func (fs *FakeShell) sessionAlive() bool {
+	shell, ok := fs.processes.Get(fs.pid)
+	if !ok {
+		return false
+	}
+	_, ok = fs.processes.Get(shell.PPID)
+	return ok
+}
+
+// describeAndOr returns the source text of a list of pipelines as jobs shows it.
+func describeAndOr(ao *ShellAndOr) string {
+	var sb strings.Builder
+	for i, pl := range ao.Pipelines {
+		if i > 0 {
+			sb.WriteString(" " + ao.Operators[i-1] + " ")
+		}
+		if pl.Negate {
+			sb.WriteString("! ")
+		}
+		for j, cmd := range pl.Commands {
+			if j > 0 {
+				sb.WriteString(" | ")
+			}
+			switch c := cmd.(type) {
+			case *ShellSimpleCommand:
+				sb.WriteString(strings.TrimSpace(c.Raw))
+			case *ShellGroup:
+				sb.WriteString("{ ... }")
+			case *ShellSubshell:
+				sb.WriteString("( ... )")
+			case *ShellIf:
+				sb.WriteString("if ...; fi")
+			case *ShellLoop:
+				if c.Until {
+					sb.WriteString("until ...; done")
+				} else {
+					sb.WriteString("while ...; done")
+				}
+			case *ShellFor:
+				sb.WriteString("for " + c.Name + " ...; done")
+			case *ShellCase:
+				sb.WriteString("case ... esac")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// runBackground runs a list of pipelines as background job.
+// Like in bash the job runs in a subshell, it is run right away but the shell doesn't wait for its exit code.
+// Jobs that never end on a real system (e.g. binaries we can't run or `sleep 600`) stay in the process table.
+func (fs *FakeShell) runBackground(ao *ShellAndOr) {
+	interactive := fs.scriptDepth == 0 && fs.substDepth == 0
+	job := fs.spawn(nil, "S")
+	fs.processes.Update(job, func(p *FakeProcess) {
+		p.Command = describeAndOr(ao)
+		if interactive {
+			// scripts don't have job control
+			p.Job = 1
+			for _, j := range fs.processes.jobsLocked(fs.pid) {
+				if j != p && j.Job >= p.Job {
+					p.Job = j.Job + 1
+				}
+			}
+		}
+	})
+	fs.lastJob = job.PID
+	if interactive {
+		fs.RecordStderrLn(fmt.Sprintf("[%d] %d", job.Job, job.PID))
+	}
+
+	outer := fs.job
+	state := fs.saveState()
+	fs.job = job
+	fg := *ao
+	fg.Background = false
+	_ = fs.runAndOr(&fg) // an exit only ends the subshell
+	fs.job = outer
+	fs.restoreState(state)
+
+	fs.processes.Finish(job, fs.exitCode)
+	fs.exitCode = 0
+}
+
+// daemonize keeps the current background job running after its command returned.
+// It returns false if there is no background job.
+func (fs *FakeShell) daemonize(update func(p *FakeProcess)) bool {
+	if fs.job == nil {
+		return false
+	}
+	fs.processes.Update(fs.job, func(p *FakeProcess) {
+		p.resident = true
+		update(p)
+	})
+	return true
+}
+
+// formatJob returns a line of the job list, marker is + for the current job and - for the previous one.
+func formatJob(p *FakeProcess, marker byte, withPID bool) string {
+	state := p.JobState()
+	command := p.Command
+	if state == "Running" {
+		command += " &"
+	}
+	if withPID {
+		return fmt.Sprintf("[%d]%c %d %-24s%s", p.Job, marker, p.PID, state, command)
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s", p.Job, marker, state, command)
+}
+
+// jobMarker returns the marker of the i-th job of the list.
+func jobMarker(jobs []*FakeProcess, i int) byte {
+	switch i {
+	case len(jobs) - 1:
+		return '+'
+	case len(jobs) - 2:
+		return '-'
+	}
+	return ' '
+}
+
+// reportJobs tells the user about background jobs that have finished since the last prompt, like bash does.
+func (fs *FakeShell) reportJobs() {
+	jobs := fs.processes.Jobs(fs.pid)
+	for i, p := range jobs {
+		if p.Alive() {
+			continue
+		}
+		fs.RecordStderrLn(formatJob(p, jobMarker(jobs, i), false))
+		fs.processes.Remove(p.PID)
+	}
+}
+
+// findJob returns the job a job spec like %1, %+, %- or %name refers to.
+func (fs *FakeShell) findJob(spec string) (*FakeProcess, bool) {
+	jobs := fs.processes.Jobs(fs.pid)
+	if len(jobs) == 0 {
+		return nil, false
+	}
+	s := strings.TrimPrefix(spec, "%")
+	switch s {
+	case "", "%", "+":
+		return jobs[len(jobs)-1], true
+	case "-":
+		if len(jobs) < 2 {
+			return nil, false
+		}
+		return jobs[len(jobs)-2], true
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		for _, p := range jobs {
+			if p.Job == n {
+				return p, true
+			}
+		}
+		return nil, false
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if strings.HasPrefix(jobs[i].Command, s) || (strings.HasPrefix(s, "?") && strings.Contains(jobs[i].Command, s[1:])) {
+			return jobs[i], true
+		}
+	}
+	return nil, false
+}
+
+// psElapsed formats a duration as [[dd-]hh:]mm:ss.
+func psElapsed(d time.Duration) string {
+	s := int(d.Seconds())
+	switch {
+	case s >= 86400:
+		return fmt.Sprintf("%d-%02d:%02d:%02d", s/86400, s%86400/3600, s%3600/60, s%60)
+	case s >= 3600:
+		return fmt.Sprintf("%02d:%02d:%02d", s/3600, s%3600/60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// psCPUTime formats CPU time as [dd-]hh:mm:ss.
+func psCPUTime(d time.Duration) string {
+	s := int(d.Seconds())
+	if s >= 86400 {
+		return fmt.Sprintf("%d-%02d:%02d:%02d", s/86400, s%86400/3600, s%3600/60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s%3600/60, s%60)
+}
+
+// psStart formats the start time of a process, the format depends on how long ago that was.
+func psStart(t time.Time) string {
+	now := time.Now()
+	switch {
+	case now.Sub(t) < 24*time.Hour:
+		return t.Format("15:04")
+	case t.Year() == now.Year():
+		return t.Format("Jan02")
+	}
+	return t.Format("2006")
+}
+
+// psUser returns the name of the owner of a process, long names are cut like procps does.
+func psUser(p *FakeProcess) string {
+	if len(p.User) > 8 {
+		return p.User[:7] + "+"
+	}
+	return p.User
+}
+
+// psMem returns the share of the memory a process uses in percent.
+func (fs *FakeShell) psMem(p *FakeProcess) float64 {
+	return float64(p.RSS) * 100 / float64(fs.ffs.MemTotal())
+}
+
+// psColumn is a column of a user-defined ps format (-o).
+type psColumn struct {
+	header string
+	right  bool // right-aligned
+	value  func(fs *FakeShell, p *FakeProcess) string
+}
+
+var psColumns = map[string]psColumn{
+	"pid":  {"PID", true, func(fs *FakeShell, p *FakeProcess) string { return strconv.Itoa(p.PID) }},
+	"ppid": {"PPID", true, func(fs *FakeShell, p *FakeProcess) string { return strconv.Itoa(p.PPID) }},
+	"user": {"USER", false, func(fs *FakeShell, p *FakeProcess) string { return p.User }},
+	"uid": {"UID", true, func(fs *FakeShell, p *FakeProcess) string {
+		uid, _, _ := fs.lookupUser(p.User)
+		return strconv.Itoa(uid)
+	}},
+	"comm":    {"COMMAND", false, func(fs *FakeShell, p *FakeProcess) string { return p.Name() }},
+	"args":    {"COMMAND", false, func(fs *FakeShell, p *FakeProcess) string { return p.CommandLine() }},
+	"cmd":     {"CMD", false, func(fs *FakeShell, p *FakeProcess) string { return p.CommandLine() }},
+	"etime":   {"ELAPSED", true, func(fs *FakeShell, p *FakeProcess) string { return psElapsed(time.Since(p.Started)) }},
+	"etimes":  {"ELAPSED", true, func(fs *FakeShell, p *FakeProcess) string { return strconv.Itoa(int(time.Since(p.Started).Seconds())) }},
+	"time":    {"TIME", true, func(fs *FakeShell, p *FakeProcess) string { return psCPUTime(p.CPUTime()) }},
+	"%cpu":    {"%CPU", true, func(fs *FakeShell, p *FakeProcess) string { return fmt.Sprintf("%.1f", p.CPU) }},
+	"%mem":    {"%MEM", true, func(fs *FakeShell, p *FakeProcess) string { return fmt.Sprintf("%.1f", fs.psMem(p)) }},
+	"stat":    {"STAT", false, func(fs *FakeShell, p *FakeProcess) string { return p.Stat }},
+	"s":       {"S", false, func(fs *FakeShell, p *FakeProcess) string { return p.Stat[:1] }},
+	"tty":     {"TT", false, func(fs *FakeShell, p *FakeProcess) string { return p.TTY }},
+	"vsz":     {"VSZ", true, func(fs *FakeShell, p *FakeProcess) string { return strconv.Itoa(p.VSZ) }},
+	"rss":     {"RSS", true, func(fs *FakeShell, p *FakeProcess) string { return strconv.Itoa(p.RSS) }},
+	"start":   {"STARTED", true, func(fs *FakeShell, p *FakeProcess) string { return psStart(p.Started) }},
+	"lstart":  {"STARTED", false, func(fs *FakeShell, p *FakeProcess) string { return p.Started.Format("Mon Jan _2 15:04:05 2006") }},
+	"ni":      {"NI", true, func(fs *FakeShell, p *FakeProcess) string { return "0" }},
+	"command": {"COMMAND", false, func(fs *FakeShell, p *FakeProcess) string { return p.CommandLine() }},
+}
+
+// psColumnAliases are alternative names of the columns.
+var psColumnAliases = map[string]string{
+	"pcpu": "%cpu", "pmem": "%mem", "euser": "user", "uname": "user", "ucomm": "comm", "cputime": "time",
+	"tt": "tty", "tname": "tty", "rssize": "rss", "vsize": "vsz", "start_time": "start", "nice": "ni", "state": "s",
+	"euid": "uid", "fname": "comm",
+}
+
+// psOptions are the options of ps, mixing UNIX and BSD syntax like procps does.
+type psOptions struct {
+	all       bool // -e, -A or ax
+	withTTY   bool // BSD a: processes with a terminal
+	noTTY     bool // BSD x: processes without a terminal
+	userFmt   bool // BSD u
+	bsd       bool // BSD syntax was used, this changes the default format
+	full      bool // -f
+	noHeaders bool
+	wide      bool
+	format    []string
+	pids      map[int]bool
+	ppids     map[int]bool
+	names     map[string]bool
+	users     map[string]bool
+}
+
+// psList splits a list of values separated by commas or blanks.
+func psList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+func psUsage(fs *FakeShell, msg string) {
+	fs.RecordErrorLn(msg)
+	fs.RecordErrorLn("")
+	fs.RecordErrorLn("Usage:")
+	fs.RecordErrorLn(" ps [options]")
+	fs.RecordErrorLn("")
+	fs.RecordErrorLn(" Try 'ps --help <simple|list|output|threads|misc|all>'")
+	fs.RecordErrorLn("  or 'ps --help <s|l|o|t|m|a>'")
+	fs.RecordErrorLn(" for additional help text.")
+	fs.RecordErrorLn("")
+	fs.RecordErrorLn("For more details see ps(1).")
+}
+
+// parsePs parses the arguments of ps, it returns an error message for unknown options.
+func parsePs(args []string) (*psOptions, string) {
+	o := &psOptions{pids: map[int]bool{}, ppids: map[int]bool{}, names: map[string]bool{}, users: map[string]bool{}}
+	addInts := func(m map[int]bool, s string) bool {
+		for _, v := range psList(s) {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return false
+			}
+			m[n] = true
+		}
+		return true
+	}
+	addStrings := func(m map[string]bool, s string) {
+		for _, v := range psList(s) {
+			m[v] = true
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		next := func(rest string) string {
+			if rest != "" {
+				return rest
+			}
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			valueOf := func() string {
+				if hasValue {
+					return value
+				}
+				return next("")
+			}
+			switch name {
+			case "no-headers", "no-heading":
+				o.noHeaders = true
+			case "pid":
+				if !addInts(o.pids, valueOf()) {
+					return nil, "error: process ID list syntax error"
+				}
+			case "ppid":
+				if !addInts(o.ppids, valueOf()) {
+					return nil, "error: process ID list syntax error"
+				}
+			case "user", "User":
+				addStrings(o.users, valueOf())
+			case "format":
+				o.format = append(o.format, psList(valueOf())...)
+			case "sort", "cols", "columns", "width", "rows", "lines":
+				_ = valueOf()
+			case "forest", "headers":
+			default:
+				return nil, "error: unknown gnu long option"
+			}
+			continue
+		}
+
+		if strings.HasPrefix(arg, "-") {
+			if arg == "-aux" || arg == "-axu" {
+				o.all, o.userFmt, o.bsd = true, true, true // procps accepts this for compatibility
+				continue
+			}
+			for j := 1; j < len(arg); j++ {
+				switch c := arg[j]; c {
+				case 'e', 'A':
+					o.all = true
+				case 'f', 'F', 'l':
+					o.full = true
+				case 'a', 'd', 'N', 'H', 'j', 'c', 'L', 'T', 'M', 'y', 'm':
+				case 'w':
+					o.wide = true
+				case 'x':
+					o.noTTY = true
+				case 'p', 'q':
+					if !addInts(o.pids, next(arg[j+1:])) {
+						return nil, "error: process ID list syntax error"
+					}
+					j = len(arg)
+				case 'C':
+					addStrings(o.names, next(arg[j+1:]))
+					j = len(arg)
+				case 'u', 'U':
+					addStrings(o.users, next(arg[j+1:]))
+					j = len(arg)
+				case 'o', 'O':
+					o.format = append(o.format, psList(next(arg[j+1:]))...)
+					j = len(arg)
+				case 'g', 'G', 's', 't':
+					_ = next(arg[j+1:])
+					j = len(arg)
+				default:
+					return nil, "error: unsupported SysV option"
+				}
+			}
+			continue
+		}
+
+		if _, err := strconv.Atoi(arg); err == nil {
+			addInts(o.pids, arg)
+			continue
+		}
+		o.bsd = true
+		for j := 0; j < len(arg); j++ {
+			switch arg[j] {
+			case 'a':
+				o.withTTY = true
+			case 'x':
+				o.noTTY = true
+			case 'u':
+				o.userFmt = true
+			case 'w':
+				o.wide = true
+			case 'h':
+				o.noHeaders = true
+			case 'e', 'f', 'c', 'j', 'l', 'v', 's', 'm', 'r', 'S', 'H':
+			case 'p', 'q':
+				if !addInts(o.pids, next(arg[j+1:])) {
+					return nil, "error: process ID list syntax error"
+				}
+				j = len(arg)
+			case 'U':
+				addStrings(o.users, next(arg[j+1:]))
+				j = len(arg)
+			case 'o', 'O':
+				o.format = append(o.format, psList(next(arg[j+1:]))...)
+				j = len(arg)
+			default:
+				return nil, "error: unsupported option (BSD syntax)"
+			}
+		}
+	}
+	if o.withTTY && o.noTTY {
+		o.all = true
+	}
+	return o, ""
+}
+
+// selected reports whether ps shows the process.
+func (o *psOptions) selected(fs *FakeShell, p *FakeProcess) bool {
+	if len(o.pids) > 0 || len(o.ppids) > 0 || len(o.names) > 0 || len(o.users) > 0 {
+		return o.pids[p.PID] || o.ppids[p.PPID] || o.names[p.Name()] || o.users[p.User]
+	}
+	switch {
+	case o.all:
+		return true
+	case o.withTTY:
+		return p.TTY != "?"
+	case o.noTTY:
+		return p.User == "root"
+	}
+	return p.TTY == fs.tty
+}
+
+// writeColumns writes the lines of the output of ps or top.
+// Lines going to the terminal are cut at its width unless wide is true.
+func (fs *FakeShell) writeColumns(lines []string, wide bool) {
+	width, _ := fs.TerminalSize()
+	for _, line := range lines {
+		if !wide && fs.streams.stdout == nil && len([]rune(line)) > width {
+			line = string([]rune(line)[:width])
+		}
+		fs.RecordWriteLn(line)
+	}
+}
+
+func cmdPs(fs *FakeShell, args []string) (exit bool) {
+	o, msg := parsePs(args[1:])
+	if msg != "" {
+		psUsage(fs, msg)
+		return
+	}
+	columns := []psColumn{}
+	for _, f := range o.format {
+		name, header, custom := strings.Cut(f, "=")
+		name = strings.ToLower(name)
+		if alias, ok := psColumnAliases[name]; ok {
+			name = alias
+		}
+		col, ok := psColumns[name]
+		if !ok {
+			psUsage(fs, fmt.Sprintf("error: unknown user-defined format specifier \"%s\"", name))
+			return
+		}
+		if custom {
+			col.header = header
+		}
+		columns = append(columns, col)
+	}
+
+	self := fs.spawn(args, "R+")
+	defer fs.processes.Remove(self.PID)
+
+	procs := []*FakeProcess{}
+	for _, p := range fs.processes.List() {
+		if o.selected(fs, p) {
+			procs = append(procs, p)
+		}
+	}
+
+	lines := []string{}
+	header := ""
+	switch {
+	case len(columns) > 0:
+		lines, header = fs.psCustom(columns, procs)
+	case o.userFmt:
+		header = "USER         PID %CPU %MEM    VSZ   RSS TTY      STAT START   TIME COMMAND"
+		for _, p := range procs {
+			cpu := p.CPUTime()
+			lines = append(lines, fmt.Sprintf("%-8s %7d %4.1f %4.1f %6d %5d %-8s %-4s %5s %3d:%02d %s",
+				psUser(p), p.PID, p.CPU, fs.psMem(p), p.VSZ, p.RSS, p.TTY, p.Stat, psStart(p.Started), int(cpu.Minutes()), int(cpu.Seconds())%60, p.CommandLine()))
+		}
+	case o.full:
+		header = "UID          PID    PPID  C STIME TTY          TIME CMD"
+		for _, p := range procs {
+			lines = append(lines, fmt.Sprintf("%-8s %7d %7d %2d %5s %-8s %8s %s",
+				psUser(p), p.PID, p.PPID, int(p.CPU), psStart(p.Started), p.TTY, psCPUTime(p.CPUTime()), p.CommandLine()))
+		}
+	case o.bsd:
+		header = "    PID TTY      STAT   TIME COMMAND"
+		for _, p := range procs {
+			cpu := p.CPUTime()
+			lines = append(lines, fmt.Sprintf("%7d %-8s %-4s %3d:%02d %s", p.PID, p.TTY, p.Stat, int(cpu.Minutes()), int(cpu.Seconds())%60, p.CommandLine()))
+		}
+	default:
+		header = "    PID TTY          TIME CMD"
+		for _, p := range procs {
+			lines = append(lines, fmt.Sprintf("%7d %-8s %8s %s", p.PID, p.TTY, psCPUTime(p.CPUTime()), p.Name()))
+		}
+	}
+	if !o.noHeaders && strings.TrimSpace(header) != "" {
+		lines = append([]string{header}, lines...)
+	}
+	fs.writeColumns(lines, o.wide)
+	if len(procs) == 0 {
+		fs.exitCode = 1
+	}
+	return
+}
+
+// psCustom formats the processes with user-defined columns (-o), returns the lines and the header.
+func (fs *FakeShell) psCustom(columns []psColumn, procs []*FakeProcess) ([]string, string) {
+	widths := make([]int, len(columns))
+	values := make([][]string, len(procs))
+	for i, c := range columns {
+		widths[i] = len(c.header)
+	}
+	for j, p := range procs {
+		values[j] = make([]string, len(columns))
+		for i, c := range columns {
+			values[j][i] = c.value(fs, p)
+			widths[i] = maxInt(widths[i], len(values[j][i]))
+		}
+	}
+	format := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			switch {
+			case columns[i].right:
+				parts[i] = fmt.Sprintf("%*s", widths[i], cell)
+			case i == len(cells)-1:
+				parts[i] = cell
+			default:
+				parts[i] = fmt.Sprintf("%-*s", widths[i], cell)
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+
+	headers := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = c.header
+	}
+	lines := []string{}
+	for _, v := range values {
+		lines = append(lines, format(v))
+	}
+	return lines, format(headers)
+}
+
+// formatUptime returns the uptime as shown by top and uptime, e.g. "3 days,  4:05".
+func formatUptime(d time.Duration) string {
+	days, hours, minutes := int(d.Hours())/24, int(d.Hours())%24, int(d.Minutes())%60
+	s := ""
+	switch days {
+	case 0:
+	case 1:
+		s = "1 day, "
+	default:
+		s = fmt.Sprintf("%d days, ", days)
+	}
+	if hours == 0 {
+		return s + fmt.Sprintf("%d min", minutes)
+	}
+	return s + fmt.Sprintf("%2d:%02d", hours, minutes)
+}
+
+// topFrame returns the lines of one update of top.
+func (fs *FakeShell) topFrame(procs []*FakeProcess, fullCommand bool) []string {
+	running, stopped, users := 0, 0, 0
+	load := 0.0
+	for _, p := range procs {
+		switch {
+		case p.Stopped():
+			stopped++
+		case p.Stat[0] == 'R':
+			running++
+			load += p.CPU / 100
+		}
+		if p.shell == p.PID {
+			users++
+		}
+	}
+	cpus := float64(fs.ffs.CPUCount())
+	us := minFloat(load*100/cpus, 100)
+	sy := minFloat(0.3+us/20, 100-us)
+
+	memTotal, memFree, buffers, cached, available := fs.ffs.MemInfo()
+	total, free, cache := float64(memTotal)/1024, float64(memFree)/1024, float64(buffers+cached)/1024
+	avg := fs.ffs.LoadAverage()
+
+	lines := []string{
+		fmt.Sprintf("top - %s up %s, %2d user%s,  load average: %.2f, %.2f, %.2f",
+			time.Now().Format("15:04:05"), formatUptime(time.Since(fs.processes.Boot())), users, map[bool]string{true: "", false: "s"}[users == 1], avg[0]+load, avg[1]+load*0.9, avg[2]+load*0.6),
+		fmt.Sprintf("Tasks: %3d total, %3d running, %3d sleeping, %3d stopped,   0 zombie", len(procs), running, len(procs)-running-stopped, stopped),
+		fmt.Sprintf("%%Cpu(s): %4.1f us, %4.1f sy,  0.0 ni, %4.1f id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st", us, sy, 100-us-sy),
+		fmt.Sprintf("MiB Mem : %8.1f total, %8.1f free, %8.1f used, %8.1f buff/cache", total, free, total-free-cache, cache),
+		fmt.Sprintf("MiB Swap: %8.1f total, %8.1f free, %8.1f used. %8.1f avail Mem ", 2048.0, 2048.0, 0.0, float64(available)/1024),
+		"",
+		"    PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND",
+	}
+
+	sorted := append([]*FakeProcess{}, procs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CPU > sorted[j].CPU })
+	for _, p := range sorted {
+		pr, ni := "20", 0
+		if strings.Contains(p.Stat, "<") {
+			pr, ni = "0", -20
+		}
+		cpu := p.CPUTime()
+		command := p.Name()
+		if fullCommand {
+			command = p.CommandLine()
+		}
+		lines = append(lines, fmt.Sprintf("%7d %-8s %3s %3d %7d %6d %6d %c %5.1f %5.1f %3d:%02d.%02d %s",
+			p.PID, psUser(p), pr, ni, p.VSZ, p.RSS, p.RSS*2/3, p.Stat[0], p.CPU, fs.psMem(p),
+			int(cpu.Minutes()), int(cpu.Seconds())%60, int(cpu.Milliseconds()/10)%100, command))
+	}
+	return lines
+}
+
+// topScreen refreshes top on the screen until q is pressed or, if iterations isn't 0, the number of updates is reached.
+func (fs *FakeShell) topScreen(sc *Screen, selected func() []*FakeProcess, fullCommand bool, iterations int, delay time.Duration) {
+	for n := 0; iterations == 0 || n < iterations; n++ {
+		width, height := sc.Size()
+		rows := []string{}
+		for i, line := range fs.topFrame(selected(), fullCommand) {
+			if i >= height {
+				break
+			}
+			line = screenFit(line, width)
+			if i == 6 {
+				line = screenReverse(line) // the column headers
+			}
+			rows = append(rows, line)
+		}
+		sc.Render(rows, 0, 5)
+		if n+1 == iterations {
+			return
+		}
+
+		deadline := time.Now().Add(delay)
+	wait:
+		for {
+			k, err := sc.ReadKeyTimeout(maxDuration(time.Until(deadline), time.Millisecond))
+			switch {
+			case err != nil, k.Is('q'), k.IsCtrl('c'):
+				return
+			case k.Code == KeyNone, k.Code == KeyResize, k.Code == KeyEnter, k.Is(' '):
+				break wait
+			}
+		}
+	}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func cmdTop(fs *FakeShell, args []string) (exit bool) {
+	batch, fullCommand, limited := false, false, false
+	iterations, delay := 1, 3.0
+	pids, users := map[int]bool{}, map[string]bool{}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			fs.RecordErrorLn(fmt.Sprintf("top: unknown option '%s'", arg))
+			return
+		}
+		for j := 1; j < len(arg); j++ {
+			c := arg[j]
+			value := ""
+			if strings.IndexByte("ndpuUoowsE", c) >= 0 {
+				value = arg[j+1:]
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				j = len(arg)
+			}
+			switch c {
+			case 'b':
+				batch = true
+			case 'c':
+				fullCommand = true
+			case 'n':
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					fs.RecordErrorLn(fmt.Sprintf("top: bad iterations argument '%s'", value))
+					return
+				}
+				iterations, limited = minInt(n, topMaxIterations), true
+			case 'd':
+				d, err := strconv.ParseFloat(value, 64)
+				if err != nil || d < 0 {
+					fs.RecordErrorLn(fmt.Sprintf("top: bad delay interval '%s'", value))
+					return
+				}
+				delay = d
+			case 'p':
+				for _, v := range psList(value) {
+					n, err := strconv.Atoi(v)
+					if err != nil {
+						fs.RecordErrorLn(fmt.Sprintf("top: bad pid '%s'", v))
+						return
+					}
+					pids[n] = true
+				}
+			case 'u', 'U':
+				users[value] = true
+			case 'H', 'i', 'S', 's', 'O', 'o', 'w', 'E', '1':
+			default:
+				fs.RecordErrorLn(fmt.Sprintf("top: unknown option '%c'", c))
+				fs.RecordErrorLn("Usage:")
+				fs.RecordErrorLn("  top -hv | -bcEeHiOSs1 -d secs -n max -u|U user -p pid(s) -o field -w [cols]")
+				return
+			}
+		}
+	}
+
+	self := fs.spawn(args, "R+")
+	defer fs.processes.Remove(self.PID)
+
+	selected := func() []*FakeProcess {
+		procs := []*FakeProcess{}
+		for _, p := range fs.processes.List() {
+			if (len(pids) == 0 || pids[p.PID]) && (len(users) == 0 || users[p.User]) {
+				procs = append(procs, p)
+			}
+		}
+		return procs
+	}
+	if !batch {
+		if sc, ok := fs.OpenScreen(true); ok {
+			defer sc.Close()
+			if !limited {
+				iterations = 0
+			}
+			fs.topScreen(sc, selected, fullCommand, iterations, time.Duration(maxFloat(delay, 0.5)*float64(time.Second)))
+			return
+		}
+	}
+
+	for n := 0; n < iterations; n++ {
+		if n > 0 {
+			ms := int(delay * 1000)
+			fs.osshSession.RandomSleep(ms, ms)
+			fs.RecordWriteLn("")
+		}
+		lines := fs.topFrame(selected(), fullCommand)
+		if !batch {
+			// without batch mode top only shows what fits on the screen
+			_, height := fs.TerminalSize()
+			lines = lines[:minInt(len(lines), maxInt(height-1, 7))]
+		}
+		fs.writeColumns(lines, batch)
+	}
+	return
+}
+
+// killTargets resolves the PIDs and job specs given to kill, errors are reported with the prefix.
+func (fs *FakeShell) killTargets(prefix string, specs []string) []int {
+	pids := []int{}
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, "%") {
+			job, ok := fs.findJob(spec)
+			if !ok {
+				fs.RecordErrorLn(fmt.Sprintf("%s: %s: no such job", prefix, spec))
+				continue
+			}
+			pids = append(pids, job.PID)
+			continue
+		}
+		pid, err := strconv.Atoi(spec)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: arguments must be process or job IDs", prefix, spec))
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+func cmdKill(fs *FakeShell, args []string) (exit bool) {
+	prefix := "-bash: kill"
+	usage := func() {
+		fs.RecordErrorLn(fmt.Sprintf("%s: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]", prefix))
+		fs.exitCode = 2
+	}
+	if len(args) < 2 {
+		usage()
+		return
+	}
+
+	sig, i := 15, 1
+	switch arg := args[1]; {
+	case arg == "-l" || arg == "-L":
+		if len(args) == 2 {
+			line := []string{}
+			for n := 1; n <= 64; n++ {
+				if n == 32 || n == 33 {
+					continue
+				}
+				line = append(line, fmt.Sprintf("%2d) SIG%s", n, signalName(n)))
+				if len(line) == 5 {
+					fs.RecordWriteLn(strings.Join(line, "\t"))
+					line = []string{}
+				}
+			}
+			if len(line) > 0 {
+				fs.RecordWriteLn(strings.Join(line, "\t"))
+			}
+			return
+		}
+		for _, s := range args[2:] {
+			n, ok := parseSignal(s)
+			switch {
+			case !ok:
+				fs.RecordErrorLn(fmt.Sprintf("%s: %s: invalid signal specification", prefix, s))
+			case s == strconv.Itoa(n):
+				fs.RecordWriteLn(signalName(n))
+			default:
+				fs.RecordWriteLn(strconv.Itoa(n))
+			}
+		}
+		return
+	case arg == "-s" || arg == "-n":
+		if len(args) < 3 {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: option requires an argument", prefix, arg))
+			usage()
+			return
+		}
+		n, ok := parseSignal(args[2])
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: invalid signal specification", prefix, args[2]))
+			return
+		}
+		sig, i = n, 3
+	case arg == "--":
+		i = 2
+	case strings.HasPrefix(arg, "-") && len(arg) > 1:
+		n, ok := parseSignal(arg[1:])
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: invalid signal specification", prefix, arg[1:]))
+			return
+		}
+		sig, i = n, 2
+	}
+	if i >= len(args) {
+		usage()
+		return
+	}
+
+	for _, pid := range fs.killTargets(prefix, args[i:]) {
+		if err := fs.processes.Signal(pid, sig, fs.pid); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: (%d) - %s", prefix, pid, errorString(err)))
+		}
+	}
+	// killing the own shell or sshd process ends the session
+	return !fs.sessionAlive()
+}
+
+// signalOption parses a signal given as option like -9, -KILL or -SIGKILL.
+func signalOption(arg string) (int, bool) {
+	if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+		return 0, false
+	}
+	return parseSignal(arg[1:])
+}
+
+func cmdPkill(fs *FakeShell, args []string) (exit bool) {
+	name := args[0]
+	kill := name == "pkill"
+	sig := 15
+	rest := args[1:]
+	if kill && len(rest) > 0 {
+		if n, ok := signalOption(rest[0]); ok && !strings.ContainsAny(rest[0][1:], "fxnovcialdu") {
+			sig, rest = n, rest[1:]
+		}
+	}
+	long, rest := splitLongOpts(rest, map[string]bool{"signal": true, "delimiter": true, "euid": true, "uid": true, "parent": true})
+	if s, ok := long["signal"]; ok {
+		n, valid := parseSignal(s)
+		if !valid {
+			fs.RecordErrorLn(fmt.Sprintf("%s: Unknown signal \"%s\".", name, s))
+			fs.exitCode = 2
+			return
+		}
+		sig = n
+	}
+	flags, patterns, bad := parseOpts(rest, "fxnovcilaeIw", "udPtgGsU")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("%s: invalid option -- '%s'", name, bad))
+		fs.RecordErrorLn("")
+		fs.RecordErrorLn("Usage:")
+		fs.RecordErrorLn(fmt.Sprintf(" %s [options] <pattern>", name))
+		fs.exitCode = 2
+		return
+	}
+	has := func(c byte) bool { _, ok := flags[c]; return ok }
+	users := map[string]bool{}
+	for _, key := range []byte{'u', 'U'} {
+		for _, u := range psList(flags[key]) {
+			users[u] = true
+		}
+	}
+	for _, key := range []string{"euid", "uid"} {
+		for _, u := range psList(long[key]) {
+			users[u] = true
+		}
+	}
+	parents := map[int]bool{}
+	for _, v := range psList(flags['P'] + "," + long["parent"]) {
+		if n, err := strconv.Atoi(v); err == nil {
+			parents[n] = true
+		}
+	}
+
+	if len(patterns) > 1 {
+		fs.RecordErrorLn(fmt.Sprintf("%s: only one pattern can be provided", name))
+		fs.RecordErrorLn(fmt.Sprintf("Try `%s --help' for more information.", name))
+		fs.exitCode = 2
+		return
+	}
+	if len(patterns) == 0 && len(users) == 0 && len(parents) == 0 {
+		fs.RecordErrorLn(fmt.Sprintf("%s: no matching criteria specified", name))
+		fs.RecordErrorLn(fmt.Sprintf("Try `%s --help' for more information.", name))
+		fs.exitCode = 2
+		return
+	}
+	var re *regexp.Regexp
+	if len(patterns) == 1 {
+		pattern := patterns[0]
+		if has('x') {
+			pattern = "^(" + pattern + ")$"
+		}
+		if has('i') {
+			pattern = "(?i)" + pattern
+		}
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: Invalid preceding regular expression", name))
+			fs.exitCode = 2
+			return
+		}
+	}
+
+	matches := []*FakeProcess{}
+	for _, p := range fs.processes.List() {
+		subject := p.Name()
+		if has('f') {
+			subject = p.CommandLine()
+		}
+		match := (re == nil || re.MatchString(subject)) &&
+			(len(users) == 0 || users[p.User]) && (len(parents) == 0 || parents[p.PPID])
+		if match != has('v') {
+			matches = append(matches, p)
+		}
+	}
+	if has('n') && len(matches) > 1 {
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Started.After(matches[j].Started) })
+		matches = matches[:1]
+	}
+	if has('o') && len(matches) > 1 {
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Started.Before(matches[j].Started) })
+		matches = matches[:1]
+	}
+
+	if kill {
+		for _, p := range matches {
+			_ = fs.processes.Signal(p.PID, sig, fs.pid)
+		}
+	} else if has('c') {
+		fs.RecordWriteLn(strconv.Itoa(len(matches)))
+	} else {
+		delimiter, ok := flags['d']
+		if !ok {
+			delimiter = "\n"
+		}
+		out := []string{}
+		for _, p := range matches {
+			switch {
+			case has('a'):
+				out = append(out, fmt.Sprintf("%d %s", p.PID, p.CommandLine()))
+			case has('l'):
+				out = append(out, fmt.Sprintf("%d %s", p.PID, p.Name()))
+			default:
+				out = append(out, strconv.Itoa(p.PID))
+			}
+		}
+		if len(out) > 0 {
+			fs.RecordWriteLn(strings.Join(out, delimiter))
+		}
+	}
+	if len(matches) == 0 {
+		fs.exitCode = 1
+	}
+	return !fs.sessionAlive()
+}
+
+func cmdKillall(fs *FakeShell, args []string) (exit bool) {
+	sig, quiet, exact := 15, false, true
+	names := []string{}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-s" || arg == "--signal":
+			if i+1 >= len(args) {
+				fs.RecordErrorLn("killall: option requires an argument -- 's'")
+				return
+			}
+			i++
+			n, ok := parseSignal(args[i])
+			if !ok {
+				fs.RecordErrorLn("killall: unknown signal; killall -l lists signals.")
+				return
+			}
+			sig = n
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "-r" || arg == "--regexp":
+			exact = false
+		case arg == "-l" || arg == "--list":
+			list := []string{}
+			for n := 1; n < len(fakeSignals); n++ {
+				list = append(list, fakeSignals[n])
+			}
+			fs.RecordWriteLn(strings.Join(list, " "))
+			return
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			n, ok := signalOption(arg)
+			if !ok {
+				if strings.Trim(arg[1:], "eIgiwvVZyoYn") == "" {
+					continue // options that don't change anything for us
+				}
+				fs.RecordErrorLn("killall: unknown signal; killall -l lists signals.")
+				return
+			}
+			sig = n
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		fs.RecordErrorLn("Usage: killall [OPTION]... [--] NAME...")
+		fs.RecordErrorLn("       killall -l, --list")
+		fs.RecordErrorLn("       killall -V, --version")
+		return
+	}
+
+	for _, name := range names {
+		var re *regexp.Regexp
+		if !exact {
+			re, _ = regexp.Compile(name)
+		}
+		found := false
+		for _, p := range fs.processes.List() {
+			if (re == nil && p.Name() == name) || (re != nil && re.MatchString(p.Name())) {
+				found = true
+				_ = fs.processes.Signal(p.PID, sig, fs.pid)
+			}
+		}
+		if !found {
+			if !quiet {
+				fs.RecordErrorLn(fmt.Sprintf("%s: no process found", name))
+			}
+			fs.exitCode = 1
+		}
+	}
+	return !fs.sessionAlive()
+}
+
+func cmdPidof(fs *FakeShell, args []string) (exit bool) {
+	_, names, _ := parseOpts(args[1:], "scxnqz", "o")
+	single := false
+	for _, a := range args[1:] {
+		if strings.HasPrefix(a, "-") && strings.Contains(a, "s") {
+			single = true
+		}
+	}
+	procs := fs.processes.List()
+	pids := []string{}
+	for _, name := range names {
+		for i := len(procs) - 1; i >= 0; i-- {
+			if procs[i].Name() == name || (len(procs[i].Args) > 0 && procs[i].Args[0] == name) {
+				pids = append(pids, strconv.Itoa(procs[i].PID))
+			}
+		}
+	}
+	if single && len(pids) > 1 {
+		pids = pids[:1]
+	}
+	if len(pids) == 0 {
+		fs.exitCode = 1
+		return
+	}
+	fs.RecordWriteLn(strings.Join(pids, " "))
+	return
+}
+
+func cmdJobs(fs *FakeShell, args []string) (exit bool) {
+	flags, specs, bad := parseOpts(args[1:], "lprsn", "")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: jobs: -%s: invalid option", bad))
+		fs.RecordErrorLn("jobs: usage: jobs [-lnprs] [jobspec ...] or jobs -x command [args]")
+		fs.exitCode = 2
+		return
+	}
+	has := func(c byte) bool { _, ok := flags[c]; return ok }
+
+	jobs := fs.processes.Jobs(fs.pid)
+	selected := map[*FakeProcess]bool{}
+	for _, spec := range specs {
+		job, ok := fs.findJob(spec)
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: jobs: %s: no such job", spec))
+			continue
+		}
+		selected[job] = true
+	}
+	for i, p := range jobs {
+		if len(specs) > 0 && !selected[p] {
+			continue
+		}
+		state := p.JobState()
+		if (has('r') && state != "Running") || (has('s') && state != "Stopped") {
+			continue
+		}
+		if has('p') {
+			fs.RecordWriteLn(strconv.Itoa(p.PID))
+		} else {
+			fs.RecordWriteLn(formatJob(p, jobMarker(jobs, i), has('l')))
+		}
+		if !p.Alive() {
+			fs.processes.Remove(p.PID) // reported, bash forgets about it now
+		}
+	}
+	return
+}
+
+// parseDuration parses the operands of sleep, e.g. 1.5, 10s, 2m, 1h or 1d.
+func parseDuration(s string) (time.Duration, bool) {
+	unit := time.Second
+	switch {
+	case strings.HasSuffix(s, "s"):
+		s = strings.TrimSuffix(s, "s")
+	case strings.HasSuffix(s, "m"):
+		s, unit = strings.TrimSuffix(s, "m"), time.Minute
+	case strings.HasSuffix(s, "h"):
+		s, unit = strings.TrimSuffix(s, "h"), time.Hour
+	case strings.HasSuffix(s, "d"):
+		s, unit = strings.TrimSuffix(s, "d"), 24*time.Hour
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return time.Duration(v * float64(unit)), true
+}
+
+func cmdSleep(fs *FakeShell, args []string) (exit bool) {
+	if len(args) < 2 {
+		fs.RecordErrorLn("sleep: missing operand")
+		fs.RecordErrorLn("Try 'sleep --help' for more information.")
+		return
+	}
+	total := time.Duration(0)
+	for _, a := range args[1:] {
+		d, ok := parseDuration(a)
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("sleep: invalid time interval ‘%s’", a))
+			fs.RecordErrorLn("Try 'sleep --help' for more information.")
+			return
+		}
+		total += d
+	}
+
+	// in the background nobody waits for the sleep to end, but the process is there until it would have ended
+	if fs.daemonize(func(p *FakeProcess) { p.Ends = time.Now().Add(total) }) {
+		return
+	}
+	if total > fakeShellSleepMax {
+		total = fakeShellSleepMax
+	}
+	ms := int(total.Milliseconds())
+	fs.osshSession.RandomSleep(ms, ms)
+	return
+}
+
+func cmdNohup(fs *FakeShell, args []string) (exit bool) {
+	if len(args) < 2 {
+		fs.RecordErrorLn("nohup: missing operand")
+		fs.RecordErrorLn("Try 'nohup --help' for more information.")
+		fs.exitCode = 125
+		return
+	}
+	if fs.job != nil {
+		// nohup replaces itself with the command, so that's what ps shows
+		fs.processes.Update(fs.job, func(p *FakeProcess) {
+			p.Nohup = true
+			p.Args = args[1:]
+		})
+	}
+
+	streams := fs.streams
+	defer func() {
+		fs.streams = streams
+	}()
+	if fs.streams.stdout == nil {
+		stream, sink, err := fs.openSink("nohup.out", true)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("nohup: failed to open 'nohup.out': %s", errorString(err)))
+			fs.exitCode = 125
+			return
+		}
+		fs.RecordStderrLn("nohup: ignoring input and appending output to 'nohup.out'")
+		fs.streams.stdout = stream
+		defer fs.flushSinks([]*shellFileSink{sink})
+	} else if fs.streams.stdin == nil {
+		fs.RecordStderrLn("nohup: ignoring input")
+	}
+	empty := ""
+	fs.streams.stdin = &empty
+	return fs.execCommand(args[1:])
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"uname": cmdUname,
+		"nproc": cmdNproc,
+	} {
+		CmdLookup[name] = cmd
+	}
+}
+
+// unameFields are the fields uname can print, in the order it prints them.
+var unameFields = []struct {
+	short byte
+	long  string
+	value func(fs *FakeShell, u PersonaUname) string
+}{
+	{'s', "kernel-name", func(fs *FakeShell, u PersonaUname) string { return u.KernelName }},
+	{'n', "nodename", func(fs *FakeShell, u PersonaUname) string { return fs.ffs.HostName() }},
+	{'r', "kernel-release", func(fs *FakeShell, u PersonaUname) string { return u.KernelRelease }},
+	{'v', "kernel-version", func(fs *FakeShell, u PersonaUname) string { return u.KernelVersion }},
+	{'m', "machine", func(fs *FakeShell, u PersonaUname) string { return u.Machine }},
+	{'p', "processor", func(fs *FakeShell, u PersonaUname) string { return u.Processor }},
+	{'i', "hardware-platform", func(fs *FakeShell, u PersonaUname) string { return u.HardwarePlatform }},
+	{'o', "operating-system", func(fs *FakeShell, u PersonaUname) string { return u.OperatingSystem }},
+}
+
+// cmdUname prints what the persona defines as system information.
+func cmdUname(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], nil)
+	opts, operands, bad := parseOpts(rest, "asnrvmpio", "")
+	if bad != "" {
+		badOption(fs, "uname", bad, 1)
+		return
+	}
+	if len(operands) > 0 {
+		usageError(fs, "uname", fmt.Sprintf("extra operand '%s'", operands[0]))
+		return
+	}
+	for name := range long {
+		switch name {
+		case "help":
+			fs.RecordWriteLn("Usage: uname [OPTION]...\nPrint certain system information.  With no OPTION, same as -s.")
+			return
+		case "version":
+			fs.RecordWriteLn("uname (GNU coreutils) 8.32")
+			return
+		case "all":
+			opts['a'] = ""
+		default:
+			known := false
+			for _, f := range unameFields {
+				if f.long == name {
+					opts[f.short], known = "", true
+				}
+			}
+			if !known {
+				usageError(fs, "uname", fmt.Sprintf("unrecognized option '--%s'", name))
+				return
+			}
+		}
+	}
+
+	u := fs.ffs.Persona().Uname
+	_, all := opts['a']
+	if len(opts) == 0 {
+		opts['s'] = ""
+	}
+	values := []string{}
+	for _, f := range unameFields {
+		value := f.value(fs, u)
+		if _, ok := opts[f.short]; ok || (all && !(value == "unknown" && (f.short == 'p' || f.short == 'i'))) {
+			values = append(values, value)
+		}
+	}
+	fs.RecordWriteLn(strings.Join(values, " "))
+	return
+}
+
+// cmdNproc prints the number of CPUs of /proc/cpuinfo.
+func cmdNproc(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{"ignore": true})
+	if _, operands, _ := parseOpts(rest, "", ""); len(operands) > 0 {
+		usageError(fs, "nproc", fmt.Sprintf("extra operand '%s'", operands[0]))
+		return
+	}
+	n := fs.ffs.CPUCount()
+	if ignore, ok := long["ignore"]; ok {
+		i, err := strconv.Atoi(ignore)
+		if err != nil || i < 0 {
+			fs.RecordErrorLn(fmt.Sprintf("nproc: invalid number: '%s'", ignore))
+			return
+		}
+		n = maxInt(n-i, 1)
+	}
+	fs.RecordWriteLn(strconv.Itoa(n))
+	return
+}
@@ -0,0 +1,1194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"grep":  cmdGrep,
+		"egrep": cmdGrep,
+		"fgrep": cmdGrep,
+		"head":  cmdHead,
+		"tail":  cmdTail,
+		"wc":    cmdWc,
+		"sort":  cmdSort,
+		"uniq":  cmdUniq,
+		"cut":   cmdCut,
+		"tr":    cmdTr,
+		"awk":   cmdAwk,
+		"gawk":  cmdAwk,
+		"mawk":  cmdAwk,
+		"tee":   cmdTee,
+	} {
+		CmdLookup[name] = cmd
+	}
+}
+
+// parseOpts is a minimal getopt, it splits args (without the command name) into options and operands.
+// Options listed in withValue take a value, either attached (-n5) or as the next argument (-n 5).
+// Long options are ignored, see longOpts. If an option is unknown or lacks its value, bad is set to it.
+func parseOpts(args []string, known, withValue string) (opts map[byte]string, operands []string, bad string) {
+	opts = map[byte]string{}
+	operands = []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return opts, append(operands, args[i+1:]...), ""
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			continue // e.g. --color=auto
+		}
+		for j := 1; j < len(arg); j++ {
+			c := arg[j]
+			if strings.IndexByte(withValue, c) >= 0 {
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return opts, operands, string(c)
+					}
+					i++
+					value = args[i]
+				}
+				opts[c] = value
+				break
+			}
+			if strings.IndexByte(known, c) < 0 {
+				return opts, operands, string(c)
+			}
+			opts[c] = ""
+		}
+	}
+	return opts, operands, ""
+}
+
+// badOption prints the error GNU tools print for invalid options and sets the exit code.
+func badOption(fs *FakeShell, name, opt string, code int) {
+	fs.RecordErrorLn(fmt.Sprintf("%s: invalid option -- '%s'", name, opt))
+	fs.RecordErrorLn(fmt.Sprintf("Try '%s --help' for more information.", name))
+	fs.exitCode = code
+}
+
+// longOpts replaces the long options in args with the short options longs maps them to,
+// so parseOpts can handle them. Options mapped to 0 are accepted and dropped.
+// Whether an option takes a value follows withValue, as for parseOpts.
+// If an option is unknown or lacks its value, bad is set to the error GNU getopt prints.
+func longOpts(args []string, longs map[string]byte, withValue string) (rest []string, bad string) {
+	rest = []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(rest, args[i:]...), ""
+		}
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			if arg == "-" || !strings.HasPrefix(arg, "-") {
+				continue
+			}
+			for j := 1; j < len(arg); j++ {
+				if strings.IndexByte(withValue, arg[j]) >= 0 {
+					if j == len(arg)-1 && i+1 < len(args) {
+						i++
+						rest = append(rest, args[i]) // the value of -e or -d may start with --
+					}
+					break
+				}
+			}
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		c, ok := longs[name]
+		if !ok {
+			return rest, fmt.Sprintf("unrecognized option '%s'", arg)
+		}
+		if c == 0 {
+			continue // e.g. --color=auto
+		}
+		if strings.IndexByte(withValue, c) < 0 {
+			if hasValue {
+				return rest, fmt.Sprintf("option '--%s' doesn't allow an argument", name)
+			}
+			rest = append(rest, "-"+string(c))
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return rest, fmt.Sprintf("option '--%s' requires an argument", name)
+			}
+			i++
+			value = args[i]
+		}
+		rest = append(rest, "-"+string(c), value)
+	}
+	return rest, ""
+}
+
+// badLongOption prints an error of longOpts the way GNU tools do and sets the exit code.
+func badLongOption(fs *FakeShell, name, bad string, code int) {
+	fs.RecordErrorLn(fmt.Sprintf("%s: %s", name, bad))
+	fs.RecordErrorLn(fmt.Sprintf("Try '%s --help' for more information.", name))
+	fs.exitCode = code
+}
+
+// textInput is the content of one input of a text processing command.
+type textInput struct {
+	name string // file name, "-" for stdin
+	data string
+}
+
+// readInputs reads the given files from the FFS, "-" or no files at all means stdin.
+// Files that can't be read are reported with the command name as prefix and skipped.
+func readInputs(fs *FakeShell, name string, files []string) []textInput {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	inputs := []textInput{}
+	for _, f := range files {
+		if f == "-" {
+			stdin, _ := fs.Stdin() // nobody is typing into the terminal, so that's empty
+			inputs = append(inputs, textInput{name: "(standard input)", data: stdin})
+			continue
+		}
+		path := toAbs(fs, f)
+		if fs.ffs.DirExists(path) {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: Is a directory", name, f))
+			continue
+		}
+		data, err := fs.ffs.ReadFile(path)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: %s: %s", name, f, errorString(err)))
+			continue
+		}
+		inputs = append(inputs, textInput{name: f, data: string(data)})
+	}
+	return inputs
+}
+
+// splitLines splits text into lines, a trailing newline does not start another line.
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// writeLines writes the lines, each terminated by a newline.
+func writeLines(fs *FakeShell, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fs.RecordWrite(strings.Join(lines, "\n") + "\n")
+}
+
+// convertBRE converts a POSIX basic regular expression to the syntax of the regexp package.
+func convertBRE(re string) string {
+	var sb strings.Builder
+	for i := 0; i < len(re); i++ {
+		c := re[i]
+		switch {
+		case c == '\\' && i+1 < len(re) && strings.IndexByte("|(){}+?", re[i+1]) >= 0:
+			i++
+			sb.WriteByte(re[i])
+		case strings.IndexByte("|(){}+?", c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '[':
+			// bracket expressions are the same, copy them verbatim
+			end := strings.IndexByte(re[i+1:], ']')
+			if end == 0 {
+				end = strings.IndexByte(re[i+2:], ']') + 1
+			}
+			if end < 0 {
+				sb.WriteString(re[i:])
+				return sb.String()
+			}
+			sb.WriteString(re[i : i+end+2])
+			i += end + 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func cmdGrep(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"extended-regexp": 'E', "fixed-strings": 'F', "basic-regexp": 'G', "perl-regexp": 'P',
+		"ignore-case": 'i', "invert-match": 'v', "count": 'c', "line-number": 'n', "word-regexp": 'w',
+		"line-regexp": 'x', "only-matching": 'o', "quiet": 'q', "silent": 'q', "no-messages": 's',
+		"files-with-matches": 'l', "no-filename": 'h', "with-filename": 'H', "recursive": 'r',
+		"regexp": 'e', "max-count": 'm', "color": 0, "colour": 0, "line-buffered": 0,
+	}, "em")
+	opts, operands, bad := parseOpts(rest, "EFGPivcnwxoqslhHr", "em")
+	if badLong != "" {
+		fs.RecordErrorLn(fmt.Sprintf("%s: %s", name, badLong))
+	}
+	if bad != "" || badLong != "" {
+		fs.RecordErrorLn(fmt.Sprintf("Usage: %s [OPTION]... PATTERNS [FILE]...", name))
+		fs.RecordErrorLn(fmt.Sprintf("Try '%s --help' for more information.", name))
+		fs.exitCode = 2
+		return
+	}
+
+	var pattern string
+	if e, ok := opts['e']; ok {
+		pattern = e
+	} else if len(operands) > 0 {
+		pattern, operands = operands[0], operands[1:]
+	} else {
+		fs.RecordErrorLn(fmt.Sprintf("Usage: %s [OPTION]... PATTERNS [FILE]...", name))
+		fs.RecordErrorLn(fmt.Sprintf("Try '%s --help' for more information.", name))
+		fs.exitCode = 2
+		return
+	}
+
+	_, fixed := opts['F']
+	_, extended := opts['E']
+	_, perl := opts['P']
+	switch {
+	case fixed || name == "fgrep":
+		pattern = regexp.QuoteMeta(pattern)
+	case extended || perl || name == "egrep":
+	default:
+		pattern = convertBRE(pattern)
+	}
+	if _, ok := opts['w']; ok {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+	if _, ok := opts['x']; ok {
+		pattern = `^(?:` + pattern + `)$`
+	}
+	if _, ok := opts['i']; ok {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("%s: Unmatched ( or \\(", name))
+		fs.exitCode = 2
+		return
+	}
+
+	maxCount := -1
+	if m, ok := opts['m']; ok {
+		if maxCount, err = strconv.Atoi(m); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("%s: invalid max count", name))
+			fs.exitCode = 2
+			return
+		}
+	}
+
+	_, invert := opts['v']
+	_, count := opts['c']
+	_, lineNumbers := opts['n']
+	_, onlyMatching := opts['o']
+	_, quiet := opts['q']
+	_, filesOnly := opts['l']
+	_, withName := opts['H']
+	if _, ok := opts['h']; ok {
+		withName = false
+	} else if len(operands) > 1 {
+		withName = true
+	}
+
+	inputs := readInputs(fs, name, operands)
+	failed := len(inputs) < len(operands)
+	matched := false
+	output := []string{}
+	for _, in := range inputs {
+		prefix := ""
+		if withName {
+			prefix = in.name + ":"
+		}
+		n := 0
+		for i, line := range splitLines(in.data) {
+			if maxCount >= 0 && n >= maxCount {
+				break
+			}
+			if re.MatchString(line) == invert {
+				continue
+			}
+			n++
+			if count || quiet || filesOnly {
+				continue
+			}
+			p := prefix
+			if lineNumbers {
+				p += strconv.Itoa(i+1) + ":"
+			}
+			if onlyMatching && !invert {
+				for _, m := range re.FindAllString(line, -1) {
+					output = append(output, p+m)
+				}
+				continue
+			}
+			output = append(output, p+line)
+		}
+		if n > 0 {
+			matched = true
+		}
+		switch {
+		case filesOnly && n > 0:
+			output = append(output, in.name)
+		case count:
+			output = append(output, prefix+strconv.Itoa(n))
+		}
+	}
+
+	if !quiet {
+		writeLines(fs, output)
+	}
+	switch {
+	case failed && !(quiet && matched):
+		fs.exitCode = 2
+	case matched:
+		fs.exitCode = 0
+	default:
+		fs.exitCode = 1
+	}
+	return
+}
+
+// headTailOpts parses the options of head and tail, including the obsolete -N form.
+// It returns the number of lines (or bytes if bytes is true) and the sign the count started with,
+// '+' or '-' for `tail -n +N` and `head -n -N`, 0 if there was none.
+func headTailOpts(fs *FakeShell, args []string) (n int, bytes bool, sign byte, operands []string, ok bool) {
+	name := filepath.Base(args[0])
+	rest := append([]string{}, args[1:]...)
+	if len(rest) > 0 && isObsoleteCount(rest[0]) {
+		rest[0] = "-n" + rest[0][1:] // like GNU, only the first argument can be -N
+	}
+	longs := map[string]byte{"lines": 'n', "bytes": 'c', "quiet": 'q', "silent": 'q', "verbose": 'v', "zero-terminated": 'z'}
+	if name == "tail" {
+		longs["follow"], longs["retry"], longs["sleep-interval"], longs["pid"] = 0, 0, 's', 0
+	}
+	rest, badLong := longOpts(rest, longs, "ncs")
+	if badLong != "" {
+		badLongOption(fs, name, badLong, 1)
+		return 0, false, 0, nil, false
+	}
+	opts, operands, bad := parseOpts(rest, "fqvzF", "ncs")
+	if bad != "" {
+		badOption(fs, name, bad, 1)
+		return 0, false, 0, nil, false
+	}
+
+	n = 10
+	value, hasLines := opts['n']
+	if c, ok := opts['c']; ok {
+		value, bytes = c, true
+	} else if !hasLines {
+		return n, false, 0, operands, true
+	}
+	digits := value
+	if value != "" && (value[0] == '+' || value[0] == '-') {
+		sign, digits = value[0], value[1:]
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || digits[0] < '0' || digits[0] > '9' {
+		what := "lines"
+		if bytes {
+			what = "bytes"
+		}
+		fs.RecordErrorLn(fmt.Sprintf("%s: invalid number of %s: ‘%s’", name, what, value))
+		return 0, false, 0, nil, false
+	}
+	return n, bytes, sign, operands, true
+}
+
+// isObsoleteCount returns whether arg is the obsolete -N form of -n N.
+func isObsoleteCount(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	for _, c := range arg[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func cmdTee(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"append": 'a', "ignore-interrupts": 'i', "output-error": 0,
+	}, "")
+	if badLong != "" {
+		badLongOption(fs, "tee", badLong, 1)
+		return
+	}
+	flags, files, bad := parseOpts(rest, "aip", "")
+	if bad != "" {
+		badOption(fs, "tee", bad, 1)
+		return
+	}
+	_, appendOutput := flags['a']
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendOutput {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	input, _ := fs.Stdin()
+	for _, f := range files {
+		switch f {
+		case "/dev/null", "/dev/zero":
+			continue
+		case "/dev/stdout", "-":
+			fs.RecordWrite(input)
+			continue
+		case "/dev/stderr":
+			fs.RecordStderrLn(strings.TrimSuffix(input, "\n"))
+			continue
+		}
+		path := toAbs(fs, f)
+		if fs.ffs.DirExists(path) {
+			fs.RecordErrorLn(fmt.Sprintf("tee: %s: Is a directory", f))
+			continue
+		}
+		written := fs.watchPersistence(path)
+		file, err := fs.ffs.OpenFile(path, flag, 0644)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("tee: %s: %s", f, errorString(err)))
+			continue
+		}
+		_, err = file.WriteString(input)
+		file.Close()
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("tee: %s: %s", f, errorString(err)))
+			continue
+		}
+		written()
+	}
+	fs.RecordWrite(input)
+	return
+}
+
+func cmdHead(fs *FakeShell, args []string) (exit bool) {
+	n, bytes, sign, operands, ok := headTailOpts(fs, args)
+	if !ok {
+		return
+	}
+	inputs := readInputs(fs, "head", operands)
+	for i, in := range inputs {
+		if len(operands) > 1 {
+			if i > 0 {
+				fs.RecordWrite("\n")
+			}
+			fs.RecordWriteLn(fmt.Sprintf("==> %s <==", in.name))
+		}
+		if bytes {
+			end := n
+			if sign == '-' {
+				end = len(in.data) - n // head -c -N prints all but the last N bytes
+			}
+			if end < 0 {
+				end = 0
+			}
+			if end < len(in.data) {
+				in.data = in.data[:end]
+			}
+			fs.RecordWrite(in.data)
+			continue
+		}
+		lines := splitLines(in.data)
+		end := n
+		if sign == '-' {
+			end = len(lines) - n // head -n -N prints all but the last N lines
+		}
+		if end < 0 {
+			end = 0
+		}
+		if end < len(lines) {
+			lines = lines[:end]
+		}
+		writeLines(fs, lines)
+	}
+	if len(inputs) < len(operands) {
+		fs.exitCode = 1
+	}
+	return
+}
+
+func cmdTail(fs *FakeShell, args []string) (exit bool) {
+	n, bytes, sign, operands, ok := headTailOpts(fs, args)
+	if !ok {
+		return
+	}
+	inputs := readInputs(fs, "tail", operands)
+	for i, in := range inputs {
+		if len(operands) > 1 {
+			if i > 0 {
+				fs.RecordWrite("\n")
+			}
+			fs.RecordWriteLn(fmt.Sprintf("==> %s <==", in.name))
+		}
+		if bytes {
+			start := len(in.data) - n
+			if sign == '+' {
+				start = n - 1
+			}
+			if start < 0 {
+				start = 0
+			}
+			if start < len(in.data) {
+				fs.RecordWrite(in.data[start:])
+			}
+			continue
+		}
+		lines := splitLines(in.data)
+		start := len(lines) - n
+		if sign == '+' {
+			start = n - 1 // tail -n +N starts with line N
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start < len(lines) {
+			writeLines(fs, lines[start:])
+		}
+	}
+	if len(inputs) < len(operands) {
+		fs.exitCode = 1
+	}
+	return
+}
+
+func cmdWc(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"lines": 'l', "words": 'w', "bytes": 'c', "chars": 'm', "max-line-length": 'L',
+	}, "")
+	if badLong != "" {
+		badLongOption(fs, "wc", badLong, 1)
+		return
+	}
+	opts, operands, bad := parseOpts(rest, "lwcmL", "")
+	if bad != "" {
+		badOption(fs, "wc", bad, 1)
+		return
+	}
+	if len(opts) == 0 {
+		opts = map[byte]string{'l': "", 'w': "", 'c': ""}
+	}
+
+	inputs := readInputs(fs, "wc", operands)
+	rows := [][]int{}
+	total := make([]int, 5)
+	for _, in := range inputs {
+		maxLine := 0
+		for _, l := range splitLines(in.data) {
+			if len(l) > maxLine {
+				maxLine = len(l)
+			}
+		}
+		counts := []int{
+			strings.Count(in.data, "\n"),
+			len(strings.Fields(in.data)),
+			len([]rune(in.data)),
+			len(in.data),
+			maxLine,
+		}
+		for i := 0; i < 4; i++ {
+			total[i] += counts[i]
+		}
+		if maxLine > total[4] {
+			total[4] = maxLine
+		}
+		rows = append(rows, counts)
+	}
+	names := []string{}
+	for _, in := range inputs {
+		names = append(names, in.name)
+	}
+	if len(inputs) > 1 {
+		rows = append(rows, total)
+		names = append(names, "total")
+	}
+
+	// the columns are as wide as the largest number, reading from stdin uses a width of 7
+	width := 1
+	if len(operands) == 0 {
+		width = 7
+	}
+	for _, c := range total {
+		if w := len(strconv.Itoa(c)); w > width {
+			width = w
+		}
+	}
+	selected := []int{}
+	for i, o := range []byte("lwmcL") {
+		if _, ok := opts[o]; ok {
+			selected = append(selected, []int{0, 1, 2, 3, 4}[i])
+		}
+	}
+	if len(selected) == 1 && len(rows) == 1 {
+		width = 0 // a single number isn't padded
+	}
+
+	output := []string{}
+	for i, row := range rows {
+		cols := []string{}
+		for _, s := range selected {
+			cols = append(cols, fmt.Sprintf("%*d", width, row[s]))
+		}
+		line := strings.Join(cols, " ")
+		if len(operands) > 0 {
+			line += " " + names[i]
+		}
+		output = append(output, line)
+	}
+	writeLines(fs, output)
+	if len(inputs) < len(operands) {
+		fs.exitCode = 1
+	}
+	return
+}
+
+// sortField returns the key of a line for sort -k, fields are numbered from 1.
+func sortField(line, sep string, field int) string {
+	if field < 1 {
+		return line
+	}
+	var fields []string
+	if sep == "" {
+		fields = strings.Fields(line)
+	} else {
+		fields = strings.Split(line, sep)
+	}
+	if field > len(fields) {
+		return ""
+	}
+	return strings.Join(fields[field-1:], " ")
+}
+
+// leadingNumber parses the number at the beginning of s like sort -n does, anything else counts as 0.
+func leadingNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || (end == 0 && s[end] == '-')) {
+		end++
+	}
+	f, _ := strconv.ParseFloat(s[:end], 64)
+	return f
+}
+
+func cmdSort(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"reverse": 'r', "numeric-sort": 'n', "unique": 'u', "ignore-case": 'f', "ignore-leading-blanks": 'b',
+		"dictionary-order": 'd', "human-numeric-sort": 'h', "version-sort": 'V', "key": 'k',
+		"field-separator": 't', "output": 'o', "stable": 0,
+	}, "kto")
+	if badLong != "" {
+		badLongOption(fs, "sort", badLong, 2)
+		return
+	}
+	opts, operands, bad := parseOpts(rest, "rnufbdhV", "kto")
+	if bad != "" {
+		badOption(fs, "sort", bad, 2)
+		return
+	}
+	field := 0
+	if k, ok := opts['k']; ok {
+		field, _ = strconv.Atoi(strings.SplitN(strings.SplitN(k, ",", 2)[0], ".", 2)[0])
+	}
+	_, numeric := opts['n']
+	if _, ok := opts['h']; ok {
+		numeric = true
+	}
+	_, reverse := opts['r']
+	_, fold := opts['f']
+
+	lines := []string{}
+	for _, in := range readInputs(fs, "sort", operands) {
+		lines = append(lines, splitLines(in.data)...)
+	}
+
+	key := func(line string) string {
+		k := sortField(line, opts['t'], field)
+		if fold {
+			k = strings.ToLower(k)
+		}
+		return k
+	}
+	less := func(a, b string) bool {
+		ka, kb := key(a), key(b)
+		if numeric {
+			na, nb := leadingNumber(ka), leadingNumber(kb)
+			if na != nb {
+				return na < nb
+			}
+		} else if ka != kb {
+			return ka < kb
+		}
+		return a < b // last resort comparison of the whole line
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		if reverse {
+			return less(lines[j], lines[i])
+		}
+		return less(lines[i], lines[j])
+	})
+
+	if _, ok := opts['u']; ok {
+		unique := []string{}
+		for i, l := range lines {
+			if i == 0 || key(l) != key(lines[i-1]) {
+				unique = append(unique, l)
+			}
+		}
+		lines = unique
+	}
+	writeLines(fs, lines)
+	return
+}
+
+func cmdUniq(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"count": 'c', "repeated": 'd', "unique": 'u', "ignore-case": 'i',
+	}, "")
+	if badLong != "" {
+		badLongOption(fs, "uniq", badLong, 1)
+		return
+	}
+	opts, operands, bad := parseOpts(rest, "cdui", "")
+	if bad != "" {
+		badOption(fs, "uniq", bad, 1)
+		return
+	}
+	if len(operands) > 1 {
+		operands = operands[:1] // the second operand would be the output file
+	}
+	_, count := opts['c']
+	_, repeated := opts['d']
+	_, unique := opts['u']
+	_, fold := opts['i']
+
+	lines := []string{}
+	for _, in := range readInputs(fs, "uniq", operands) {
+		lines = append(lines, splitLines(in.data)...)
+	}
+
+	output := []string{}
+	for i := 0; i < len(lines); {
+		j := i + 1
+		for j < len(lines) && (lines[j] == lines[i] || (fold && strings.EqualFold(lines[j], lines[i]))) {
+			j++
+		}
+		n := j - i
+		if (!repeated || n > 1) && (!unique || n == 1) {
+			if count {
+				output = append(output, fmt.Sprintf("%7d %s", n, lines[i]))
+			} else {
+				output = append(output, lines[i])
+			}
+		}
+		i = j
+	}
+	writeLines(fs, output)
+	return
+}
+
+// parseRanges parses a list like 1,3-5,7- as used by cut. The returned function reports if a position (starting at 1) is selected.
+func parseRanges(list string) (func(int) bool, bool) {
+	type rng struct{ from, to int }
+	ranges := []rng{}
+	for _, part := range strings.Split(list, ",") {
+		r := rng{1, -1}
+		bounds := strings.SplitN(part, "-", 2)
+		var err error
+		if bounds[0] != "" {
+			if r.from, err = strconv.Atoi(bounds[0]); err != nil || r.from < 1 {
+				return nil, false
+			}
+		}
+		switch {
+		case len(bounds) == 1:
+			r.to = r.from
+		case bounds[1] != "":
+			if r.to, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, false
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return func(pos int) bool {
+		for _, r := range ranges {
+			if pos >= r.from && (r.to < 0 || pos <= r.to) {
+				return true
+			}
+		}
+		return false
+	}, true
+}
+
+func cmdCut(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"delimiter": 'd', "fields": 'f', "characters": 'c', "bytes": 'b', "only-delimited": 's',
+	}, "dfcb")
+	if badLong != "" {
+		badLongOption(fs, "cut", badLong, 1)
+		return
+	}
+	opts, operands, bad := parseOpts(rest, "sn", "dfcb")
+	if bad != "" {
+		badOption(fs, "cut", bad, 1)
+		return
+	}
+
+	list, byField := opts['f']
+	if !byField {
+		if list = opts['c']; list == "" {
+			list = opts['b']
+		}
+	}
+	if list == "" {
+		fs.RecordErrorLn("cut: you must specify a list of bytes, characters, or fields")
+		fs.RecordErrorLn("Try 'cut --help' for more information.")
+		return
+	}
+	selected, ok := parseRanges(list)
+	if !ok {
+		fs.RecordErrorLn("cut: invalid field value")
+		fs.RecordErrorLn("Try 'cut --help' for more information.")
+		return
+	}
+	delim, hasDelim := opts['d']
+	if !hasDelim {
+		delim = "\t"
+	} else if len(delim) != 1 {
+		fs.RecordErrorLn("cut: the delimiter must be a single character")
+		fs.RecordErrorLn("Try 'cut --help' for more information.")
+		return
+	}
+	_, onlyDelimited := opts['s']
+
+	output := []string{}
+	for _, in := range readInputs(fs, "cut", operands) {
+		for _, line := range splitLines(in.data) {
+			parts := []string{}
+			if byField {
+				if !strings.Contains(line, delim) {
+					if !onlyDelimited {
+						output = append(output, line)
+					}
+					continue
+				}
+				for i, f := range strings.Split(line, delim) {
+					if selected(i + 1) {
+						parts = append(parts, f)
+					}
+				}
+				output = append(output, strings.Join(parts, delim))
+				continue
+			}
+			for i, r := range []rune(line) {
+				if selected(i + 1) {
+					parts = append(parts, string(r))
+				}
+			}
+			output = append(output, strings.Join(parts, ""))
+		}
+	}
+	writeLines(fs, output)
+	return
+}
+
+// expandTrSet expands a tr set like a-z, [:upper:] or \n into its characters.
+func expandTrSet(set string) []rune {
+	classes := map[string]func(rune) bool{
+		"[:lower:]": unicode.IsLower,
+		"[:upper:]": unicode.IsUpper,
+		"[:digit:]": unicode.IsDigit,
+		"[:alpha:]": unicode.IsLetter,
+		"[:alnum:]": func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+		"[:space:]": unicode.IsSpace,
+		"[:blank:]": func(r rune) bool { return r == ' ' || r == '\t' },
+		"[:punct:]": unicode.IsPunct,
+	}
+	escapes := map[byte]rune{'n': '\n', 't': '\t', 'r': '\r', '\\': '\\', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v'}
+
+	chars := []rune{}
+	src := []rune(set)
+	for i := 0; i < len(src); i++ {
+		if src[i] == '[' {
+			matched := false
+			for name, is := range classes {
+				if strings.HasPrefix(string(src[i:]), name) {
+					for r := rune(0); r < 128; r++ {
+						if is(r) {
+							chars = append(chars, r)
+						}
+					}
+					i += len(name) - 1
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+		c := src[i]
+		if c == '\\' && i+1 < len(src) {
+			i++
+			if src[i] >= '0' && src[i] <= '7' {
+				// octal escapes like \0, e.g. for the NUL separated files in /proc
+				c = 0
+				for n := 0; n < 3 && i < len(src) && src[i] >= '0' && src[i] <= '7'; n++ {
+					c = c*8 + src[i] - '0'
+					i++
+				}
+				i--
+			} else if e, ok := escapes[byte(src[i])]; ok {
+				c = e
+			} else {
+				c = src[i]
+			}
+		}
+		if i+2 < len(src) && src[i+1] == '-' && src[i+2] >= c {
+			for r := c; r <= src[i+2]; r++ {
+				chars = append(chars, r)
+			}
+			i += 2
+			continue
+		}
+		chars = append(chars, c)
+	}
+	return chars
+}
+
+func cmdTr(fs *FakeShell, args []string) (exit bool) {
+	rest, badLong := longOpts(args[1:], map[string]byte{
+		"delete": 'd', "squeeze-repeats": 's', "complement": 'c',
+	}, "")
+	if badLong != "" {
+		badLongOption(fs, "tr", badLong, 1)
+		return
+	}
+	opts, operands, bad := parseOpts(rest, "dscC", "")
+	if bad != "" {
+		badOption(fs, "tr", bad, 1)
+		return
+	}
+	_, del := opts['d']
+	_, squeeze := opts['s']
+	if len(operands) == 0 || (!del && !squeeze && len(operands) < 2) {
+		if len(operands) == 0 {
+			fs.RecordErrorLn("tr: missing operand")
+		} else {
+			fs.RecordErrorLn(fmt.Sprintf("tr: missing operand after ‘%s’", operands[0]))
+			fs.RecordErrorLn("Two strings must be given when translating.")
+		}
+		fs.RecordErrorLn("Try 'tr --help' for more information.")
+		return
+	}
+
+	set1 := expandTrSet(operands[0])
+	var set2 []rune
+	if len(operands) > 1 {
+		set2 = expandTrSet(operands[1])
+	}
+	in1 := map[rune]bool{}
+	for _, r := range set1 {
+		in1[r] = true
+	}
+	translate := map[rune]rune{}
+	if !del && len(set2) > 0 {
+		for i, r := range set1 {
+			if i < len(set2) {
+				translate[r] = set2[i]
+			} else {
+				translate[r] = set2[len(set2)-1] // set2 is extended with its last char
+			}
+		}
+	}
+	// squeezing applies to set2 when translating, to set1 otherwise
+	squeezeSet := in1
+	if len(set2) > 0 {
+		squeezeSet = map[rune]bool{}
+		for _, r := range set2 {
+			squeezeSet[r] = true
+		}
+	}
+
+	stdin, _ := fs.Stdin()
+	var sb strings.Builder
+	var last rune = -1
+	for _, r := range stdin {
+		if del && in1[r] {
+			continue
+		}
+		if t, ok := translate[r]; ok {
+			r = t
+		}
+		if squeeze && r == last && squeezeSet[r] {
+			continue
+		}
+		sb.WriteRune(r)
+		last = r
+	}
+	fs.RecordWrite(sb.String())
+	return
+}
+
+var regexAwkProgram = regexp.MustCompile(`^\s*(?:/((?:[^/\\]|\\.)*)/)?\s*(?:\{\s*(.*?)\s*;?\s*\})?\s*$`)
+
+// awkExpr evaluates an item of an awk print statement, e.g. $1, NF, $NF or "text".
+func awkExpr(expr string, fields []string, line string, nr int) (string, bool) {
+	switch {
+	case strings.HasPrefix(expr, `"`) && strings.HasSuffix(expr, `"`) && len(expr) > 1:
+		s, _ := unescapeEcho(expr[1 : len(expr)-1])
+		return s, true
+	case expr == "NF":
+		return strconv.Itoa(len(fields)), true
+	case expr == "NR":
+		return strconv.Itoa(nr), true
+	case strings.HasPrefix(expr, "$"):
+		idx := expr[1:]
+		var n int
+		if idx == "NF" {
+			n = len(fields)
+		} else if i, err := strconv.Atoi(strings.Trim(idx, "()")); err == nil {
+			n = i
+		} else {
+			return "", false
+		}
+		if n == 0 {
+			return line, true
+		}
+		if n < 0 || n > len(fields) {
+			return "", true
+		}
+		return fields[n-1], true
+	}
+	return "", false
+}
+
+// awkPrintItems splits the expression list of a print statement into its comma separated items,
+// each being a list of expressions that are concatenated, e.g. `$1" "$2, NF`.
+func awkPrintItems(list string) [][]string {
+	items := [][]string{{}}
+	for i := 0; i < len(list); {
+		c := list[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == ',':
+			items = append(items, []string{})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(list) && list[end] != '"' {
+				if list[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(list) {
+				end = len(list) - 1
+			}
+			items[len(items)-1] = append(items[len(items)-1], list[i:end+1])
+			i = end + 1
+		default:
+			end := i + 1
+			for end < len(list) && strings.IndexByte(" \t,\"", list[end]) < 0 {
+				end++
+			}
+			items[len(items)-1] = append(items[len(items)-1], list[i:end])
+			i = end
+		}
+	}
+	return items
+}
+
+// cmdAwk implements the part of awk bots actually use: `awk [-F sep] '[/regex/] {print $N, ...}'`.
+func cmdAwk(fs *FakeShell, args []string) (exit bool) {
+	opts, operands, bad := parseOpts(args[1:], "", "Fv")
+	if bad != "" || len(operands) == 0 {
+		fs.RecordErrorLn("usage: awk [-F value] [-v var=value] [--] 'program text' [file ...]")
+		fs.exitCode = 2
+		return
+	}
+	program := operands[0]
+	m := regexAwkProgram.FindStringSubmatch(program)
+	if m == nil || (m[1] == "" && m[2] == "" && strings.TrimSpace(program) != "") {
+		fs.RecordErrorLn(fmt.Sprintf("awk: line 1: syntax error at or near %s", strings.TrimSpace(program)))
+		fs.exitCode = 2
+		return
+	}
+
+	var filter *regexp.Regexp
+	if m[1] != "" {
+		var err error
+		if filter, err = regexp.Compile(m[1]); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("awk: line 1: regular expression compile failed (%s)", m[1]))
+			fs.exitCode = 2
+			return
+		}
+	}
+
+	// the action is either empty (print the line) or a print statement with a list of expressions
+	action := strings.TrimSpace(m[2])
+	items := [][]string{} // items separated by commas, each a list of concatenated expressions
+	if action != "" && action != "print" && action != "print $0" {
+		if !strings.HasPrefix(action, "print ") {
+			fs.RecordErrorLn(fmt.Sprintf("awk: line 1: syntax error at or near %s", strings.Fields(action)[0]))
+			fs.exitCode = 2
+			return
+		}
+		items = awkPrintItems(strings.TrimPrefix(action, "print "))
+	}
+
+	sep := opts['F']
+	if sep == "t" || sep == `\t` {
+		sep = "\t"
+	}
+
+	output := []string{}
+	nr := 0
+	for _, in := range readInputs(fs, "awk", operands[1:]) {
+		for _, line := range splitLines(in.data) {
+			nr++
+			if filter != nil && !filter.MatchString(line) {
+				continue
+			}
+			if len(items) == 0 {
+				output = append(output, line)
+				continue
+			}
+			var fields []string
+			if sep == "" || sep == " " {
+				fields = strings.Fields(line)
+			} else {
+				fields = strings.Split(line, sep)
+			}
+			values := []string{}
+			for _, item := range items {
+				var sb strings.Builder
+				for _, expr := range item {
+					v, ok := awkExpr(expr, fields, line, nr)
+					if !ok {
+						fs.RecordErrorLn(fmt.Sprintf("awk: line 1: syntax error at or near %s", expr))
+						fs.exitCode = 2
+						return
+					}
+					sb.WriteString(v)
+				}
+				values = append(values, sb.String())
+			}
+			output = append(output, strings.Join(values, " "))
+		}
+	}
+	writeLines(fs, output)
+	return
+}
@@ -0,0 +1,1280 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	for name, cmd := range map[string]Command{
+		"su":       cmdSu,
+		"sudo":     cmdSudo,
+		"passwd":   cmdPasswd,
+		"chpasswd": cmdChpasswd,
+		"useradd":  cmdUseradd,
+		"usermod":  cmdUsermod,
+		"id":       cmdID,
+		"whoami":   cmdWhoami,
+		"groups":   cmdGroups,
+	} {
+		CmdLookup[name] = cmd
+	}
+}
+
+// fakeLogin is a shell started by su (or sudo -i/-s), exit returns to the shell it was started from.
+type fakeLogin struct {
+	user   string
+	login  bool       // a login shell, e.g. su -
+	parent shellState // the state of the shell su was run from
+}
+
+// userDB is one of the colon separated user databases of /etc, e.g. /etc/passwd.
+type userDB struct {
+	path    string
+	entries [][]string
+}
+
+// loadUserDB reads a user database of the FFS, a missing file is an empty database.
+func (fs *FakeShell) loadUserDB(path string) *userDB {
+	db := &userDB{path: path}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		return db
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			db.entries = append(db.entries, strings.Split(line, ":"))
+		}
+	}
+	return db
+}
+
+// saveUserDB writes a user database back to the FFS.
+func (fs *FakeShell) saveUserDB(db *userDB, perm uint32) error {
+	lines := make([]string, 0, len(db.entries))
+	for _, e := range db.entries {
+		lines = append(lines, strings.Join(e, ":"))
+	}
+	return fs.ffs.WriteFile(db.path, []byte(strings.Join(lines, "\n")+"\n"), fileMode(perm))
+}
+
+// find returns the entry with the given name, it is padded to the given number of fields.
+func (db *userDB) find(name string, fields int) ([]string, bool) {
+	for i, e := range db.entries {
+		if e[0] == name {
+			for len(e) < fields {
+				e = append(e, "")
+			}
+			db.entries[i] = e
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the entry of the same name or appends it.
+func (db *userDB) set(entry []string) {
+	for i, e := range db.entries {
+		if e[0] == entry[0] {
+			db.entries[i] = entry
+			return
+		}
+	}
+	db.entries = append(db.entries, entry)
+}
+
+// nextID returns the lowest free id of the database that is at least min, e.g. the uid of a new user.
+func (db *userDB) nextID(min int) int {
+	used := map[int]bool{}
+	for _, e := range db.entries {
+		if len(e) > 2 {
+			if id, err := strconv.Atoi(e[2]); err == nil {
+				used[id] = true
+			}
+		}
+	}
+	for used[min] {
+		min++
+	}
+	return min
+}
+
+// User returns the effective user, i.e. the user sudo or su run as or the user that logged in.
+func (fs *FakeShell) User() string {
+	if fs.runAs != "" {
+		return fs.runAs
+	}
+	if n := len(fs.logins); n > 0 {
+		return fs.logins[n-1].user
+	}
+	return (*fs.session).User()
+}
+
+// isRoot returns true if the effective user has the uid 0.
+// Like everywhere else in oSSH a user that logged in but is unknown to /etc/passwd is root.
+func (fs *FakeShell) isRoot() bool {
+	uid, _, ok := fs.lookupUser(fs.User())
+	return !ok || uid == 0
+}
+
+// startLogin starts a shell of the user like su does, login shells start in the home directory of the user.
+func (fs *FakeShell) startLogin(user string, login bool) {
+	fs.logins = append(fs.logins, fakeLogin{user: user, login: login, parent: fs.saveState()})
+	home, shell := "/", "/bin/sh"
+	if e, ok := fs.loadUserDB("/etc/passwd").find(user, 7); ok {
+		home, shell = e[5], e[6]
+	}
+	level, _ := strconv.Atoi(fs.env.Value("SHLVL"))
+	if login {
+		level = 0
+		if fs.ffs.DirExists(home) {
+			fs.cwd = home
+		} else {
+			fs.RecordStderrLn(fmt.Sprintf("su: warning: cannot change directory to %s: No such file or directory", home))
+		}
+	}
+	for name, value := range map[string]string{
+		"USER":    user,
+		"LOGNAME": user,
+		"HOME":    home,
+		"SHELL":   shell,
+		"PWD":     fs.cwd,
+		"SHLVL":   strconv.Itoa(level + 1),
+	} {
+		fs.env.Set(name, value)
+		fs.env.Export(name)
+	}
+	fs.name = filepath.Base(shell)
+	if login {
+		fs.name = "-" + fs.name
+	}
+	fs.refreshPrompt()
+}
+
+// exitLogin ends the shell started by su and returns to the shell su was run from.
+func (fs *FakeShell) exitLogin(args []string) {
+	l := fs.logins[len(fs.logins)-1]
+	if l.login {
+		fs.RecordWriteLn("logout")
+	}
+	fs.restoreState(l.parent)
+	fs.exitCode = 0
+	if len(args) > 1 {
+		fs.exitCode, _ = strconv.Atoi(args[1])
+	}
+}
+
+// readPassword reads a password without echoing it, from the terminal or, if fromStdin is set, from stdin.
+// The second return value is false if there is nothing to read the password from.
+func (fs *FakeShell) readPassword(prompt string, fromStdin bool) (string, bool) {
+	if stdin, ok := fs.Stdin(); fromStdin && ok {
+		if prompt != "" {
+			fs.RecordWrite(prompt)
+		}
+		if stdin == "" {
+			return "", false
+		}
+		line, rest, _ := strings.Cut(stdin, "\n")
+		fs.streams.stdin = &rest
+		return strings.TrimSuffix(line, "\r"), true
+	}
+	if _, _, ok := (*fs.session).Pty(); !ok {
+		return "", false
+	}
+	fs.stats.recording.AddOutputEvent(prompt)
+	password, err := fs.terminal.ReadPassword(prompt)
+	return password, err == nil
+}
+
+// capturePassword adds a password the bot entered or set to the loot and records it as event.
+func (fs *FakeShell) capturePassword(source, user, password string) {
+	if password == "" {
+		return
+	}
+	fs.RecordEvent("password", fmt.Sprintf("%s %s:%s", source, user, password), "")
+	if !fs.osshSession.Whitelisted {
+		SrvOSSH.Loot.AddPassword(password)
+	}
+}
+
+// shadowHash returns something that looks like the SHA-512 crypt hash of the password.
+func shadowHash(password string) string {
+	alphabet := "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	enc := base64.NewEncoding(alphabet).WithPadding(base64.NoPadding)
+	salt := make([]byte, 12)
+	_, _ = rand.Read(salt)
+	s := enc.EncodeToString(salt)
+	sum := sha512.Sum512([]byte(s + password))
+	return "$6$" + s + "$" + enc.EncodeToString(sum[:])
+}
+
+// shadowDays returns the current date as used by /etc/shadow, i.e. the days since the epoch.
+func shadowDays() string {
+	return strconv.FormatInt(time.Now().Unix()/86400, 10)
+}
+
+// setPassword stores the hash of a password in /etc/shadow, encrypted passwords are stored as they are.
+func (fs *FakeShell) setPassword(user, password string, encrypted bool) error {
+	if !encrypted {
+		password = shadowHash(password)
+	}
+	return fs.updateShadow(user, func(e []string) {
+		e[1], e[2] = password, shadowDays()
+	})
+}
+
+// updateShadow changes the /etc/shadow entry of the user, a missing entry is created.
+func (fs *FakeShell) updateShadow(user string, fn func(e []string)) error {
+	db := fs.loadUserDB("/etc/shadow")
+	e, ok := db.find(user, 9)
+	if !ok {
+		e = []string{user, "!", shadowDays(), "0", "99999", "7", "", "", ""}
+	}
+	fn(e)
+	db.set(e)
+	return fs.saveUserDB(db, 0640)
+}
+
+func sudoUsage(fs *FakeShell) {
+	fs.RecordErrorLn("usage: sudo -h | -K | -k | -V")
+	fs.RecordErrorLn("usage: sudo -v [-ABkNnS] [-g group] [-h host] [-p prompt] [-u user]")
+	fs.RecordErrorLn("usage: sudo -l [-ABkNnS] [-g group] [-h host] [-p prompt] [-U user] [-u user] [command [arg ...]]")
+	fs.RecordErrorLn("usage: sudo [-ABbEHkNnPS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] [VAR=value] [-i | -s] [command [arg ...]]")
+	fs.RecordErrorLn("usage: sudo -e [-ABkNnS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] file ...")
+}
+
+// sudoBuiltins are the shell builtins that sudo can't run because they only exist in the shell.
+var sudoBuiltins = map[string]bool{
+	"cd":     true,
+	"exit":   true,
+	"logout": true,
+	"export": true,
+	"source": true,
+	".":      true,
+	"alias":  true,
+	"unset":  true,
+	"set":    true,
+}
+
+// cmdSudo asks for the password of the user (once per session like sudo's timestamp) and runs the command
+// as root or the user given with -u. -i and -s start a shell instead, exit returns to the previous user.
+func cmdSudo(fs *FakeShell, args []string) (exit bool) {
+	target, prompt, action := "root", "[sudo] password for %p: ", byte(0)
+	shell, login, fromStdin, nonInteractive := false, false, false, false
+	longs := map[string]byte{
+		"login": 'i', "shell": 's', "stdin": 'S', "non-interactive": 'n', "list": 'l', "validate": 'v',
+		"reset-timestamp": 'k', "remove-timestamp": 'K', "help": 'h', "version": 'V', "user": 'u', "prompt": 'p',
+		"group": 'g', "preserve-env": 'E', "set-home": 'H', "background": 'b', "askpass": 'A', "bell": 'B',
+		"chdir": 'D', "role": 'r', "type": 't', "close-from": 'C', "command-timeout": 'T', "other-user": 'U',
+	}
+	withValue := "ugpCDrtTUR"
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		opts := []byte{}
+		values := []string{}
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			c, ok := longs[name]
+			if !ok {
+				fs.RecordErrorLn(fmt.Sprintf("sudo: unrecognized option '%s'", arg))
+				sudoUsage(fs)
+				return
+			}
+			if strings.IndexByte(withValue, c) >= 0 && !hasValue {
+				if i+1 >= len(args) {
+					fs.RecordErrorLn(fmt.Sprintf("sudo: option '%s' requires an argument", arg))
+					sudoUsage(fs)
+					return
+				}
+				i++
+				value = args[i]
+			}
+			opts, values = append(opts, c), append(values, value)
+		} else {
+			for j := 1; j < len(arg); j++ {
+				c, value := arg[j], ""
+				if strings.IndexByte(withValue, c) >= 0 || (c == 'h' && j+1 < len(arg)) {
+					value = arg[j+1:]
+					if value == "" {
+						if i+1 >= len(args) {
+							fs.RecordErrorLn(fmt.Sprintf("sudo: option requires an argument -- '%c'", c))
+							sudoUsage(fs)
+							return
+						}
+						i++
+						value = args[i]
+					}
+					opts, values = append(opts, c), append(values, value)
+					break
+				}
+				opts, values = append(opts, c), append(values, "")
+			}
+		}
+		for k, c := range opts {
+			switch c {
+			case 'u':
+				target = values[k]
+			case 'p':
+				prompt = values[k]
+			case 'i':
+				login = true
+			case 's':
+				shell = true
+			case 'S':
+				fromStdin = true
+			case 'n':
+				nonInteractive = true
+			case 'l', 'v', 'k', 'K':
+				action = c
+			case 'h':
+				if values[k] == "" {
+					fs.RecordWriteLn("sudo - execute a command as another user")
+					fs.RecordWriteLn("")
+					sudoUsage(fs)
+					fs.exitCode = 0
+					return
+				}
+			case 'V':
+				fs.RecordWriteLn("Sudo version 1.9.9")
+				fs.RecordWriteLn("Sudoers policy plugin version 1.9.9")
+				fs.RecordWriteLn("Sudoers file grammar version 48")
+				fs.RecordWriteLn("Sudoers I/O plugin version 1.9.9")
+				fs.RecordWriteLn("Sudoers audit plugin version 1.9.9")
+				return
+			case 'g', 'C', 'D', 'r', 't', 'T', 'U', 'R', 'E', 'H', 'P', 'b', 'A', 'B', 'N':
+				// nothing we have to care about
+			default:
+				fs.RecordErrorLn(fmt.Sprintf("sudo: invalid option -- '%c'", c))
+				sudoUsage(fs)
+				return
+			}
+		}
+	}
+	command := args[i:]
+	user := fs.User()
+
+	if action == 'k' || action == 'K' {
+		delete(fs.sudoers, user)
+		if len(command) == 0 || action == 'K' {
+			return
+		}
+	}
+	if len(command) == 0 && !shell && !login && action == 0 {
+		sudoUsage(fs)
+		return
+	}
+	if _, ok := fs.loadUserDB("/etc/passwd").find(target, 7); !ok {
+		fs.RecordErrorLn(fmt.Sprintf("sudo: unknown user %s", target))
+		return
+	}
+
+	if user != "root" && !fs.sudoers[user] {
+		if nonInteractive {
+			fs.RecordErrorLn("sudo: a password is required")
+			return
+		}
+		prompt = strings.NewReplacer("%p", user, "%u", user, "%U", target, "%h", fs.ffs.HostName(), "%H", fs.ffs.HostName(), "%%", "%").Replace(prompt)
+		authenticated := false
+		for attempt := 0; attempt < 3 && !authenticated; attempt++ {
+			password, ok := fs.readPassword(prompt, fromStdin)
+			if !ok {
+				if _, piped := fs.Stdin(); fromStdin && piped {
+					fs.RecordErrorLn("sudo: no password was provided")
+				} else {
+					fs.RecordErrorLn("sudo: a terminal is required to read the password; either use the -S option to read from standard input or configure an askpass helper")
+				}
+				fs.RecordErrorLn("sudo: a password is required")
+				return
+			}
+			fs.capturePassword("sudo", user, password)
+			if password != "" {
+				authenticated = true
+			} else {
+				fs.RecordStderrLn("Sorry, try again.")
+			}
+		}
+		if !authenticated {
+			fs.RecordErrorLn("sudo: 3 incorrect password attempts")
+			return
+		}
+		if !fs.maySudo(user) {
+			if action == 'l' {
+				fs.RecordErrorLn(fmt.Sprintf("Sorry, user %s may not run sudo on %s.", user, fs.ffs.HostName()))
+				return
+			}
+			fs.RecordErrorLn(fmt.Sprintf("%s is not in the sudoers file.", user))
+			return
+		}
+		if fs.sudoers == nil {
+			fs.sudoers = map[string]bool{}
+		}
+		fs.sudoers[user] = true
+	}
+
+	switch {
+	case action == 'v':
+		return
+	case action == 'l':
+		host := fs.ffs.HostName()
+		fs.RecordWriteLn(fmt.Sprintf("Matching Defaults entries for %s on %s:", user, host))
+		fs.RecordWriteLn("    env_reset, mail_badpass, secure_path=/usr/local/sbin\\:/usr/local/bin\\:/usr/sbin\\:/usr/bin\\:/sbin\\:/bin\\:/snap/bin, use_pty")
+		fs.RecordWriteLn("")
+		fs.RecordWriteLn(fmt.Sprintf("User %s may run the following commands on %s:", user, host))
+		fs.RecordWriteLn("    (ALL : ALL) ALL")
+		return
+	case len(command) == 0:
+		fs.startLogin(target, login)
+		return
+	case sudoBuiltins[command[0]]:
+		fs.RecordErrorLn(fmt.Sprintf("sudo: %s: command not found", command[0]))
+		return
+	}
+
+	runAs := fs.runAs
+	fs.runAs = target
+	defer func() {
+		fs.runAs = runAs
+	}()
+	return fs.execCommand(command)
+}
+
+// sudoGroups are the groups whose members may use sudo on the usual distributions.
+var sudoGroups = []string{"sudo", "wheel", "admin"}
+
+// maySudo returns whether a user may use sudo: root, the members of the sudoGroups and the users
+// /etc/sudoers or a file of /etc/sudoers.d names directly or by one of their groups.
+func (fs *FakeShell) maySudo(user string) bool {
+	if fs.isRoot() {
+		return true
+	}
+	id, ok := fs.identity(user)
+	if !ok {
+		return false
+	}
+	groups := map[string]bool{}
+	for _, gid := range id.groups {
+		groups[fs.groupName(id, gid)] = true
+	}
+	for _, g := range sudoGroups {
+		if groups[g] {
+			return true
+		}
+	}
+
+	files := []string{"/etc/sudoers"}
+	if entries, err := fs.ffs.ReadDir("/etc/sudoers.d"); err == nil {
+		for _, e := range entries {
+			files = append(files, "/etc/sudoers.d/"+e.Name())
+		}
+	}
+	for _, file := range files {
+		data, err := fs.ffs.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if fields[0] == user || strings.HasPrefix(fields[0], "%") && groups[fields[0][1:]] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cmdSu asks for the password of the target user unless root runs it and starts a shell of that user.
+// With -c it only runs the command as that user.
+func cmdSu(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{"command": true, "shell": true, "group": true, "supp-group": true, "whitelist-environment": true})
+	login, command, hasCommand := false, "", false
+	for name, value := range long {
+		switch name {
+		case "login":
+			login = true
+		case "command":
+			command, hasCommand = value, true
+		case "help":
+			fs.RecordWriteLn("")
+			fs.RecordWriteLn("Usage:")
+			fs.RecordWriteLn(" su [options] [-] [<user> [<argument>...]]")
+			fs.RecordWriteLn("")
+			fs.RecordWriteLn("Change the effective user ID and group ID to that of <user>.")
+			fs.RecordWriteLn("A mere - implies -l.  If <user> is not given, root is assumed.")
+			return
+		case "version":
+			fs.RecordWriteLn("su from util-linux 2.37.2")
+			return
+		case "shell", "group", "supp-group", "whitelist-environment", "preserve-environment", "pty", "fast":
+		default:
+			fs.RecordErrorLn(fmt.Sprintf("su: unrecognized option '--%s'", name))
+			fs.RecordErrorLn("Try 'su --help' for more information.")
+			return
+		}
+	}
+	operands := []string{}
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		switch {
+		case arg == "--":
+			operands = append(operands, rest[i+1:]...)
+			i = len(rest)
+		case arg == "-":
+			login = true
+		case strings.HasPrefix(arg, "-"):
+			for j := 1; j < len(arg); j++ {
+				c := arg[j]
+				if strings.IndexByte("csgGw", c) >= 0 {
+					value := arg[j+1:]
+					if value == "" {
+						if i+1 >= len(rest) {
+							fs.RecordErrorLn(fmt.Sprintf("su: option requires an argument -- '%c'", c))
+							fs.RecordErrorLn("Try 'su --help' for more information.")
+							return
+						}
+						i++
+						value = rest[i]
+					}
+					if c == 'c' {
+						command, hasCommand = value, true
+					}
+					break
+				}
+				switch c {
+				case 'l':
+					login = true
+				case 'm', 'p', 'f', 'P':
+				default:
+					badOption(fs, "su", string(c), 1)
+					return
+				}
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+
+	target := "root"
+	if len(operands) > 0 {
+		target = operands[0]
+	}
+	e, ok := fs.loadUserDB("/etc/passwd").find(target, 7)
+	if !ok {
+		fs.RecordErrorLn(fmt.Sprintf("su: user %s does not exist or the user entry does not contain all the required fields", target))
+		return
+	}
+
+	if fs.User() != "root" {
+		_, piped := fs.Stdin()
+		password, ok := fs.readPassword("Password: ", piped)
+		if !ok {
+			fs.RecordErrorLn("su: must be run from a terminal")
+			return
+		}
+		fs.capturePassword("su", target, password)
+		if password == "" {
+			time.Sleep(2 * time.Second)
+			fs.RecordErrorLn("su: Authentication failure")
+			return
+		}
+	}
+
+	if hasCommand {
+		runAs := fs.runAs
+		fs.runAs = target
+		defer func() {
+			fs.runAs = runAs
+		}()
+		return fs.runScript(filepath.Base(e[6]), command, nil, true)
+	}
+	fs.startLogin(target, login)
+	return
+}
+
+// cmdPasswd changes the password of the effective user or, for root, of any user.
+// The new password is read twice, from the terminal or from stdin if something is piped into passwd.
+func cmdPasswd(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], nil)
+	opts, operands, bad := parseOpts(rest, "adeluSkqh", "inwxr")
+	if bad != "" {
+		badOption(fs, "passwd", bad, 2)
+		return
+	}
+	for name := range long {
+		switch name {
+		case "delete", "lock", "unlock", "status", "expire":
+			opts[map[string]byte{"delete": 'd', "lock": 'l', "unlock": 'u', "status": 'S', "expire": 'e'}[name]] = ""
+		case "stdin":
+			opts['0'] = ""
+		case "help":
+			opts['h'] = ""
+		default:
+			fs.RecordErrorLn(fmt.Sprintf("passwd: unrecognized option '--%s'", name))
+			fs.exitCode = 2
+			return
+		}
+	}
+	if _, ok := opts['h']; ok {
+		fs.RecordWriteLn("Usage: passwd [options] [LOGIN]")
+		return
+	}
+
+	self := fs.User()
+	user := self
+	if len(operands) > 0 {
+		user = operands[0]
+	}
+	if user != self && !fs.isRoot() {
+		fs.RecordErrorLn(fmt.Sprintf("passwd: You may not view or modify password information for %s.", user))
+		return
+	}
+	if _, ok := fs.loadUserDB("/etc/passwd").find(user, 7); !ok {
+		fs.RecordErrorLn(fmt.Sprintf("passwd: user '%s' does not exist", user))
+		return
+	}
+
+	if _, ok := opts['S']; ok {
+		status, changed := "NP", "01/01/1970"
+		if e, ok := fs.loadUserDB("/etc/shadow").find(user, 9); ok {
+			switch {
+			case strings.HasPrefix(e[1], "!") || e[1] == "*":
+				status = "L"
+			case e[1] != "":
+				status = "P"
+			}
+			if days, err := strconv.ParseInt(e[2], 10, 64); err == nil {
+				changed = time.Unix(days*86400, 0).UTC().Format("01/02/2006")
+			}
+		}
+		fs.RecordWriteLn(fmt.Sprintf("%s %s %s 0 99999 7 -1", user, status, changed))
+		return
+	}
+	for c, change := range map[byte]func(e []string){
+		'd': func(e []string) { e[1] = "" },
+		'l': func(e []string) { e[1] = "!" + strings.TrimPrefix(e[1], "!") },
+		'u': func(e []string) { e[1] = strings.TrimPrefix(e[1], "!") },
+		'e': func(e []string) { e[2] = "0" },
+	} {
+		if _, ok := opts[c]; ok {
+			if err := fs.updateShadow(user, change); err != nil {
+				fs.RecordErrorLn("passwd: Authentication token manipulation error")
+				return
+			}
+			fs.RecordWriteLn("passwd: password expiry information changed.")
+			return
+		}
+	}
+
+	unchanged := func(reason string) {
+		if reason != "" {
+			fs.RecordStderrLn(reason)
+		}
+		fs.RecordErrorLn("passwd: Authentication token manipulation error")
+		fs.RecordErrorLn("passwd: password unchanged")
+		fs.exitCode = 10
+	}
+	_, piped := fs.Stdin()
+	if _, ok := opts['0']; ok {
+		// passwd --stdin of RHEL reads the password once
+		fs.RecordWriteLn(fmt.Sprintf("Changing password for user %s.", user))
+		password, _ := fs.readPassword("", true)
+		if password == "" {
+			unchanged("")
+			return
+		}
+		fs.capturePassword("passwd", user, password)
+		if fs.setPassword(user, password, false) != nil {
+			unchanged("")
+			return
+		}
+		fs.RecordWriteLn("passwd: all authentication tokens updated successfully.")
+		return
+	}
+
+	if !fs.isRoot() {
+		fs.RecordWriteLn(fmt.Sprintf("Changing password for %s.", user))
+		current, ok := fs.readPassword("Current password: ", piped)
+		if !ok {
+			unchanged("")
+			return
+		}
+		fs.capturePassword("passwd", user, current)
+	}
+	password, ok := fs.readPassword("New password: ", piped)
+	if !ok {
+		unchanged("")
+		return
+	}
+	if password == "" {
+		unchanged("No password has been supplied.")
+		return
+	}
+	retyped, ok := fs.readPassword("Retype new password: ", piped)
+	if !ok {
+		unchanged("")
+		return
+	}
+	if retyped != password {
+		unchanged("Sorry, passwords do not match.")
+		return
+	}
+	fs.capturePassword("passwd", user, password)
+	if fs.setPassword(user, password, false) != nil {
+		unchanged("")
+		return
+	}
+	fs.RecordWriteLn("passwd: password updated successfully")
+	return
+}
+
+// cmdChpasswd sets the passwords of the user:password lines piped into it.
+func cmdChpasswd(fs *FakeShell, args []string) (exit bool) {
+	long, rest := splitLongOpts(args[1:], map[string]bool{"crypt-method": true, "sha-rounds": true, "root": true})
+	opts, _, bad := parseOpts(rest, "emh", "csR")
+	if bad != "" {
+		badOption(fs, "chpasswd", bad, 2)
+		return
+	}
+	_, encrypted := opts['e']
+	if _, ok := long["encrypted"]; ok {
+		encrypted = true
+	}
+	if !fs.isRoot() {
+		fs.RecordErrorLn("chpasswd: Permission denied.")
+		return
+	}
+	stdin, _ := fs.Stdin() // without a pipe chpasswd would wait for the terminal to close
+	users := fs.loadUserDB("/etc/passwd")
+	for i, line := range strings.Split(stdin, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("chpasswd: line %d: missing new password", i+1))
+			continue
+		}
+		if _, ok := users.find(user, 7); !ok {
+			fs.RecordErrorLn(fmt.Sprintf("chpasswd: (user %s) pam_chauthtok() failed, error:", user))
+			fs.RecordErrorLn("Authentication token manipulation error")
+			fs.RecordErrorLn(fmt.Sprintf("chpasswd: (line %d, user %s) password not changed", i+1, user))
+			continue
+		}
+		fs.capturePassword("chpasswd", user, password)
+		if err := fs.setPassword(user, password, encrypted); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("chpasswd: (line %d, user %s) password not changed", i+1, user))
+		}
+	}
+	return
+}
+
+// userGroups returns the names of the groups the user is a supplementary member of.
+func userGroups(groups *userDB, user string) []string {
+	names := []string{}
+	for _, e := range groups.entries {
+		if len(e) < 4 {
+			continue
+		}
+		for _, m := range strings.Split(e[3], ",") {
+			if m == user {
+				names = append(names, e[0])
+			}
+		}
+	}
+	return names
+}
+
+// userIdentity is the uid and the groups of a user as id prints them.
+type userIdentity struct {
+	user   string
+	uid    int
+	gid    int
+	groups []int // the login group followed by the supplementary groups
+}
+
+// identity returns the ids of a user of /etc/passwd and /etc/group. Like everywhere else in oSSH
+// the effective user is root if it is unknown to /etc/passwd, other unknown users don't exist.
+func (fs *FakeShell) identity(user string) (userIdentity, bool) {
+	id := userIdentity{user: user}
+	entry, ok := fs.loadUserDB("/etc/passwd").find(user, 7)
+	if ok {
+		id.uid, _ = strconv.Atoi(entry[2])
+		id.gid, _ = strconv.Atoi(entry[3])
+	} else if user != fs.User() {
+		return id, false
+	}
+	id.groups = []int{id.gid}
+	for _, e := range fs.loadUserDB("/etc/group").entries {
+		if len(e) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(e[2])
+		if err != nil || indexOf(strings.Split(e[3], ","), user) < 0 {
+			continue
+		}
+		if !containsInt(id.groups, gid) {
+			id.groups = append(id.groups, gid)
+		}
+	}
+	return id, true
+}
+
+// containsInt returns whether the list contains n.
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// groupName returns the name of a group, the user name for groups of unknown users and the gid otherwise.
+func (fs *FakeShell) groupName(id userIdentity, gid int) string {
+	if name, ok := fs.lookupIDs("/etc/group")[uint32(gid)]; ok {
+		return name
+	}
+	if gid == id.gid {
+		if _, known := fs.loadUserDB("/etc/passwd").find(id.user, 7); !known {
+			return id.user
+		}
+	}
+	return strconv.Itoa(gid)
+}
+
+func cmdID(fs *FakeShell, args []string) (exit bool) {
+	flags, operands, bad := parseOpts(args[1:], "ugGnrza", "")
+	if bad != "" {
+		badOption(fs, "id", bad, 1)
+		return
+	}
+	if len(operands) > 1 {
+		usageError(fs, "id", fmt.Sprintf("extra operand '%s'", operands[1]))
+		return
+	}
+	user := fs.User()
+	if len(operands) == 1 {
+		user = operands[0]
+	}
+	id, ok := fs.identity(user)
+	if !ok {
+		fs.RecordErrorLn(fmt.Sprintf("id: '%s': no such user", user))
+		return
+	}
+
+	_, names := flags['n']
+	userName := user
+	if name, ok := fs.lookupIDs("/etc/passwd")[uint32(id.uid)]; ok {
+		userName = name
+	}
+	show := func(id int, name string) string {
+		if names {
+			return name
+		}
+		return strconv.Itoa(id)
+	}
+	_, onlyUser := flags['u']
+	_, onlyGroup := flags['g']
+	_, allGroups := flags['G']
+	switch {
+	case onlyUser:
+		fs.RecordWriteLn(show(id.uid, userName))
+	case onlyGroup:
+		fs.RecordWriteLn(show(id.gid, fs.groupName(id, id.gid)))
+	case allGroups:
+		list := []string{}
+		for _, gid := range id.groups {
+			list = append(list, show(gid, fs.groupName(id, gid)))
+		}
+		fs.RecordWriteLn(strings.Join(list, " "))
+	case names:
+		usageError(fs, "id", "cannot print only names or real IDs in default format")
+	default:
+		list := []string{}
+		for _, gid := range id.groups {
+			list = append(list, fmt.Sprintf("%d(%s)", gid, fs.groupName(id, gid)))
+		}
+		fs.RecordWriteLn(fmt.Sprintf("uid=%d(%s) gid=%d(%s) groups=%s", id.uid, userName, id.gid, fs.groupName(id, id.gid), strings.Join(list, ",")))
+	}
+	return
+}
+
+func cmdWhoami(fs *FakeShell, args []string) (exit bool) {
+	if len(args) > 1 {
+		if strings.HasPrefix(args[1], "-") && args[1] != "-" {
+			badOption(fs, "whoami", strings.TrimLeft(args[1], "-")[:1], 1)
+			return
+		}
+		usageError(fs, "whoami", fmt.Sprintf("extra operand '%s'", args[1]))
+		return
+	}
+	fs.RecordWriteLn(fs.User())
+	return
+}
+
+func cmdGroups(fs *FakeShell, args []string) (exit bool) {
+	users := args[1:]
+	if len(users) == 0 {
+		users = []string{""}
+	}
+	for _, user := range users {
+		name := user
+		if name == "" {
+			name = fs.User()
+		}
+		id, ok := fs.identity(name)
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("groups: '%s': no such user", user))
+			continue
+		}
+		list := []string{}
+		for _, gid := range id.groups {
+			list = append(list, fs.groupName(id, gid))
+		}
+		if user == "" {
+			fs.RecordWriteLn(strings.Join(list, " "))
+		} else {
+			fs.RecordWriteLn(user + " : " + strings.Join(list, " "))
+		}
+	}
+	return
+}
+
+// setUserGroups makes the user a supplementary member of exactly the given groups.
+func setUserGroups(groups *userDB, user string, names []string) {
+	wanted := map[string]bool{}
+	for _, n := range names {
+		wanted[n] = true
+	}
+	for i, e := range groups.entries {
+		for len(e) < 4 {
+			e = append(e, "")
+		}
+		members := []string{}
+		for _, m := range strings.Split(e[3], ",") {
+			if m != "" && m != user {
+				members = append(members, m)
+			}
+		}
+		if wanted[e[0]] {
+			members = append(members, user)
+		}
+		e[3] = strings.Join(members, ",")
+		groups.entries[i] = e
+	}
+}
+
+// resolveGroup returns the gid of a group given by name or id.
+func resolveGroup(groups *userDB, group string) (string, bool) {
+	for _, e := range groups.entries {
+		if len(e) > 2 && (e[0] == group || e[2] == group) {
+			return e[2], true
+		}
+	}
+	return "", false
+}
+
+// userOptions are the options useradd and usermod have in common, long names are mapped to short ones.
+var userOptions = map[string]byte{
+	"comment": 'c', "home-dir": 'd', "home": 'd', "expiredate": 'e', "inactive": 'f', "gid": 'g', "groups": 'G',
+	"shell": 's', "uid": 'u', "password": 'p', "login": 'l', "append": 'a', "move-home": 'm', "create-home": 'm',
+	"no-create-home": 'M', "non-unique": 'o', "system": 'r', "user-group": 'U', "no-user-group": 'N',
+	"lock": 'L', "unlock": 'U', "base-dir": 'b', "skel": 'k', "key": 'K', "help": 'h', "defaults": 'D',
+}
+
+// parseUserOptions parses the options of useradd and usermod, it prints the error itself.
+func parseUserOptions(fs *FakeShell, name string, args []string, known, withValue string) (opts map[byte]string, operands []string, ok bool) {
+	longValues := map[string]bool{}
+	for l, c := range userOptions {
+		if strings.IndexByte(withValue, c) >= 0 {
+			longValues[l] = true
+		}
+	}
+	long, rest := splitLongOpts(args, longValues)
+	opts, operands, bad := parseOpts(rest, known, withValue)
+	if bad != "" {
+		if strings.IndexByte(withValue, bad[0]) >= 0 {
+			fs.RecordErrorLn(fmt.Sprintf("%s: option requires an argument -- '%s'", name, bad))
+		} else {
+			fs.RecordErrorLn(fmt.Sprintf("%s: invalid option -- '%s'", name, bad))
+		}
+		fs.RecordErrorLn(fmt.Sprintf("Usage: %s [options] LOGIN", name))
+		fs.exitCode = 2
+		return nil, nil, false
+	}
+	for l, value := range long {
+		c, known := userOptions[l]
+		if !known {
+			fs.RecordErrorLn(fmt.Sprintf("%s: unrecognized option '--%s'", name, l))
+			fs.RecordErrorLn(fmt.Sprintf("Usage: %s [options] LOGIN", name))
+			fs.exitCode = 2
+			return nil, nil, false
+		}
+		opts[c] = value
+	}
+	if _, help := opts['h']; help {
+		fs.RecordWriteLn(fmt.Sprintf("Usage: %s [options] LOGIN", name))
+		return nil, nil, false
+	}
+	if len(operands) != 1 {
+		fs.RecordErrorLn(fmt.Sprintf("Usage: %s [options] LOGIN", name))
+		fs.exitCode = 2
+		return nil, nil, false
+	}
+	if !fs.isRoot() {
+		fs.RecordErrorLn(fmt.Sprintf("%s: Permission denied.", name))
+		fs.RecordErrorLn(fmt.Sprintf("%s: cannot lock /etc/passwd; try again later.", name))
+		return nil, nil, false
+	}
+	return opts, operands, true
+}
+
+// cmdUseradd adds a user to /etc/passwd, /etc/shadow and /etc/group like useradd of Debian.
+func cmdUseradd(fs *FakeShell, args []string) (exit bool) {
+	opts, operands, ok := parseUserOptions(fs, "useradd", args[1:], "DlmMNorUh", "bcdefgGkKpsuZ")
+	if !ok {
+		return
+	}
+	user := operands[0]
+	users, groups := fs.loadUserDB("/etc/passwd"), fs.loadUserDB("/etc/group")
+	if _, exists := users.find(user, 7); exists {
+		fs.RecordErrorLn(fmt.Sprintf("useradd: user '%s' already exists", user))
+		fs.exitCode = 9
+		return
+	}
+
+	_, system := opts['r']
+	uid := users.nextID(1000)
+	if system {
+		uid = users.nextID(100)
+	}
+	if v, ok := opts['u']; ok {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			fs.RecordErrorLn(fmt.Sprintf("useradd: invalid user ID '%s'", v))
+			fs.exitCode = 3
+			return
+		}
+		if _, dup := opts['o']; !dup && users.nextID(id) != id {
+			fs.RecordErrorLn(fmt.Sprintf("useradd: UID %d is not unique", id))
+			fs.exitCode = 4
+			return
+		}
+		uid = id
+	}
+
+	var gid string
+	if g, ok := opts['g']; ok {
+		if gid, ok = resolveGroup(groups, g); !ok {
+			fs.RecordErrorLn(fmt.Sprintf("useradd: group '%s' does not exist", g))
+			fs.exitCode = 6
+			return
+		}
+	} else if _, noGroup := opts['N']; noGroup {
+		gid = "100"
+	} else {
+		if _, exists := groups.find(user, 4); exists {
+			fs.RecordErrorLn(fmt.Sprintf("useradd: group %s exists - if you want to add this user to that group, use -g.", user))
+			fs.exitCode = 9
+			return
+		}
+		id := uid
+		if groups.nextID(id) != id {
+			id = groups.nextID(1000)
+		}
+		gid = strconv.Itoa(id)
+		groups.set([]string{user, "x", gid, ""})
+	}
+	var supplementary []string
+	if g, ok := opts['G']; ok {
+		for _, name := range strings.Split(g, ",") {
+			if _, ok := resolveGroup(groups, name); !ok {
+				fs.RecordErrorLn(fmt.Sprintf("useradd: group '%s' does not exist", name))
+				fs.exitCode = 6
+				return
+			}
+			supplementary = append(supplementary, name)
+		}
+		setUserGroups(groups, user, supplementary)
+	}
+
+	home := filepath.Join("/home", user)
+	if b, ok := opts['b']; ok {
+		home = filepath.Join(b, user)
+	}
+	if d, ok := opts['d']; ok {
+		home = d
+	}
+	shell := "/bin/sh"
+	if s, ok := opts['s']; ok {
+		shell = s
+	}
+	password := "!"
+	if p, ok := opts['p']; ok {
+		password = p
+		fs.capturePassword("useradd", user, p)
+	}
+
+	users.set([]string{user, "x", strconv.Itoa(uid), gid, opts['c'], home, shell})
+	if fs.saveUserDB(users, 0644) != nil || fs.saveUserDB(groups, 0644) != nil {
+		fs.RecordErrorLn("useradd: cannot lock /etc/passwd; try again later.")
+		fs.exitCode = 1
+		return
+	}
+	_ = fs.updateShadow(user, func(e []string) {
+		e[1], e[2] = password, shadowDays()
+	})
+	if _, ok := opts['m']; ok {
+		if fs.ffs.DirExists(home) {
+			fs.RecordStderrLn("useradd: warning: the home directory already exists.")
+			fs.RecordStderrLn("useradd: Not copying any file from skel directory into it.")
+		} else {
+			_ = fs.ffs.MkdirAll(home, 0750)
+			if g, err := strconv.Atoi(gid); err == nil {
+				_ = fs.ffs.Chown(home, uid, g, true)
+			}
+		}
+	}
+	fs.RecordEvent("useradd", fmt.Sprintf("%s uid=%d home=%s shell=%s", user, uid, home, shell), "")
+	if !fs.osshSession.Whitelisted {
+		SrvOSSH.Loot.AddUser(user)
+	}
+	return
+}
+
+// cmdUsermod changes the /etc/passwd, /etc/shadow and /etc/group entries of a user.
+func cmdUsermod(fs *FakeShell, args []string) (exit bool) {
+	opts, operands, ok := parseUserOptions(fs, "usermod", args[1:], "aLmoUh", "cdefgGlpsuZ")
+	if !ok {
+		return
+	}
+	user := operands[0]
+	users, groups := fs.loadUserDB("/etc/passwd"), fs.loadUserDB("/etc/group")
+	e, exists := users.find(user, 7)
+	if !exists {
+		fs.RecordErrorLn(fmt.Sprintf("usermod: user '%s' does not exist", user))
+		fs.exitCode = 6
+		return
+	}
+	if len(opts) == 0 {
+		fs.RecordErrorLn("Usage: usermod [options] LOGIN")
+		fs.exitCode = 2
+		return
+	}
+
+	if v, ok := opts['u']; ok {
+		if _, err := strconv.Atoi(v); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("usermod: invalid user ID '%s'", v))
+			fs.exitCode = 3
+			return
+		}
+		e[2] = v
+	}
+	if g, ok := opts['g']; ok {
+		gid, ok := resolveGroup(groups, g)
+		if !ok {
+			fs.RecordErrorLn(fmt.Sprintf("usermod: group '%s' does not exist", g))
+			fs.exitCode = 6
+			return
+		}
+		e[3] = gid
+	}
+	if g, ok := opts['G']; ok {
+		names := []string{}
+		if _, appendGroups := opts['a']; appendGroups {
+			names = userGroups(groups, user)
+		}
+		for _, name := range strings.Split(g, ",") {
+			if _, ok := resolveGroup(groups, name); !ok {
+				fs.RecordErrorLn(fmt.Sprintf("usermod: group '%s' does not exist", name))
+				fs.exitCode = 6
+				return
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		setUserGroups(groups, user, names)
+	}
+	if c, ok := opts['c']; ok {
+		e[4] = c
+	}
+	if d, ok := opts['d']; ok {
+		if _, move := opts['m']; move && fs.ffs.DirExists(e[5]) {
+			_ = fs.ffs.Rename(e[5], d)
+		}
+		e[5] = d
+	}
+	if s, ok := opts['s']; ok {
+		e[6] = s
+	}
+	renamed := user
+	if l, ok := opts['l']; ok {
+		if _, taken := users.find(l, 7); taken && l != user {
+			fs.RecordErrorLn(fmt.Sprintf("usermod: user '%s' already exists", l))
+			fs.exitCode = 9
+			return
+		}
+		renamed = l
+	}
+	users.set(e)
+	if fs.saveUserDB(users, 0644) != nil || fs.saveUserDB(groups, 0644) != nil {
+		fs.RecordErrorLn("usermod: cannot lock /etc/passwd; try again later.")
+		fs.exitCode = 16
+		return
+	}
+
+	if p, ok := opts['p']; ok {
+		fs.capturePassword("usermod", user, p)
+		_ = fs.setPassword(user, p, true)
+	}
+	if _, ok := opts['L']; ok {
+		_ = fs.updateShadow(user, func(e []string) { e[1] = "!" + strings.TrimPrefix(e[1], "!") })
+	}
+	if _, ok := opts['U']; ok {
+		_ = fs.updateShadow(user, func(e []string) { e[1] = strings.TrimPrefix(e[1], "!") })
+	}
+	if renamed != user {
+		rename := func(path string, perm uint32) {
+			db := fs.loadUserDB(path)
+			for _, entry := range db.entries {
+				if entry[0] == user {
+					entry[0] = renamed
+				}
+				if path == "/etc/group" && len(entry) > 3 {
+					members := strings.Split(entry[3], ",")
+					for i, m := range members {
+						if m == user {
+							members[i] = renamed
+						}
+					}
+					entry[3] = strings.Join(members, ",")
+				}
+			}
+			_ = fs.saveUserDB(db, perm)
+		}
+		rename("/etc/passwd", 0644)
+		rename("/etc/shadow", 0640)
+		rename("/etc/group", 0644)
+	}
+	fs.RecordEvent("usermod", strings.Join(args[1:], " "), "")
+	return
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// autoComplete is the AutoCompleteCallback of the terminal.
+// On tab it completes command names and paths of the FFS, pressing tab twice lists the candidates.
+func (fs *FakeShell) autoComplete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		fs.tabPending = false
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+	start := strings.LastIndexAny(prefix, " \t;|&()<>`") + 1
+	word := prefix[start:]
+	before := strings.TrimRight(prefix[:start], " \t")
+
+	var candidates []string
+	if (before == "" || strings.ContainsAny(before[len(before)-1:], ";|&(`")) && !strings.Contains(word, "/") {
+		candidates = fs.completeCommand(word)
+	} else {
+		candidates = fs.completePath(word)
+	}
+	if len(candidates) == 0 {
+		return line, pos, true // swallow the tab like bash does
+	}
+
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	if completion != word {
+		fs.tabPending = false
+		return line[:start] + completion + line[pos:], start + len(completion), true
+	}
+
+	if fs.tabPending {
+		fs.tabPending = false
+		fs.listCompletions(line, candidates)
+	} else {
+		fs.tabPending = true
+	}
+	return line, pos, true
+}
+
+// completeCommand returns all command names starting with the given prefix.
+func (fs *FakeShell) completeCommand(prefix string) []string {
+	names := map[string]bool{}
+	for name := range CmdLookup {
+		names[name] = true
+	}
+	for _, name := range TemplateNames(fs.templateDirs()...) {
+		names[name] = true
+	}
+	for _, name := range fs.ruleNames() {
+		names[name] = true
+	}
+
+	candidates := []string{}
+	for name := range names {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name, "/") {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// completePath returns all paths of the FFS starting with the given prefix, directories end with a slash.
+func (fs *FakeShell) completePath(prefix string) []string {
+	candidates := []string{}
+	if fs.ffs == nil {
+		return candidates
+	}
+
+	dir, base := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, base = prefix[:i+1], prefix[i+1:]
+	}
+	path := fs.cwd
+	if dir != "" {
+		path = toAbs(fs, dir)
+	}
+
+	entries, err := fs.ffs.ReadDir(path)
+	if err != nil {
+		return candidates
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue // hidden files are only completed if asked for
+		}
+		candidate := dir + name
+		if e.IsDir() {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// listCompletions prints the candidates in columns below the current line and repeats the prompt.
+func (fs *FakeShell) listCompletions(line string, candidates []string) {
+	names := []string{}
+	for _, c := range candidates {
+		name := c
+		if i := strings.LastIndex(strings.TrimSuffix(c, "/"), "/"); i >= 0 {
+			name = c[i+1:]
+		}
+		names = append(names, name)
+	}
+	width, _ := fs.TerminalSize()
+	output := strings.Join(formatColumns(names, width), "\n")
+	fs.stats.recording.AddOutputEvent(output)
+	// the terminal replaces the current line with what we write and then repeats the prompt,
+	// so we have to write the current line ourselves
+	_, _ = fs.terminal.Write([]byte(fs.prompt + line + "\n" + output + "\n"))
+}
+
+// commonPrefix returns the longest prefix all strings share.
+func commonPrefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/toxyl/gutils"
+)
+
+const fakeShellDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ShellEnv holds the variables of a fake shell.
+// Only exported variables are passed on to commands, e.g. `env` lists them.
+type ShellEnv struct {
+	vars     map[string]string
+	exported map[string]bool
+}
+
+func (e *ShellEnv) Get(name string) (string, bool) {
+	v, ok := e.vars[name]
+	return v, ok
+}
+
+// Value returns the value of the variable or an empty string if it's not set.
+func (e *ShellEnv) Value(name string) string {
+	return e.vars[name]
+}
+
+func (e *ShellEnv) Set(name, value string) {
+	e.vars[name] = value
+}
+
+func (e *ShellEnv) Export(name string) {
+	if _, ok := e.vars[name]; !ok {
+		e.vars[name] = ""
+	}
+	e.exported[name] = true
+}
+
+func (e *ShellEnv) IsExported(name string) bool {
+	return e.exported[name]
+}
+
+func (e *ShellEnv) Unset(name string) {
+	delete(e.vars, name)
+	delete(e.exported, name)
+}
+
+// Exported returns a copy of all exported variables.
+func (e *ShellEnv) Exported() map[string]string {
+	res := map[string]string{}
+	for name := range e.exported {
+		res[name] = e.vars[name]
+	}
+	return res
+}
+
+// ExportedNames returns the names of all exported variables in alphabetical order.
+func (e *ShellEnv) ExportedNames() []string {
+	names := []string{}
+	for name := range e.exported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Environ returns the exported variables as NAME=value pairs, sorted by name.
+func (e *ShellEnv) Environ() []string {
+	res := []string{}
+	for _, name := range e.ExportedNames() {
+		res = append(res, fmt.Sprintf("%s=%s", name, e.vars[name]))
+	}
+	return res
+}
+
+func (e *ShellEnv) Clone() *ShellEnv {
+	c := NewShellEnv()
+	for k, v := range e.vars {
+		c.vars[k] = v
+	}
+	for k, v := range e.exported {
+		c.exported[k] = v
+	}
+	return c
+}
+
+// splitAssignment splits a NAME=value string. If it is not an assignment ok will be false.
+func splitAssignment(s string) (name, value string, ok bool) {
+	if !regexShellAssignment.MatchString(s) {
+		return "", "", false
+	}
+	i := strings.IndexByte(s, '=')
+	return s[:i], s[i+1:], true
+}
+
+// initEnv sets up the environment of a fresh login shell.
+func (fs *FakeShell) initEnv() {
+	fs.env = NewShellEnv()
+
+	// like sshd's default AcceptEnv we take the locale settings from the client
+	for _, kv := range (*fs.session).Environ() {
+		name, value, ok := splitAssignment(kv)
+		if ok && (name == "LANG" || strings.HasPrefix(name, "LC_")) {
+			fs.env.Set(name, value)
+			fs.env.Export(name)
+		}
+	}
+
+	term := fs.osshSession.Term
+	if term == "" {
+		term = "dumb"
+	}
+
+	rmtH, rmtP := gutils.SplitHostPortFromAddr((*fs.session).RemoteAddr())
+	lclH, lclP := gutils.SplitHostPortFromAddr((*fs.session).LocalAddr())
+
+	exported := map[string]string{
+		"HOME":           fs.cwd,
+		"PATH":           fakeShellDefaultPath,
+		"USER":           fs.User(),
+		"LOGNAME":        fs.User(),
+		"PWD":            fs.cwd,
+		"SHELL":          "/bin/bash",
+		"TERM":           term,
+		"SHLVL":          "1",
+		"SSH_CLIENT":     fmt.Sprintf("%s %d %d", rmtH, rmtP, lclP),
+		"SSH_CONNECTION": fmt.Sprintf("%s %d %s %d", rmtH, rmtP, lclH, lclP),
+	}
+	for name, value := range exported {
+		fs.env.Set(name, value)
+		fs.env.Export(name)
+	}
+	fs.env.Set("HOSTNAME", fs.ffs.HostName())
+	fs.env.Set("IFS", " \t\n")
+
+	if fs.ffs != nil && !fs.ffs.DirExists(fs.cwd) {
+		_ = fs.ffs.MkdirAll(fs.cwd, 0755)
+	}
+}
+
+func NewShellEnv() *ShellEnv {
+	return &ShellEnv{
+		vars:     map[string]string{},
+		exported: map[string]bool{},
+	}
+}
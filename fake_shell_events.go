@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/toxyl/glog"
+	"github.com/toxyl/gutils"
+)
+
+// FakeShellEvent is something noteworthy that happened during a session, e.g. the execution of a file.
+type FakeShellEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Detail string    `json:"detail"`
+	Hash   string    `json:"hash,omitempty"`   // SHA-256 of the file involved, see SaveSample
+	Parent string    `json:"parent,omitempty"` // SHA-256 of the stage that caused the event, see recordStage
+}
+
+// RecordEvent logs an event and adds it to the session stats, events of a stage are linked to it.
+func (fs *FakeShell) RecordEvent(eventType, detail, hash string) {
+	fs.logger.Info("%s: %s %s %s", fs.osshSession.LogID(), glog.Reason(eventType), glog.Wrap(detail, glog.LightBlue), glog.Auto(hash))
+	fs.stats.AddEvent(FakeShellEvent{
+		Time:   time.Now(),
+		Type:   eventType,
+		Detail: detail,
+		Hash:   hash,
+		Parent: fs.stage,
+	})
+}
+
+// recordStage records a script that is run by piping it into a shell, e.g. `echo ... | base64 -d | sh`,
+// and makes it the stage of the events that follow until it ends. The returned function ends the stage.
+// The script is stored as sample, a stage run by another stage is linked to it.
+func (fs *FakeShell) recordStage(shell, script string) func() {
+	return fs.recordSource("stage", shell, script)
+}
+
+// recordInline records the source code given to an interpreter on the command line, e.g. `python3 -c '...'`.
+// Like a stage it is the parent of the events it causes until the returned function is called.
+func (fs *FakeShell) recordInline(interpreter, source string) func() {
+	return fs.recordSource("inline", interpreter, source)
+}
+
+// recordSource records a script as event of the given type and makes it the current stage.
+func (fs *FakeShell) recordSource(eventType, interpreter, script string) func() {
+	hash := SaveSample([]byte(script))
+	detail := ""
+	if lines := significantLines(script); len(lines) > 0 {
+		detail = lines[0]
+		if len(lines) > 1 {
+			detail += fmt.Sprintf(" (+%d lines)", len(lines)-1)
+		}
+	}
+	fs.logger.Info("%s: %s %s %s", fs.osshSession.LogID(), glog.Reason(eventType), glog.Wrap(detail, glog.LightBlue), glog.Auto(hash))
+	fs.stats.AddEvent(FakeShellEvent{
+		Time:   time.Now(),
+		Type:   eventType,
+		Detail: fmt.Sprintf("%s: %s", interpreter, detail),
+		Hash:   hash,
+		Parent: fs.stage,
+	})
+	parent := fs.stage
+	fs.stage = hash
+	return func() {
+		fs.stage = parent
+	}
+}
+
+// SaveSample stores a file the attacker worked with in the captures directory and returns its SHA-256.
+func SaveSample(data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	fpath := filepath.Join(Conf.PathCaptures, "samples", hash)
+	if !gutils.FileExists(fpath) {
+		_ = os.WriteFile(fpath, data, 0400)
+	}
+	return hash
+}
+
+// SaveEvents appends the events of the session to the event log of the payload.
+func (fss *FakeShellStats) SaveEvents(host, payloadHash string) {
+	if len(fss.Events) == 0 {
+		return
+	}
+	f, err := os.OpenFile(fmt.Sprintf("%s/events/%s.jsonl", Conf.PathCaptures, payloadHash), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	for _, e := range fss.Events {
+		line, err := json.Marshal(struct {
+			FakeShellEvent
+			Host string `json:"host"`
+			User string `json:"user"`
+		}{e, host, fss.User})
+		if err == nil {
+			_, _ = f.Write(append(line, '\n'))
+		}
+	}
+}
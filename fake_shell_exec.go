@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/toxyl/gutils"
+)
+
+// elfArch is an ELF architecture: the machine, the word size and the byte order.
+type elfArch struct {
+	machine elf.Machine
+	class   elf.Class
+	data    elf.Data
+}
+
+var (
+	elfX86_64  = elfArch{elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATA2LSB}
+	elf386     = elfArch{elf.EM_386, elf.ELFCLASS32, elf.ELFDATA2LSB}
+	elfAArch64 = elfArch{elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB}
+	elfARM     = elfArch{elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB}
+	elfMIPS    = elfArch{elf.EM_MIPS, elf.ELFCLASS32, elf.ELFDATA2MSB}
+	elfMIPSel  = elfArch{elf.EM_MIPS, elf.ELFCLASS32, elf.ELFDATA2LSB}
+	elfPPC     = elfArch{elf.EM_PPC, elf.ELFCLASS32, elf.ELFDATA2MSB}
+)
+
+// fakeShellMachines maps the machine names uname reports to the ELF architectures such a system can run,
+// 64-bit systems also run the binaries of their 32-bit predecessor.
+var fakeShellMachines = map[string][]elfArch{
+	"x86_64":   {elfX86_64, elf386},
+	"amd64":    {elfX86_64, elf386},
+	"i386":     {elf386},
+	"i486":     {elf386},
+	"i586":     {elf386},
+	"i686":     {elf386},
+	"aarch64":  {elfAArch64, elfARM},
+	"arm64":    {elfAArch64, elfARM},
+	"mips":     {elfMIPS},
+	"mipsel":   {elfMIPSel},
+	"mips64":   {{elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2MSB}, elfMIPS},
+	"mips64el": {{elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2LSB}, elfMIPSel},
+	"ppc":      {elfPPC},
+	"ppc64":    {{elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB}, elfPPC},
+	"ppc64le":  {{elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB}},
+	"riscv64":  {{elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB}},
+	"s390x":    {{elf.EM_S390, elf.ELFCLASS64, elf.ELFDATA2MSB}},
+}
+
+// runsMachine returns whether the fake system can run binaries with the given ELF header,
+// which depends on the machine of the persona.
+func (fs *FakeShell) runsMachine(h elf.FileHeader) bool {
+	machine := fs.ffs.Persona().Uname.Machine
+	archs, ok := fakeShellMachines[machine]
+	if !ok && strings.HasPrefix(machine, "arm") {
+		archs = []elfArch{elfARM} // armv6l, armv7l, ...
+	}
+	for _, a := range archs {
+		if a.machine == h.Machine && a.class == h.Class && a.data == h.Data {
+			return true
+		}
+	}
+	return false
+}
+
+// elfMaxInterp is the longest path of a dynamic loader a binary can ask for (PATH_MAX).
+const elfMaxInterp = 4096
+
+// lookPath searches the directories of $PATH in the FFS for an executable with the given name.
+func (fs *FakeShell) lookPath(command string) (string, bool) {
+	if fs.ffs == nil {
+		return "", false
+	}
+	for _, dir := range strings.Split(fs.env.Value("PATH"), ":") {
+		if dir == "" {
+			dir = "." // an empty entry means the current directory
+		}
+		path := toAbs(fs, filepath.Join(dir, command))
+		if fs.ffs.FileExists(path) && !fs.ffs.DirExists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// execFile runs a file of the FFS, command is what the user typed, e.g. `./x.sh`.
+// Shell scripts are executed, for everything else the answer depends on the content of the file.
+func (fs *FakeShell) execFile(command, path string, args []string) (exit bool) {
+	fail := func(code int, msg string) bool {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s", command, msg))
+		fs.exitCode = code
+		return false
+	}
+
+	if name := fs.packageCommand(path); name != "" {
+		return fs.execPackageCommand(name, args)
+	}
+	if fs.ffs.DirExists(path) {
+		return fail(126, "Is a directory")
+	}
+	info, err := fs.ffs.Stat(path)
+	if err != nil {
+		return fail(127, "No such file or directory")
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return fail(126, "Permission denied")
+	}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		return fail(126, errorString(err))
+	}
+
+	fs.RecordEvent("exec", path, SaveSample(data))
+	content := string(data)
+
+	if bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return fs.execELF(command, data)
+	}
+
+	if !strings.HasPrefix(content, "#!") {
+		if bytes.IndexByte(data[:minInt(len(data), 80)], 0) >= 0 {
+			return fail(126, "cannot execute binary file: Exec format error")
+		}
+		// bash runs files without a shebang as shell scripts
+		return fs.runScript(command, content, args[1:], true)
+	}
+
+	shebang := strings.TrimLeft(strings.SplitN(content[2:], "\n", 2)[0], " \t")
+	fields := strings.FieldsFunc(shebang, func(r rune) bool { return r == ' ' || r == '\t' })
+	if len(fields) == 0 {
+		return fs.runScript(command, content, args[1:], true)
+	}
+
+	interpreter := fields[0]
+	if strings.HasSuffix(interpreter, "\r") {
+		// scripts with Windows line endings are a classic
+		return fail(126, fmt.Sprintf("%s^M: bad interpreter: No such file or directory", strings.TrimSuffix(interpreter, "\r")))
+	}
+	if filepath.Base(interpreter) == "env" && len(fields) > 1 {
+		interpreter = strings.TrimSuffix(fields[1], "\r")
+		if _, builtin := CmdLookup[interpreter]; !builtin {
+			if _, ok := fs.lookPath(interpreter); !ok {
+				fs.RecordErrorLn(fmt.Sprintf("%s: ‘%s’: No such file or directory", fields[0], fields[1]))
+				fs.exitCode = 127
+				return false
+			}
+		}
+	}
+
+	if shellInterpreters[filepath.Base(interpreter)] {
+		return fs.runScript(command, content, args[1:], true)
+	}
+	if fs.execScript(interpreter, command, content) {
+		return false
+	}
+
+	if !fs.ffs.FileExists(interpreter) {
+		return fail(126, fmt.Sprintf("%s: bad interpreter: No such file or directory", interpreter))
+	}
+
+	// the interpreter exists, but we can't run it
+	if fs.daemonize(func(p *FakeProcess) {}) {
+		return false // nobody sees it crash in the background
+	}
+	fs.RecordErrorLn("Segmentation fault (core dumped)")
+	fs.exitCode = 139
+	return false
+}
+
+// execELF answers the execution of an ELF binary the way a real system would if it couldn't run it.
+func (fs *FakeShell) execELF(command string, data []byte) (exit bool) {
+	formatError := func() bool {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: cannot execute binary file: Exec format error", command))
+		fs.exitCode = 126
+		return false
+	}
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil || !fs.runsMachine(f.FileHeader) || (f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN) {
+		return formatError()
+	}
+
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		// like the kernel, refuse loader paths that can't be a path, the header can claim any size
+		if p.Filesz < 2 || p.Filesz > elfMaxInterp {
+			return formatError()
+		}
+		interp, err := io.ReadAll(io.LimitReader(p.Open(), elfMaxInterp))
+		if err != nil {
+			break
+		}
+		// a missing dynamic loader results in the infamous "No such file or directory" for a file that exists
+		if !fs.ffs.FileExists(string(bytes.TrimRight(interp, "\x00"))) {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: %s: No such file or directory", command))
+			fs.exitCode = 127
+			return false
+		}
+	}
+
+	// a binary started in the background is most likely a miner, so it keeps running and keeps the CPU busy
+	if fs.daemonize(func(p *FakeProcess) {
+		p.Stat = "Rl"
+		p.CPU = float64(gutils.GetRandomInt(900, 999)) / 10
+		p.VSZ, p.RSS = gutils.GetRandomInt(200000, 2500000), gutils.GetRandomInt(20000, 400000)
+	}) {
+		return false
+	}
+	fs.RecordErrorLn("Segmentation fault (core dumped)")
+	fs.exitCode = 139
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
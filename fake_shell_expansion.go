@@ -0,0 +1,569 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/toxyl/glog"
+)
+
+var regexBacktickEscape = regexp.MustCompile("\\\\([$`\\\\])")
+
+const (
+	shellSubstMaxDepth  = 8         // max nesting level of command substitutions
+	shellSubstMaxOutput = 64 * 1024 // max bytes of output a command substitution can produce
+)
+
+// wordExpander collects the fields a word expands to.
+// Next to each field it keeps the field as a glob pattern in which the quoted characters are escaped.
+type wordExpander struct {
+	fields   []string
+	patterns []string
+	globs    []bool // true if the field has unquoted glob characters, i.e. is subject to pathname expansion
+	cur      strings.Builder
+	pattern  strings.Builder
+	glob     bool
+	started  bool // true if the current field exists, even if it's empty (e.g. "")
+}
+
+// addLiteral adds quoted text, it never acts as a glob pattern.
+func (e *wordExpander) addLiteral(s string) {
+	e.cur.WriteString(s)
+	e.pattern.WriteString(escapePattern(s))
+	e.started = true
+}
+
+func (e *wordExpander) addByte(c byte) {
+	e.addLiteral(string(c))
+}
+
+// addUnquoted adds unquoted text, glob characters in it are active.
+func (e *wordExpander) addUnquoted(s string) {
+	e.cur.WriteString(s)
+	e.pattern.WriteString(s)
+	e.glob = e.glob || strings.ContainsAny(s, "*?[")
+	e.started = true
+}
+
+// addSplit adds the result of an unquoted expansion, which is subject to word splitting.
+func (e *wordExpander) addSplit(s string) {
+	if s == "" {
+		return
+	}
+	if strings.IndexByte(" \t\n", s[0]) >= 0 {
+		e.endField()
+	}
+	for i, f := range strings.Fields(s) {
+		if i > 0 {
+			e.endField()
+		}
+		e.addUnquoted(f)
+	}
+	if strings.IndexByte(" \t\n", s[len(s)-1]) >= 0 {
+		e.endField()
+	}
+}
+
+func (e *wordExpander) endField() {
+	if !e.started {
+		return
+	}
+	e.fields = append(e.fields, e.cur.String())
+	e.patterns = append(e.patterns, e.pattern.String())
+	e.globs = append(e.globs, e.glob)
+	e.cur.Reset()
+	e.pattern.Reset()
+	e.glob = false
+	e.started = false
+}
+
+// escapePattern escapes the characters that have a special meaning in a glob pattern.
+func escapePattern(s string) string {
+	if !strings.ContainsAny(s, "*?[\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("*?[\\", s[i]) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func isShellNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// param returns the value of a shell parameter, which is either a special parameter ($?, $$, $1, ...) or a variable.
+func (fs *FakeShell) param(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(fs.exitCode), true
+	case "$":
+		return strconv.Itoa(fs.pid), true
+	case "!":
+		if fs.lastJob == 0 {
+			return "", false
+		}
+		return strconv.Itoa(fs.lastJob), true
+	case "#":
+		return strconv.Itoa(len(fs.positional)), true
+	case "0":
+		return fs.name, true
+	case "@", "*":
+		return strings.Join(fs.positional, " "), len(fs.positional) > 0
+	case "-":
+		return "himBHs", true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(fs.positional) {
+			return "", false
+		}
+		return fs.positional[n-1], true
+	}
+	return fs.env.Get(name)
+}
+
+// matchPattern reports whether s matches the shell pattern, unlike path.Match a * also matches slashes.
+func matchPattern(pattern, s string) bool {
+	var sb strings.Builder
+	sb.WriteString("^(?s)")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// trimPattern removes the shortest (or longest) prefix (or suffix) matching the glob pattern, like ${x#p}, ${x##p}, ${x%p} and ${x%%p}.
+func trimPattern(value, pattern string, suffix, longest bool) string {
+	n := len(value)
+	for i := 0; i <= n; i++ {
+		l := i
+		if longest {
+			l = n - i
+		}
+		var candidate string
+		if suffix {
+			candidate = value[n-l:]
+		} else {
+			candidate = value[:l]
+		}
+		if matchPattern(pattern, candidate) {
+			if suffix {
+				return value[:n-l]
+			}
+			return value[l:]
+		}
+	}
+	return value
+}
+
+// expandBraced evaluates the inside of ${...}.
+func (fs *FakeShell) expandBraced(expr string) string {
+	if strings.HasPrefix(expr, "#") && len(expr) > 1 {
+		v, _ := fs.param(expr[1:])
+		return strconv.Itoa(utf8.RuneCountInString(v))
+	}
+
+	i := 0
+	switch {
+	case expr == "":
+	case expr[0] >= '0' && expr[0] <= '9':
+		for i < len(expr) && expr[i] >= '0' && expr[i] <= '9' {
+			i++
+		}
+	case strings.IndexByte("?$!#@*-", expr[0]) >= 0:
+		i = 1 // special parameters are a single char
+	default:
+		for i < len(expr) && isShellNameChar(expr[i], i == 0) {
+			i++
+		}
+	}
+	name, op := expr[:i], expr[i:]
+	value, set := fs.param(name)
+	if op == "" {
+		return value
+	}
+
+	for _, o := range []string{":-", ":=", ":+", ":?", "-", "=", "+", "?", "##", "#", "%%", "%"} {
+		if !strings.HasPrefix(op, o) {
+			continue
+		}
+		word := fs.expandString(ShellWord(op[len(o):]))
+		useDefault := !set || (strings.HasPrefix(o, ":") && value == "")
+		switch o {
+		case ":-", "-":
+			if useDefault {
+				return word
+			}
+		case ":=", "=":
+			if useDefault {
+				fs.env.Set(name, word)
+				return word
+			}
+		case ":+", "+":
+			if useDefault {
+				return ""
+			}
+			return word
+		case ":?", "?":
+			if useDefault {
+				if word == "" {
+					word = "parameter null or not set"
+				}
+				fs.RecordErrorLn("-bash: " + name + ": " + word)
+				return ""
+			}
+		case "##", "#":
+			return trimPattern(value, word, false, o == "##")
+		case "%%", "%":
+			return trimPattern(value, word, true, o == "%%")
+		}
+		return value
+	}
+	return value
+}
+
+// substitute runs the command of a command substitution in a subshell and returns its output.
+func (fs *FakeShell) substitute(command string) string {
+	if fs.substDepth >= shellSubstMaxDepth {
+		fs.logger.Debug("%s: Command substitution nested too deep: %s", fs.osshSession.LogID(), glog.Wrap(command, glog.LightBlue))
+		fs.RecordErrorLn("-bash: command substitution: maximum nesting level exceeded")
+		return ""
+	}
+	fs.logger.Debug("%s: Command substitution: %s", fs.osshSession.LogID(), glog.Wrap(command, glog.LightBlue))
+
+	fs.substDepth++
+	fs.substCount++
+	state := fs.saveState()
+	streams := fs.streams
+	output := &shellOutput{max: shellSubstMaxOutput}
+	fs.streams.stdout = output
+	defer func() {
+		fs.streams = streams
+		fs.restoreState(state)
+		fs.substDepth--
+	}()
+
+	// `exit` only leaves the subshell
+	_ = fs.run(command, "-bash: command substitution")
+
+	if output.truncated {
+		fs.logger.Debug("%s: Command substitution output truncated to %d bytes", fs.osshSession.LogID(), shellSubstMaxOutput)
+	}
+	return strings.TrimRight(output.String(), "\n")
+}
+
+// expandDollar expands the parameter expression at the beginning of s, which starts with a $.
+// It returns the value, the number of bytes consumed and whether there was anything to expand at all.
+func (fs *FakeShell) expandDollar(s string) (string, int, bool) {
+	if len(s) < 2 {
+		return "", 1, false
+	}
+	c := s[1]
+	switch {
+	case c == '(' && !strings.HasPrefix(s, "$(("):
+		l := &shellLexer{src: s, pos: 0}
+		if err := l.skipDollar(); err != nil {
+			return "", 1, false
+		}
+		return fs.substitute(s[2 : l.pos-1]), l.pos, true
+	case c == '{':
+		l := &shellLexer{src: s, pos: 0}
+		if err := l.skipDollar(); err != nil {
+			return "", 1, false
+		}
+		return fs.expandBraced(s[2 : l.pos-1]), l.pos, true
+	case strings.IndexByte("?$!#@*-", c) >= 0, c >= '0' && c <= '9':
+		v, _ := fs.param(string(c))
+		return v, 2, true
+	case isShellNameChar(c, true):
+		i := 2
+		for i < len(s) && isShellNameChar(s[i], false) {
+			i++
+		}
+		v, _ := fs.param(s[1:i])
+		return v, i, true
+	}
+	return "", 1, false
+}
+
+// expandBackticks runs the command substitution at the beginning of s, which starts with a backtick.
+// It returns the output, the number of bytes consumed and whether the substitution was complete.
+func (fs *FakeShell) expandBackticks(s string) (string, int, bool) {
+	l := &shellLexer{src: s, pos: 0}
+	if err := l.skipBackticks(); err != nil {
+		return "", 1, false
+	}
+	// inside backticks a backslash only escapes $, ` and itself
+	command := regexBacktickEscape.ReplaceAllString(s[1:l.pos-1], "$1")
+	return fs.substitute(command), l.pos, true
+}
+
+// expandHereDoc performs parameter expansion and command substitution on the body of a here-document.
+func (fs *FakeShell) expandHereDoc(body string) string {
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch c {
+		case '\\':
+			if i+1 < len(body) && strings.IndexByte("$`\\\n", body[i+1]) >= 0 {
+				i++
+				if body[i] != '\n' {
+					sb.WriteByte(body[i])
+				}
+				continue
+			}
+			sb.WriteByte(c)
+		case '$', '`':
+			var value string
+			var n int
+			var ok bool
+			if c == '$' {
+				value, n, ok = fs.expandDollar(body[i:])
+			} else {
+				value, n, ok = fs.expandBackticks(body[i:])
+			}
+			if !ok {
+				sb.WriteByte(c)
+				continue
+			}
+			sb.WriteString(value)
+			i += n - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// expandWord performs tilde expansion, parameter expansion, command substitution, word splitting (if split is true) and quote removal on a word.
+func (fs *FakeShell) expandWord(word ShellWord, split bool) []string {
+	return fs.expand(word, split).fields
+}
+
+func (fs *FakeShell) expand(word ShellWord, split bool) *wordExpander {
+	w := string(word)
+	e := &wordExpander{}
+
+	if w == "~" || strings.HasPrefix(w, "~/") {
+		e.addLiteral(fs.env.Value("HOME"))
+		w = w[1:]
+	}
+
+	inDouble := false
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(w) {
+				e.addLiteral("\\")
+				continue
+			}
+			next := w[i+1]
+			i++
+			if next == '\n' {
+				continue // line continuation
+			}
+			if inDouble && strings.IndexByte("$`\"\\", next) < 0 {
+				e.addLiteral("\\") // inside double quotes the backslash only escapes a few characters
+			}
+			e.addByte(next)
+		case c == '\'' && !inDouble:
+			end := strings.IndexByte(w[i+1:], '\'')
+			if end < 0 {
+				end = len(w) - i - 1
+			}
+			e.addLiteral(w[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inDouble = !inDouble
+			e.started = true
+		case c == '$':
+			value, n, ok := fs.expandDollar(w[i:])
+			if !ok {
+				e.addLiteral("$")
+				continue
+			}
+			i += n - 1
+			switch {
+			case inDouble:
+				e.addLiteral(value)
+			case !split:
+				e.addUnquoted(value)
+			default:
+				e.addSplit(value)
+			}
+		case c == '`':
+			value, n, ok := fs.expandBackticks(w[i:])
+			if !ok {
+				e.addByte(c)
+				continue
+			}
+			i += n - 1
+			switch {
+			case inDouble:
+				e.addLiteral(value)
+			case !split:
+				e.addUnquoted(value)
+			default:
+				e.addSplit(value)
+			}
+		case inDouble:
+			e.addByte(c)
+		default:
+			e.addUnquoted(string(c))
+		}
+	}
+	e.endField()
+	return e
+}
+
+// expandString expands a word without word splitting, e.g. the value of an assignment or the target of a redirection.
+func (fs *FakeShell) expandString(word ShellWord) string {
+	return strings.Join(fs.expandWord(word, false), "")
+}
+
+// expandPattern expands a word into a glob pattern, e.g. the pattern of a case item.
+func (fs *FakeShell) expandPattern(word ShellWord) string {
+	return strings.Join(fs.expand(word, false).patterns, "")
+}
+
+// expandWords expands the words of a command into its arguments.
+// Fields with unquoted glob characters are replaced by the paths they match, if there are any.
+func (fs *FakeShell) expandWords(words []ShellWord) []string {
+	fields := []string{}
+	for _, w := range words {
+		e := fs.expand(w, true)
+		for i, f := range e.fields {
+			if e.globs[i] {
+				if paths := fs.glob(e.patterns[i]); len(paths) > 0 {
+					fields = append(fields, paths...)
+					continue
+				}
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// glob returns the sorted paths of the FFS that match the pattern, one path component at a time.
+// Like in bash, names starting with a dot only match if the pattern component starts with a dot as well.
+func (fs *FakeShell) glob(pattern string) []string {
+	prefix := ""
+	if strings.HasPrefix(pattern, "/") {
+		prefix = "/"
+	}
+	components := strings.Split(strings.Trim(pattern, "/"), "/")
+	paths := []string{prefix}
+	for i, c := range components {
+		last := i == len(components)-1
+		var next []string
+		for _, p := range paths {
+			if !hasGlobChars(c) {
+				next = append(next, p+unescapePattern(c))
+				continue
+			}
+			dir := fs.cwd
+			if p != "" {
+				dir = toAbs(fs, p)
+			}
+			entries, err := fs.ffs.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(c, ".") && !strings.HasPrefix(c, "\\.") {
+					continue
+				}
+				if matchPattern(c, name) {
+					next = append(next, p+name)
+				}
+			}
+		}
+		if !last {
+			for j := range next {
+				next[j] += "/"
+			}
+		}
+		paths = next
+	}
+
+	dirsOnly := strings.HasSuffix(pattern, "/") && pattern != "/"
+	matches := []string{}
+	for _, p := range paths {
+		info, err := fs.ffs.Stat(toAbs(fs, p))
+		if err != nil || (dirsOnly && !info.IsDir()) {
+			continue
+		}
+		if dirsOnly {
+			p += "/"
+		}
+		matches = append(matches, p)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// hasGlobChars checks if the pattern has unescaped glob characters.
+func hasGlobChars(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapePattern removes the escapes from a pattern without glob characters.
+func unescapePattern(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		sb.WriteByte(pattern[i])
+	}
+	return sb.String()
+}
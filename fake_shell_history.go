@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const fakeShellHistoryFile = ".bash_history"
+
+// historyFile returns the path of the history file in the FFS.
+func (fs *FakeShell) historyFile() string {
+	return toAbs(fs, "~/"+fakeShellHistoryFile)
+}
+
+// loadHistory reads the history of previous sessions from the FFS.
+func (fs *FakeShell) loadHistory() {
+	fs.history = []string{}
+	if fs.ffs == nil {
+		return
+	}
+	data, err := fs.ffs.ReadFile(fs.historyFile())
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			fs.history = append(fs.history, line)
+		}
+	}
+}
+
+// saveHistory writes the history to the FFS, like bash does on logout.
+func (fs *FakeShell) saveHistory() {
+	if fs.ffs == nil {
+		return
+	}
+	f, err := fs.ffs.OpenFile(fs.historyFile(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		fs.logger.Debug("%s: Failed to save history: %s", fs.osshSession.LogID(), errorString(err))
+		return
+	}
+	defer f.Close()
+	for _, line := range fs.history {
+		_, _ = f.WriteString(line + "\n")
+	}
+}
+
+// addHistory adds input to the history the user can see,
+// like the HISTCONTROL=ignoreboth default of many distros it ignores duplicates and lines starting with a space.
+func (fs *FakeShell) addHistory(input string) {
+	if strings.TrimSpace(input) == "" || strings.HasPrefix(input, " ") {
+		return
+	}
+	if len(fs.history) > 0 && fs.history[len(fs.history)-1] == input {
+		return
+	}
+	fs.history = append(fs.history, input)
+}
+
+func cmdHistory(fs *FakeShell, args []string) (exit bool) {
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-c":
+			fs.history = []string{}
+			return
+		case "-w", "-a":
+			fs.saveHistory()
+			return
+		case "-n", "-r":
+			return
+		case "-d":
+			if i+1 >= len(args) {
+				fs.RecordErrorLn("-bash: history: -d: option requires an argument")
+				fs.exitCode = 2
+				return
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 || n > len(fs.history) {
+				fs.RecordErrorLn(fmt.Sprintf("-bash: history: %s: history position out of range", args[i+1]))
+				fs.exitCode = 1
+				return
+			}
+			fs.history = append(fs.history[:n-1], fs.history[n:]...)
+			return
+		default:
+			if len(arg) > 1 && arg[0] == '-' {
+				fs.RecordErrorLn(fmt.Sprintf("-bash: history: %s: invalid option", arg))
+				fs.RecordErrorLn("history: usage: history [-c] [-d offset] [n] or history -anrw [filename] or history -ps arg [arg...]")
+				fs.exitCode = 2
+				return
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				fs.RecordErrorLn(fmt.Sprintf("-bash: history: %s: numeric argument required", arg))
+				fs.exitCode = 1
+				return
+			}
+			if i+1 < len(args) {
+				fs.RecordErrorLn("-bash: history: too many arguments")
+				fs.exitCode = 1
+				return
+			}
+			start := len(fs.history) - n
+			if start < 0 {
+				start = 0
+			}
+			for j := start; j < len(fs.history); j++ {
+				fs.RecordWriteLn(fmt.Sprintf("%5d  %s", j+1, fs.history[j]))
+			}
+			return
+		}
+	}
+
+	for i, line := range fs.history {
+		fs.RecordWriteLn(fmt.Sprintf("%5d  %s", i+1, line))
+	}
+	return
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/toxyl/glog"
+	"github.com/toxyl/gutils"
+)
+
+const shellLoopMaxIterations = 100 // max iterations of a loop, bots like to loop forever and nothing would stop them
+
+// shellStreams are the standard streams of the command that is currently executed.
+type shellStreams struct {
+	stdin  *string      // nil if stdin is the terminal
+	stdout *shellOutput // nil if stdout is the terminal
+	stderr *shellOutput // nil if stderr is the terminal
+}
+
+// shellOutput collects the output of a stream that doesn't go to the terminal.
+// If max is set, output beyond max bytes is discarded instead of being buffered.
+type shellOutput struct {
+	sb        strings.Builder
+	max       int
+	truncated bool
+}
+
+func (o *shellOutput) WriteString(s string) (int, error) {
+	if o.max > 0 && o.sb.Len()+len(s) > o.max {
+		s = s[:o.max-o.sb.Len()]
+		o.truncated = true
+	}
+	return o.sb.WriteString(s)
+}
+
+func (o *shellOutput) String() string {
+	return o.sb.String()
+}
+
+// shellFileSink collects output that is redirected into a file of the FFS,
+// it is written to the file once the command has finished.
+type shellFileSink struct {
+	path    string
+	output  *shellOutput
+	written func() // see watchPersistence
+}
+
+// shellState is the part of the shell state that a subshell must not change.
+type shellState struct {
+	cwd        string
+	prompt     string
+	env        *ShellEnv
+	name       string
+	positional []string
+	logins     []fakeLogin
+	loops      int
+	breaks     int
+	continues  int
+}
+
+func (fs *FakeShell) saveState() shellState {
+	return shellState{
+		cwd:        fs.cwd,
+		prompt:     fs.prompt,
+		env:        fs.env.Clone(),
+		name:       fs.name,
+		positional: fs.positional,
+		logins:     append([]fakeLogin{}, fs.logins...),
+		loops:      fs.loops,
+		breaks:     fs.breaks,
+		continues:  fs.continues,
+	}
+}
+
+func (fs *FakeShell) restoreState(state shellState) {
+	fs.cwd = state.cwd
+	fs.prompt = state.prompt
+	fs.env = state.env
+	fs.name = state.name
+	fs.positional = state.positional
+	fs.logins = state.logins
+	fs.loops = state.loops
+	fs.breaks = state.breaks
+	fs.continues = state.continues
+	fs.terminal.SetPrompt(fs.prompt)
+}
+
+// Stdin returns the input piped into the current command.
+// If the command reads from the terminal the second return value is false.
+func (fs *FakeShell) Stdin() (string, bool) {
+	if fs.streams.stdin == nil {
+		return "", false
+	}
+	return *fs.streams.stdin, true
+}
+
+func (fs *FakeShell) runList(list *ShellList) (exit bool) {
+	for _, item := range list.Items {
+		if fs.runAndOr(item) {
+			return true
+		}
+		if fs.loopInterrupted() {
+			break
+		}
+	}
+	return false
+}
+
+// loopInterrupted checks if a break or continue is pending, the rest of the loop body is skipped then.
+func (fs *FakeShell) loopInterrupted() bool {
+	return fs.breaks > 0 || fs.continues > 0
+}
+
+func (fs *FakeShell) runAndOr(ao *ShellAndOr) (exit bool) {
+	if ao.Background {
+		fs.runBackground(ao)
+		return false
+	}
+	for i, pl := range ao.Pipelines {
+		if i > 0 {
+			op := ao.Operators[i-1]
+			if (op == "&&" && fs.exitCode != 0) || (op == "||" && fs.exitCode == 0) {
+				continue
+			}
+		}
+		if fs.runPipeline(pl) {
+			return true
+		}
+		if fs.loopInterrupted() {
+			break
+		}
+	}
+	return false
+}
+
+func (fs *FakeShell) runPipeline(pl *ShellPipeline) (exit bool) {
+	streams := fs.streams
+	defer func() {
+		fs.streams = streams
+	}()
+
+	stdin := streams.stdin
+	for i, cmd := range pl.Commands {
+		fs.streams = streams
+		fs.streams.stdin = stdin
+
+		var stdout *shellOutput
+		if i < len(pl.Commands)-1 {
+			stdout = &shellOutput{}
+			fs.streams.stdout = stdout
+		}
+
+		// like in bash, every part of a multi-command pipeline runs in a subshell,
+		// so an exit there does not end the session
+		if len(pl.Commands) > 1 {
+			fs.subshells++
+			_ = fs.runCommand(cmd)
+			fs.subshells--
+		} else if fs.runCommand(cmd) {
+			return true
+		}
+
+		if stdout != nil {
+			out := stdout.String()
+			stdin = &out
+		}
+	}
+
+	if pl.Negate {
+		if fs.exitCode == 0 {
+			fs.exitCode = 1
+		} else {
+			fs.exitCode = 0
+		}
+	}
+	return false
+}
+
+func (fs *FakeShell) fdStream(fd int) *shellOutput {
+	if fd == 2 {
+		return fs.streams.stderr
+	}
+	return fs.streams.stdout
+}
+
+func (fs *FakeShell) setFdStream(fd int, stream *shellOutput) {
+	switch fd {
+	case 1:
+		fs.streams.stdout = stream
+	case 2:
+		fs.streams.stderr = stream
+	}
+}
+
+// openSink opens the target of an output redirection. The file is created (or truncated) right away,
+// like bash does, but the output is only written when the command has finished.
+func (fs *FakeShell) openSink(target string, appendOutput bool) (*shellOutput, *shellFileSink, error) {
+	switch target {
+	case "/dev/null", "/dev/zero":
+		return &shellOutput{}, nil, nil
+	case "/dev/stdout":
+		return fs.streams.stdout, nil, nil
+	case "/dev/stderr":
+		return fs.streams.stderr, nil, nil
+	}
+
+	path := toAbs(fs, target)
+	if fs.ffs.DirExists(path) {
+		return nil, nil, syscall.EISDIR
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendOutput {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	written := fs.watchPersistence(path)
+	file, err := fs.ffs.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	file.Close()
+
+	output := &shellOutput{}
+	return output, &shellFileSink{path: path, output: output, written: written}, nil
+}
+
+// applyRedirects sets up the streams of a command according to its redirections.
+// If a redirection fails an error is printed and false is returned, the command must not be run then.
+func (fs *FakeShell) applyRedirects(redirects []*ShellRedirect) ([]*shellFileSink, bool) {
+	sinks := []*shellFileSink{}
+	for _, r := range redirects {
+		if r.Op == "<<" || r.Op == "<<-" {
+			stdin := r.HereDoc
+			if r.Expand {
+				stdin = fs.expandHereDoc(stdin)
+			}
+			fs.streams.stdin = &stdin
+			continue
+		}
+
+		target := fs.expandString(r.Target)
+		fd := r.Fd
+
+		if r.Op == "<<<" {
+			// a here-string is the expanded word with a newline
+			stdin := target + "\n"
+			fs.streams.stdin = &stdin
+			continue
+		}
+
+		switch r.Op {
+		case "<", "<>":
+			if fd > 0 {
+				continue // we only care about stdin
+			}
+			path := toAbs(fs, target)
+			if fs.ffs.DirExists(path) {
+				fs.RecordErrorLn(fmt.Sprintf("-bash: %s: Is a directory", target))
+				return nil, false
+			}
+			data, err := fs.ffs.ReadFile(path)
+			if err != nil {
+				fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s", target, errorString(err)))
+				return nil, false
+			}
+			stdin := string(data)
+			fs.streams.stdin = &stdin
+			continue
+		case "<&":
+			continue // duplicating input fds makes no difference for us
+		case ">&":
+			if fd < 0 {
+				fd = 1
+			}
+			if target == "-" {
+				fs.setFdStream(fd, &shellOutput{}) // closed, nothing will be printed
+				continue
+			}
+			if src, err := strconv.Atoi(target); err == nil {
+				fs.setFdStream(fd, fs.fdStream(src))
+				continue
+			}
+			if r.Fd < 0 {
+				fd = -1 // >&file is the same as &>file
+			}
+		}
+
+		appendOutput := r.Op == ">>" || r.Op == "&>>"
+		stream, sink, err := fs.openSink(target, appendOutput)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s", target, errorString(err)))
+			return nil, false
+		}
+		if sink != nil {
+			sinks = append(sinks, sink)
+		}
+
+		switch {
+		case r.Op == "&>" || r.Op == "&>>" || fd < 0 && r.Op == ">&":
+			fs.streams.stdout = stream
+			fs.streams.stderr = stream
+		case fd < 0:
+			fs.streams.stdout = stream
+		default:
+			fs.setFdStream(fd, stream)
+		}
+	}
+	return sinks, true
+}
+
+// flushSinks writes the output collected for redirections into the FFS.
+func (fs *FakeShell) flushSinks(sinks []*shellFileSink) {
+	for _, sink := range sinks {
+		file, err := fs.ffs.OpenFile(sink.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fs.logger.Error("%s: Failed to write redirected output to %s: %s", fs.osshSession.LogID(), glog.File(sink.path), glog.Error(err))
+			continue
+		}
+		_, _ = file.WriteString(sink.output.String())
+		file.Close()
+		sink.written()
+	}
+}
+
+func (fs *FakeShell) runCommand(cmd ShellCommand) (exit bool) {
+	streams := fs.streams
+	defer func() {
+		fs.streams = streams
+	}()
+
+	sinks, ok := fs.applyRedirects(cmd.redirects())
+	if !ok {
+		fs.exitCode = 1
+		return false
+	}
+	defer fs.flushSinks(sinks)
+
+	switch c := cmd.(type) {
+	case *ShellSimpleCommand:
+		return fs.runSimpleCommand(c)
+	case *ShellGroup:
+		return fs.runList(c.List)
+	case *ShellSubshell:
+		state := fs.saveState()
+		fs.subshells++
+		_ = fs.runList(c.List)
+		fs.subshells--
+		fs.restoreState(state)
+	case *ShellIf:
+		return fs.runIf(c)
+	case *ShellLoop:
+		return fs.runLoop(c)
+	case *ShellFor:
+		return fs.runFor(c)
+	case *ShellCase:
+		return fs.runCase(c)
+	}
+	return false
+}
+
+func (fs *FakeShell) runIf(cmd *ShellIf) (exit bool) {
+	for i, condition := range cmd.Conditions {
+		if fs.runList(condition) {
+			return true
+		}
+		if fs.loopInterrupted() {
+			return false
+		}
+		if fs.exitCode == 0 {
+			return fs.runList(cmd.Bodies[i])
+		}
+	}
+	if len(cmd.Bodies) > len(cmd.Conditions) {
+		return fs.runList(cmd.Bodies[len(cmd.Bodies)-1])
+	}
+	fs.exitCode = 0
+	return false
+}
+
+// loopLimitReached checks if a loop has run as many iterations as it may.
+func (fs *FakeShell) loopLimitReached(iteration int) bool {
+	if iteration < shellLoopMaxIterations {
+		return false
+	}
+	fs.logger.Debug("%s: Loop stopped after %d iterations", fs.osshSession.LogID(), iteration)
+	return true
+}
+
+// runLoopBody runs one iteration of a loop, stop is true if the loop must not continue.
+func (fs *FakeShell) runLoopBody(body *ShellList) (exit, stop bool) {
+	if fs.runList(body) {
+		return true, true
+	}
+	return false, fs.leaveLoop()
+}
+
+// leaveLoop consumes a pending break or continue when the end of a loop body is reached.
+// It returns true if the loop has to stop, i.e. on a break or a continue meant for an outer loop.
+func (fs *FakeShell) leaveLoop() bool {
+	switch {
+	case fs.breaks > 0:
+		fs.breaks--
+		return true
+	case fs.continues > 0:
+		fs.continues--
+		return fs.continues > 0
+	}
+	return false
+}
+
+func (fs *FakeShell) runLoop(cmd *ShellLoop) (exit bool) {
+	fs.loops++
+	defer func() {
+		fs.loops--
+	}()
+
+	status := 0
+	for i := 0; !fs.loopLimitReached(i); i++ {
+		if fs.runList(cmd.Condition) {
+			return true
+		}
+		if fs.loopInterrupted() {
+			if fs.leaveLoop() {
+				break
+			}
+			continue
+		}
+		if (fs.exitCode == 0) == cmd.Until {
+			break
+		}
+		exit, stop := fs.runLoopBody(cmd.Body)
+		status = fs.exitCode
+		if exit {
+			return true
+		}
+		if stop {
+			break
+		}
+	}
+	fs.exitCode = status
+	return false
+}
+
+func (fs *FakeShell) runFor(cmd *ShellFor) (exit bool) {
+	values := fs.positional
+	if cmd.In {
+		values = fs.expandWords(cmd.Words)
+	}
+
+	fs.loops++
+	defer func() {
+		fs.loops--
+	}()
+
+	fs.exitCode = 0
+	for i, value := range values {
+		if fs.loopLimitReached(i) {
+			break
+		}
+		fs.env.Set(cmd.Name, value)
+		exit, stop := fs.runLoopBody(cmd.Body)
+		if exit {
+			return true
+		}
+		if stop {
+			break
+		}
+	}
+	return false
+}
+
+func (fs *FakeShell) runCase(cmd *ShellCase) (exit bool) {
+	word := fs.expandString(cmd.Word)
+	for _, item := range cmd.Items {
+		for _, pattern := range item.Patterns {
+			if matchPattern(fs.expandPattern(pattern), word) {
+				fs.exitCode = 0
+				return fs.runList(item.Body)
+			}
+		}
+	}
+	fs.exitCode = 0
+	return false
+}
+
+func (fs *FakeShell) runSimpleCommand(cmd *ShellSimpleCommand) (exit bool) {
+	// everything is expanded before the status is reset so that $? still refers to the previous command
+	substs := fs.substCount
+	args := fs.expandWords(cmd.Words)
+
+	if len(args) == 0 {
+		// assignments without a command change the shell variables,
+		// the status is the one of the last command substitution or 0 if there was none
+		for _, a := range cmd.Assignments {
+			name, value, _ := splitAssignment(string(a))
+			fs.env.Set(name, fs.expandString(ShellWord(value)))
+		}
+		if fs.substCount == substs {
+			fs.exitCode = 0
+		}
+		return false
+	}
+
+	fs.lastStatus = fs.exitCode
+	if len(cmd.Assignments) == 0 {
+		fs.exitCode = 0
+		return fs.execCommand(args)
+	}
+
+	// assignments in front of a command only apply to the environment of that command
+	vars := map[string]string{}
+	for _, a := range cmd.Assignments {
+		name, value, _ := splitAssignment(string(a))
+		vars[name] = fs.expandString(ShellWord(value))
+	}
+	fs.exitCode = 0
+	return fs.execCommandWithEnv(args, vars)
+}
+
+// execCommandWithEnv runs a command with additional environment variables,
+// the previous state of the variables is restored afterwards.
+func (fs *FakeShell) execCommandWithEnv(args []string, vars map[string]string) (exit bool) {
+	previous := NewShellEnv()
+	for name, value := range vars {
+		if v, ok := fs.env.Get(name); ok {
+			previous.Set(name, v)
+			if fs.env.IsExported(name) {
+				previous.Export(name)
+			}
+		}
+		fs.env.Set(name, value)
+		fs.env.Export(name)
+	}
+
+	defer func() {
+		for name := range vars {
+			fs.env.Unset(name)
+			if v, ok := previous.Get(name); ok {
+				fs.env.Set(name, v)
+				if previous.IsExported(name) {
+					fs.env.Export(name)
+				}
+			}
+		}
+	}()
+
+	return fs.execCommand(args)
+}
+
+// execCommand runs a single command, args[0] being the command name.
+// All command categories of the config, the Go-implemented commands and templates are tried in that order.
+func (fs *FakeShell) execCommand(args []string) (exit bool) {
+	s := fs.osshSession
+	command := args[0]
+	line := strings.Join(args, " ")
+
+	fs.logger.Info("%s: %s %s", s.LogID(), glog.Reason(command), glog.Wrap(strings.Join(args[1:], " "), glog.LightBlue))
+
+	if fs.job != nil && len(fs.job.Args) == 0 {
+		// the first command of a background job names its process
+		fs.processes.Update(fs.job, func(p *FakeProcess) {
+			p.Args = args
+		})
+	}
+
+	fail := func(code int, output string) {
+		fs.RecordErrorLn(output)
+		fs.exitCode = code
+	}
+
+	// 1) check if command should exit immediately, in a shell started by su that only ends that shell
+	// and in a subshell, a script or a command substitution that only ends those
+	nested := fs.subshells > 0 || fs.scriptDepth > 0 || fs.substDepth > 0 || fs.job != nil
+	if len(fs.logins) > 0 && (command == "exit" || command == "logout") && !nested {
+		fs.exitLogin(args)
+		return false
+	}
+	if command == "exit" && nested {
+		return fs.exitScript(args)
+	}
+	match := fs.matchRule(line)
+	if match != nil && match.rule.kind == ruleExit {
+		fs.RecordWriteLn(gutils.GeneratePseudoEmptyString(0)) // just to waste some more time ;)
+		return true
+	}
+
+	SrvMetrics.IncrementExecutedCommands()
+
+	data := fs.templateData(args)
+	funcs := fs.templateFunctions(data)
+
+	// 2) check if the command matches one of the other rules of the persona or the config,
+	// the rule with the highest priority decides the response
+	if match != nil {
+		data.Match, data.Groups = match.groups, match.named
+		switch match.rule.kind {
+		case ruleSimple:
+			if output := ParseTemplateFromString(match.rule.response, data, funcs); output != "" {
+				fs.RecordWriteLn(output)
+			}
+		case rulePermissionDenied:
+			fail(126, ParseTemplateFromString("{{ .Command }}: permission denied", data))
+		case ruleDiskError:
+			fail(1, gutils.GenerateGarbageString(1000)+"\nend_request: I/O error")
+		case ruleCommandNotFound:
+			fail(127, ParseTemplateFromString("{{ .Command }}: command not found", data))
+		case ruleFileNotFound:
+			fail(127, ParseTemplateFromString("\"{{ .Command }}\": No such file or directory (os error 2)", data))
+		case ruleNotImplemented:
+			fail(1, ParseTemplateFromString("{{ .Command }}: Function not implemented", data))
+		case ruleBullshit:
+			fs.RecordWriteLn(gutils.GenerateGarbageString(1000))
+		}
+		return false
+	}
+
+	// 3) check if the command is a file of the FFS, e.g. ./x.sh
+	if strings.Contains(command, "/") {
+		return fs.execFile(command, toAbs(fs, command), args)
+	}
+
+	// 4) check if there is a go-implemented command for this,
+	// unless it belongs to a package that isn't installed
+	missing := fs.packageMissing(command)
+	if goCmd, found := CmdLookup[command]; found && !missing {
+		return goCmd(fs, args)
+	}
+
+	// 5) check if the command is an executable in the $PATH of the FFS
+	if path, found := fs.lookPath(command); found {
+		return fs.execFile(path, path, args)
+	}
+
+	// 6) check if we have a template for the command
+	if missing {
+		fail(127, fmt.Sprintf("%s: command not found", command))
+		return false
+	}
+	output, err := RenderTemplate(command, data, funcs, fs.templateDirs()...)
+	if err != nil {
+		fail(127, fmt.Sprintf("%s: command not found", command))
+		return false
+	}
+	if output != "" {
+		fs.RecordWriteLn(output)
+	}
+	return false
+}
+
+// Exec parses and executes a line of input.
+// It returns true if the session must be terminated.
+func (fs *FakeShell) Exec(line string) bool {
+	s := fs.osshSession
+	fs.stats.AddCommandToHistory(line)
+
+	s.UpdateActivity()
+	defer func() {
+		s.UpdateActivity()
+	}()
+
+	if !s.Whitelisted {
+		// make sure the client waits some time at least,
+		// the more input the more wait time, hehe
+		dly := len(line) * int(Conf.InputDelay)
+		gutils.RandomSleep(dly, dly*2, time.Millisecond)
+	}
+
+	// Ignore just pressing enter with whitespace
+	if strings.TrimSpace(line) == "" {
+		return false
+	}
+
+	fs.RecordInput(line)
+
+	return fs.run(line, "-bash")
+}
+
+// run parses and runs the input, errors are prefixed with the given string.
+func (fs *FakeShell) run(input, errPrefix string) (exit bool) {
+	list, err := ParseShellInput(input)
+	if err != nil {
+		fs.logger.Debug("%s: Failed to parse %s: %s", fs.osshSession.LogID(), glog.Wrap(input, glog.LightBlue), glog.Error(err))
+		fs.RecordErrorLn(fmt.Sprintf("%s: %s", errPrefix, err.Error()))
+		fs.exitCode = 2
+		return false
+	}
+
+	return fs.runList(list)
+}
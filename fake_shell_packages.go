@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	packageMinBinary = 4 * 1024  // the binaries of installed packages are at least this large
+	packageMaxBinary = 64 * 1024 // and at most this large, no matter the size of the package
+)
+
+// packageDBFiles are the files the package managers keep the installed packages in. Except for apk,
+// whose database has a format of its own, they are written in the format of dpkg's status file.
+var packageDBFiles = map[string]string{
+	"apt":  "/var/lib/dpkg/status",
+	"yum":  "/var/lib/rpm/Packages",
+	"apk":  "/lib/apk/db/installed",
+	"opkg": "/usr/lib/opkg/status",
+}
+
+// packageDB is the state of the package manager of the system the session is logged into. It lives in the FFS,
+// so packages a bot installs stay installed. As long as the file doesn't exist the packages of the catalog
+// that are marked as installed are.
+type packageDB struct {
+	fs        *FakeShell
+	catalog   *PersonaPackages
+	installed map[string]string // name => version
+}
+
+// loadPackageDB reads the installed packages from the FFS.
+func (fs *FakeShell) loadPackageDB() *packageDB {
+	db := &packageDB{fs: fs, catalog: fs.ffs.Persona().Packages(), installed: map[string]string{}}
+	data, err := fs.ffs.ReadFile(packageDBFiles[db.catalog.Manager])
+	if err != nil {
+		for _, p := range db.catalog.Packages {
+			if p.Installed {
+				db.installed[p.Name] = p.Version
+			}
+		}
+		return db
+	}
+	for _, stanza := range strings.Split(string(data), "\n\n") {
+		name, version, installed := "", "", true
+		for _, line := range strings.Split(stanza, "\n") {
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			switch key {
+			case "Package", "P":
+				name = value
+			case "Version", "V":
+				version = value
+			case "Status":
+				installed = strings.HasSuffix(value, " installed")
+			}
+		}
+		if name != "" && installed {
+			db.installed[name] = version
+		}
+	}
+	return db
+}
+
+// save writes the installed packages to the FFS.
+func (db *packageDB) save() error {
+	sb := strings.Builder{}
+	for _, name := range db.names() {
+		p := db.catalog.Get(name)
+		desc, size := "", 0
+		if p != nil {
+			desc, size = p.Description, p.Size*3
+		}
+		if db.catalog.Manager == "apk" {
+			sb.WriteString(fmt.Sprintf("P:%s\nV:%s\nA:%s\nI:%d\nT:%s\n\n", name, db.installed[name], db.catalog.Arch, size*1024, desc))
+			continue
+		}
+		status := "install ok installed"
+		if db.catalog.Manager == "opkg" {
+			status = "install user installed"
+		}
+		sb.WriteString(fmt.Sprintf("Package: %s\nStatus: %s\nInstalled-Size: %d\nArchitecture: %s\nVersion: %s\n", name, status, size, db.catalog.Arch, db.installed[name]))
+		if p != nil && len(p.Depends) > 0 {
+			sb.WriteString(fmt.Sprintf("Depends: %s\n", strings.Join(p.Depends, ", ")))
+		}
+		sb.WriteString(fmt.Sprintf("Description: %s\n\n", desc))
+	}
+	file := packageDBFiles[db.catalog.Manager]
+	if err := db.fs.ffs.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return db.fs.ffs.WriteFile(file, []byte(sb.String()), 0644)
+}
+
+// names returns the names of the installed packages in alphabetical order.
+func (db *packageDB) names() []string {
+	names := []string{}
+	for name := range db.installed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isInstalled returns whether the package is installed.
+func (db *packageDB) isInstalled(name string) bool {
+	_, ok := db.installed[name]
+	return ok
+}
+
+// plan returns what installing the packages takes: the packages that have to be installed (dependencies first),
+// the requested packages that already are and the names the catalog doesn't know. Names can carry a version
+// or an architecture like apt accepts them (curl=7.81.0, curl:amd64).
+func (db *packageDB) plan(names []string, reinstall bool) (install, already []*PersonaPackage, unknown []string) {
+	seen := map[string]bool{}
+	var add func(p *PersonaPackage, requested bool)
+	add = func(p *PersonaPackage, requested bool) {
+		if seen[p.Name] {
+			return
+		}
+		seen[p.Name] = true
+		if db.isInstalled(p.Name) && !(requested && reinstall) {
+			if requested {
+				already = append(already, p)
+			}
+			return
+		}
+		for _, dep := range p.Depends {
+			if d := db.catalog.Get(dep); d != nil {
+				add(d, false)
+			}
+		}
+		install = append(install, p)
+	}
+	for _, name := range names {
+		name = strings.SplitN(strings.SplitN(name, "=", 2)[0], ":", 2)[0]
+		p := db.catalog.Get(name)
+		if p == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		add(p, true)
+	}
+	return install, already, unknown
+}
+
+// install marks the package as installed and puts its binaries into the FFS.
+func (db *packageDB) install(p *PersonaPackage) {
+	db.installed[p.Name] = p.Version
+	for _, bin := range p.Binaries {
+		_ = db.fs.ffs.MkdirAll(filepath.Dir(bin), 0755)
+		_ = db.fs.ffs.WriteFile(bin, packageBinary(p, bin), 0755)
+	}
+}
+
+// remove marks the package as not installed and removes its binaries from the FFS.
+func (db *packageDB) remove(p *PersonaPackage) {
+	delete(db.installed, p.Name)
+	for _, bin := range p.Binaries {
+		if db.fs.ffs.FileExists(bin) {
+			_ = db.fs.ffs.RemoveFile(bin, false)
+		}
+	}
+}
+
+// packageBinary returns the content of a binary of a package: an ELF header followed by noise,
+// the same for the same binary so it can be recognized later on, see packageCommand.
+func packageBinary(p *PersonaPackage, bin string) []byte {
+	size := p.Size * 1024 * 3 / maxInt(len(p.Binaries), 1)
+	size = maxInt(packageMinBinary, minInt(size, packageMaxBinary))
+	sum := sha256.Sum256([]byte(p.Name + "/" + p.Version + "/" + bin))
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8])))).Read(data)
+	copy(data, "\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	return data
+}
+
+// packageCommand returns the command an installed binary of a package provides, empty if the path isn't one.
+// Binaries of packages that are part of the system from the start don't exist in the FFS, binaries that have been
+// replaced don't belong to the package anymore.
+func (fs *FakeShell) packageCommand(file string) string {
+	name := filepath.Base(file)
+	candidates := fs.ffs.Persona().Packages().Provides(name)
+	if len(candidates) == 0 {
+		return ""
+	}
+	db := fs.loadPackageDB()
+	for _, p := range candidates {
+		if !db.isInstalled(p.Name) || indexOf(p.Binaries, file) < 0 {
+			continue
+		}
+		if !fs.ffs.FileExists(file) && !fs.ffs.DirExists(file) {
+			return name
+		}
+		if data, err := fs.ffs.ReadFile(file); err == nil && string(data) == string(packageBinary(p, file)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// execPackageCommand runs a binary of an installed package, it behaves like the command of the same name.
+// Packages without a built-in command or a template do nothing.
+func (fs *FakeShell) execPackageCommand(name string, args []string) (exit bool) {
+	args = append([]string{name}, args[1:]...)
+	if cmd, found := CmdLookup[name]; found {
+		return cmd(fs, args)
+	}
+	if indexOf(TemplateNames(fs.templateDirs()...), name) < 0 {
+		return false
+	}
+	data := fs.templateData(args)
+	output, err := RenderTemplate(name, data, fs.templateFunctions(data), fs.templateDirs()...)
+	if err == nil && output != "" {
+		fs.RecordWriteLn(output)
+	}
+	return false
+}
+
+// packageMissing returns true if the command belongs to packages of the catalog that aren't installed.
+// Such commands don't exist until a package that provides them is installed.
+func (fs *FakeShell) packageMissing(command string) bool {
+	candidates := fs.ffs.Persona().Packages().Provides(command)
+	if len(candidates) == 0 {
+		return false
+	}
+	db := fs.loadPackageDB()
+	for _, p := range candidates {
+		if db.isInstalled(p.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandPaths returns where the command is found in the $PATH, like which does. Besides the executables
+// of the FFS these are the binaries of the installed packages and, for the commands oSSH answers to that
+// no package provides, a file in /usr/bin.
+func (fs *FakeShell) commandPaths(command string, all bool) []string {
+	if strings.Contains(command, "/") {
+		p := toAbs(fs, command)
+		if info, err := fs.ffs.Stat(p); err == nil && !info.IsDir() && info.Mode().Perm()&0111 != 0 {
+			return []string{command}
+		}
+		return nil
+	}
+
+	db := fs.loadPackageDB()
+	paths := []string{}
+	for _, dir := range strings.Split(fs.env.Value("PATH"), ":") {
+		if dir == "" {
+			dir = "."
+		}
+		p := path.Join(dir, command)
+		found := false
+		if info, err := fs.ffs.Stat(toAbs(fs, p)); err == nil && !info.IsDir() && info.Mode().Perm()&0111 != 0 {
+			found = true
+		}
+		for _, pkg := range db.catalog.Provides(command) {
+			if db.isInstalled(pkg.Name) && indexOf(pkg.Binaries, toAbs(fs, p)) >= 0 {
+				found = true
+			}
+		}
+		if found {
+			paths = append(paths, p)
+			if !all {
+				return paths
+			}
+		}
+	}
+	if len(paths) > 0 || fs.packageMissing(command) || sudoBuiltins[command] || command == "history" {
+		return paths
+	}
+
+	known := false
+	if _, ok := CmdLookup[command]; ok {
+		known = true
+	} else if indexOf(TemplateNames(fs.templateDirs()...), command) >= 0 {
+		known = true
+	} else if m := fs.matchRule(command); m != nil {
+		known = m.rule.kind != ruleExit && m.rule.kind != ruleCommandNotFound && m.rule.kind != ruleFileNotFound
+	}
+	if known {
+		paths = append(paths, "/usr/bin/"+command)
+	}
+	return paths
+}
+
+// cmdWhich prints where commands are found in the $PATH.
+func cmdWhich(fs *FakeShell, args []string) (exit bool) {
+	all := false
+	names := []string{}
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			names = append(names, args[i+2:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" && len(names) == 0 {
+			for _, c := range arg[1:] {
+				if c != 'a' {
+					fs.RecordErrorLn(fmt.Sprintf("Illegal option -%c", c))
+					fs.RecordErrorLn("Usage: /usr/bin/which [-a] args")
+					fs.exitCode = 2
+					return
+				}
+				all = true
+			}
+			continue
+		}
+		names = append(names, arg)
+	}
+	if len(names) == 0 {
+		fs.exitCode = 1
+		return
+	}
+	for _, name := range names {
+		paths := fs.commandPaths(name, all)
+		if len(paths) == 0 {
+			fs.exitCode = 1
+		}
+		for _, p := range paths {
+			fs.RecordWriteLn(p)
+		}
+	}
+	return
+}
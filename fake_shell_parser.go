@@ -0,0 +1,985 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The fake shell understands a POSIX-ish subset of the shell grammar:
+//
+//	list     := and_or ( ( ';' | '&' | newline ) and_or )*
+//	and_or   := pipeline ( ( '&&' | '||' ) pipeline )*
+//	pipeline := [ '!' ] command ( '|' command )*
+//	command  := ( '(' list ')' | '{' list '}' | if | loop | for | case ) redirect* | simple
+//	if       := 'if' list 'then' list ( 'elif' list 'then' list )* [ 'else' list ] 'fi'
+//	loop     := ( 'while' | 'until' ) list 'do' list 'done'
+//	for      := 'for' name [ 'in' word* ] ( ';' | newline ) 'do' list 'done'
+//	case     := 'case' word 'in' ( [ '(' ] word ( '|' word )* ')' list [ ';;' ] )* 'esac'
+//	simple   := ( assignment | redirect )* ( word | redirect )*
+//
+// Words keep their quotes, expansion and quote removal happen when a command is executed.
+
+var regexShellAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// shellOperators lists all operators, longer ones first so they take precedence.
+var shellOperators = []string{
+	"<<<", "<<-", "&>>",
+	"&&", "||", ";;", "&>", ">>", ">|", ">&", "<<", "<&", "<>",
+	"<", ">", "|", "&", ";", "(", ")",
+}
+
+type ShellSyntaxError struct {
+	msg        string
+	Incomplete bool // the input ended before the construct was closed, more input might fix it
+}
+
+func (e *ShellSyntaxError) Error() string {
+	return e.msg
+}
+
+func newShellSyntaxError(incomplete bool, format string, a ...interface{}) *ShellSyntaxError {
+	return &ShellSyntaxError{
+		msg:        fmt.Sprintf(format, a...),
+		Incomplete: incomplete,
+	}
+}
+
+type shellTokenType int
+
+const (
+	shellTokenWord shellTokenType = iota
+	shellTokenOperator
+	shellTokenNewline
+	shellTokenEOF
+)
+
+type shellToken struct {
+	typ   shellTokenType
+	val   string
+	fd    int // file descriptor prefix of redirection operators (e.g. the 2 in 2>), -1 if absent
+	start int
+	end   int
+}
+
+func (t shellToken) String() string {
+	switch t.typ {
+	case shellTokenNewline:
+		return "newline"
+	case shellTokenEOF:
+		return "EOF"
+	}
+	return t.val
+}
+
+func (t shellToken) isOperator(ops ...string) bool {
+	if t.typ != shellTokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			return true
+		}
+	}
+	return false
+}
+
+// isReserved checks if the token is an unquoted reserved word such as `{`, `}` or `!`.
+func (t shellToken) isReserved(word string) bool {
+	return t.typ == shellTokenWord && t.val == word
+}
+
+func (t shellToken) isRedirect() bool {
+	return t.isOperator("<", ">", ">>", ">|", "<>", "<&", ">&", "&>", "&>>", "<<", "<<-", "<<<")
+}
+
+type ShellWord string
+
+type ShellRedirect struct {
+	Fd      int    // file descriptor the redirection applies to, -1 means the operator's default
+	Op      string // one of <, >, >>, >|, <>, <&, >&, &>, &>>, <<, <<-, <<<
+	Target  ShellWord
+	HereDoc string // the body of a here-document
+	Expand  bool   // whether expansions are performed on the here-document, false if the delimiter was quoted
+}
+
+type ShellCommand interface {
+	redirects() []*ShellRedirect
+}
+
+type ShellSimpleCommand struct {
+	Assignments []ShellWord
+	Words       []ShellWord
+	Redirects   []*ShellRedirect
+	Raw         string // the source text of the command
+}
+
+func (c *ShellSimpleCommand) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellSubshell is a list in parentheses, changes it makes to the shell state are discarded.
+type ShellSubshell struct {
+	List      *ShellList
+	Redirects []*ShellRedirect
+}
+
+func (c *ShellSubshell) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellGroup is a list in curly braces, it is executed in the current shell.
+type ShellGroup struct {
+	List      *ShellList
+	Redirects []*ShellRedirect
+}
+
+func (c *ShellGroup) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellIf is an if command, Bodies[i] runs if Conditions[i] succeeds.
+// If there is one body more than there are conditions, it's the else branch.
+type ShellIf struct {
+	Conditions []*ShellList
+	Bodies     []*ShellList
+	Redirects  []*ShellRedirect
+}
+
+func (c *ShellIf) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellLoop is a while loop, or an until loop if Until is set.
+type ShellLoop struct {
+	Condition *ShellList
+	Body      *ShellList
+	Until     bool
+	Redirects []*ShellRedirect
+}
+
+func (c *ShellLoop) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellFor is a for loop, without the `in` part it iterates over the positional parameters.
+type ShellFor struct {
+	Name      string
+	Words     []ShellWord
+	In        bool
+	Body      *ShellList
+	Redirects []*ShellRedirect
+}
+
+func (c *ShellFor) redirects() []*ShellRedirect { return c.Redirects }
+
+// ShellCase is a case command, the body of the first item with a matching pattern runs.
+type ShellCase struct {
+	Word      ShellWord
+	Items     []*ShellCaseItem
+	Redirects []*ShellRedirect
+}
+
+func (c *ShellCase) redirects() []*ShellRedirect { return c.Redirects }
+
+type ShellCaseItem struct {
+	Patterns []ShellWord
+	Body     *ShellList
+}
+
+type ShellPipeline struct {
+	Commands []ShellCommand
+	Negate   bool
+}
+
+type ShellAndOr struct {
+	Pipelines  []*ShellPipeline
+	Operators  []string // Operators[i] joins Pipelines[i] and Pipelines[i+1], either && or ||
+	Background bool
+}
+
+type ShellList struct {
+	Items []*ShellAndOr
+}
+
+type shellLexer struct {
+	src      string
+	pos      int
+	hereDocs []*ShellRedirect // here-documents whose bodies start after the next newline
+}
+
+func isShellBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isShellMeta(c byte) bool {
+	return isShellBlank(c) || strings.IndexByte("\n;&|()<>", c) >= 0
+}
+
+func (l *shellLexer) peekByte(offset int) byte {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *shellLexer) skipBlanks() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isShellBlank(c):
+			l.pos++
+		case c == '\\' && l.peekByte(1) == '\n':
+			l.pos += 2 // line continuation
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *shellLexer) skipSingleQuoted() error {
+	end := strings.IndexByte(l.src[l.pos+1:], '\'')
+	if end < 0 {
+		l.pos = len(l.src)
+		return newShellSyntaxError(true, "unexpected EOF while looking for matching `''")
+	}
+	l.pos += end + 2
+	return nil
+}
+
+func (l *shellLexer) skipDoubleQuoted() error {
+	l.pos++
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\\':
+			l.pos += 2
+		case c == '"':
+			l.pos++
+			return nil
+		case c == '`':
+			if err := l.skipBackticks(); err != nil {
+				return err
+			}
+		case c == '$' && (l.peekByte(1) == '(' || l.peekByte(1) == '{'):
+			if err := l.skipDollar(); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+	return newShellSyntaxError(true, "unexpected EOF while looking for matching `\"'")
+}
+
+func (l *shellLexer) skipBackticks() error {
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '`':
+			l.pos++
+			return nil
+		default:
+			l.pos++
+		}
+	}
+	return newShellSyntaxError(true, "unexpected EOF while looking for matching ``'")
+}
+
+// skipDollar skips a $(...), $((...)) or ${...} expression, including nested ones.
+func (l *shellLexer) skipDollar() error {
+	open, close := l.peekByte(1), byte(')')
+	if open == '{' {
+		close = '}'
+	}
+	l.pos += 2
+	depth := 1
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\\':
+			l.pos += 2
+		case c == '\'' && open == '(':
+			if err := l.skipSingleQuoted(); err != nil {
+				return err
+			}
+		case c == '"':
+			if err := l.skipDoubleQuoted(); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := l.skipBackticks(); err != nil {
+				return err
+			}
+		case c == '$' && (l.peekByte(1) == '(' || l.peekByte(1) == '{'):
+			if err := l.skipDollar(); err != nil {
+				return err
+			}
+		case c == open:
+			depth++
+			l.pos++
+		case c == close:
+			depth--
+			l.pos++
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.pos++
+		}
+	}
+	return newShellSyntaxError(true, "unexpected EOF while looking for matching `%c'", close)
+}
+
+func (l *shellLexer) scanWord() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		var err error
+		switch {
+		case c == '\\':
+			l.pos += 2
+		case c == '\'':
+			err = l.skipSingleQuoted()
+		case c == '"':
+			err = l.skipDoubleQuoted()
+		case c == '`':
+			err = l.skipBackticks()
+		case c == '$' && (l.peekByte(1) == '(' || l.peekByte(1) == '{'):
+			err = l.skipDollar()
+		case isShellMeta(c):
+			return nil
+		default:
+			l.pos++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if l.pos > len(l.src) {
+		l.pos = len(l.src) // a trailing backslash
+	}
+	return nil
+}
+
+func (l *shellLexer) next() (shellToken, error) {
+	l.skipBlanks()
+	start := l.pos
+	if l.pos >= len(l.src) {
+		if len(l.hereDocs) > 0 {
+			return shellToken{}, newShellSyntaxError(true, "warning: here-document delimited by end-of-file (wanted `%s')", l.hereDocs[0].Target)
+		}
+		return shellToken{typ: shellTokenEOF, fd: -1, start: start, end: start}, nil
+	}
+
+	if l.src[l.pos] == '\n' {
+		l.pos++
+		if err := l.readHereDocs(); err != nil {
+			return shellToken{}, err
+		}
+		return shellToken{typ: shellTokenNewline, val: "\n", fd: -1, start: start, end: l.pos}, nil
+	}
+
+	// a sequence of digits directly followed by < or > is the fd of a redirection
+	fd := -1
+	digits := 0
+	for l.pos+digits < len(l.src) && l.src[l.pos+digits] >= '0' && l.src[l.pos+digits] <= '9' {
+		digits++
+	}
+	if digits > 0 && (l.peekByte(digits) == '<' || l.peekByte(digits) == '>') {
+		fd, _ = strconv.Atoi(l.src[l.pos : l.pos+digits])
+		l.pos += digits
+	}
+
+	for _, op := range shellOperators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return shellToken{typ: shellTokenOperator, val: op, fd: fd, start: start, end: l.pos}, nil
+		}
+	}
+
+	if err := l.scanWord(); err != nil {
+		return shellToken{}, err
+	}
+	return shellToken{typ: shellTokenWord, val: l.src[start:l.pos], fd: -1, start: start, end: l.pos}, nil
+}
+
+// unquoteHereDocDelimiter removes the quotes of a here-document delimiter.
+// If any part of the delimiter was quoted, the body is not expanded.
+func unquoteHereDocDelimiter(word string) (delimiter string, quoted bool) {
+	var sb strings.Builder
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; c {
+		case '\'', '"':
+			quoted = true
+		case '\\':
+			quoted = true
+			if i+1 < len(word) {
+				i++
+				sb.WriteByte(word[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), quoted
+}
+
+// readHereDocs reads the bodies of the pending here-documents, they start at the current position.
+func (l *shellLexer) readHereDocs() error {
+	for len(l.hereDocs) > 0 {
+		r := l.hereDocs[0]
+		delimiter := string(r.Target)
+		var body strings.Builder
+		for {
+			if l.pos >= len(l.src) {
+				return newShellSyntaxError(true, "warning: here-document delimited by end-of-file (wanted `%s')", delimiter)
+			}
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			next := l.pos + end + 1
+			if end < 0 {
+				end = len(l.src) - l.pos
+				next = len(l.src)
+			}
+			line := l.src[l.pos : l.pos+end]
+			l.pos = next
+			if r.Op == "<<-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == delimiter {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		r.HereDoc = body.String()
+		l.hereDocs = l.hereDocs[1:]
+	}
+	return nil
+}
+
+type shellParser struct {
+	lexer    *shellLexer
+	tok      shellToken
+	peeked   bool
+	closedBy string // the reserved word that ended the last body, see parseBody
+}
+
+// isShellReserved checks if the token is a reserved word that can't start a simple command.
+func isShellReserved(t shellToken) bool {
+	for _, word := range []string{"}", "then", "elif", "else", "fi", "do", "done", "esac", "in"} {
+		if t.isReserved(word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *shellParser) peek() (shellToken, error) {
+	if !p.peeked {
+		t, err := p.lexer.next()
+		if err != nil {
+			return t, err
+		}
+		p.tok = t
+		p.peeked = true
+	}
+	return p.tok, nil
+}
+
+func (p *shellParser) advance() (shellToken, error) {
+	t, err := p.peek()
+	p.peeked = false
+	return t, err
+}
+
+func (p *shellParser) unexpected(t shellToken) error {
+	if t.typ == shellTokenEOF {
+		return newShellSyntaxError(true, "syntax error: unexpected end of file")
+	}
+	return newShellSyntaxError(false, "syntax error near unexpected token `%s'", t)
+}
+
+// skipNewlines consumes any number of newlines, e.g. after && or |.
+func (p *shellParser) skipNewlines() error {
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if t.typ != shellTokenNewline {
+			return nil
+		}
+		_, _ = p.advance()
+	}
+}
+
+// isListEnd checks if the token terminates the list that is currently parsed.
+func isListEnd(t shellToken, terminators ...string) bool {
+	if t.typ == shellTokenEOF {
+		return true
+	}
+	for _, terminator := range terminators {
+		if t.isOperator(terminator) || t.isReserved(terminator) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *shellParser) parseList(terminators ...string) (*ShellList, error) {
+	list := &ShellList{}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if isListEnd(t, terminators...) {
+			return list, nil
+		}
+
+		ao, err := p.parseAndOr()
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, ao)
+
+		t, err = p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.isOperator(";"), t.typ == shellTokenNewline:
+			_, _ = p.advance()
+		case t.isOperator("&"):
+			_, _ = p.advance()
+			ao.Background = true
+		case isListEnd(t, terminators...):
+			return list, nil
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+}
+
+func (p *shellParser) parseAndOr() (*ShellAndOr, error) {
+	ao := &ShellAndOr{}
+	for {
+		pl, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		ao.Pipelines = append(ao.Pipelines, pl)
+
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !t.isOperator("&&", "||") {
+			return ao, nil
+		}
+		_, _ = p.advance()
+		ao.Operators = append(ao.Operators, t.val)
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *shellParser) parsePipeline() (*ShellPipeline, error) {
+	pl := &ShellPipeline{}
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t.isReserved("!") {
+		_, _ = p.advance()
+		pl.Negate = true
+	}
+	for {
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		pl.Commands = append(pl.Commands, cmd)
+
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !t.isOperator("|") {
+			return pl, nil
+		}
+		_, _ = p.advance()
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *shellParser) parseCompound(closing string) (*ShellList, []*ShellRedirect, error) {
+	_, _ = p.advance() // the opening ( or {
+	list, err := p.parseBody(closing)
+	if err != nil {
+		return nil, nil, err
+	}
+	redirects, err := p.parseRedirects()
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, redirects, nil
+}
+
+// parseBody parses a list that must not be empty and the reserved word (or operator) that ends it.
+// If several words can end it, the one that did is stored in closedBy.
+func (p *shellParser) parseBody(closing ...string) (*ShellList, error) {
+	list, err := p.parseList(closing...)
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ == shellTokenEOF || !isListEnd(t, closing...) || len(list.Items) == 0 {
+		return nil, p.unexpected(t)
+	}
+	p.closedBy = t.val
+	return list, nil
+}
+
+// expectReserved consumes the reserved word, anything else is a syntax error.
+func (p *shellParser) expectReserved(word string) error {
+	t, err := p.advance()
+	if err != nil {
+		return err
+	}
+	if !t.isReserved(word) {
+		return p.unexpected(t)
+	}
+	return nil
+}
+
+// parseRedirects parses the redirections that follow a compound command.
+func (p *shellParser) parseRedirects() ([]*ShellRedirect, error) {
+	var redirects []*ShellRedirect
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !t.isRedirect() {
+			return redirects, nil
+		}
+		r, err := p.parseRedirect()
+		if err != nil {
+			return nil, err
+		}
+		redirects = append(redirects, r)
+	}
+}
+
+func (p *shellParser) parseIf() (*ShellIf, error) {
+	cmd := &ShellIf{}
+	_, _ = p.advance() // if
+	for {
+		condition, err := p.parseBody("then")
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parseBody("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+		cmd.Conditions = append(cmd.Conditions, condition)
+		cmd.Bodies = append(cmd.Bodies, body)
+
+		switch p.closedBy {
+		case "else":
+			body, err := p.parseBody("fi")
+			if err != nil {
+				return nil, err
+			}
+			cmd.Bodies = append(cmd.Bodies, body)
+			fallthrough
+		case "fi":
+			redirects, err := p.parseRedirects()
+			cmd.Redirects = redirects
+			return cmd, err
+		}
+	}
+}
+
+func (p *shellParser) parseLoop() (*ShellLoop, error) {
+	t, _ := p.advance() // while or until
+	condition, err := p.parseBody("do")
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody("done")
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := p.parseRedirects()
+	if err != nil {
+		return nil, err
+	}
+	return &ShellLoop{Condition: condition, Body: body, Until: t.val == "until", Redirects: redirects}, nil
+}
+
+func (p *shellParser) parseFor() (*ShellFor, error) {
+	_, _ = p.advance() // for
+	t, err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ != shellTokenWord || !regexShellAssignment.MatchString(t.val+"=") {
+		if t.typ == shellTokenWord {
+			return nil, newShellSyntaxError(false, "`%s': not a valid identifier", t.val)
+		}
+		return nil, p.unexpected(t)
+	}
+	cmd := &ShellFor{Name: t.val}
+
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if t, err = p.peek(); err != nil {
+		return nil, err
+	}
+	if t.isReserved("in") {
+		_, _ = p.advance()
+		cmd.In = true
+		for {
+			t, err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+			if t.isOperator(";") || t.typ == shellTokenNewline {
+				break
+			}
+			if t.typ != shellTokenWord {
+				return nil, p.unexpected(t)
+			}
+			cmd.Words = append(cmd.Words, ShellWord(t.val))
+		}
+	} else if t.isOperator(";") {
+		_, _ = p.advance()
+	}
+
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if err := p.expectReserved("do"); err != nil {
+		return nil, err
+	}
+	if cmd.Body, err = p.parseBody("done"); err != nil {
+		return nil, err
+	}
+	cmd.Redirects, err = p.parseRedirects()
+	return cmd, err
+}
+
+func (p *shellParser) parseCase() (*ShellCase, error) {
+	_, _ = p.advance() // case
+	t, err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ != shellTokenWord {
+		return nil, p.unexpected(t)
+	}
+	cmd := &ShellCase{Word: ShellWord(t.val)}
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if err := p.expectReserved("in"); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		t, err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		if t.isReserved("esac") {
+			break
+		}
+		if t.isOperator("(") {
+			if t, err = p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		// the patterns, separated by | and terminated by )
+		item := &ShellCaseItem{}
+		for {
+			if t.typ != shellTokenWord {
+				return nil, p.unexpected(t)
+			}
+			item.Patterns = append(item.Patterns, ShellWord(t.val))
+			if t, err = p.advance(); err != nil {
+				return nil, err
+			}
+			if t.isOperator(")") {
+				break
+			}
+			if !t.isOperator("|") {
+				return nil, p.unexpected(t)
+			}
+			if t, err = p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		if item.Body, err = p.parseList(";;", "esac"); err != nil {
+			return nil, err
+		}
+		cmd.Items = append(cmd.Items, item)
+		if t, err = p.advance(); err != nil {
+			return nil, err
+		}
+		if t.isReserved("esac") {
+			break
+		}
+		if !t.isOperator(";;") {
+			return nil, p.unexpected(t)
+		}
+	}
+	cmd.Redirects, err = p.parseRedirects()
+	return cmd, err
+}
+
+func (p *shellParser) parseCommand() (ShellCommand, error) {
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t.isOperator("(") {
+		list, redirects, err := p.parseCompound(")")
+		if err != nil {
+			return nil, err
+		}
+		return &ShellSubshell{List: list, Redirects: redirects}, nil
+	}
+	if t.isReserved("{") {
+		list, redirects, err := p.parseCompound("}")
+		if err != nil {
+			return nil, err
+		}
+		return &ShellGroup{List: list, Redirects: redirects}, nil
+	}
+	switch {
+	case t.isReserved("if"):
+		return p.parseIf()
+	case t.isReserved("while"), t.isReserved("until"):
+		return p.parseLoop()
+	case t.isReserved("for"):
+		return p.parseFor()
+	case t.isReserved("case"):
+		return p.parseCase()
+	}
+	return p.parseSimpleCommand()
+}
+
+func (p *shellParser) parseRedirect() (*ShellRedirect, error) {
+	op, _ := p.advance()
+	t, err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ != shellTokenWord {
+		return nil, p.unexpected(t)
+	}
+	r := &ShellRedirect{
+		Fd:     op.fd,
+		Op:     op.val,
+		Target: ShellWord(t.val),
+	}
+	if op.isOperator("<<", "<<-") {
+		delimiter, quoted := unquoteHereDocDelimiter(t.val)
+		r.Target = ShellWord(delimiter)
+		r.Expand = !quoted
+		p.lexer.hereDocs = append(p.lexer.hereDocs, r)
+	}
+	return r, nil
+}
+
+func (p *shellParser) parseSimpleCommand() (*ShellSimpleCommand, error) {
+	cmd := &ShellSimpleCommand{}
+	start, end := -1, -1
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if start < 0 {
+			start = t.start
+		}
+
+		switch {
+		case t.isRedirect():
+			r, err := p.parseRedirect()
+			if err != nil {
+				return nil, err
+			}
+			cmd.Redirects = append(cmd.Redirects, r)
+			end = p.lexer.pos
+		case t.typ == shellTokenWord:
+			// reserved words like `}` or `done` only end a compound command when they are not an argument
+			if len(cmd.Words) == 0 && len(cmd.Assignments) == 0 && isShellReserved(t) {
+				return nil, p.unexpected(t)
+			}
+			_, _ = p.advance()
+			if len(cmd.Words) == 0 && regexShellAssignment.MatchString(t.val) {
+				cmd.Assignments = append(cmd.Assignments, ShellWord(t.val))
+			} else {
+				cmd.Words = append(cmd.Words, ShellWord(t.val))
+			}
+			end = t.end
+		default:
+			if end < 0 {
+				return nil, p.unexpected(t)
+			}
+			cmd.Raw = strings.TrimSpace(p.lexer.src[start:end])
+			return cmd, nil
+		}
+	}
+}
+
+// ParseShellInput parses the given input into a list of commands.
+// If parsing fails a *ShellSyntaxError is returned.
+func ParseShellInput(input string) (*ShellList, error) {
+	p := &shellParser{
+		lexer: &shellLexer{
+			src: input,
+			pos: 0,
+		},
+	}
+	list, err := p.parseList("")
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t.typ != shellTokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return list, nil
+}
+
+// IsShellInputIncomplete checks if the input needs more lines to be complete,
+// e.g. because of an open quote, a trailing backslash or a missing here-document delimiter.
+func IsShellInputIncomplete(input string) bool {
+	trailing := len(input) - len(strings.TrimRight(input, "\\"))
+	if trailing%2 == 1 {
+		return true
+	}
+	_, err := ParseShellInput(input)
+	var serr *ShellSyntaxError
+	return errors.As(err, &serr) && serr.Incomplete
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/toxyl/glog"
+)
+
+func init() {
+	CmdLookup["crontab"] = cmdCrontab
+}
+
+// fakeCrontabDir is where crontab keeps the crontabs of the users, like on Debian.
+const fakeCrontabDir = "/var/spool/cron/crontabs"
+
+// persistenceLocation is a kind of place bots write to in order to survive a reboot or to get back in.
+type persistenceLocation struct {
+	kind    string
+	match   func(path string) bool
+	entries func(content string) []string // the lines that are worth reporting
+}
+
+// hasPathPrefix returns true if the path is inside one of the directories.
+func hasPathPrefix(path string, dirs ...string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// significantLines returns the lines that are neither empty nor comments.
+func significantLines(content string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+var persistenceLocations = []persistenceLocation{
+	{
+		kind: "authorized_keys",
+		match: func(path string) bool {
+			return strings.HasSuffix(path, "/.ssh/authorized_keys") || strings.HasSuffix(path, "/.ssh/authorized_keys2")
+		},
+		entries: significantLines,
+	},
+	{
+		kind: "cron",
+		match: func(path string) bool {
+			return path == "/etc/crontab" || hasPathPrefix(path,
+				"/etc/cron.d", "/etc/cron.hourly", "/etc/cron.daily", "/etc/cron.weekly", "/etc/cron.monthly",
+				"/var/spool/cron", "/etc/crontabs")
+		},
+		entries: significantLines,
+	},
+	{
+		kind: "systemd",
+		match: func(path string) bool {
+			return hasPathPrefix(path, "/etc/systemd", "/lib/systemd/system", "/usr/lib/systemd/system", "/run/systemd/system") ||
+				strings.Contains(path, "/.config/systemd/user/")
+		},
+		entries: func(content string) []string {
+			lines := []string{}
+			for _, line := range significantLines(content) {
+				if strings.HasPrefix(line, "Exec") {
+					lines = append(lines, line)
+				}
+			}
+			return lines
+		},
+	},
+	{
+		kind: "rc.local",
+		match: func(path string) bool {
+			if path == "/etc/rc.local" || hasPathPrefix(path, "/etc/init.d", "/etc/rc.d") {
+				return true
+			}
+			matched, _ := filepath.Match("/etc/rc[0-6S].d/*", path)
+			return matched
+		},
+		entries: significantLines,
+	},
+}
+
+// persistenceLocationOf returns the persistence location the path belongs to, nil if it isn't one.
+func persistenceLocationOf(path string) *persistenceLocation {
+	for i := range persistenceLocations {
+		if persistenceLocations[i].match(path) {
+			return &persistenceLocations[i]
+		}
+	}
+	return nil
+}
+
+// watchPersistence remembers the content of a file that is about to be written. The returned function reports
+// what was added to it, if the file is a persistence location, and must be called once the file has been written.
+func (fs *FakeShell) watchPersistence(path string) func() {
+	loc := persistenceLocationOf(path)
+	if loc == nil {
+		return func() {}
+	}
+	before, _ := fs.ffs.ReadFile(path)
+	return func() {
+		fs.detectPersistence(loc, path, string(before))
+	}
+}
+
+// detectPersistence records a persistence event for every entry that was added to the file.
+// SSH keys are saved along with the ones bots log in with.
+func (fs *FakeShell) detectPersistence(loc *persistenceLocation, path, before string) {
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		return
+	}
+	known := map[string]bool{}
+	for _, entry := range loc.entries(before) {
+		known[entry] = true
+	}
+	hash := ""
+	for _, entry := range loc.entries(string(data)) {
+		if known[entry] {
+			continue
+		}
+		known[entry] = true
+		if hash == "" {
+			hash = SaveSample(data)
+		}
+		fs.RecordEvent("persistence", fmt.Sprintf("%s %s: %s", loc.kind, path, entry), hash)
+		if loc.kind == "authorized_keys" {
+			fs.captureSSHKey(entry)
+		}
+	}
+}
+
+// captureSSHKey saves the key of an authorized_keys line.
+func (fs *FakeShell) captureSSHKey(line string) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil || fs.osshSession.Whitelisted {
+		return
+	}
+	if fpath, isNew := saveSSHKey(key); isNew {
+		fs.logger.OK("%s: SSH key saved to %s", fs.osshSession.LogID(), glog.File(fpath))
+	}
+}
+
+func crontabUsage(fs *FakeShell) {
+	fs.RecordErrorLn("usage:\tcrontab [-u user] file")
+	fs.RecordErrorLn("\tcrontab [ -u user ] [ -i ] { -e | -l | -r }")
+	fs.RecordErrorLn("\t\t(default operation is replace, per 1003.2)")
+	fs.RecordErrorLn("\t-e\t(edit user's crontab)")
+	fs.RecordErrorLn("\t-l\t(list user's crontab)")
+	fs.RecordErrorLn("\t-r\t(delete user's crontab)")
+	fs.RecordErrorLn("\t-i\t(prompt before deleting user's crontab)")
+}
+
+// checkCrontab returns the error cron reports for the first line that isn't a valid crontab entry.
+func checkCrontab(name, content string) string {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		return "new crontab file is missing newline before EOF, can't install."
+	}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if eq := strings.IndexByte(line, '='); eq > 0 && !strings.ContainsAny(line[:eq], " \t") {
+			continue // an environment setting, e.g. SHELL=/bin/sh
+		}
+		if strings.HasPrefix(fields[0], "@") {
+			if len(fields) < 2 {
+				return fmt.Sprintf("\"%s\":%d: bad command\nerrors in crontab file, can't install.", name, i)
+			}
+			continue
+		}
+		for f, field := range []string{"minute", "hour", "day-of-month", "month", "day-of-week"} {
+			allowed := "0123456789*,-/"
+			if f > 2 {
+				allowed += "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" // e.g. jan or mon-fri
+			}
+			if len(fields) <= f || strings.Trim(fields[f], allowed) != "" {
+				return fmt.Sprintf("\"%s\":%d: bad %s\nerrors in crontab file, can't install.", name, i, field)
+			}
+		}
+		if len(fields) < 6 {
+			return fmt.Sprintf("\"%s\":%d: bad command\nerrors in crontab file, can't install.", name, i)
+		}
+	}
+	return ""
+}
+
+// editCrontab lets the user edit a copy of the crontab in the editor and installs it if it changed.
+func (fs *FakeShell) editCrontab(user, path string) {
+	before, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		fs.RecordStderrLn(fmt.Sprintf("no crontab for %s - using an empty one", user))
+	}
+	dir := fmt.Sprintf("/tmp/crontab.%06d", rand.Intn(1000000))
+	tmp := dir + "/crontab"
+	_ = fs.ffs.MkdirAll(dir, 0700)
+	defer func() { _ = fs.ffs.RemoveFile(dir, true) }()
+	if err := fs.ffs.WriteFile(tmp, before, 0600); err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("crontab: %s: %s", tmp, errorString(err)))
+		return
+	}
+	if !fs.editFile(tmp) {
+		// without a terminal the editor can't change anything
+		fs.RecordStderrLn("No modification made")
+		return
+	}
+	after, err := fs.ffs.ReadFile(tmp)
+	if err != nil || string(after) == string(before) {
+		fs.RecordStderrLn("No modification made")
+		return
+	}
+	if msg := checkCrontab(tmp, string(after)); msg != "" {
+		fs.RecordErrorLn(msg)
+		return
+	}
+	fs.RecordStderrLn("crontab: installing new crontab")
+	written := fs.watchPersistence(path)
+	if err := fs.ffs.WriteFile(path, after, 0600); err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("crontab: %s: %s", path, errorString(err)))
+		return
+	}
+	written()
+}
+
+// cmdCrontab lists, edits, removes and installs the crontab of a user, installed crontabs are reported as persistence.
+func cmdCrontab(fs *FakeShell, args []string) (exit bool) {
+	opts, operands, bad := parseOpts(args[1:], "elri", "u")
+	if bad != "" {
+		fs.RecordErrorLn(fmt.Sprintf("crontab: invalid option -- '%s'", bad))
+		crontabUsage(fs)
+		return
+	}
+	user := fs.User()
+	if u, ok := opts['u']; ok {
+		if !fs.isRoot() {
+			fs.RecordErrorLn("must be privileged to use -u")
+			return
+		}
+		if _, _, ok := fs.lookupUser(u); !ok {
+			fs.RecordErrorLn(fmt.Sprintf("crontab: user `%s' unknown", u))
+			return
+		}
+		user = u
+	}
+	path := filepath.Join(fakeCrontabDir, user)
+	_, edit := opts['e']
+	_, list := opts['l']
+	_, remove := opts['r']
+
+	switch {
+	case list:
+		data, err := fs.ffs.ReadFile(path)
+		if err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("no crontab for %s", user))
+			return
+		}
+		if content := strings.TrimSuffix(string(data), "\n"); content != "" {
+			fs.RecordWriteLn(content)
+		}
+	case remove:
+		if err := fs.ffs.RemoveFile(path, false); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("no crontab for %s", user))
+		}
+	case edit:
+		fs.editCrontab(user, path)
+	default:
+		name, content := "-", ""
+		if len(operands) > 0 && operands[0] != "-" {
+			name = operands[0]
+			data, err := fs.ffs.ReadFile(toAbs(fs, name))
+			if err != nil {
+				fs.RecordErrorLn(fmt.Sprintf("%s: %s", name, errorString(err)))
+				return
+			}
+			content = string(data)
+		} else if stdin, ok := fs.Stdin(); ok {
+			content = stdin
+		} else {
+			return // crontab would read the terminal until it is closed
+		}
+		if msg := checkCrontab(name, content); msg != "" {
+			fs.RecordErrorLn(msg)
+			return
+		}
+		written := fs.watchPersistence(path)
+		if err := fs.ffs.WriteFile(path, []byte(content), 0600); err != nil {
+			fs.RecordErrorLn(fmt.Sprintf("crontab: %s: %s", path, errorString(err)))
+			return
+		}
+		written()
+	}
+	return
+}
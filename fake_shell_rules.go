@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/toxyl/glog"
+)
+
+// ruleKind is the list of the config a command rule comes from, it decides the response.
+// Rules with the same priority are checked in the order of the kinds.
+type ruleKind int
+
+const (
+	ruleExit ruleKind = iota
+	ruleSimple
+	rulePermissionDenied
+	ruleDiskError
+	ruleCommandNotFound
+	ruleFileNotFound
+	ruleNotImplemented
+	ruleBullshit
+	ruleKinds // the number of kinds
+)
+
+var ruleKindNames = [ruleKinds]string{"exit", "simple", "permission_denied", "disk_error", "command_not_found", "file_not_found", "not_implemented", "bullshit"}
+
+// matchMode is how the pattern of a rule is compared to a command, it is selected with a prefix like "regex:".
+type matchMode int
+
+const (
+	matchPrefix matchMode = iota // the command or the command followed by arguments, the default
+	matchExact                   // the command with exactly these arguments
+	matchRegex                   // a regular expression, the capture groups are available to the response
+	matchGlob                    // a shell pattern for the whole line, each wildcard is a capture group
+)
+
+var matchModes = map[string]matchMode{
+	"prefix": matchPrefix,
+	"exact":  matchExact,
+	"regex":  matchRegex,
+	"re":     matchRegex,
+	"glob":   matchGlob,
+}
+
+// commandRule is an entry of one of the command lists of the config or of a persona.
+type commandRule struct {
+	kind     ruleKind
+	mode     matchMode
+	pattern  string         // without the mode
+	re       *regexp.Regexp // of regex and glob rules
+	response string         // of simple rules
+	priority int
+	persona  bool // rules of a persona come before those of the config
+	order    int  // position in the list
+}
+
+// before reports whether the rule wins over another rule that matches the same command:
+// the higher priority wins, then the rule of the list that is checked first, then the first rule of the list.
+func (r *commandRule) before(o *commandRule) bool {
+	switch {
+	case o == nil:
+		return true
+	case r.priority != o.priority:
+		return r.priority > o.priority
+	case r.kind != o.kind:
+		return r.kind < o.kind
+	case r.persona != o.persona:
+		return r.persona
+	}
+	return r.order < o.order
+}
+
+// name returns the command the rule answers to, empty if the pattern doesn't tell.
+func (r *commandRule) name() string {
+	if r.mode != matchPrefix && r.mode != matchExact {
+		return ""
+	}
+	return strings.SplitN(r.pattern, " ", 2)[0]
+}
+
+// parseCommandRule parses an entry of a command list: the pattern, the response (only simple rules have one)
+// and an optional priority.
+func parseCommandRule(kind ruleKind, entry []string) (*commandRule, error) {
+	if len(entry) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	r := &commandRule{kind: kind, pattern: entry[0]}
+	rest := entry[1:]
+	if kind == ruleSimple {
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("needs a pattern and a response")
+		}
+		r.response, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 1 {
+		return nil, fmt.Errorf("too many values")
+	}
+	if len(rest) == 1 {
+		priority, err := strconv.Atoi(strings.TrimSpace(rest[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q", rest[0])
+		}
+		r.priority = priority
+	}
+
+	if mode, pattern, found := strings.Cut(r.pattern, ":"); found {
+		if m, ok := matchModes[mode]; ok {
+			r.mode, r.pattern = m, pattern
+		}
+	}
+	var err error
+	switch r.mode {
+	case matchPrefix, matchExact:
+		r.pattern = strings.TrimSpace(r.pattern)
+		if r.pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+	case matchRegex:
+		r.re, err = regexp.Compile(r.pattern)
+	case matchGlob:
+		r.re, err = regexp.Compile(globToRegexp(r.pattern))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// globToRegexp converts a shell pattern into an anchored regular expression. `*` matches any text
+// (including spaces and slashes), `?` a single character and `[...]` a character class.
+// Each wildcard becomes a capture group.
+func globToRegexp(glob string) string {
+	sb := strings.Builder{}
+	sb.WriteString("^")
+	chars := []rune(glob)
+	for i := 0; i < len(chars); i++ {
+		switch c := chars[i]; c {
+		case '*':
+			sb.WriteString("(.*)")
+		case '?':
+			sb.WriteString("(.)")
+		case '[':
+			end := i + 1
+			if end < len(chars) && (chars[end] == '!' || chars[end] == '^') {
+				end++
+			}
+			if end < len(chars) && chars[end] == ']' {
+				end++
+			}
+			for end < len(chars) && chars[end] != ']' {
+				end++
+			}
+			if end >= len(chars) {
+				sb.WriteString(regexp.QuoteMeta(string(c))) // no closing bracket, so it's a literal one
+				continue
+			}
+			class := chars[i+1 : end]
+			if class[0] == '!' {
+				class = append([]rune{'^'}, class[1:]...)
+			}
+			sb.WriteString("([" + strings.ReplaceAll(string(class), `\`, `\\`) + "])")
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// ruleTrie indexes the exact and prefix rules by the characters of their patterns.
+type ruleTrie struct {
+	children map[byte]*ruleTrie
+	exact    []*commandRule // rules whose pattern ends here
+	prefix   []*commandRule
+}
+
+func (t *ruleTrie) add(r *commandRule) {
+	node := t
+	for i := 0; i < len(r.pattern); i++ {
+		if node.children == nil {
+			node.children = map[byte]*ruleTrie{}
+		}
+		next, ok := node.children[r.pattern[i]]
+		if !ok {
+			next = &ruleTrie{}
+			node.children[r.pattern[i]] = next
+		}
+		node = next
+	}
+	if r.mode == matchExact {
+		node.exact = append(node.exact, r)
+	} else {
+		node.prefix = append(node.prefix, r)
+	}
+}
+
+// commandMatch is the rule that matched a command.
+type commandMatch struct {
+	rule   *commandRule
+	groups []string          // the matched text followed by the capture groups, only for regex and glob rules
+	named  map[string]string // the named capture groups
+}
+
+// commandMatcher finds the rule for a command: exact and prefix rules are looked up in a trie,
+// regex and glob rules are tried from the highest rank down until no rule could beat the best match anymore.
+type commandMatcher struct {
+	trie     *ruleTrie
+	patterns []*commandRule // sorted, see commandRule.before
+	rules    []*commandRule
+}
+
+// newCommandMatcher compiles the command lists (indexed by ruleKind), invalid rules are logged and left out.
+// context tells in the log where the lists come from.
+func newCommandMatcher(context string, persona bool, lists [ruleKinds][][]string) *commandMatcher {
+	m := &commandMatcher{trie: &ruleTrie{}}
+	for kind, list := range lists {
+		for i, entry := range list {
+			r, err := parseCommandRule(ruleKind(kind), entry)
+			if err != nil {
+				LogGlobal.Error("[Config] Invalid rule %s of %s%s: %s", glog.Wrap(strings.Join(entry, ", "), glog.LightBlue), context, ruleKindNames[kind], glog.Error(err))
+				continue
+			}
+			r.persona, r.order = persona, i
+			m.rules = append(m.rules, r)
+			if r.re != nil {
+				m.patterns = append(m.patterns, r)
+			} else {
+				m.trie.add(r)
+			}
+		}
+	}
+	sort.SliceStable(m.patterns, func(i, j int) bool {
+		return m.patterns[i].before(m.patterns[j])
+	})
+	return m
+}
+
+// Match returns the rule that wins for the command line, nil if there is none.
+func (m *commandMatcher) Match(line string) *commandMatch {
+	if m == nil {
+		return nil
+	}
+	var best *commandRule
+	pick := func(rules []*commandRule) {
+		for _, r := range rules {
+			if r.before(best) {
+				best = r
+			}
+		}
+	}
+	node := m.trie
+	for i := 0; node != nil; i++ {
+		if i == len(line) {
+			pick(node.exact)
+			pick(node.prefix)
+			break
+		}
+		if line[i] == ' ' {
+			pick(node.prefix)
+		}
+		node = node.children[line[i]]
+	}
+	match := &commandMatch{rule: best}
+
+	for _, r := range m.patterns {
+		if !r.before(best) {
+			break // the rest can't win either
+		}
+		groups := r.re.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+		match = &commandMatch{rule: r, groups: groups, named: map[string]string{}}
+		for i, name := range r.re.SubexpNames() {
+			if name != "" {
+				match.named[name] = groups[i]
+			}
+		}
+		break
+	}
+	if match.rule == nil {
+		return nil
+	}
+	return match
+}
+
+// Names returns the commands the rules of the given kinds answer to.
+func (m *commandMatcher) Names(kinds ...ruleKind) []string {
+	names := []string{}
+	if m == nil {
+		return names
+	}
+	for _, r := range m.rules {
+		for _, k := range kinds {
+			if r.kind == k && r.name() != "" {
+				names = append(names, r.name())
+			}
+		}
+	}
+	return names
+}
+
+var commandRules = struct {
+	lock    *sync.Mutex
+	matcher *commandMatcher
+}{
+	lock: &sync.Mutex{},
+}
+
+// compileCommandRules builds the matcher of the command lists of the config.
+func compileCommandRules() {
+	c := Conf.Commands
+	m := newCommandMatcher("", false, [ruleKinds][][]string{
+		ruleExit:             c.Exit,
+		ruleSimple:           c.Simple,
+		rulePermissionDenied: c.PermissionDenied,
+		ruleDiskError:        c.DiskError,
+		ruleCommandNotFound:  c.CommandNotFound,
+		ruleFileNotFound:     c.FileNotFound,
+		ruleNotImplemented:   c.NotImplemented,
+		ruleBullshit:         c.Bullshit,
+	})
+	commandRules.lock.Lock()
+	commandRules.matcher = m
+	commandRules.lock.Unlock()
+}
+
+// configRules returns the matcher of the command lists of the config.
+func configRules() *commandMatcher {
+	commandRules.lock.Lock()
+	defer commandRules.lock.Unlock()
+	return commandRules.matcher
+}
+
+// matchRule returns the rule of the persona or the config that wins for the command line, nil if there is none.
+func (fs *FakeShell) matchRule(line string) *commandMatch {
+	pm := fs.ffs.Persona().rules.Match(line)
+	cm := configRules().Match(line)
+	if pm != nil && (cm == nil || pm.rule.before(cm.rule)) {
+		return pm
+	}
+	return cm
+}
+
+// ruleNames returns the commands the exit and simple rules of the persona and the config answer to.
+func (fs *FakeShell) ruleNames() []string {
+	return append(fs.ffs.Persona().rules.Names(ruleExit, ruleSimple), configRules().Names(ruleExit, ruleSimple)...)
+}
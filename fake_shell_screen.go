@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	shellInputMax  = 16 * 1024       // input that isn't consumed yet, reading stops while the buffer is full
+	shellInputWait = 5 * time.Second // how long reading stops before further input is dropped
+)
+
+// shellInput reads the input of the SSH session in the background. The line editor, the raw reads of
+// commands like scp and the screens of interactive programs all read from it, so a program can wait for
+// a key press with a timeout without the next reader losing what was typed in the meantime.
+// While nobody consumes the input, reading pauses for a while (so the SSH flow control holds the client back)
+// and then continues without keeping what doesn't fit anymore.
+type shellInput struct {
+	lock   *sync.Mutex
+	buf    []byte // read from the session but not consumed yet
+	err    error  // the error that ended the session, returned once buf is empty
+	notify chan struct{}
+	space  chan struct{} // signaled when input has been consumed
+}
+
+func newShellInput(r io.Reader) *shellInput {
+	in := &shellInput{
+		lock:   &sync.Mutex{},
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			in.waitForSpace()
+			n, err := r.Read(b)
+			in.lock.Lock()
+			if room := shellInputMax - len(in.buf); room > 0 {
+				in.buf = append(in.buf, b[:minInt(n, room)]...)
+			}
+			in.err = err
+			in.lock.Unlock()
+			select {
+			case in.notify <- struct{}{}:
+			default:
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return in
+}
+
+// waitForSpace waits until the buffer isn't full anymore, but at most shellInputWait.
+func (in *shellInput) waitForSpace() {
+	timeout := time.NewTimer(shellInputWait)
+	defer timeout.Stop()
+	for {
+		in.lock.Lock()
+		full := len(in.buf) >= shellInputMax
+		in.lock.Unlock()
+		if !full {
+			return
+		}
+		select {
+		case <-in.space:
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// ReadTimeout reads what is available, waiting at most the timeout for input. It returns 0 bytes
+// if nothing arrived in time, a timeout <= 0 waits until there is input or the session ended.
+func (in *shellInput) ReadTimeout(p []byte, timeout time.Duration) (int, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		in.lock.Lock()
+		if len(in.buf) > 0 {
+			n := copy(p, in.buf)
+			in.buf = in.buf[n:]
+			in.lock.Unlock()
+			select {
+			case in.space <- struct{}{}:
+			default:
+			}
+			return n, nil
+		}
+		err := in.err
+		in.lock.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-in.notify:
+		case <-expired:
+			return 0, nil
+		}
+	}
+}
+
+func (in *shellInput) Read(p []byte) (int, error) {
+	return in.ReadTimeout(p, 0)
+}
+
+// KeyCode identifies a key read by a Screen.
+type KeyCode int
+
+const (
+	KeyNone   KeyCode = iota // no key was pressed before the timeout
+	KeyRune                  // a printable character, see Key.Rune
+	KeyCtrl                  // a control character, Key.Rune is the lower case letter, e.g. 'x' for ^X
+	KeyResize                // the terminal has been resized, the program should redraw
+	KeyEnter
+	KeyBackspace
+	KeyDelete
+	KeyTab
+	KeyEscape
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+	KeyUnknown // an escape sequence the screen doesn't know
+)
+
+// Key is a key press read from the terminal.
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+// Is returns true if the key is the printable character.
+func (k Key) Is(r rune) bool {
+	return k.Code == KeyRune && k.Rune == r
+}
+
+// IsCtrl returns true if the key is the letter pressed together with Ctrl.
+func (k Key) IsCtrl(r rune) bool {
+	return k.Code == KeyCtrl && k.Rune == r
+}
+
+// parseKey decodes the key at the start of the input and returns how many bytes it used.
+func parseKey(b []byte) (Key, int) {
+	switch c := b[0]; {
+	case c == 0x1b:
+		if len(b) < 3 || (b[1] != '[' && b[1] != 'O') {
+			return Key{Code: KeyEscape}, 1
+		}
+		end := 2
+		for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+			end++
+		}
+		if end == len(b) {
+			return Key{Code: KeyEscape}, 1
+		}
+		code := KeyUnknown
+		switch b[end] {
+		case 'A':
+			code = KeyUp
+		case 'B':
+			code = KeyDown
+		case 'C':
+			code = KeyRight
+		case 'D':
+			code = KeyLeft
+		case 'H':
+			code = KeyHome
+		case 'F':
+			code = KeyEnd
+		case '~':
+			switch string(b[2:end]) {
+			case "1", "7":
+				code = KeyHome
+			case "4", "8":
+				code = KeyEnd
+			case "3":
+				code = KeyDelete
+			case "5":
+				code = KeyPageUp
+			case "6":
+				code = KeyPageDown
+			}
+		}
+		return Key{Code: code}, end + 1
+	case c == '\r':
+		if len(b) > 1 && b[1] == '\n' {
+			return Key{Code: KeyEnter}, 2
+		}
+		return Key{Code: KeyEnter}, 1
+	case c == '\n':
+		return Key{Code: KeyEnter}, 1
+	case c == '\t':
+		return Key{Code: KeyTab}, 1
+	case c == 0x7f || c == 0x08:
+		return Key{Code: KeyBackspace}, 1
+	case c < 0x20:
+		return Key{Code: KeyCtrl, Rune: rune(c) + 'a' - 1}, 1
+	}
+	r, n := utf8.DecodeRune(b)
+	return Key{Code: KeyRune, Rune: r}, n
+}
+
+// Screen gives an interactive program like an editor or a pager the terminal. The program draws frames
+// and reads key presses instead of lines, Close returns to the line editor of the shell.
+// Screens are drawn at full speed, an editor that redraws at the rate limit would give the honeypot away.
+type Screen struct {
+	fs        *FakeShell
+	alternate bool
+	frame     []string // the rows currently on the screen
+	width     int      // the size the frame was drawn for
+	height    int
+	pending   []byte // input that hasn't been decoded yet
+}
+
+// OpenScreen takes over the terminal. It fails if there is none, i.e. if the session has no pty, stdout
+// doesn't go to the terminal or the program runs in the background. With alternate the program gets a
+// screen of its own that disappears when it exits, like vi and less, otherwise it writes below the prompt
+// like more.
+func (fs *FakeShell) OpenScreen(alternate bool) (*Screen, bool) {
+	if _, _, pty := (*fs.session).Pty(); !pty || fs.streams.stdout != nil || fs.job != nil {
+		return nil, false
+	}
+	sc := &Screen{fs: fs, alternate: alternate}
+	if alternate {
+		sc.Write("\x1b[?1049h\x1b[H\x1b[2J")
+	}
+	return sc, true
+}
+
+// Size returns the number of columns and rows of the terminal.
+func (sc *Screen) Size() (width, height int) {
+	return sc.fs.TerminalSize()
+}
+
+// Write sends raw output to the terminal and records it in the session capture.
+// Like all output of the terminal, new lines become \r\n.
+func (sc *Screen) Write(output string) {
+	_, _ = sc.fs.terminal.Write([]byte(output))
+	sc.fs.stats.recording.AddRawOutputEvent(strings.ReplaceAll(output, "\n", "\r\n"))
+}
+
+// Render draws a frame and puts the cursor at the given column and row. Only the rows that changed
+// since the last frame are sent, unless the terminal has been resized in the meantime.
+func (sc *Screen) Render(rows []string, col, row int) {
+	width, height := sc.Size()
+	var out strings.Builder
+	if width != sc.width || height != sc.height {
+		sc.width, sc.height, sc.frame = width, height, nil
+		out.WriteString("\x1b[H\x1b[2J")
+	}
+	out.WriteString("\x1b[?25l")
+	for y := 0; y < height; y++ {
+		line := ""
+		if y < len(rows) {
+			line = rows[y]
+		}
+		if sc.frame != nil && y < len(sc.frame) && sc.frame[y] == line {
+			continue
+		}
+		fmt.Fprintf(&out, "\x1b[%d;1H%s\x1b[0m\x1b[K", y+1, line)
+	}
+	fmt.Fprintf(&out, "\x1b[%d;%dH\x1b[?25h", row+1, col+1)
+	sc.frame = append(sc.frame[:0], rows...)
+	sc.Write(out.String())
+}
+
+// ReadKey waits for the next key press. It returns a KeyResize key if the terminal is resized while waiting.
+func (sc *Screen) ReadKey() (Key, error) {
+	for {
+		k, err := sc.ReadKeyTimeout(250 * time.Millisecond)
+		if err != nil || k.Code != KeyNone {
+			return k, err
+		}
+	}
+}
+
+// ReadKeyTimeout waits at most the timeout for the next key press, a KeyNone key means there was none.
+func (sc *Screen) ReadKeyTimeout(timeout time.Duration) (Key, error) {
+	if len(sc.pending) == 0 {
+		if width, height := sc.Size(); sc.frame != nil && (width != sc.width || height != sc.height) {
+			return Key{Code: KeyResize}, nil
+		}
+		b := make([]byte, 256)
+		n, err := sc.fs.input.ReadTimeout(b, timeout)
+		if n == 0 {
+			return Key{Code: KeyNone}, err
+		}
+		sc.pending = b[:n]
+	}
+	k, n := parseKey(sc.pending)
+	sc.pending = sc.pending[n:]
+	return k, nil
+}
+
+// Close returns the terminal to the line editor of the shell.
+func (sc *Screen) Close() {
+	if sc.alternate {
+		sc.Write("\x1b[?25h\x1b[?1049l")
+	}
+}
+
+// screenReverse returns the text in reverse video, e.g. for status lines.
+func screenReverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
+
+// screenFit cuts the text to the width or pads it with spaces to fill it.
+func screenFit(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:maxInt(width, 0)])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+// expandTabs replaces tabs with spaces up to the next tab stop.
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// wrapRows splits a line into rows of the given width, an empty line still takes one row.
+func wrapRows(line string, width int) []string {
+	r := []rune(expandTabs(line))
+	if len(r) == 0 || width <= 0 {
+		return []string{string(r)}
+	}
+	rows := []string{}
+	for len(r) > 0 {
+		n := minInt(width, len(r))
+		rows = append(rows, string(r[:n]))
+		r = r[n:]
+	}
+	return rows
+}
+
+// editLine applies an editing key to the text of a prompt, e.g. the : command line of vi.
+// It returns false if the key doesn't edit text.
+func editLine(text []rune, k Key) ([]rune, bool) {
+	switch {
+	case k.Code == KeyRune:
+		return append(text, k.Rune), true
+	case k.Code == KeyTab:
+		return append(text, '\t'), true
+	case k.Code == KeyBackspace:
+		if len(text) > 0 {
+			text = text[:len(text)-1]
+		}
+		return text, true
+	case k.IsCtrl('u'):
+		return text[:0], true
+	}
+	return text, false
+}
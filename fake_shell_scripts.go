@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/toxyl/glog"
+)
+
+const shellScriptMaxDepth = 16 // max nesting level of scripts running other scripts
+
+// shellInterpreters are the shells that can run scripts.
+var shellInterpreters = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"ash":  true,
+}
+
+func init() {
+	// these run other commands, registering them directly would be an initialization cycle
+	for name := range shellInterpreters {
+		CmdLookup[name] = cmdSh
+	}
+	CmdLookup["source"] = cmdSource
+	CmdLookup["."] = cmdSource
+}
+
+// runScript runs the lines of a script one after another.
+// The name is used as $0 and in error messages, args are the positional parameters.
+// If isolated is true the script runs in a child shell that only inherits the exported variables,
+// otherwise it runs in the current shell (like `source` does) and can terminate the session.
+func (fs *FakeShell) runScript(name, content string, args []string, isolated bool) (exit bool) {
+	if fs.scriptDepth >= shellScriptMaxDepth {
+		fs.logger.Debug("%s: Scripts nested too deep: %s", fs.osshSession.LogID(), glog.File(name))
+		fs.RecordErrorLn("-bash: fork: retry: Resource temporarily unavailable")
+		return false
+	}
+	fs.logger.Info("%s: Running script %s", fs.osshSession.LogID(), glog.File(name))
+
+	fs.scriptDepth++
+	defer func() {
+		fs.scriptDepth--
+	}()
+
+	if isolated {
+		state := fs.saveState()
+		defer fs.restoreState(state)
+
+		fs.env = NewShellEnv()
+		for k, v := range state.env.Exported() {
+			fs.env.Set(k, v)
+			fs.env.Export(k)
+		}
+		fs.name = name
+		fs.positional = args
+		fs.loops = 0
+	} else if len(args) > 0 {
+		positional := fs.positional
+		defer func() {
+			fs.positional = positional
+		}()
+		fs.positional = args
+	}
+
+	fs.exitCode = 0
+	lines := strings.Split(content, "\n")
+	input, start := "", 0
+	for i, line := range lines {
+		if input == "" {
+			start = i + 1
+		} else {
+			input += "\n"
+		}
+		input += line
+
+		// commands can span multiple lines, e.g. here-documents
+		if i < len(lines)-1 && IsShellInputIncomplete(input) {
+			continue
+		}
+		cmd := strings.TrimSpace(input)
+		input = ""
+		if cmd == "" || (strings.HasPrefix(cmd, "#") && !strings.Contains(cmd, "\n")) {
+			continue
+		}
+
+		fs.RecordScriptInput(name, start, cmd)
+		if fs.run(cmd, fmt.Sprintf("%s: line %d", name, start)) {
+			// exit only ends the script unless it runs in the current shell
+			return !isolated
+		}
+	}
+	return false
+}
+
+// exitScript handles `exit [N]` in a script or a command substitution: it ends those with the given exit status,
+// without N the status of the last command is kept.
+func (fs *FakeShell) exitScript(args []string) (exit bool) {
+	if len(args) < 2 {
+		fs.exitCode = fs.lastStatus // like bash, the status of the last command is kept
+		return true
+	}
+	code, err := strconv.Atoi(args[1])
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("%s: exit: %s: numeric argument required", fs.name, args[1]))
+		code = 2
+	}
+	fs.exitCode = code & 0xff
+	return true
+}
+
+func cmdSh(fs *FakeShell, args []string) (exit bool) {
+	name := filepath.Base(args[0])
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if arg == "-c" {
+			if i+1 >= len(args) {
+				fs.RecordErrorLn(fmt.Sprintf("%s: -c: option requires an argument", name))
+				fs.exitCode = 2
+				return
+			}
+			// sh -c 'command' [$0 [$1 ...]]
+			if i+2 < len(args) {
+				return fs.runScript(args[i+2], args[i+1], args[i+3:], true)
+			}
+			return fs.runScript(name, args[i+1], nil, true)
+		}
+		if !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+			break // options like -x or +e don't change anything for us
+		}
+	}
+
+	if i >= len(args) {
+		// without a script the shell reads the commands from stdin, e.g. `curl ... | sh`
+		if stdin, ok := fs.Stdin(); ok {
+			if strings.TrimSpace(stdin) != "" {
+				defer fs.recordStage(name, stdin)()
+			}
+			return fs.runScript(name, stdin, nil, true)
+		}
+		return // an interactive shell, nothing to do
+	}
+
+	script := args[i]
+	path := toAbs(fs, script)
+	if fs.ffs.DirExists(path) {
+		fs.RecordErrorLn(fmt.Sprintf("%s: %s: Is a directory", name, script))
+		fs.exitCode = 126
+		return
+	}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("%s: %s: %s", name, script, errorString(err)))
+		fs.exitCode = 127
+		return
+	}
+	return fs.runScript(script, string(data), args[i+1:], true)
+}
+
+func cmdSource(fs *FakeShell, args []string) (exit bool) {
+	if len(args) < 2 {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: filename argument required", args[0]))
+		fs.RecordErrorLn(fmt.Sprintf("%s: usage: %s filename [arguments]", args[0], args[0]))
+		fs.exitCode = 2
+		return
+	}
+
+	script := args[1]
+	path := toAbs(fs, script)
+	if fs.ffs.DirExists(path) {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: is a directory", script))
+		return
+	}
+	data, err := fs.ffs.ReadFile(path)
+	if err != nil {
+		fs.RecordErrorLn(fmt.Sprintf("-bash: %s: %s", script, errorString(err)))
+		return
+	}
+	return fs.runScript(script, string(data), args[2:], false)
+}
@@ -11,6 +11,7 @@ type FakeShellStats struct {
 	User             string
 	CommandsExecuted uint
 	CommandHistory   []string
+	Events           []FakeShellEvent
 	recording        *utils.ASCIICastV2
 }
 
@@ -19,6 +20,10 @@ func (fss *FakeShellStats) AddCommandToHistory(cmd string) {
 	fss.CommandsExecuted++
 }
 
+func (fss *FakeShellStats) AddEvent(e FakeShellEvent) {
+	fss.Events = append(fss.Events, e)
+}
+
 func (fss *FakeShellStats) ToPayload() *Payload {
 	pl := strings.Join(fss.CommandHistory, "\n")
 	p := NewPayload()
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/toxyl/gutils"
+)
+
+const templateMaxSleep = 30 * time.Second // the longest pause a template can make with sleep
+
+// CommandTemplateData is what command templates and the responses of simple commands have access to.
+type CommandTemplateData struct {
+	User      string
+	IP        string
+	IPLocal   string
+	Port      int
+	PortLocal int
+	HostName  string
+	InputRaw  string
+	Command   string
+	Arguments []string
+	Env       map[string]string
+	Cwd       string
+	Width     int // the size of the terminal
+	Height    int
+	Uptime    float64           // of the session, in seconds
+	Logins    uint              // successful logins of the attacker's IP, including the current one
+	Match     []string          // the text matched by a regex or glob rule followed by its capture groups
+	Groups    map[string]string // the named capture groups of a regex rule
+}
+
+// templateData returns the data for the templates of a command.
+func (fs *FakeShell) templateData(args []string) CommandTemplateData {
+	rmtH, rmtP := gutils.SplitHostPortFromAddr((*fs.session).RemoteAddr())
+	lclH, lclP := gutils.SplitHostPortFromAddr((*fs.session).LocalAddr())
+	width, height := fs.TerminalSize()
+	logins := uint(0)
+	if SrvOSSH != nil && SrvOSSH.Logins != nil {
+		logins = SrvOSSH.Logins.Get(fs.osshSession.Host).GetSuccesses()
+	}
+	return CommandTemplateData{
+		User:      fs.User(),
+		IP:        rmtH,
+		IPLocal:   lclH,
+		Port:      rmtP,
+		PortLocal: lclP,
+		HostName:  fs.ffs.HostName(),
+		InputRaw:  strings.Join(args, " "),
+		Command:   args[0],
+		Arguments: args[1:],
+		Env:       fs.env.Exported(),
+		Cwd:       fs.cwd,
+		Width:     width,
+		Height:    height,
+		Uptime:    fs.osshSession.Uptime().Seconds(),
+		Logins:    logins,
+	}
+}
+
+// templateFunctions returns the template functions that work with the session. They replace the placeholders
+// of InitTemplaterFunctions while a command template or the response of a simple command is executed.
+// random and choice are seeded with the IP and the input, so the same command gets the same answer.
+// setvar, getvar and incr remember values for the session, the attacker's host or the node, see templateVarsOf.
+func (fs *FakeShell) templateFunctions(data CommandTemplateData) template.FuncMap {
+	sum := sha256.Sum256([]byte(data.IP + "/" + data.InputRaw))
+	rnd := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+
+	return template.FuncMap{
+		"readfile": func(path string) string {
+			content, err := fs.ffs.ReadFile(toAbs(fs, path))
+			if err != nil {
+				return ""
+			}
+			return string(content)
+		},
+		"exists": func(path string) bool {
+			p := toAbs(fs, path)
+			return fs.ffs.FileExists(p) || fs.ffs.DirExists(p)
+		},
+		"listdir": func(path string) []string {
+			names := []string{}
+			entries, err := fs.ffs.ReadDir(toAbs(fs, path))
+			if err != nil {
+				return names
+			}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			return names
+		},
+		"hasflag": func(flags string) bool {
+			_, found := templateFlag(data.Arguments, flags)
+			return found
+		},
+		"flag": func(flags, def string) string {
+			if value, found := templateFlag(data.Arguments, flags); found && value != "" {
+				return value
+			}
+			return def
+		},
+		"positional": func(i int, withValue ...string) string {
+			operands := templateOperands(data.Arguments, withValue)
+			if i < 0 || i >= len(operands) {
+				return ""
+			}
+			return operands[i]
+		},
+		"random": func(min, max interface{}) int {
+			lo, _ := gutils.GetFloat(min)
+			hi, _ := gutils.GetFloat(max)
+			if hi <= lo {
+				return int(lo)
+			}
+			return int(lo) + rnd.Intn(int(hi)-int(lo)+1)
+		},
+		"choice": func(values ...interface{}) interface{} {
+			if len(values) == 1 {
+				if list, ok := values[0].([]string); ok {
+					if len(list) == 0 {
+						return ""
+					}
+					return list[rnd.Intn(len(list))]
+				}
+			}
+			if len(values) == 0 {
+				return ""
+			}
+			return values[rnd.Intn(len(values))]
+		},
+		"sleep": func(ms interface{}) string {
+			if !fs.osshSession.Whitelisted {
+				v, _ := gutils.GetFloat(ms)
+				d := time.Duration(v) * time.Millisecond
+				if d > templateMaxSleep {
+					d = templateMaxSleep
+				}
+				time.Sleep(d)
+			}
+			return ""
+		},
+		"exec": func(command string) string {
+			return fs.substitute(command)
+		},
+		"setvar": func(key string, value interface{}) string {
+			store, name := fs.templateVarsOf(key)
+			store.Set(name, fmt.Sprint(value))
+			return ""
+		},
+		"getvar": func(key string, def ...string) string {
+			store, name := fs.templateVarsOf(key)
+			if value, found := store.Get(name); found {
+				return value
+			}
+			return strings.Join(def, "")
+		},
+		"incr": func(key string, n ...int) int {
+			step := 1
+			if len(n) > 0 {
+				step = n[0]
+			}
+			store, name := fs.templateVarsOf(key)
+			return store.Incr(name, step)
+		},
+	}
+}
+
+// templateFlag looks for an option in the arguments of a command, flags names its forms, e.g. "-n|--lines".
+// Short options match in groups (-la) and with attached values (-n5), long ones with values after = (--lines=5).
+// The value is the attached one or the next argument.
+func templateFlag(args []string, flags string) (value string, found bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, name := range strings.Split(flags, "|") {
+			next := ""
+			if i+1 < len(args) {
+				next = args[i+1]
+			}
+			switch {
+			case arg == name:
+				return next, true
+			case strings.HasPrefix(name, "--"):
+				if strings.HasPrefix(arg, name+"=") {
+					return arg[len(name)+1:], true
+				}
+			case len(name) == 2 && name[0] == '-' && len(arg) > 2 && arg[0] == '-' && arg[1] != '-':
+				if arg[1] == name[1] {
+					return arg[2:], true
+				}
+				if strings.ContainsRune(arg[1:], rune(name[1])) {
+					return "", true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// templateOperands returns the arguments that aren't options. withValue names the options (like "-n|--lines")
+// whose value is the next argument.
+func templateOperands(args []string, withValue []string) []string {
+	takesValue := map[string]bool{}
+	for _, flags := range withValue {
+		for _, name := range strings.Split(flags, "|") {
+			takesValue[name] = true
+		}
+	}
+	operands := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(operands, args[i+1:]...)
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+		case takesValue[arg]:
+			i++
+		}
+	}
+	return operands
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toxyl/glog"
+)
+
+const (
+	templateMaxVars     = 1000            // per store, further keys are ignored
+	templateMaxVarValue = 4096            // longer values are cut
+	templateVarsDelay   = 2 * time.Second // changes of a store are saved at most this often
+)
+
+// templateVars is a store of the template functions setvar, getvar and incr. Stores with a file are read once
+// and kept in memory, changes are written shortly after they happen, so they survive reconnects and restarts;
+// the others only live in memory.
+type templateVars struct {
+	lock   *sync.Mutex
+	file   string
+	vars   map[string]string
+	used   time.Time
+	saving bool // a save of the changes is scheduled
+}
+
+// newTemplateVars returns an empty store, without a file it only lives in memory.
+func newTemplateVars(file string) *templateVars {
+	return &templateVars{lock: &sync.Mutex{}, file: file}
+}
+
+// templateVarStores are the stores with a file by file, so all sessions share the same copy.
+var templateVarStores = struct {
+	lock   *sync.Mutex
+	stores map[string]*templateVars
+}{
+	lock:   &sync.Mutex{},
+	stores: map[string]*templateVars{},
+}
+
+// templateVarsDir returns the directory with the stores of the hosts and the node.
+func templateVarsDir() string {
+	return filepath.Join(Conf.PathData, "vars")
+}
+
+// templateVarsFor returns the store of the file, it is read when it's needed first.
+// Stores nobody used for longer than `max_session_age` are dropped from memory once they are saved.
+func templateVarsFor(file string) *templateVars {
+	templateVarStores.lock.Lock()
+	defer templateVarStores.lock.Unlock()
+
+	for f, tv := range templateVarStores.stores {
+		tv.lock.Lock()
+		expired := !tv.saving && time.Since(tv.used) > time.Duration(Conf.MaxSessionAge)*time.Second
+		tv.lock.Unlock()
+		if expired {
+			delete(templateVarStores.stores, f)
+		}
+	}
+
+	tv, ok := templateVarStores.stores[file]
+	if !ok {
+		tv = newTemplateVars(file)
+		templateVarStores.stores[file] = tv
+	}
+	return tv
+}
+
+// hostTemplateVars returns the store of an attacker's host.
+func hostTemplateVars(host string) *templateVars {
+	return templateVarsFor(filepath.Join(templateVarsDir(), filepath.Base(host)+".json"))
+}
+
+// nodeTemplateVars returns the store shared by all sessions of the node.
+func nodeTemplateVars() *templateVars {
+	return templateVarsFor(filepath.Join(templateVarsDir(), "node.json"))
+}
+
+// load reads the variables of the store from its file, the lock of the store must be held.
+func (tv *templateVars) load() {
+	tv.vars = map[string]string{}
+	data, err := os.ReadFile(tv.file)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &tv.vars); err != nil {
+		LogGlobal.Error("Template variables in %s are broken, starting over: %s", glog.File(tv.file), glog.Error(err))
+		tv.vars = map[string]string{}
+	}
+}
+
+// save writes the variables of the store to its file. The file is replaced as a whole,
+// so a crash while writing never leaves a broken store behind.
+func (tv *templateVars) save() {
+	tv.lock.Lock()
+	defer tv.lock.Unlock()
+	tv.saving = false
+
+	data, err := json.Marshal(tv.vars)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(tv.file), 0755)
+	}
+	var tmp *os.File
+	if err == nil {
+		tmp, err = os.CreateTemp(filepath.Dir(tv.file), filepath.Base(tv.file)+".*.tmp")
+	}
+	if err == nil {
+		_, err = tmp.Write(data)
+		if errClose := tmp.Close(); err == nil {
+			err = errClose
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), tv.file)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		LogGlobal.Error("Failed to save template variables to %s: %s", glog.File(tv.file), glog.Error(err))
+	}
+}
+
+// update calls change with the variables of the store and schedules a save if change reports that it modified them.
+func (tv *templateVars) update(change func(vars map[string]string) bool) {
+	tv.lock.Lock()
+	defer tv.lock.Unlock()
+
+	tv.used = time.Now()
+	if tv.vars == nil {
+		if tv.file == "" {
+			tv.vars = map[string]string{}
+		} else {
+			tv.load()
+		}
+	}
+	if !change(tv.vars) || tv.file == "" || tv.saving {
+		return
+	}
+	tv.saving = true
+	time.AfterFunc(templateVarsDelay, tv.save)
+}
+
+// Get returns the value of a variable, found is false if it isn't set.
+func (tv *templateVars) Get(key string) (value string, found bool) {
+	tv.update(func(vars map[string]string) bool {
+		value, found = vars[key]
+		return false
+	})
+	return value, found
+}
+
+// Set changes the value of a variable.
+func (tv *templateVars) Set(key, value string) {
+	if len(value) > templateMaxVarValue {
+		value = value[:templateMaxVarValue]
+	}
+	tv.update(func(vars map[string]string) bool {
+		if _, ok := vars[key]; !ok && len(vars) >= templateMaxVars {
+			return false
+		}
+		vars[key] = value
+		return true
+	})
+}
+
+// Incr adds n to a variable and returns the new value, variables that aren't set or aren't numbers count as 0.
+func (tv *templateVars) Incr(key string, n int) (value int) {
+	tv.update(func(vars map[string]string) bool {
+		value, _ = strconv.Atoi(vars[key])
+		value += n
+		if _, ok := vars[key]; !ok && len(vars) >= templateMaxVars {
+			return false
+		}
+		vars[key] = strconv.Itoa(value)
+		return true
+	})
+	return value
+}
+
+// templateVarsOf returns the store a key of setvar, getvar or incr belongs to and the key without the scope:
+// "host:x" is stored for the attacker's host, "node:x" for the whole node and everything else for the session.
+func (fs *FakeShell) templateVarsOf(key string) (*templateVars, string) {
+	if scope, name, found := strings.Cut(key, ":"); found {
+		switch scope {
+		case "session":
+			return fs.vars, name
+		case "host":
+			return hostTemplateVars(fs.osshSession.Host), name
+		case "node":
+			return nodeTemplateVars(), name
+		}
+	}
+	return fs.vars, key
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// PersonaPackage is a package the package manager of a persona can install.
+type PersonaPackage struct {
+	Name        string   `mapstructure:"name"`
+	Version     string   `mapstructure:"version"`
+	Size        int      `mapstructure:"size"` // of the download in kB, installed packages take about three times as much
+	Description string   `mapstructure:"description"`
+	Binaries    []string `mapstructure:"binaries"`  // the commands the package provides, absolute paths
+	Depends     []string `mapstructure:"depends"`   // packages that are installed along with it
+	Installed   bool     `mapstructure:"installed"` // whether the package is part of the system from the start
+}
+
+// PersonaPackages is the package catalog of a persona, see packages.yaml of the persona directories.
+type PersonaPackages struct {
+	Manager  string           `mapstructure:"manager"` // apt, yum, apk or opkg
+	Mirror   string           `mapstructure:"mirror"`  // the URL of the repository
+	Release  string           `mapstructure:"release"` // e.g. jammy for apt or el7 for yum
+	Arch     string           `mapstructure:"arch"`    // as used in the names of the packages, derived from the machine if empty
+	Packages []PersonaPackage `mapstructure:"packages"`
+	index    map[string]*PersonaPackage
+	provides map[string][]*PersonaPackage // the packages with a binary of the given name
+}
+
+// packageArchs maps the machine names of uname to the architectures of the package managers.
+var packageArchs = map[string]map[string]string{
+	"apt":  {"x86_64": "amd64", "aarch64": "arm64", "armv7l": "armhf", "armv6l": "armhf", "i686": "i386", "mips": "mips"},
+	"yum":  {"x86_64": "x86_64", "aarch64": "aarch64", "i686": "i686"},
+	"apk":  {"x86_64": "x86_64", "aarch64": "aarch64", "armv7l": "armv7", "armv6l": "armhf"},
+	"opkg": {"mips": "mips_24kc", "x86_64": "x86_64", "aarch64": "aarch64_cortex-a53", "armv7l": "arm_cortex-a7_neon-vfpv4"},
+}
+
+// init indexes the catalog and fills in the defaults.
+func (pp *PersonaPackages) init(machine string) {
+	if pp.Manager == "" {
+		pp.Manager = "apt"
+	}
+	if pp.Arch == "" {
+		if pp.Arch = packageArchs[pp.Manager][machine]; pp.Arch == "" {
+			pp.Arch = machine
+		}
+	}
+	pp.index = map[string]*PersonaPackage{}
+	pp.provides = map[string][]*PersonaPackage{}
+	for i := range pp.Packages {
+		p := &pp.Packages[i]
+		if p.Size <= 0 {
+			p.Size = 100
+		}
+		pp.index[p.Name] = p
+		for _, bin := range p.Binaries {
+			name := filepath.Base(bin)
+			pp.provides[name] = append(pp.provides[name], p)
+		}
+	}
+}
+
+// Get returns the package with the given name, nil if the catalog doesn't have it.
+func (pp *PersonaPackages) Get(name string) *PersonaPackage {
+	return pp.index[name]
+}
+
+// Provides returns the packages with a binary of the given name.
+func (pp *PersonaPackages) Provides(command string) []*PersonaPackage {
+	return pp.provides[command]
+}
+
+// loadPersonaPackages reads the package catalog of a persona, personas without one use the default catalog.
+func loadPersonaPackages(p *Persona) (*PersonaPackages, error) {
+	file := filepath.Join(p.dir, "packages.yaml")
+	pp := &PersonaPackages{}
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return defaultPackages(), nil
+	}
+	if err := v.Unmarshal(pp); err != nil {
+		return nil, fmt.Errorf("can't decode packages of persona %s: %w", p.Name, err)
+	}
+	pp.init(p.Uname.Machine)
+	return pp, nil
+}
+
+var defaultPackageCatalog = struct {
+	once     *sync.Once
+	packages *PersonaPackages
+}{
+	once: &sync.Once{},
+}
+
+// defaultPackages returns the package catalog of systems whose persona doesn't have one, it matches the Ubuntu
+// release of the default SSH version. Everything the built-in commands implement is installed, so the default
+// system answers to all of them.
+func defaultPackages() *PersonaPackages {
+	defaultPackageCatalog.once.Do(func() {
+		pp := &PersonaPackages{
+			Manager: "apt",
+			Mirror:  "http://archive.ubuntu.com/ubuntu",
+			Release: "impish",
+			Packages: []PersonaPackage{
+				{Name: "bash", Version: "5.1-3ubuntu2", Size: 614, Description: "GNU Bourne Again SHell", Binaries: []string{"/bin/bash"}, Installed: true},
+				{Name: "coreutils", Version: "8.32-4ubuntu2", Size: 1402, Description: "GNU core utilities", Installed: true, Binaries: []string{
+					"/bin/cat", "/bin/chgrp", "/bin/chmod", "/bin/chown", "/bin/cp", "/bin/echo", "/bin/ln", "/bin/ls", "/bin/mkdir",
+					"/bin/mv", "/bin/pwd", "/bin/rm", "/bin/rmdir", "/bin/touch", "/bin/uname", "/usr/bin/base64", "/usr/bin/env",
+					"/usr/bin/head", "/usr/bin/nohup", "/usr/bin/nproc", "/usr/bin/printenv", "/usr/bin/printf", "/usr/bin/tail",
+					"/usr/bin/wc", "/usr/bin/sort", "/usr/bin/uniq", "/usr/bin/cut", "/usr/bin/tr",
+				}},
+				{Name: "debianutils", Version: "5.5-1", Size: 87, Description: "Miscellaneous utilities specific to Debian", Binaries: []string{"/usr/bin/which"}, Installed: true},
+				{Name: "grep", Version: "3.7-0ubuntu1", Size: 158, Description: "GNU grep, egrep and fgrep", Binaries: []string{"/bin/grep"}, Installed: true},
+				{Name: "procps", Version: "2:3.3.17-5ubuntu3", Size: 241, Description: "/proc file system utilities", Binaries: []string{"/bin/ps", "/usr/bin/top", "/usr/bin/free", "/bin/kill", "/usr/bin/pkill", "/usr/bin/uptime"}, Installed: true},
+				{Name: "passwd", Version: "1:4.8.1-1ubuntu9", Size: 768, Description: "change and administer password and group data", Binaries: []string{"/usr/bin/passwd", "/usr/sbin/chpasswd", "/usr/sbin/useradd", "/usr/sbin/usermod"}, Installed: true},
+				{Name: "login", Version: "1:4.8.1-1ubuntu9", Size: 221, Description: "system login tools", Binaries: []string{"/bin/su"}, Installed: true},
+				{Name: "sudo", Version: "1.9.5p2-2ubuntu3", Size: 821, Description: "Provide limited super user privileges to specific users", Binaries: []string{"/usr/bin/sudo"}, Installed: true},
+				{Name: "cron", Version: "3.0pl1-137ubuntu2", Size: 73, Description: "process scheduling daemon", Binaries: []string{"/usr/bin/crontab"}, Installed: true},
+				{Name: "less", Version: "551-2", Size: 124, Description: "pager program similar to more", Binaries: []string{"/usr/bin/less"}, Installed: true},
+				{Name: "util-linux", Version: "2.36.1-8ubuntu1", Size: 1137, Description: "miscellaneous system utilities", Binaries: []string{"/bin/more", "/usr/bin/rev"}, Installed: true},
+				{Name: "nano", Version: "5.6.1-1", Size: 268, Description: "small, friendly text editor inspired by Pico", Binaries: []string{"/bin/nano"}, Installed: true},
+				{Name: "vim-tiny", Version: "2:8.2.2434-3ubuntu3", Size: 696, Description: "Vi IMproved - enhanced vi editor - compact version", Binaries: []string{"/usr/bin/vi", "/usr/bin/vim.tiny"}, Installed: true},
+				{Name: "vim", Version: "2:8.2.2434-3ubuntu3", Size: 1529, Description: "Vi IMproved - enhanced vi editor", Binaries: []string{"/usr/bin/vim"}, Depends: []string{"vim-runtime"}, Installed: true},
+				{Name: "vim-runtime", Version: "2:8.2.2434-3ubuntu3", Size: 6512, Description: "Vi IMproved - Runtime files", Installed: true},
+				{Name: "xxd", Version: "2:8.2.2434-3ubuntu3", Size: 56, Description: "tool to make (or reverse) a hex dump", Binaries: []string{"/usr/bin/xxd"}, Installed: true},
+				{Name: "wget", Version: "1.21-1ubuntu3", Size: 367, Description: "retrieves files from the web", Binaries: []string{"/usr/bin/wget"}, Installed: true},
+				{Name: "curl", Version: "7.74.0-1.3ubuntu2", Size: 178, Description: "command line tool for transferring data with URL syntax", Binaries: []string{"/usr/bin/curl"}, Depends: []string{"libcurl4"}, Installed: true},
+				{Name: "libcurl4", Version: "7.74.0-1.3ubuntu2", Size: 290, Description: "easy-to-use client-side URL transfer library (OpenSSL flavour)", Installed: true},
+				{Name: "tftp-hpa", Version: "5.2+20150808-1.2", Size: 19, Description: "HPA's tftp client", Binaries: []string{"/usr/bin/tftp"}, Installed: true},
+				{Name: "busybox", Version: "1:1.30.1-6ubuntu3", Size: 344, Description: "Tiny utilities for small and embedded systems", Binaries: []string{"/bin/busybox"}, Installed: true},
+				{Name: "python3", Version: "3.9.4-1", Size: 38, Description: "interactive high-level object-oriented language (default python3 version)", Binaries: []string{"/usr/bin/python3"}, Depends: []string{"python3.9"}, Installed: true},
+				{Name: "python3.9", Version: "3.9.7-2build1", Size: 496, Description: "Interactive high-level object-oriented language (version 3.9)", Binaries: []string{"/usr/bin/python3.9"}, Installed: true},
+				{Name: "python2.7", Version: "2.7.18-8", Size: 245, Description: "Interactive high-level object-oriented language (version 2.7)", Binaries: []string{"/usr/bin/python2.7", "/usr/bin/python2"}, Installed: true},
+				{Name: "perl", Version: "5.32.1-3ubuntu3", Size: 232, Description: "Larry Wall's Practical Extraction and Report Language", Binaries: []string{"/usr/bin/perl"}, Installed: true},
+				{Name: "php7.4-cli", Version: "7.4.25-1+ubuntu21.10.1", Size: 1431, Description: "command-line interpreter for the PHP scripting language", Binaries: []string{"/usr/bin/php", "/usr/bin/php7.4"}, Depends: []string{"php7.4-common"}, Installed: true},
+				{Name: "php7.4-common", Version: "7.4.25-1+ubuntu21.10.1", Size: 991, Description: "documentation, examples and common module for PHP", Installed: true},
+				{Name: "netcat-openbsd", Version: "1.217-3ubuntu1", Size: 45, Description: "TCP/IP swiss army knife", Binaries: []string{"/bin/nc", "/bin/nc.openbsd", "/bin/netcat"}, Installed: true},
+				{Name: "ncat", Version: "7.91+dfsg1+really7.80+dfsg1-1", Size: 319, Description: "NMAP netcat reimplementation", Binaries: []string{"/usr/bin/ncat"}},
+				{Name: "nmap", Version: "7.91+dfsg1+really7.80+dfsg1-1", Size: 1689, Description: "The Network Mapper", Binaries: []string{"/usr/bin/nmap"}, Depends: []string{"nmap-common", "libpcap0.8", "liblua5.3-0", "liblinear4"}},
+				{Name: "nmap-common", Version: "7.91+dfsg1+really7.80+dfsg1-1", Size: 3949, Description: "Architecture independent files for nmap"},
+				{Name: "libpcap0.8", Version: "1.10.0-2", Size: 150, Description: "system interface for user-level packet capture"},
+				{Name: "liblua5.3-0", Version: "5.3.3-1.1ubuntu3", Size: 116, Description: "Shared library for the Lua interpreter version 5.3"},
+				{Name: "liblinear4", Version: "2.3.0+dfsg-5", Size: 41, Description: "Library for Large Linear Classification"},
+				{Name: "masscan", Version: "2:1.3.2+ds1-1", Size: 303, Description: "TCP port scanner", Binaries: []string{"/usr/bin/masscan"}, Depends: []string{"libpcap0.8"}},
+				{Name: "zmap", Version: "2.1.1-2build3", Size: 112, Description: "network scanner for Internet-wide network studies", Binaries: []string{"/usr/sbin/zmap"}, Depends: []string{"libpcap0.8"}},
+				{Name: "hydra", Version: "9.1-1build1", Size: 305, Description: "very fast network logon cracker", Binaries: []string{"/usr/bin/hydra"}},
+				{Name: "socat", Version: "1.7.4.1-3ubuntu1", Size: 352, Description: "multipurpose relay for bidirectional data transfer", Binaries: []string{"/usr/bin/socat"}},
+				{Name: "screen", Version: "4.8.0-6ubuntu1", Size: 560, Description: "terminal multiplexer with VT100/ANSI terminal emulation", Binaries: []string{"/usr/bin/screen"}},
+				{Name: "tmux", Version: "3.1c-1build1", Size: 331, Description: "terminal multiplexer", Binaries: []string{"/usr/bin/tmux"}},
+				{Name: "git", Version: "1:2.32.0-1ubuntu1", Size: 4384, Description: "fast, scalable, distributed revision control system", Binaries: []string{"/usr/bin/git"}, Depends: []string{"git-man", "liberror-perl"}},
+				{Name: "git-man", Version: "1:2.32.0-1ubuntu1", Size: 1889, Description: "fast, scalable, distributed revision control system (manual pages)"},
+				{Name: "liberror-perl", Version: "0.17029-1", Size: 26, Description: "Perl module for error/exception handling in an OO-ish way"},
+				{Name: "gcc", Version: "4:11.2.0-1ubuntu1", Size: 5, Description: "GNU C compiler", Binaries: []string{"/usr/bin/gcc", "/usr/bin/cc"}, Depends: []string{"gcc-11", "libc6-dev"}},
+				{Name: "gcc-11", Version: "11.2.0-7ubuntu2", Size: 20382, Description: "GNU C compiler", Binaries: []string{"/usr/bin/gcc-11"}},
+				{Name: "libc6-dev", Version: "2.34-0ubuntu3", Size: 2373, Description: "GNU C Library: Development Libraries and Header Files"},
+				{Name: "make", Version: "4.3-4ubuntu1", Size: 180, Description: "utility for directing compilation", Binaries: []string{"/usr/bin/make"}},
+				{Name: "unzip", Version: "6.0-26ubuntu1", Size: 168, Description: "De-archiver for .zip files", Binaries: []string{"/usr/bin/unzip"}},
+				{Name: "net-tools", Version: "1.60+git20181103.0eebece-1ubuntu3", Size: 200, Description: "NET-3 networking toolkit", Binaries: []string{"/sbin/ifconfig", "/bin/netstat", "/sbin/route"}},
+				{Name: "tor", Version: "0.4.5.10-1", Size: 1425, Description: "anonymizing overlay network for TCP", Binaries: []string{"/usr/bin/tor"}},
+				{Name: "openssh-client", Version: "1:8.4p1-6ubuntu2.1", Size: 780, Description: "secure shell (SSH) client, for secure access to remote machines", Binaries: []string{"/usr/bin/ssh", "/usr/bin/scp"}, Installed: true},
+				{Name: "openssh-server", Version: "1:8.4p1-6ubuntu2.1", Size: 394, Description: "secure shell (SSH) server, for secure access from remote machines", Binaries: []string{"/usr/sbin/sshd"}, Installed: true},
+			},
+		}
+		pp.init(defaultUname.Machine)
+		defaultPackageCatalog.packages = pp
+	})
+	return defaultPackageCatalog.packages
+}
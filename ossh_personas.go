@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/toxyl/glog"
+	"github.com/toxyl/gutils"
+)
+
+// PersonaUname is what uname reports about the fake system.
+type PersonaUname struct {
+	KernelName       string `mapstructure:"kernel_name"`
+	KernelRelease    string `mapstructure:"kernel_release"`
+	KernelVersion    string `mapstructure:"kernel_version"`
+	Machine          string `mapstructure:"machine"`
+	Processor        string `mapstructure:"processor"`
+	HardwarePlatform string `mapstructure:"hardware_platform"`
+	OperatingSystem  string `mapstructure:"operating_system"`
+}
+
+// defaultUname is used for the fields a persona doesn't define and if no persona is used at all.
+var defaultUname = PersonaUname{
+	KernelName:       "Linux",
+	KernelRelease:    "5.13.19-2-pve",
+	KernelVersion:    "#1 SMP PVE 5.13.19-4 (Mon, 29 Nov 2021 12:10:09 +0100)",
+	Machine:          "x86_64",
+	Processor:        "x86_64",
+	HardwarePlatform: "x86_64",
+	OperatingSystem:  "GNU/Linux",
+}
+
+// Persona bundles everything that makes up the identity of a fake system: the host name, the SSH version banner,
+// what uname reports, files that are laid over the default FS and command templates that override the default ones.
+// Each persona is a directory in `path_personas` with a `persona.yaml`, optional `ffs` and `commands` directories
+// and an optional package catalog, `packages.yaml`.
+type Persona struct {
+	Name     string
+	HostName string       `mapstructure:"host_name"`
+	Version  string       `mapstructure:"version"`
+	Uname    PersonaUname `mapstructure:"uname"`
+	MemTotal int          `mapstructure:"mem_total"` // in KiB
+	Commands struct {
+		Simple [][]string `mapstructure:"simple"` // checked before the simple commands of the config
+	} `mapstructure:"commands"`
+	dir      string
+	rules    *commandMatcher  // of the simple commands
+	packages *PersonaPackages // see Packages
+}
+
+// subdir returns the path of a subdirectory of the persona, or an empty string if it doesn't exist.
+func (p *Persona) subdir(name string) string {
+	if p.dir == "" {
+		return ""
+	}
+	dir := filepath.Join(p.dir, name)
+	if !gutils.DirExists(dir) {
+		return ""
+	}
+	return dir
+}
+
+// FFSDir returns the directory with the files the persona adds to the default FS.
+func (p *Persona) FFSDir() string {
+	return p.subdir("ffs")
+}
+
+// CommandsDir returns the directory with the command templates of the persona.
+func (p *Persona) CommandsDir() string {
+	return p.subdir("commands")
+}
+
+// Packages returns the package catalog of the persona.
+func (p *Persona) Packages() *PersonaPackages {
+	if p.packages == nil {
+		return defaultPackages()
+	}
+	return p.packages
+}
+
+// defaultPersona returns the persona that is used if the config doesn't select one.
+func defaultPersona() *Persona {
+	return &Persona{
+		HostName: Conf.HostName,
+		Version:  Conf.Version,
+		Uname:    defaultUname,
+	}
+}
+
+var personas = struct {
+	lock   *sync.Mutex
+	loaded map[string]*Persona
+}{
+	lock:   &sync.Mutex{},
+	loaded: map[string]*Persona{},
+}
+
+// resetPersonas forgets the loaded personas, so they are read again with the current config.
+// The file systems of the identities are created again too, new sessions get the reloaded personas.
+func resetPersonas() {
+	personas.lock.Lock()
+	personas.loaded = map[string]*Persona{}
+	personas.lock.Unlock()
+
+	if SrvOSSH != nil {
+		SrvOSSH.identitiesLock.Lock()
+		SrvOSSH.identities = map[string]*FakeFS{}
+		SrvOSSH.identitiesLock.Unlock()
+	}
+}
+
+// LoadPersona returns the persona with the given name, an empty name selects the default persona.
+func LoadPersona(name string) (*Persona, error) {
+	if name == "" {
+		return defaultPersona(), nil
+	}
+	personas.lock.Lock()
+	defer personas.lock.Unlock()
+	if p, ok := personas.loaded[name]; ok {
+		return p, nil
+	}
+
+	dir := filepath.Join(Conf.PathPersonas, filepath.Base(name))
+	v := viper.New()
+	v.SetConfigFile(filepath.Join(dir, "persona.yaml"))
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("can't read persona %s: %w", name, err)
+	}
+	p := &Persona{}
+	if err := v.Unmarshal(p); err != nil {
+		return nil, fmt.Errorf("can't decode persona %s: %w", name, err)
+	}
+	p.Name = name
+	p.dir = dir
+
+	// what the persona doesn't define is taken from the config
+	if p.HostName == "" {
+		p.HostName = Conf.HostName
+	}
+	if p.Version == "" {
+		p.Version = Conf.Version
+	}
+	for field, value := range map[*string]string{
+		&p.Uname.KernelName:       defaultUname.KernelName,
+		&p.Uname.KernelRelease:    defaultUname.KernelRelease,
+		&p.Uname.KernelVersion:    defaultUname.KernelVersion,
+		&p.Uname.Machine:          defaultUname.Machine,
+		&p.Uname.Processor:        "unknown",
+		&p.Uname.HardwarePlatform: "unknown",
+		&p.Uname.OperatingSystem:  defaultUname.OperatingSystem,
+	} {
+		if *field == "" {
+			*field = value
+		}
+	}
+
+	packages, err := loadPersonaPackages(p)
+	if err != nil {
+		return nil, err
+	}
+	p.packages = packages
+
+	// like those of the config, the templates of the persona are checked right away
+	p.rules = newCommandMatcher(fmt.Sprintf("persona %s, ", name), true, [ruleKinds][][]string{ruleSimple: p.Commands.Simple})
+	compileTemplateStrings(fmt.Sprintf("persona %s, simple command", name), p.Commands.Simple)
+	if dir := p.CommandsDir(); dir != "" {
+		_, _ = compiledTemplates(Conf.PathCommands, dir)
+	}
+
+	personas.loaded[name] = p
+	return p, nil
+}
+
+// personaOrDefault returns the persona with the given name, if it can't be loaded the default persona is used.
+func personaOrDefault(name string, logger *glog.Logger) *Persona {
+	p, err := LoadPersona(name)
+	if err != nil {
+		logger.Error("Failed to load persona %s, using the default one: %s", glog.Highlight(name), glog.Error(err))
+		return defaultPersona()
+	}
+	return p
+}
+
+// Persona returns the persona of the system the FFS belongs to.
+func (ofs *FakeFS) Persona() *Persona {
+	if ofs.persona != nil {
+		return ofs.persona
+	}
+	return defaultPersona()
+}
+
+// hostIdentity returns the host name and the persona the local IP selects, see Conf.Hostnames.
+// The host name is empty if the IP has none, persona is used if it doesn't select one.
+func hostIdentity(persona, localIP string) (hostName, personaName string) {
+	for _, h := range Conf.Hostnames {
+		if h.IP == localIP {
+			hostName = h.Name
+			if h.Persona != "" {
+				persona = h.Persona
+			}
+			break
+		}
+	}
+	if persona == "" {
+		persona = Conf.Persona
+	}
+	return hostName, persona
+}
+
+// identity returns the file system of the system a bot sees when it logs into the server with the given persona
+// via the local IP. Each entry of `hostnames` gives the IP its own host name (and optionally its own persona),
+// and each combination of host name and persona gets its own FFS, so boot time, MAC address and the files of
+// the persona differ between them.
+func (ossh *OSSHServer) identity(persona, localIP string) *FakeFS {
+	hostName, persona := hostIdentity(persona, localIP)
+	p := personaOrDefault(persona, ossh.logger)
+	if hostName == "" {
+		hostName = p.HostName
+	}
+	if p.Name == "" && hostName == Conf.HostName {
+		return activeFS
+	}
+
+	ossh.identitiesLock.Lock()
+	defer ossh.identitiesLock.Unlock()
+	key := hostName + "/" + p.Name
+	if ofs, ok := ossh.identities[key]; ok {
+		return ofs
+	}
+
+	layers := []string{}
+	if dir := p.FFSDir(); dir != "" {
+		layers = append(layers, dir)
+	}
+	ofs, err := ossh.newOverlayFS(fmt.Sprintf("%s-%d", hostName, time.Now().Unix()), layers...)
+	if err != nil {
+		ossh.logger.Error("Failed to create fake file system for %s: %s", glog.Highlight(key), glog.Error(err))
+		return activeFS
+	}
+	ofs.hostName = hostName
+	ofs.persona = p
+	ossh.identities[key] = ofs
+	return ofs
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/gliderlabs/ssh"
 	"github.com/toxyl/glog"
 	"github.com/toxyl/gutils"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type TimeWastedCounter struct {
@@ -42,7 +43,10 @@ type OSSHServer struct {
 	TimeWasted *TimeWastedCounter
 	server     []*ssh.Server
 	fs         *FakeFSManager
-	logger     *glog.Logger
+	identities map[string]*FakeFS // the file systems of the systems bots can log into, see identity
+
+	identitiesLock *sync.Mutex
+	logger         *glog.Logger
 }
 
 func (ossh *OSSHServer) stats() *SyncNodeStats {
@@ -192,35 +196,41 @@ func (ossh *OSSHServer) addLoginSuccess(s *Session, reason string) {
 	)
 }
 
-func (ossh *OSSHServer) initOverlayFS() {
-	overlayFS, err := ossh.fs.NewSession(fmt.Sprintf("%d", time.Now().Unix()))
+// newOverlayFS creates and mounts a new fake file system. The layers are laid over the default FS, the first one
+// is the top-most layer.
+func (ossh *OSSHServer) newOverlayFS(sandboxKey string, layers ...string) (*FakeFS, error) {
+	overlayFS, err := ossh.fs.NewSession(sandboxKey, layers...)
 	if err != nil {
-		ossh.logger.Error("Failed to initialize fake file system: %s", glog.Error(err))
-		os.Exit(2)
-		return
+		return nil, fmt.Errorf("initialize: %w", err)
 	}
 
-	if overlayFS != nil {
-		err = overlayFS.Mount()
-		if err != nil {
-			ossh.logger.Error("Failed to mount fake file system: %s", glog.Error(err))
-			os.Exit(3)
-			return
-		}
+	err = overlayFS.Mount()
+	if err != nil {
+		return nil, fmt.Errorf("mount: %w", err)
+	}
 
-		if !overlayFS.DirExists("/home") {
-			err := overlayFS.Mkdir("/home", 700)
-			if err != nil {
-				ossh.logger.Error("Failed to create /home dir in fake file system: %s", glog.Error(err))
-				os.Exit(4)
-			}
+	if !overlayFS.DirExists("/home") {
+		err := overlayFS.Mkdir("/home", 700)
+		if err != nil {
+			return nil, fmt.Errorf("create /home dir: %w", err)
 		}
+	}
+	return overlayFS, nil
+}
 
-		activeFS = overlayFS
+func (ossh *OSSHServer) initOverlayFS() {
+	overlayFS, err := ossh.newOverlayFS(fmt.Sprintf("%d", time.Now().Unix()))
+	if err != nil {
+		ossh.logger.Error("Failed to initialize fake file system: %s", glog.Error(err))
+		os.Exit(2)
+		return
 	}
+
+	activeFS = overlayFS
 }
 
-func (ossh *OSSHServer) sessionHandler(sess ssh.Session) {
+// sessionHandler runs the shell of a session on a server with the given persona.
+func (ossh *OSSHServer) sessionHandler(sess ssh.Session, persona string) {
 	// Catch panics, so a bug triggered in a SSH session doesn't crash the whole service
 	defer func() {
 		if err := recover(); err != nil {
@@ -234,6 +244,8 @@ func (ossh *OSSHServer) sessionHandler(sess ssh.Session) {
 		return
 	}
 
+	localIP, _ := gutils.SplitHostPortFromAddr(sess.LocalAddr())
+	s.SetFFS(ossh.identity(persona, localIP))
 	s.RandomSleep(1, 250)
 	s.SetShell()
 	stats := s.Shell.Process(s)
@@ -255,6 +267,7 @@ func (ossh *OSSHServer) sessionHandler(sess ssh.Session) {
 		pl := stats.ToPayload()
 		SrvOSSH.Loot.AddPayload(pl.hash)
 		pl.Save()
+		stats.SaveEvents(s.Host, pl.hash)
 	}
 
 	ossh.Sessions.Remove(s.ID, "")
@@ -281,11 +294,12 @@ func (ossh *OSSHServer) reversePortForwardingCallback(ctx ssh.Context, bindHost
 }
 
 func (ossh *OSSHServer) ptyCallback(ctx ssh.Context, pty ssh.Pty) bool {
-	s := ossh.Sessions.Create(ctx.RemoteAddr().String()).SetTerm(pty.Term)
+	s := ossh.Sessions.Create(ctx.RemoteAddr().String()).SetTerm(pty.Term).SetWindow(pty.Window.Width, pty.Window.Height)
 	if !s.Whitelisted {
-		ossh.logger.OK("%s: Requested %s PTY session",
+		ossh.logger.OK("%s: Requested %s PTY session (%s)",
 			s.LogID(),
 			glog.Highlight(s.Term),
+			glog.Highlight(fmt.Sprintf("%dx%d", s.Width, s.Height)),
 		)
 	}
 	s.RandomSleep(1, 250)
@@ -370,6 +384,7 @@ func (ossh *OSSHServer) authHandler(ctx ssh.Context, pwd string) bool {
 }
 
 func (ossh *OSSHServer) connectionCallback(ctx ssh.Context, conn net.Conn) net.Conn {
+	ctx.SetValue(ssh.ContextKeyLocalAddr, conn.LocalAddr()) // needed by serverConfig, before the handshake
 	s := ossh.Sessions.Create(conn.RemoteAddr().String())
 	if s != nil {
 		s.RandomSleep(1, 250)
@@ -377,6 +392,30 @@ func (ossh *OSSHServer) connectionCallback(ctx ssh.Context, conn net.Conn) net.C
 	return conn
 }
 
+// serverConfig returns the SSH configuration of a connection, the version it announces is the one of the persona
+// the local IP of the connection selects.
+func (ossh *OSSHServer) serverConfig(ctx ssh.Context, persona string) *gossh.ServerConfig {
+	localIP, _ := gutils.SplitHostPortFromAddr(ctx.LocalAddr())
+	_, persona = hostIdentity(persona, localIP)
+	config := &gossh.ServerConfig{}
+	if version := personaOrDefault(persona, ossh.logger).Version; version != "" {
+		config.ServerVersion = "SSH-2.0-" + version
+	}
+	return config
+}
+
+// saveSSHKey stores a public key in the captures directory, the second return value is false if we know it already.
+func saveSSHKey(key ssh.PublicKey) (string, bool) {
+	kb := key.Marshal()
+	sha1 := gutils.StringToSha1(string(kb))
+	fpath := fmt.Sprintf("%s/%s/%s.pub", Conf.PathCaptures, "ssh-keys", sha1)
+	if gutils.FileExists(fpath) {
+		return fpath, false
+	}
+	_ = os.WriteFile(fpath, kb, 0400)
+	return fpath, true
+}
+
 func (ossh *OSSHServer) publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
 	s := ossh.Sessions.Create(ctx.RemoteAddr().String()).SetUser(ctx.User())
 	if isIPWhitelisted(s.Host) {
@@ -384,12 +423,7 @@ func (ossh *OSSHServer) publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) boo
 		return true
 	}
 
-	kb := key.Marshal()
-	sha1 := gutils.StringToSha1(string(kb))
-	fpath := fmt.Sprintf("%s/%s/%s.pub", Conf.PathCaptures, "ssh-keys", sha1)
-
-	if !gutils.FileExists(fpath) {
-		_ = os.WriteFile(fpath, kb, 0400)
+	if fpath, isNew := saveSSHKey(key); isNew {
 		ossh.logger.OK("%s: SSH key saved to %s", s.LogID(), glog.File(fpath))
 		ossh.addLoginSuccess(s, "host gave us a public key")
 		return true
@@ -478,9 +512,15 @@ func (ossh *OSSHServer) init() {
 	ossh.initOverlayFS()
 
 	for _, srv := range Conf.Servers {
+		persona := srv.Persona
+		if persona == "" {
+			persona = Conf.Persona
+		}
 		ossh.server = append(ossh.server, &ssh.Server{
-			Addr:                          fmt.Sprintf("%s:%d", srv.Host, srv.Port),
-			Handler:                       ossh.sessionHandler,
+			Addr: fmt.Sprintf("%s:%d", srv.Host, srv.Port),
+			Handler: func(sess ssh.Session) {
+				ossh.sessionHandler(sess, persona)
+			},
 			PasswordHandler:               ossh.authHandler,
 			IdleTimeout:                   time.Duration(Conf.MaxIdleTimeout) * time.Second,
 			MaxTimeout:                    time.Duration(Conf.MaxSessionAge) * time.Second,
@@ -489,9 +529,11 @@ func (ossh *OSSHServer) init() {
 			PtyCallback:                   ossh.ptyCallback,
 			ConnectionFailedCallback:      ossh.connectionFailedCallback,
 			SessionRequestCallback:        ossh.sessionRequestCallback,
-			Version:                       Conf.Version,
 			ConnCallback:                  ossh.connectionCallback,
 			PublicKeyHandler:              ossh.publicKeyHandler,
+			ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+				return ossh.serverConfig(ctx, persona)
+			},
 		})
 	}
 }
@@ -515,10 +557,12 @@ func (ossh *OSSHServer) Start() {
 
 func NewOSSHServer() *OSSHServer {
 	ossh := &OSSHServer{
-		Loot:     NewLoot(),
-		Logins:   NewLogins(),
-		server:   []*ssh.Server{},
-		Sessions: NewActiveSessions(Conf.MaxSessionAge, glog.NewLogger("Sessions", glog.DarkOrange, Conf.Debug.Sessions, logMessageHandler)),
+		Loot:           NewLoot(),
+		Logins:         NewLogins(),
+		server:         []*ssh.Server{},
+		identities:     map[string]*FakeFS{},
+		identitiesLock: &sync.Mutex{},
+		Sessions:       NewActiveSessions(Conf.MaxSessionAge, glog.NewLogger("Sessions", glog.DarkOrange, Conf.Debug.Sessions, logMessageHandler)),
 		TimeWasted: &TimeWastedCounter{
 			val:  0,
 			lock: &sync.Mutex{},
@@ -18,12 +18,15 @@ type Session struct {
 	Shell        *FakeShell
 	SSHSession   *ssh.Session
 	Term         string
+	Width        int // the initial size of the terminal as requested by the client
+	Height       int
 	User         string
 	Password     string
 	Host         string
 	Port         int
 	Whitelisted  bool
 	Orphan       bool
+	FFS          *FakeFS // the file system of the system the session is logged into
 	logger       *glog.Logger
 	lock         *sync.Mutex
 }
@@ -87,6 +90,15 @@ func (s *Session) SetShell() *Session {
 	return s
 }
 
+// SetFFS sets the file system of the system the session is logged into, see OSSHServer.identity.
+func (s *Session) SetFFS(ffs *FakeFS) *Session {
+	s.Lock()
+	s.FFS = ffs
+	s.Unlock()
+	s.UpdateActivity()
+	return s
+}
+
 func (s *Session) SetSSHSession(sshSession *ssh.Session) *Session {
 	s.Lock()
 	s.SSHSession = sshSession
@@ -103,6 +115,15 @@ func (s *Session) SetTerm(term string) *Session {
 	return s
 }
 
+func (s *Session) SetWindow(width, height int) *Session {
+	s.Lock()
+	s.Width = width
+	s.Height = height
+	s.Unlock()
+	s.UpdateActivity()
+	return s
+}
+
 func (s *Session) SetUser(user string) *Session {
 	s.Lock()
 	s.User = user
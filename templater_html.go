@@ -123,7 +123,7 @@ func InitTemplaterFunctionsHTML() {
 		},
 		"template_string": func(name string, values interface{}) (string, error) {
 			var tpl bytes.Buffer
-			_ = ParseTemplate(name, &tpl, values)
+			_ = ParseTemplate(name, &tpl, values, nil)
 			return strings.ReplaceAll(strings.Trim(tpl.String(), " \r\n\t"), "\n", ""), nil
 		},
 	}
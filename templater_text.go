@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -21,25 +22,169 @@ var LogTextTemplater *glog.Logger = glog.NewLogger("Text Templater", glog.Medium
 
 var templateFunctions template.FuncMap = template.FuncMap{}
 
-func parseTemplateDir(dir string) (*template.Template, error) {
-	var paths []string
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+const templateCheckInterval = 2 * time.Second // how often the directories of the command templates are checked for changes
+
+// templateSet is a parsed set of command templates.
+type templateSet struct {
+	t         *template.Template
+	err       error
+	signature string    // the files it was parsed from, see templateDirsSignature
+	checked   time.Time // when the signature was last compared to the files
+}
+
+// templateCache holds the parsed command templates by their directories and the parsed template strings,
+// e.g. the responses of simple commands.
+var templateCache = struct {
+	lock    *sync.Mutex
+	sets    map[string]*templateSet
+	strings map[string]*template.Template
+	clones  map[*template.Template][]*template.Template // unused copies of the cached templates, see withFunctions
+}{
+	lock:    &sync.Mutex{},
+	sets:    map[string]*templateSet{},
+	strings: map[string]*template.Template{},
+	clones:  map[*template.Template][]*template.Template{},
+}
+
+const templateMaxClones = 16 // unused copies kept per parsed template
+
+// ResetTemplateCache drops all parsed templates, e.g. because the template functions have changed.
+func ResetTemplateCache() {
+	templateCache.lock.Lock()
+	defer templateCache.lock.Unlock()
+	templateCache.sets = map[string]*templateSet{}
+	templateCache.strings = map[string]*template.Template{}
+	templateCache.clones = map[*template.Template][]*template.Template{}
+}
+
+// templateDirsSignature describes the files of the directories, it changes whenever a file is added, removed or modified.
+func templateDirsSignature(dirs ...string) string {
+	var sb strings.Builder
+	for _, dir := range dirs {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil {
+				fmt.Fprintf(&sb, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return sb.String()
+}
+
+// compiledTemplates returns the parsed command templates of the directories. They are only parsed again once the files
+// of the directories change, which is checked at most every templateCheckInterval.
+func compiledTemplates(dirs ...string) (*template.Template, error) {
+	key := strings.Join(dirs, "\n")
+	templateCache.lock.Lock()
+	defer templateCache.lock.Unlock()
+
+	set, ok := templateCache.sets[key]
+	if ok && time.Since(set.checked) < templateCheckInterval {
+		return set.t, set.err
+	}
+	signature := templateDirsSignature(dirs...)
+	if ok && set.signature == signature {
+		set.checked = time.Now()
+		return set.t, set.err
+	}
+
+	t, err := parseTemplateDirs(dirs...)
+	if err != nil {
+		LogTextTemplater.Error("Failed to parse the command templates of %s: %s", glog.File(strings.Join(dirs, ", ")), glog.Error(err))
+	} else if ok {
+		LogTextTemplater.Info("Command templates of %s have changed, parsed them again", glog.File(strings.Join(dirs, ", ")))
+	}
+	if ok {
+		delete(templateCache.clones, set.t)
+	}
+	templateCache.sets[key] = &templateSet{t: t, err: err, signature: signature, checked: time.Now()}
+	if t != nil {
+		templateCache.clones[t] = nil
+	}
+	return t, err
+}
+
+// compiledTemplateString returns the parsed template string, each string is only parsed once.
+func compiledTemplateString(templateString string) (*template.Template, error) {
+	templateCache.lock.Lock()
+	defer templateCache.lock.Unlock()
+	if t, ok := templateCache.strings[templateString]; ok {
+		return t, nil
+	}
+	t, err := template.New("tpl").Funcs(templateFunctions).Parse(templateString)
+	if err != nil {
+		return nil, err
+	}
+	templateCache.strings[templateString] = t
+	templateCache.clones[t] = nil
+	return t, nil
+}
+
+// withFunctions returns a copy of the template that uses the functions instead of those of the same name.
+// The parsed templates are shared by all sessions, so the functions of one must not end up in them.
+// Copies are reused, release must be called once the copy has been executed.
+func withFunctions(t *template.Template, funcs ...template.FuncMap) (clone *template.Template, release func(), err error) {
+	if len(funcs) == 0 {
+		return t, func() {}, nil
+	}
+	templateCache.lock.Lock()
+	if free := templateCache.clones[t]; len(free) > 0 {
+		clone = free[len(free)-1]
+		templateCache.clones[t] = free[:len(free)-1]
+	}
+	templateCache.lock.Unlock()
+	if clone == nil {
+		clone, err = t.Clone()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		if !info.IsDir() {
-			paths = append(paths, path)
+	}
+
+	// the stand-ins replace the functions again, so a copy doesn't keep the session alive
+	standIns := template.FuncMap{}
+	for _, f := range funcs {
+		clone.Funcs(f)
+		for name := range f {
+			if fn, ok := templateFunctions[name]; ok {
+				standIns[name] = fn
+			}
+		}
+	}
+	release = func() {
+		clone.Funcs(standIns)
+		templateCache.lock.Lock()
+		defer templateCache.lock.Unlock()
+		// copies of templates that have been replaced in the meantime are dropped
+		if free, ok := templateCache.clones[t]; ok && len(free) < templateMaxClones {
+			templateCache.clones[t] = append(free, clone)
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
-	return template.New(dir).Funcs(templateFunctions).ParseFiles(paths...)
+	return clone, release, nil
 }
 
-func parseTemplateString(templateString string, wr io.Writer, data interface{}) error {
-	t, err := template.New("tpl").Funcs(templateFunctions).Parse(templateString)
+// parseTemplateDirs parses the templates of the directories, templates of later directories replace
+// earlier ones of the same name.
+func parseTemplateDirs(dirs ...string) (*template.Template, error) {
+	var paths []string
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return template.New(dirs[0]).Funcs(templateFunctions).ParseFiles(paths...)
+}
+
+func parseTemplateString(templateString string, wr io.Writer, data interface{}, funcs ...template.FuncMap) error {
+	t, err := compiledTemplateString(templateString)
 	if err != nil {
 		if strings.Contains(err.Error(), "no template") {
 			LogTextTemplater.Error("Template '%s' not found", templateString)
@@ -48,46 +193,88 @@ func parseTemplateString(templateString string, wr io.Writer, data interface{})
 		}
 		return err
 	}
+	t, release, err := withFunctions(t, funcs...)
+	if err != nil {
+		return err
+	}
+	defer release()
 	return t.Execute(wr, data)
 }
 
-func ParseTemplateFromString(templateString string, data interface{}) string {
+// ParseTemplateFromString executes the template string and returns its trimmed output.
+// The functions replace those of the same name, e.g. the ones that need a session.
+func ParseTemplateFromString(templateString string, data interface{}, funcs ...template.FuncMap) string {
 	var tpl bytes.Buffer
-	err := parseTemplateString(templateString, &tpl, data)
+	err := parseTemplateString(templateString, &tpl, data, funcs...)
 	if err != nil {
 		return ""
 	}
 	return strings.Trim(tpl.String(), " \r\n")
 }
 
-func ParseTemplate(name string, wr io.Writer, data interface{}) error {
+// ParseTemplate executes the command template with the given name. The templates of the override directories
+// (e.g. those of a persona) replace the default ones, the functions (if any) replace those of the same name.
+func ParseTemplate(name string, wr io.Writer, data interface{}, funcs template.FuncMap, overrides ...string) error {
 	dir := Conf.PathCommands
 	_, err := os.Stat(dir)
 	if err != nil {
 		return err
 	}
 
-	t, err := parseTemplateDir(dir)
-
+	t, err := compiledTemplates(append([]string{dir}, overrides...)...)
 	if err != nil {
 		return err
 	}
+	if funcs != nil {
+		var release func()
+		t, release, err = withFunctions(t, funcs)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
 	return t.ExecuteTemplate(wr, name, data)
 }
 
-func ParseTemplateToString(name string, data interface{}) string {
+// RenderTemplate executes the command template with the given name and returns its trimmed output.
+func RenderTemplate(name string, data interface{}, funcs template.FuncMap, overrides ...string) (string, error) {
 	var tpl bytes.Buffer
-	err := ParseTemplate(name, &tpl, data)
+	err := ParseTemplate(name, &tpl, data, funcs, overrides...)
 	if err != nil {
 		if strings.Contains(err.Error(), "no template") {
 			LogTextTemplater.Error("Template '%s' not found", name)
 		} else {
 			LogTextTemplater.Error("Failed to parse template string %s: %s", name, err.Error())
 		}
+		return "", err
+	}
+	return strings.Trim(tpl.String(), " \r\n"), nil
+}
+
+// TemplateNames returns the names of all command templates, including those of the override directories.
+func TemplateNames(overrides ...string) []string {
+	names := []string{}
+	t, err := compiledTemplates(append([]string{Conf.PathCommands}, overrides...)...)
+	if err != nil {
+		return names
+	}
+	for _, tpl := range t.Templates() {
+		name := tpl.Name()
+		if name == Conf.PathCommands || strings.HasSuffix(name, ".gotmpl") {
+			continue // the root template and the files themselves
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func ParseTemplateToString(name string, data interface{}) string {
+	output, err := RenderTemplate(name, data, nil)
+	if err != nil {
 		return fmt.Sprintf("%s: command not found", name)
 	}
-	return strings.Trim(tpl.String(), " \r\n")
+	return output
 }
 
 func InitTemplaterFunctions() {
@@ -158,8 +345,16 @@ func InitTemplaterFunctions() {
 		},
 		"template_string": func(name string, values interface{}) (string, error) {
 			var tpl bytes.Buffer
-			_ = ParseTemplate(name, &tpl, values)
+			_ = ParseTemplate(name, &tpl, values, nil)
 			return strings.ReplaceAll(strings.Trim(tpl.String(), " \r\n\t"), "\n", ""), nil
 		},
 	}
+
+	// the functions that need a session are replaced when a command template is executed, see FakeShell.templateFunctions
+	for name := range (&FakeShell{}).templateFunctions(CommandTemplateData{}) {
+		name := name
+		templateFunctions[name] = func(args ...interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("%s is only available in the fake shell", name)
+		}
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,12 +42,12 @@ func (ac2h *ASCIICastV2Header) String() string {
 
 type ASCIICastV2Event struct {
 	Time float64
-	Type string // either "o" (stdout) or "i" (stdin)
+	Type string // either "o" (stdout), "i" (stdin) or "r" (resize)
 	Data string // UTF-8 encoded JSON string
 }
 
 func (ac2e *ASCIICastV2Event) String() string {
-	if ac2e.Type != "o" && ac2e.Type != "i" {
+	if ac2e.Type != "o" && ac2e.Type != "i" && ac2e.Type != "r" {
 		return "" // unknown type, ignore
 	}
 
@@ -65,9 +66,12 @@ func (ac2e *ASCIICastV2Event) String() string {
 type ASCIICastV2 struct {
 	Header      ASCIICastV2Header
 	EventStream []ASCIICastV2Event
+	lock        sync.Mutex // resize events arrive concurrently to the other events
 }
 
 func (ac2 *ASCIICastV2) addEventRaw(eventtype, data string, time float64) {
+	ac2.lock.Lock()
+	defer ac2.lock.Unlock()
 	ac2.EventStream = append(ac2.EventStream, ASCIICastV2Event{
 		Time: time,
 		Type: eventtype,
@@ -81,8 +85,10 @@ func (ac2 *ASCIICastV2) addEvent(eventtype, data string) {
 	timeSinceStart := timeNow.Sub(timeStart)
 	secondsSinceStart := timeSinceStart.Seconds()
 
-	ac2.Header.Duration = secondsSinceStart
 	ac2.addEventRaw(eventtype, data, secondsSinceStart)
+	ac2.lock.Lock()
+	ac2.Header.Duration = secondsSinceStart
+	ac2.lock.Unlock()
 }
 
 func (ac2 *ASCIICastV2) AddInputEvent(data string) {
@@ -100,7 +106,19 @@ func (ac2 *ASCIICastV2) AddOutputEvent(data string) {
 	ac2.addEvent("o", fmt.Sprintf("%s\r\n\u001b[?2004l\r", data))
 }
 
+// AddRawOutputEvent records output exactly as it was sent, e.g. the escape sequences of a full-screen program.
+func (ac2 *ASCIICastV2) AddRawOutputEvent(data string) {
+	ac2.addEvent("o", data)
+}
+
+// AddResizeEvent records that the terminal has been resized to the given number of columns and rows.
+func (ac2 *ASCIICastV2) AddResizeEvent(width, height int) {
+	ac2.addEvent("r", fmt.Sprintf("%dx%d", width, height))
+}
+
 func (ac2 *ASCIICastV2) String() string {
+	ac2.lock.Lock()
+	defer ac2.lock.Unlock()
 	output := []string{ac2.Header.String()}
 	for _, e := range ac2.EventStream {
 		output = append(output, e.String())